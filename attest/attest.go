@@ -0,0 +1,177 @@
+// Package attest produces signed, reproducible statements describing what a
+// run scanned and did. Statements are wrapped in a DSSE-style envelope and
+// signed with a local Ed25519 key, so an organization can later prove which
+// binary ran with which configuration and what it found.
+package attest
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// PayloadType identifies attestation payloads inside an envelope.
+const PayloadType = "application/vnd.immich-stray-finder.attestation+json"
+
+// Statement is the signed content of an attestation.
+type Statement struct {
+	Version       string     `json:"version"`
+	ConfigHash    string     `json:"config_hash"`
+	StartedAt     time.Time  `json:"started_at"`
+	FinishedAt    time.Time  `json:"finished_at"`
+	Mode          string     `json:"mode"`
+	Outcome       string     `json:"outcome"`
+	FilesScanned  int        `json:"files_scanned"`
+	Untracked     int        `json:"untracked"`
+	UntrackedHash string     `json:"untracked_sha256"`
+	Artifacts     []Artifact `json:"artifacts,omitempty"`
+}
+
+// Artifact records the digest of a file produced by the run (report, audit
+// log, ...), binding its exact content to the signature.
+type Artifact struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+// Envelope is a signed statement. Payload holds the exact bytes that were
+// signed, so verification never depends on re-encoding.
+type Envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     string      `json:"payload"`
+	Signatures  []Signature `json:"signatures"`
+}
+
+// Signature is a single signature over an envelope's payload.
+type Signature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// ErrBadSignature is returned by Verify when no signature matches.
+var ErrBadSignature = errors.New("attestation signature does not verify")
+
+// HashPaths returns a hex SHA-256 digest over the sorted list of paths. The
+// digest is independent of the order in which paths were discovered.
+func HashPaths(paths []string) string {
+	sorted := append([]string(nil), paths...)
+	sort.Strings(sorted)
+	h := sha256.New()
+	for _, p := range sorted {
+		io.WriteString(h, p)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// FileArtifact hashes the file at path and returns it as an Artifact.
+func FileArtifact(name, path string) (Artifact, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Artifact{}, fmt.Errorf("open artifact: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return Artifact{}, fmt.Errorf("hash artifact: %w", err)
+	}
+	return Artifact{Name: name, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// LoadPrivateKey reads a PEM-encoded PKCS#8 Ed25519 private key, as produced
+// by `openssl genpkey -algorithm ed25519`.
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("read key %s: no PEM block found", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse key: %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("parse key %s: not an Ed25519 key", path)
+	}
+	return key, nil
+}
+
+// KeyID returns a short identifier for a public key.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Sign encodes st and signs it with key.
+func Sign(st *Statement, key ed25519.PrivateKey) (*Envelope, error) {
+	payload, err := json.Marshal(st)
+	if err != nil {
+		return nil, fmt.Errorf("marshal statement: %w", err)
+	}
+	sig := ed25519.Sign(key, pae(PayloadType, payload))
+	return &Envelope{
+		PayloadType: PayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures: []Signature{{
+			KeyID: KeyID(key.Public().(ed25519.PublicKey)),
+			Sig:   base64.StdEncoding.EncodeToString(sig),
+		}},
+	}, nil
+}
+
+// Verify checks env against pub and returns the decoded statement.
+func Verify(env *Envelope, pub ed25519.PublicKey) (*Statement, error) {
+	payload, err := base64.StdEncoding.DecodeString(env.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("decode payload: %w", err)
+	}
+	msg := pae(env.PayloadType, payload)
+	for _, s := range env.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err != nil {
+			continue
+		}
+		if ed25519.Verify(pub, msg, sig) {
+			var st Statement
+			if err := json.Unmarshal(payload, &st); err != nil {
+				return nil, fmt.Errorf("unmarshal statement: %w", err)
+			}
+			return &st, nil
+		}
+	}
+	return nil, ErrBadSignature
+}
+
+// WriteFile signs st and writes the envelope to path.
+func WriteFile(path string, st *Statement, key ed25519.PrivateKey) error {
+	env, err := Sign(st, key)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal envelope: %w", err)
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// pae is the DSSE pre-authentication encoding, which binds the payload type
+// into the signed message.
+func pae(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
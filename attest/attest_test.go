@@ -0,0 +1,70 @@
+package attest
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSignVerify_RoundTrip(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &Statement{
+		Version:       "v1.2.3",
+		ConfigHash:    "abc",
+		StartedAt:     time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC),
+		Untracked:     2,
+		UntrackedHash: HashPaths([]string{"b", "a"}),
+	}
+
+	env, err := Sign(st, key)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	got, err := Verify(env, pub)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if got.Version != "v1.2.3" || got.Untracked != 2 {
+		t.Errorf("statement mismatch: %+v", got)
+	}
+
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	if _, err := Verify(env, otherPub); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature for wrong key, got %v", err)
+	}
+}
+
+func TestHashPaths_OrderIndependent(t *testing.T) {
+	if HashPaths([]string{"a", "b"}) != HashPaths([]string{"b", "a"}) {
+		t.Error("expected hash to be independent of input order")
+	}
+	if HashPaths([]string{"ab"}) == HashPaths([]string{"a", "b"}) {
+		t.Error("expected path boundaries to affect the hash")
+	}
+}
+
+func TestLoadPrivateKey(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(nil)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+
+	loaded, err := LoadPrivateKey(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !loaded.Equal(key) {
+		t.Error("loaded key does not match")
+	}
+}
@@ -0,0 +1,107 @@
+// Package audit keeps an append-only record of every file the tool moved or
+// deleted, apart from the logs, so what happened to a file can be proven
+// long after the run that did it.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry records one change to one file. Paths are absolute, so an entry
+// stands on its own without the configuration of the run.
+type Entry struct {
+	Time  time.Time `json:"time"`
+	RunID string    `json:"run_id"`
+	// Action is what was done, e.g. "moved" or "purged".
+	Action string `json:"action"`
+	// Source is the file that was moved or deleted.
+	Source string `json:"source"`
+	// Dest is where it was moved to; empty for deletions.
+	Dest string `json:"dest,omitempty"`
+	// DuplicateOf is the identical file that justified deleting Source.
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	Size        int64  `json:"size"`
+	// SHA256 is the hex digest of the content, taken before the change.
+	SHA256 string `json:"sha256"`
+}
+
+// Log appends entries to an audit log file. A nil *Log records nothing.
+// It is safe for concurrent use.
+type Log struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// Open opens the audit log at path for appending, creating it if needed.
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	return &Log{f: f}, nil
+}
+
+// Record appends e and flushes it to disk before returning, so an entry
+// exists for every change that completed, even if the process dies next.
+func (l *Log) Record(e Entry) error {
+	if l == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal audit entry: %w", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write audit log: %w", err)
+	}
+	if err := l.f.Sync(); err != nil {
+		return fmt.Errorf("sync audit log: %w", err)
+	}
+	return nil
+}
+
+// Close closes the audit log.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// Load reads every entry of the audit log at path.
+func Load(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("parse audit log line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read audit log: %w", err)
+	}
+	return entries, nil
+}
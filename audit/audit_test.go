@@ -0,0 +1,43 @@
+package audit
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	for _, run := range []string{"run1", "run2"} {
+		l, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Record(Entry{RunID: run, Action: "moved", Source: "/lib/a.jpg", Dest: "/q/a.jpg", Size: 3, SHA256: "abc"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].RunID != "run1" || entries[1].RunID != "run2" {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if entries[0].Time.IsZero() || entries[0].Dest != "/q/a.jpg" {
+		t.Errorf("entry = %+v", entries[0])
+	}
+}
+
+func TestNilLog(t *testing.T) {
+	var l *Log
+	if err := l.Record(Entry{Action: "moved"}); err != nil {
+		t.Errorf("Record on nil log: %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Errorf("Close on nil log: %v", err)
+	}
+}
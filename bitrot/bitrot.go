@@ -0,0 +1,177 @@
+// Package bitrot keeps the progress of verifying assets against the
+// checksums Immich recorded for them, so the verification of a large
+// library can be interrupted and resumed. Corruption changes neither the
+// size nor the modification time of a file, so a completed verification
+// is never reused: the next one hashes every file again.
+package bitrot
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/throttle"
+)
+
+// Entry records the verification of one asset's original.
+type Entry struct {
+	AssetID string `json:"asset_id"`
+	// Path is relative to the library root.
+	Path string `json:"path"`
+	// Size and ModTime are the file's when it was hashed; a file whose
+	// size or time changed since must be hashed again.
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	// Expected is the checksum Immich recorded, Actual the one computed,
+	// both the base64-encoded SHA-1 of the content.
+	Expected   string    `json:"expected"`
+	Actual     string    `json:"actual"`
+	VerifiedAt time.Time `json:"verified_at"`
+}
+
+// OK reports whether the file matched its checksum.
+func (e Entry) OK() bool {
+	return e.Actual == e.Expected
+}
+
+// Current reports whether e still holds for the file at path with the
+// given checksum, size, and modification time, so a resumed verification
+// need not hash it again.
+func (e Entry) Current(path, expected string, size int64, modTime time.Time) bool {
+	return e.Path == path && e.Expected == expected && e.Size == size && e.ModTime.Equal(modTime)
+}
+
+// Progress is a JSON Lines file of the entries of a verification in
+// progress, appended to as files are verified. The last entry of an asset
+// wins. A nil *Progress records nothing. It is safe for concurrent use.
+type Progress struct {
+	path    string
+	mu      sync.Mutex
+	f       *os.File
+	entries map[string]Entry
+}
+
+// Open loads the progress file at path, creating it if needed, and opens
+// it for appending. A line cut short by an interruption is ignored.
+func Open(path string) (*Progress, error) {
+	p := &Progress{path: path, entries: make(map[string]Entry)}
+	f, err := os.Open(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("open verify progress: %w", err)
+	default:
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			var e Entry
+			if json.Unmarshal(sc.Bytes(), &e) != nil || e.AssetID == "" {
+				continue
+			}
+			p.entries[e.AssetID] = e
+		}
+		err := sc.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read verify progress %s: %w", path, err)
+		}
+	}
+	if p.f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+		return nil, fmt.Errorf("open verify progress: %w", err)
+	}
+	return p, nil
+}
+
+// Len returns the number of assets with an entry.
+func (p *Progress) Len() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.entries)
+}
+
+// Lookup returns the last entry of the asset with the given ID.
+func (p *Progress) Lookup(assetID string) (Entry, bool) {
+	if p == nil {
+		return Entry{}, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.entries[assetID]
+	return e, ok
+}
+
+// Record appends e. Entries are not synced one by one: losing the last
+// few to a crash only means hashing those files again.
+func (p *Progress) Record(e Entry) error {
+	if p == nil {
+		return nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal verify progress: %w", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.entries[e.AssetID] = e
+	if _, err := p.f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write verify progress: %w", err)
+	}
+	return nil
+}
+
+// Finish closes and removes the progress file once the verification is
+// complete, so the next one starts over.
+func (p *Progress) Finish() error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.f.Close()
+	p.f = nil
+	if err := os.Remove(p.path); err != nil {
+		return fmt.Errorf("remove verify progress: %w", err)
+	}
+	return nil
+}
+
+// Close syncs and closes the progress file, keeping it for the next run
+// to resume from. It does nothing after Finish.
+func (p *Progress) Close() error {
+	if p == nil || p.f == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.f.Sync(); err != nil {
+		p.f.Close()
+		return fmt.Errorf("sync verify progress: %w", err)
+	}
+	return p.f.Close()
+}
+
+// Checksum computes the checksum Immich records for the file at path, the
+// base64-encoded SHA-1 of its content, reading at most as fast as limit
+// allows.
+func Checksum(path string, limit *throttle.Limiter) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err := io.Copy(throttle.Writer(h, limit), f); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
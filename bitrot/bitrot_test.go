@@ -0,0 +1,66 @@
+package bitrot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProgress_ResumeAndFinish(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "verify-progress.jsonl")
+	mtime := time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC)
+
+	p, err := Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	p.Record(Entry{AssetID: "a", Path: "library/a.jpg", Size: 3, ModTime: mtime, Expected: "x", Actual: "x"})
+	p.Record(Entry{AssetID: "b", Path: "library/b.jpg", Size: 3, ModTime: mtime, Expected: "y", Actual: "z"})
+	if err := p.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	// A line cut short by an interruption.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`{"asset_id":"c","pa`)
+	f.Close()
+
+	p, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer p.Close()
+	if p.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", p.Len())
+	}
+	a, ok := p.Lookup("a")
+	if !ok || !a.OK() || !a.Current("library/a.jpg", "x", 3, mtime) {
+		t.Errorf("unexpected entry for a: %+v", a)
+	}
+	if a.Current("library/a.jpg", "x", 4, mtime) {
+		t.Error("entry should not be current after a size change")
+	}
+	if a.Current("library/a.jpg", "w", 3, mtime) {
+		t.Error("entry should not be current after a checksum change")
+	}
+
+	if err := p.Finish(); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("progress file should be removed after finishing: %v", err)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f")
+	os.WriteFile(path, []byte("hello"), 0o644)
+	got, err := Checksum(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SHA-1 of "hello", base64-encoded.
+	if want := "qvTGHdzF6KLavt4PO0gs2a6pQ00="; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/goeland86/immich-stray-finder/immich"
+)
+
+// feature is something the tool does through the Immich API, together with
+// the API key permissions it needs.
+type feature struct {
+	name        string
+	permissions []string
+	// probed reports the result of a read-only probe. Nil for features that
+	// cannot be probed without side effects; those are judged by the key's
+	// reported permissions alone.
+	probed func(*immich.Capabilities) bool
+}
+
+var features = []feature{
+	{"single-user scan", []string{"user.read", "asset.read"}, func(c *immich.Capabilities) bool {
+		return c.UserRead && c.AssetRead
+	}},
+	{"admin mode (all users, with --db-url)", []string{"admin.user.read"}, func(c *immich.Capabilities) bool {
+		return c.Admin
+	}},
+	{"Immich duplicates (--immich-duplicates)", []string{"duplicate.read"}, nil},
+	{"review previews (review command)", []string{
+		"asset.upload", "asset.update", "album.read", "album.create", "albumAsset.create", "tag.create", "tag.asset",
+	}, nil},
+}
+
+// checkCapabilities probes the API key and prints which features it enables.
+// It returns an error when the key cannot run a scan at all.
+func checkCapabilities(ctx context.Context, cfg *config, logger *slog.Logger) error {
+	client := immich.NewClient(cfg.immichURL, cfg.apiKey, logger)
+	caps, err := client.ProbeCapabilities(ctx)
+	if err != nil {
+		return fmt.Errorf("probe API key capabilities: %w", err)
+	}
+
+	fmt.Fprintln(stderr, "\nAPI key capabilities:")
+	if caps.Permissions == nil {
+		fmt.Fprintln(stderr, "  (this Immich version does not report key permissions; results are from probing)")
+	}
+	for _, f := range features {
+		status, detail := featureStatus(f, caps)
+		fmt.Fprintf(stderr, "  %-10s %s%s\n", status, f.name, detail)
+	}
+	fmt.Fprintln(stderr)
+
+	// Without --db-url every scan reads the key owner's assets through the API.
+	if !(caps.UserRead && caps.AssetRead) && !(caps.Admin && cfg.dbURL != "") {
+		return fmt.Errorf("the API key cannot run a scan: grant it %s, or use an admin key with --db-url",
+			strings.Join(features[0].permissions, " and "))
+	}
+	return nil
+}
+
+// featureStatus returns "available", "disabled", or "unknown" for f and,
+// when disabled, which permissions are missing.
+func featureStatus(f feature, caps *immich.Capabilities) (status, detail string) {
+	var missing []string
+	if caps.Permissions != nil {
+		for _, p := range f.permissions {
+			if !caps.Has(p) {
+				missing = append(missing, p)
+			}
+		}
+	}
+
+	var ok bool
+	switch {
+	case f.probed != nil:
+		ok = f.probed(caps)
+	case caps.Permissions != nil:
+		ok = len(missing) == 0
+	default:
+		return "unknown", ""
+	}
+	if ok {
+		return "available", ""
+	}
+	if len(missing) == 0 {
+		missing = f.permissions
+	}
+	return "disabled", " (needs " + strings.Join(missing, ", ") + ")"
+}
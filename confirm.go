@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/goeland86/immich-stray-finder/matcher"
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/report"
+)
+
+// errDeclined is returned when the user does not confirm a move or delete.
+var errDeclined = errors.New("not confirmed; no files were moved, copied, or deleted")
+
+// isTerminal reports whether f is an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// confirmMove summarizes what is about to be moved, or with --delete
+// deleted, with --copy copied, with --link linked, and with --archive
+// archived, and asks the user to type that verb to proceed. It returns
+// errDeclined for any other answer, and the context's error when
+// interrupted while waiting.
+func confirmMove(ctx context.Context, untracked []matcher.UntrackedFile, cfg *config, in io.Reader) error {
+	type dirStats struct {
+		files int
+		bytes int64
+	}
+	dirs := make(map[string]*dirStats)
+	var total int64
+	n := 0
+	for _, u := range untracked {
+		if (cfg.delete || cfg.copy || cfg.link) && u.Category == matcher.CategoryBackup {
+			// Misplaced dumps are moved into backups/ when deleting, and
+			// left alone when copying or linking.
+			continue
+		}
+		n++
+		dir := paths.TopDir(u.RelPath)
+		if owner := paths.Owner(u.RelPath); owner != "" {
+			dir += "/" + owner
+		}
+		if dirs[dir] == nil {
+			dirs[dir] = &dirStats{}
+		}
+		dirs[dir].files++
+		dirs[dir].bytes += u.Size
+		total += u.Size
+	}
+
+	verb := "move"
+	switch {
+	case cfg.delete:
+		verb = "delete"
+		fmt.Fprintf(os.Stderr, "\nAbout to permanently delete %d file(s), %s:\n", n, report.FormatBytes(total))
+	case cfg.copy:
+		verb = "copy"
+		fmt.Fprintf(os.Stderr, "\nAbout to copy %d file(s), %s, to %s:\n", n, report.FormatBytes(total), cfg.targetDir)
+	case cfg.archive != "":
+		verb = "archive"
+		fmt.Fprintf(os.Stderr, "\nAbout to archive %d file(s), %s, into %s:\n", n, report.FormatBytes(total), cfg.archive)
+	case cfg.link:
+		verb = "link"
+		fmt.Fprintf(os.Stderr, "\nAbout to hardlink %d file(s), %s, into %s:\n", n, report.FormatBytes(total), cfg.targetDir)
+	default:
+		fmt.Fprintf(os.Stderr, "\nAbout to move %d file(s), %s, to %s:\n", n, report.FormatBytes(total), cfg.targetDir)
+	}
+	for _, dir := range slices.Sorted(maps.Keys(dirs)) {
+		fmt.Fprintf(os.Stderr, "  %-40s %6d file(s)  %10s\n", dir+"/", dirs[dir].files, report.FormatBytes(dirs[dir].bytes))
+	}
+	fmt.Fprintf(os.Stderr, "\nType %q to continue: ", verb)
+
+	// Read in the background so Ctrl+C, which only cancels ctx, still works.
+	answer := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(in).ReadString('\n')
+		answer <- strings.TrimSpace(line)
+	}()
+	select {
+	case <-ctx.Done():
+		fmt.Fprintln(os.Stderr)
+		return ctx.Err()
+	case a := <-answer:
+		if a != verb {
+			return errDeclined
+		}
+		return nil
+	}
+}
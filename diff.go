@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/report"
+)
+
+// diffAgainst compares the strays of res against the report named by
+// --diff-against and stores the result in res.diff. The first run with
+// --diff-against last has nothing to compare against and lists every stray.
+// Since scoped runs are not stored, a scoped run compared against the last
+// one lists the strays outside its scope as gone, and is warned about.
+func diffAgainst(cfg *config, res *runResult, logger *slog.Logger) error {
+	prev, err := report.Load(cfg.diffAgainst)
+	if err != nil {
+		if cfg.diffLast && errors.Is(err, fs.ErrNotExist) {
+			logger.Info("no earlier run to compare against", "dir", cfg.stateDir)
+			return nil
+		}
+		return fmt.Errorf("--diff-against: %w", err)
+	}
+	if prev.Sample != nil {
+		return fmt.Errorf("--diff-against: %s is the report of a sampled scan", cfg.diffAgainst)
+	}
+	if scope := scopeFlags(cfg); cfg.diffLast && len(scope) > 0 {
+		logger.Warn("the last run was not limited like this one; strays outside its scope are listed as gone",
+			"flags", strings.Join(scope, ","))
+	}
+	cur := report.New("", "", 0, res.untracked)
+	res.diff = report.Compare(prev, cur.Files)
+	logger.Info("compared with earlier run", "previous_run_id", prev.RunID,
+		"new", len(res.diff.New), "gone", len(res.diff.Gone))
+	return nil
+}
+
+// printDiff lists the strays that appeared and disappeared since the earlier
+// report on stderr for a human reader.
+func printDiff(d *report.Diff, untracked int) {
+	since := d.PreviousGeneratedAt.Local().Format(time.DateTime)
+	if d.PreviousRunID != "" {
+		since = "run " + d.PreviousRunID
+	}
+	unchanged := untracked - len(d.New)
+	fmt.Fprintf(stderr, "\nSince %s: %d new, %d gone, %d unchanged untracked file(s).\n", since, len(d.New), len(d.Gone), unchanged)
+	if len(d.New) > 0 {
+		fmt.Fprintln(stderr, "New:")
+		for _, f := range d.New {
+			line := "  + " + f.Path
+			if f.ProbablyTrackedAs != "" {
+				line += "  (probably tracked as " + f.ProbablyTrackedAs + ")"
+			}
+			fmt.Fprintln(stderr, line)
+		}
+	}
+	if len(d.Gone) > 0 {
+		fmt.Fprintln(stderr, "Gone:")
+		for _, f := range d.Gone {
+			fmt.Fprintln(stderr, "  - "+f.Path)
+		}
+	}
+}
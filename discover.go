@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/goeland86/immich-stray-finder/immich"
+	"github.com/goeland86/immich-stray-finder/paths"
+)
+
+// autoPrefix is the default of --path-prefix, which has discoverLayout
+// detect it.
+const autoPrefix = "auto"
+
+// defaultMediaPrefix is the media location of Immich's Docker setup,
+// assumed when the prefix cannot be detected.
+const defaultMediaPrefix = "/data/"
+
+// prefixSample is how many assets discoverLayout looks at.
+const prefixSample = 50
+
+// discoverLayout sets --path-prefix, unless given, to the media location
+// the paths of the API key owner's assets start with, and resolves the
+// mounts of --library-path with it. It warns when those assets are not
+// where --library-path says, and, for admin keys, when the storage template
+// keeps originals out of library/.
+func discoverLayout(ctx context.Context, cfg *config, logger *slog.Logger) error {
+	if cfg.pathPrefix != autoPrefix {
+		return nil
+	}
+	if cfg.immichURL == "" || cfg.apiKey == "" {
+		return errors.New("--path-prefix can only be detected with --immich-url and --api-key; give it explicitly")
+	}
+	client := immich.NewClient(cfg.immichURL, cfg.apiKey, logger)
+	user, err := client.FetchCurrentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("detect --path-prefix: %w", err)
+	}
+	assets, err := client.SampleAssets(ctx, prefixSample)
+	if err != nil {
+		return fmt.Errorf("detect --path-prefix: %w", err)
+	}
+	cfg.pathPrefix = defaultMediaPrefix
+	var sample string
+	for _, a := range assets {
+		if _, mapped := paths.Rel(cfg.pathMaps, a.OriginalPath); mapped {
+			// Recorded under an older media location.
+			continue
+		}
+		if prefix, ok := immich.MediaPrefix(a.OriginalPath, user.ID, user.StorageLabel, user.ID); ok {
+			cfg.pathPrefix, sample = prefix, a.OriginalPath
+			break
+		}
+	}
+	if err := resolveMounts(cfg); err != nil {
+		return fmt.Errorf("--library-path: %w", err)
+	}
+
+	if sample == "" {
+		logger.Warn("cannot detect the path prefix from the asset paths; set --path-prefix if Immich's media location is not "+defaultMediaPrefix,
+			"path_prefix", cfg.pathPrefix, "assets_sampled", len(assets))
+	} else {
+		logger.Info("detected path prefix", "path_prefix", cfg.pathPrefix, "from", sample)
+		file := cfg.libraryFile(strings.TrimPrefix(sample, cfg.pathPrefix))
+		if _, err := os.Stat(file); err != nil {
+			logger.Warn("an asset is not where --library-path says; it should be the directory mounted as Immich's media location "+cfg.pathPrefix,
+				"path", file, "error", err)
+		}
+	}
+
+	tmpl, err := client.FetchStorageTemplate(ctx)
+	if err != nil {
+		logger.Debug("cannot read the storage template setting", "error", err)
+		return nil
+	}
+	logger.Info("storage template", "enabled", tmpl.Enabled, "template", tmpl.Template)
+	if !tmpl.Enabled && cfg.dbURL == "" {
+		logger.Warn("the storage template is disabled, so originals stay in upload/; without --db-url only library/" +
+			libraryDir(*user) + "/ is scanned")
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/goeland86/immich-stray-finder/immich"
+	"github.com/goeland86/immich-stray-finder/matcher"
+	"github.com/goeland86/immich-stray-finder/tracing"
+)
+
+// labelDuplicates sets DuplicateOf on the strays in res that are identical
+// to an asset in one of Immich's duplicate groups. Only originals are
+// compared, and only those whose size matches such an asset are hashed.
+func labelDuplicates(ctx context.Context, cfg *config, res *runResult, logger *slog.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, "duplicates")
+	defer span.EndErr(&err)
+	client := immich.NewClient(cfg.immichURL, cfg.apiKey, logger)
+	groups, err := client.FetchDuplicates(ctx)
+	if err != nil {
+		return err
+	}
+	idx := immich.NewDuplicateIndex(groups)
+	logger.Info("fetched Immich duplicate groups", "groups", len(groups), "assets", idx.Len())
+
+	hashed, labeled, missing, err := labelIdentical(ctx, cfg, res, idx, logger)
+	if err != nil {
+		return err
+	}
+	span.SetAttrs("hashed", hashed, "identical", labeled, "missing_originals", missing)
+	logger.Info("compared strays with Immich duplicates", "hashed", hashed, "identical", labeled, "missing_originals", missing)
+	return nil
+}
+
+// matchChecksums sets DuplicateOf on the strays in res that are identical
+// to any asset, going by the checksums fetched with the assets, and
+// UnknownContent on the other originals: those are the ones that need a
+// look before they are deleted.
+func matchChecksums(ctx context.Context, cfg *config, res *runResult, idx *immich.DuplicateIndex, logger *slog.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, "checksums")
+	defer span.EndErr(&err)
+	hashed, labeled, missing, err := labelIdentical(ctx, cfg, res, idx, logger)
+	if err != nil {
+		return err
+	}
+	unknown := 0
+	for i := range res.untracked {
+		u := &res.untracked[i]
+		if mayBeCopy(u) && u.DuplicateOf == "" && u.MissingOriginalOf == "" {
+			u.UnknownContent = true
+			unknown++
+		}
+	}
+	span.SetAttrs("hashed", hashed, "identical", labeled, "missing_originals", missing, "unknown", unknown)
+	logger.Info("compared strays with the checksums of all assets", "assets", idx.Len(), "hashed", hashed, "identical", labeled, "missing_originals", missing, "unknown_content", unknown)
+	return nil
+}
+
+// groupIdentical sets CopyOf on the strays in res with the same content as
+// one listed before them. Only strays sharing their size with another are
+// hashed.
+func groupIdentical(ctx context.Context, cfg *config, res *runResult, logger *slog.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, "identical")
+	defer span.EndErr(&err)
+	sizes := make(map[int64]int)
+	for _, u := range res.untracked {
+		sizes[u.Size]++
+	}
+	firsts := make(map[string]string)
+	hashed, copies := 0, 0
+	for i := range res.untracked {
+		u := &res.untracked[i]
+		if sizes[u.Size] < 2 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sum, err := immich.FileChecksum(cfg.libraryFile(u.RelPath))
+		if err != nil {
+			logger.Warn("cannot hash stray", "path", u.RelPath, "error", err)
+			continue
+		}
+		hashed++
+		// The size keeps SHA-1 collisions of different lengths apart.
+		key := strconv.FormatInt(u.Size, 10) + ":" + sum
+		if first, ok := firsts[key]; ok {
+			u.CopyOf = first
+			copies++
+			continue
+		}
+		firsts[key] = u.RelPath
+	}
+	span.SetAttrs("hashed", hashed, "copies", copies)
+	logger.Info("grouped identical strays", "hashed", hashed, "copies", copies)
+	return nil
+}
+
+// mayBeCopy reports whether u can be a copy of an asset's original.
+func mayBeCopy(u *matcher.UntrackedFile) bool {
+	return u.Category == matcher.CategoryOriginal || u.Category == matcher.CategoryUnmanaged
+}
+
+// labelIdentical sets DuplicateOf on the strays in res whose checksum is
+// that of an asset in idx, or MissingOriginalOf if that asset's original is
+// missing from disk, hashing only originals of a size idx may contain. It
+// returns how many strays it hashed and labeled either way.
+func labelIdentical(ctx context.Context, cfg *config, res *runResult, idx *immich.DuplicateIndex, logger *slog.Logger) (hashed, labeled, missing int, err error) {
+	for i := range res.untracked {
+		u := &res.untracked[i]
+		if !mayBeCopy(u) || !idx.MayContain(u.Size) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return hashed, labeled, missing, err
+		}
+		sum, err := immich.FileChecksum(cfg.libraryFile(u.RelPath))
+		if err != nil {
+			// Vanished or unreadable; the move reports it if it matters.
+			logger.Warn("cannot hash stray", "path", u.RelPath, "error", err)
+			continue
+		}
+		hashed++
+		asset, ok := idx.Lookup(sum)
+		if !ok {
+			continue
+		}
+		rel := cfg.libraryRel(asset.OriginalPath)
+		if rel == u.RelPath {
+			continue
+		}
+		// Only a path inside the library can be checked, and relinked to.
+		if filepath.IsLocal(filepath.FromSlash(rel)) {
+			if _, err := os.Lstat(cfg.libraryFile(rel)); errors.Is(err, fs.ErrNotExist) {
+				u.MissingOriginalOf = rel
+				missing++
+				logger.Debug("stray has the content of an asset whose original is missing", "path", u.RelPath, "original", rel, "asset_id", asset.ID)
+				continue
+			}
+		}
+		u.DuplicateOf = rel
+		labeled++
+		logger.Debug("stray is identical to an Immich asset", "path", u.RelPath, "duplicate_of", rel, "asset_id", asset.ID)
+	}
+	return hashed, labeled, missing, nil
+}
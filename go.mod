@@ -2,10 +2,15 @@ module github.com/goeland86/immich-stray-finder
 
 go 1.25.0
 
-require github.com/jackc/pgx/v5 v5.8.0
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/jackc/pgx/v5 v5.8.0
+	golang.org/x/sync v0.17.0
+	golang.org/x/text v0.29.0
+)
 
 require (
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
-	golang.org/x/text v0.29.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 )
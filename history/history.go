@@ -0,0 +1,154 @@
+// Package history records a compact summary of every run in an append-only
+// JSON Lines file and derives a stray growth forecast from it.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Record summarizes a single run.
+type Record struct {
+	Time           time.Time `json:"time"`
+	Mode           string    `json:"mode"`
+	AssetsFetched  int       `json:"assets_fetched,omitempty"`
+	FilesScanned   int       `json:"files_scanned"`
+	Untracked      int       `json:"untracked"`
+	UntrackedBytes int64     `json:"untracked_bytes"`
+}
+
+// Append adds rec as a new line to the history file at path, creating it if
+// necessary.
+func Append(path string, rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal record: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open history: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("write history: %w", err)
+	}
+	return f.Close()
+}
+
+// Load reads all records from the history file at path. A missing file is
+// not an error and yields no records.
+func Load(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open history: %w", err)
+	}
+	defer f.Close()
+
+	var records []Record
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("parse history line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	return records, nil
+}
+
+// Forecast is a linear estimate of how fast strays accumulate.
+type Forecast struct {
+	// Runs is the number of runs the estimate is based on.
+	Runs int
+	// Span is the time between the first and last of those runs.
+	Span time.Duration
+	// FilesPerWeek and BytesPerWeek are the fitted growth rates.
+	FilesPerWeek float64
+	BytesPerWeek float64
+	// Last is the most recent record, the base for projections.
+	Last Record
+}
+
+const week = 7 * 24 * time.Hour
+
+// NewForecast fits a least-squares line through the untracked counts of the
+// runs after the last move-mode run, because moving strays resets the count.
+// ok is false when fewer than two runs at distinct times are available.
+func NewForecast(records []Record) (f Forecast, ok bool) {
+	start := 0
+	for i, r := range records {
+		if r.Mode == "move" && i < len(records)-1 {
+			start = i + 1
+		}
+	}
+	window := records[start:]
+	if len(window) < 2 {
+		return Forecast{}, false
+	}
+
+	origin := window[0].Time
+	xs := make([]float64, len(window))
+	files := make([]float64, len(window))
+	bytes := make([]float64, len(window))
+	for i, r := range window {
+		xs[i] = float64(r.Time.Sub(origin)) / float64(week)
+		files[i] = float64(r.Untracked)
+		bytes[i] = float64(r.UntrackedBytes)
+	}
+
+	filesSlope, ok := slope(xs, files)
+	if !ok {
+		return Forecast{}, false
+	}
+	bytesSlope, _ := slope(xs, bytes)
+
+	last := window[len(window)-1]
+	return Forecast{
+		Runs:         len(window),
+		Span:         last.Time.Sub(origin),
+		FilesPerWeek: filesSlope,
+		BytesPerWeek: bytesSlope,
+		Last:         last,
+	}, true
+}
+
+// Project extrapolates the untracked file count and size d after the last
+// run. Projections never go below zero.
+func (f Forecast) Project(d time.Duration) (files, bytes float64) {
+	weeks := float64(d) / float64(week)
+	files = max(0, float64(f.Last.Untracked)+f.FilesPerWeek*weeks)
+	bytes = max(0, float64(f.Last.UntrackedBytes)+f.BytesPerWeek*weeks)
+	return files, bytes
+}
+
+// slope returns the least-squares slope of ys over xs. ok is false when all
+// xs are identical.
+func slope(xs, ys []float64) (float64, bool) {
+	n := float64(len(xs))
+	var sumX, sumY, sumXY, sumXX float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+		sumXY += xs[i] * ys[i]
+		sumXX += xs[i] * xs[i]
+	}
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		return 0, false
+	}
+	return (n*sumXY - sumX*sumY) / denom, true
+}
@@ -0,0 +1,94 @@
+package history
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAppendLoad_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	records, err := Load(path)
+	if err != nil {
+		t.Fatalf("load missing file: %v", err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("expected no records, got %d", len(records))
+	}
+
+	now := time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC)
+	Append(path, Record{Time: now, Mode: "dry-run", Untracked: 3, UntrackedBytes: 300})
+	Append(path, Record{Time: now.Add(week), Mode: "dry-run", Untracked: 5, UntrackedBytes: 500})
+
+	records, err = Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if records[1].Untracked != 5 || !records[1].Time.Equal(now.Add(week)) {
+		t.Errorf("unexpected record: %+v", records[1])
+	}
+}
+
+func TestNewForecast_LinearGrowth(t *testing.T) {
+	base := time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC)
+	var records []Record
+	for i := 0; i < 4; i++ {
+		records = append(records, Record{
+			Time:           base.Add(time.Duration(i) * week),
+			Mode:           "dry-run",
+			Untracked:      10 + 5*i,
+			UntrackedBytes: int64(1000 + 500*i),
+		})
+	}
+
+	f, ok := NewForecast(records)
+	if !ok {
+		t.Fatal("expected a forecast")
+	}
+	if math.Abs(f.FilesPerWeek-5) > 1e-9 {
+		t.Errorf("expected 5 files/week, got %f", f.FilesPerWeek)
+	}
+	if math.Abs(f.BytesPerWeek-500) > 1e-9 {
+		t.Errorf("expected 500 bytes/week, got %f", f.BytesPerWeek)
+	}
+	files, _ := f.Project(2 * week)
+	if math.Abs(files-35) > 1e-9 {
+		t.Errorf("expected 35 projected files, got %f", files)
+	}
+}
+
+func TestNewForecast_RestartsAfterMove(t *testing.T) {
+	base := time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Time: base, Mode: "dry-run", Untracked: 100},
+		{Time: base.Add(week), Mode: "move", Untracked: 200},
+		{Time: base.Add(2 * week), Mode: "dry-run", Untracked: 2},
+		{Time: base.Add(3 * week), Mode: "dry-run", Untracked: 4},
+	}
+
+	f, ok := NewForecast(records)
+	if !ok {
+		t.Fatal("expected a forecast")
+	}
+	if f.Runs != 2 {
+		t.Errorf("expected forecast over 2 runs, got %d", f.Runs)
+	}
+	if math.Abs(f.FilesPerWeek-2) > 1e-9 {
+		t.Errorf("expected 2 files/week, got %f", f.FilesPerWeek)
+	}
+}
+
+func TestNewForecast_NotEnoughData(t *testing.T) {
+	if _, ok := NewForecast(nil); ok {
+		t.Error("expected no forecast without records")
+	}
+	now := time.Now()
+	if _, ok := NewForecast([]Record{{Time: now}, {Time: now}}); ok {
+		t.Error("expected no forecast for runs at identical times")
+	}
+}
@@ -0,0 +1,172 @@
+// Package ignore reads .strayignore files, which list site-specific files
+// in gitignore syntax that are never reported as strays, like scripts,
+// notes, or import staging folders kept in the library.
+package ignore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/goeland86/immich-stray-finder/paths"
+)
+
+// FileName is the name of ignore files. One at the root of the library
+// applies to all of it, one in a directory to what is below that directory.
+const FileName = ".strayignore"
+
+// pattern is one line of an ignore file.
+type pattern struct {
+	// glob is matched against the base name when it has no slash, and
+	// against the path below the ignore file's directory otherwise.
+	glob     string
+	anchored bool
+	negate   bool
+	dirOnly  bool
+}
+
+// match reports whether the path rel below the ignore file's directory,
+// with base name base, matches p.
+func (p pattern) match(rel, base string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if !p.anchored {
+		ok, _ := path.Match(p.glob, base)
+		return ok
+	}
+	return paths.MatchExclusion(p.glob, rel)
+}
+
+// parse reads the patterns of an ignore file, skipping blank lines and
+// comments.
+func parse(data []byte) []pattern {
+	var patterns []pattern
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var p pattern
+		if line[0] == '!' {
+			p.negate, line = true, line[1:]
+		} else if line[0] == '\\' {
+			line = line[1:]
+		}
+		if trimmed, ok := strings.CutSuffix(line, "/"); ok {
+			p.dirOnly, line = true, trimmed
+		}
+		if trimmed, ok := strings.CutPrefix(line, "/"); ok {
+			p.anchored, line = true, trimmed
+		}
+		if strings.Contains(line, "/") {
+			p.anchored = true
+		}
+		if line == "" {
+			continue
+		}
+		p.glob = line
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// Rules are the patterns of the ignore files below a root directory,
+// loaded as the directories holding them are first asked about. A nil
+// *Rules ignores nothing. It is safe for concurrent use.
+type Rules struct {
+	root   string
+	mounts []paths.Mount
+	logger *slog.Logger
+	mu     sync.Mutex
+	// dirs holds the patterns of each directory's ignore file by its
+	// forward-slash path relative to root, "" for root itself; nil for
+	// directories without one.
+	dirs map[string][]pattern
+}
+
+// New returns the rules of the ignore files below root, and in the
+// directories of mounts for the parts of the library kept elsewhere.
+func New(root string, mounts []paths.Mount, logger *slog.Logger) *Rules {
+	return &Rules{root: root, mounts: mounts, logger: logger, dirs: make(map[string][]pattern)}
+}
+
+// patterns returns the patterns of the ignore file in dir, reading it the
+// first time. An unreadable file is logged and ignores nothing.
+func (r *Rules) patterns(dir string) []pattern {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p, ok := r.dirs[dir]; ok {
+		return p
+	}
+	file := filepath.Join(paths.Join(r.root, r.mounts, dir), FileName)
+	data, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrPermission) {
+		r.logger.Warn("cannot read ignore file", "path", file, "error", err)
+	}
+	var p []pattern
+	if err == nil {
+		p = parse(data)
+		r.logger.Debug("loaded ignore file", "path", file, "patterns", len(p))
+	}
+	r.dirs[dir] = p
+	return p
+}
+
+// Match reports whether the file or directory at rel, a forward-slash path
+// relative to the root, is ignored by its own name, assuming the
+// directories above it are not. As in gitignore, the ignore files of
+// deeper directories take precedence, and so do later lines of a file.
+// Ignore files themselves are always ignored.
+func (r *Rules) Match(rel string, isDir bool) bool {
+	if r == nil || rel == "" || rel == "." {
+		return false
+	}
+	base := path.Base(rel)
+	if !isDir && base == FileName {
+		return true
+	}
+	ignored := false
+	dir := ""
+	for {
+		sub := strings.TrimPrefix(rel, dir)
+		sub = strings.TrimPrefix(sub, "/")
+		for _, p := range r.patterns(dir) {
+			if p.match(sub, base, isDir) {
+				ignored = !p.negate
+			}
+		}
+		i := strings.IndexByte(sub, '/')
+		if i < 0 {
+			break
+		}
+		if dir != "" {
+			dir += "/"
+		}
+		dir += sub[:i]
+	}
+	return ignored
+}
+
+// Ignored reports whether the file at rel, a forward-slash path relative to
+// the root, is ignored, either by its own name or because a directory
+// above it is.
+func (r *Rules) Ignored(rel string) bool {
+	if r == nil {
+		return false
+	}
+	for i, c := range rel {
+		if c == '/' && r.Match(rel[:i], true) {
+			return true
+		}
+	}
+	return r.Match(rel, false)
+}
@@ -0,0 +1,51 @@
+package ignore
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "library", "admin"), 0o755)
+	os.WriteFile(filepath.Join(root, FileName), []byte(`# site extras
+*.sh
+/staging/
+library/**/notes.txt
+*.log
+!keep.log
+`), 0o644)
+	os.WriteFile(filepath.Join(root, "library", "admin", FileName), []byte("drafts/\n!run.sh\n"), 0o644)
+	r := New(root, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for rel, want := range map[string]bool{
+		"import.sh":                     true,
+		"library/admin/2024/import.sh":  true,
+		"library/admin/run.sh":          false,
+		"staging/a.jpg":                 true,
+		"library/staging/a.jpg":         false,
+		"library/admin/notes.txt":       true,
+		"notes.txt":                     false,
+		"upload/debug.log":              true,
+		"upload/keep.log":               false,
+		"library/admin/drafts/a.jpg":    true,
+		"library/other/drafts/a.jpg":    false,
+		"library/admin/.strayignore":    true,
+		"library/admin/2024/IMG_01.jpg": false,
+	} {
+		if got := r.Ignored(rel); got != want {
+			t.Errorf("Ignored(%q) = %v, want %v", rel, got, want)
+		}
+	}
+	if !r.Match("staging", true) || r.Match("staging", false) {
+		t.Error("a pattern ending in a slash should only match directories")
+	}
+
+	var none *Rules
+	if none.Ignored("import.sh") {
+		t.Error("nil rules should ignore nothing")
+	}
+}
@@ -0,0 +1,123 @@
+package immich
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+)
+
+// ErrUnauthorized is returned when the server rejects the API key outright.
+var ErrUnauthorized = errors.New("API key rejected by Immich")
+
+// PermissionAll is the scope of an unrestricted API key.
+const PermissionAll = "all"
+
+// Capabilities describes what the configured API key is allowed to do.
+type Capabilities struct {
+	// Permissions lists the key's scopes as reported by /api/api-keys/me.
+	// It is nil when the server does not report them (older Immich versions).
+	Permissions []string
+	// Admin, UserRead, and AssetRead record whether the read-only probes of
+	// the admin user list, the current user, and asset search succeeded.
+	Admin     bool
+	UserRead  bool
+	AssetRead bool
+}
+
+// Has reports whether the key's reported scopes include permission. It
+// returns false when the scopes are unknown.
+func (c *Capabilities) Has(permission string) bool {
+	return slices.Contains(c.Permissions, PermissionAll) || slices.Contains(c.Permissions, permission)
+}
+
+// ProbeCapabilities determines what the API key can do. It only issues read
+// requests: the key's own scopes, where the server reports them, and one
+// minimal request per capability. A 403 marks a capability as missing; a 401
+// fails with ErrUnauthorized.
+func (c *Client) ProbeCapabilities(ctx context.Context) (*Capabilities, error) {
+	caps := &Capabilities{}
+
+	var key struct {
+		Permissions []string `json:"permissions"`
+	}
+	ok, err := c.probe(ctx, http.MethodGet, "/api/api-keys/me", nil, &key)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		caps.Permissions = key.Permissions
+	}
+
+	q := url.Values{}
+	q.Set("page", "1")
+	q.Set("size", "1")
+	if caps.Admin, err = c.probe(ctx, http.MethodGet, "/api/admin/users?"+q.Encode(), nil, nil); err != nil {
+		return nil, err
+	}
+	if caps.UserRead, err = c.probe(ctx, http.MethodGet, "/api/users/me", nil, nil); err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(SearchMetadataRequest{Page: 1, Size: 1})
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+	if caps.AssetRead, err = c.probe(ctx, http.MethodPost, "/api/search/metadata", body, nil); err != nil {
+		return nil, err
+	}
+
+	c.logger.Debug("probed API key capabilities", "permissions", caps.Permissions,
+		"admin", caps.Admin, "user_read", caps.UserRead, "asset_read", caps.AssetRead)
+	return caps, nil
+}
+
+// probe issues a single request and reports whether it succeeded. 403 and
+// 404 count as "not available"; other failures are errors. When out is not
+// nil, a successful response is decoded into it.
+func (c *Client) probe(ctx context.Context, method, path string, body []byte, out any) (bool, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, rd)
+	if err != nil {
+		return false, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("x-api-key", c.apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return false, fmt.Errorf("http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("read response: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return false, ErrUnauthorized
+	case http.StatusForbidden, http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("API returned status %d for %s: %s", resp.StatusCode, path, string(respBody))
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return false, fmt.Errorf("unmarshal %s: %w", path, err)
+		}
+	}
+	return true, nil
+}
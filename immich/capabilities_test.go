@@ -0,0 +1,76 @@
+package immich
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProbeCapabilities_ScopedKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/api-keys/me":
+			w.Write([]byte(`{"id":"k","permissions":["user.read","asset.read"]}`))
+		case "/api/admin/users":
+			w.WriteHeader(http.StatusForbidden)
+		case "/api/users/me":
+			w.Write([]byte(`{"id":"u"}`))
+		case "/api/search/metadata":
+			w.Write([]byte(`{"assets":{}}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	caps, err := NewClient(server.URL, "k", testLogger()).ProbeCapabilities(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if caps.Admin || !caps.UserRead || !caps.AssetRead {
+		t.Errorf("unexpected probe results: %+v", caps)
+	}
+	if !caps.Has("asset.read") || caps.Has("asset.delete") {
+		t.Errorf("unexpected permissions: %v", caps.Permissions)
+	}
+}
+
+func TestProbeCapabilities_OldServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/api-keys/me":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	caps, err := NewClient(server.URL, "k", testLogger()).ProbeCapabilities(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if caps.Permissions != nil {
+		t.Errorf("expected unknown permissions, got %v", caps.Permissions)
+	}
+	if !caps.Admin || !caps.UserRead || !caps.AssetRead {
+		t.Errorf("expected all probes to succeed: %+v", caps)
+	}
+	if caps.Has("asset.read") {
+		t.Error("Has must be false when permissions are unknown")
+	}
+}
+
+func TestProbeCapabilities_Unauthorized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL, "bad", testLogger()).ProbeCapabilities(context.Background())
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+}
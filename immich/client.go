@@ -9,12 +9,21 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/progress"
+	"github.com/goeland86/immich-stray-finder/runstats"
+	"github.com/goeland86/immich-stray-finder/tracing"
 )
 
 const defaultPageSize = 1000
 
+// maxRetries bounds how often a rate-limited request is retried.
+const maxRetries = 5
+
 // ErrNotAdmin is returned when the API key does not have admin privileges.
 var ErrNotAdmin = errors.New("API key does not have admin privileges")
 
@@ -44,7 +53,7 @@ func (c *Client) FetchCurrentUser(ctx context.Context) (*User, error) {
 	}
 	req.Header.Set("x-api-key", c.apiKey)
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("http request: %w", err)
 	}
@@ -68,16 +77,63 @@ func (c *Client) FetchCurrentUser(ctx context.Context) (*User, error) {
 	return &user, nil
 }
 
-// FetchAllUsers returns all users from the admin API.
+// FetchAllUsers returns all users from the admin API, including users that
+// are soft-deleted but not yet purged (their files are still on disk and must
+// not be reported as strays). Large instances may paginate the endpoint, so
+// pages are requested until one comes back short or adds no new users.
 // Returns ErrNotAdmin if the API key lacks admin privileges (403).
 func (c *Client) FetchAllUsers(ctx context.Context) ([]User, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/admin/users", nil)
+	var users []User
+	seen := make(map[string]struct{})
+
+	for page := 1; ; page++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		q := url.Values{}
+		q.Set("withDeleted", "true")
+		q.Set("page", strconv.Itoa(page))
+		q.Set("size", strconv.Itoa(defaultPageSize))
+
+		pageUsers, err := c.fetchUsersPage(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+
+		added := 0
+		for _, u := range pageUsers {
+			if _, dup := seen[u.ID]; dup {
+				continue
+			}
+			seen[u.ID] = struct{}{}
+			users = append(users, u)
+			added++
+		}
+
+		c.logger.Debug("fetched user page", "page", page, "users", len(pageUsers), "new", added)
+
+		// Servers that ignore the pagination parameters return the full list
+		// every time; stop as soon as a page contributes nothing new.
+		if len(pageUsers) < defaultPageSize || added == 0 {
+			break
+		}
+	}
+
+	c.logger.Info("fetched admin user list", "user_count", len(users))
+	return users, nil
+}
+
+// fetchUsersPage requests a single page of the admin user list.
+func (c *Client) fetchUsersPage(ctx context.Context, query url.Values) ([]User, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		c.baseURL+"/api/admin/users?"+query.Encode(), nil)
 	if err != nil {
 		return nil, fmt.Errorf("create request: %w", err)
 	}
 	req.Header.Set("x-api-key", c.apiKey)
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("http request: %w", err)
 	}
@@ -99,23 +155,91 @@ func (c *Client) FetchAllUsers(ctx context.Context) ([]User, error) {
 	if err := json.Unmarshal(body, &users); err != nil {
 		return nil, fmt.Errorf("unmarshal users: %w", err)
 	}
-
-	c.logger.Info("fetched admin user list", "user_count", len(users))
 	return users, nil
 }
 
+// do sends req, retrying when the server answers 429 Too Many Requests.
+// The Retry-After header is honored when present; otherwise the wait doubles
+// from one second. Requests with a body are not retried.
+//
+// Requests that fail outright, or that Immich answers with a server error
+// or a final 429, are counted as API errors. Client errors are not: a 403
+// from an admin endpoint is how a non-admin key is detected.
+func (c *Client) do(req *http.Request) (resp *http.Response, err error) {
+	_, span := tracing.StartKind(req.Context(), req.Method+" "+req.URL.Path, tracing.KindClient,
+		"http.request.method", req.Method, "url.path", req.URL.Path)
+	defer func() {
+		if resp != nil {
+			span.SetAttrs("http.response.status_code", resp.StatusCode)
+			if resp.StatusCode >= 400 {
+				span.SetError(errors.New(resp.Status))
+			}
+		}
+		span.EndErr(&err)
+	}()
+
+	wait := time.Second
+	for attempt := 0; ; attempt++ {
+		runstats.AddAPICall()
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			runstats.AddAPIError()
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= maxRetries || req.Body != nil {
+			if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+				runstats.AddAPIError()
+			}
+			span.SetAttrs("retries", attempt)
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+			wait = time.Duration(secs) * time.Second
+		}
+		c.logger.Warn("rate limited by Immich, retrying", "url", req.URL.Path, "wait", wait, "attempt", attempt+1)
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// FetchLibraries returns all libraries. It needs an admin key.
+func (c *Client) FetchLibraries(ctx context.Context) ([]Library, error) {
+	var libs []Library
+	if err := c.sendJSON(ctx, http.MethodGet, "/api/libraries", nil, &libs); err != nil {
+		return nil, fmt.Errorf("fetch libraries: %w", err)
+	}
+	return libs, nil
+}
+
 // FetchAllAssets collects all asset data needed for directory-aware matching.
 // The Immich v2 search/metadata API is always scoped to the calling user's
 // assets — there is no ownerId filter. This method paginates through all
 // results available to the current API key.
+//
+// The search leaves out hidden assets, like the videos of live photos, so
+// they are searched for separately, and any live photo video still unknown
+// after that is fetched on its own.
 func (c *Client) FetchAllAssets(ctx context.Context) (*AllAssetsResult, error) {
 	result := &AllAssetsResult{
-		AssetPaths: make(map[string]struct{}),
-		AssetIDs:   make(map[string]struct{}),
-		UserIDs:    make(map[string]struct{}),
+		AssetPaths:   make(map[string]struct{}),
+		SidecarPaths: make(map[string]struct{}),
+		AssetIDs:     make(map[string]struct{}),
+		PersonIDs:    make(map[string]struct{}),
+		UserIDs:      make(map[string]struct{}),
 	}
 
-	if err := c.fetchAssetsPage(ctx, result); err != nil {
+	linked := make(map[string]struct{})
+	if err := c.fetchAssetsPage(ctx, result, "", linked); err != nil {
+		return nil, err
+	}
+	if err := c.fetchLivePhotoVideos(ctx, result, linked); err != nil {
 		return nil, err
 	}
 
@@ -127,9 +251,88 @@ func (c *Client) FetchAllAssets(ctx context.Context) (*AllAssetsResult, error) {
 	return result, nil
 }
 
-// fetchAssetsPage paginates through the search endpoint and merges results
-// into the provided AllAssetsResult.
-func (c *Client) fetchAssetsPage(ctx context.Context, result *AllAssetsResult) error {
+// fetchLivePhotoVideos adds the video assets of live photos in linked that
+// result does not hold yet: first with a search for hidden assets, then one
+// by one for servers that do not filter by visibility.
+func (c *Client) fetchLivePhotoVideos(ctx context.Context, result *AllAssetsResult, linked map[string]struct{}) error {
+	missing := func() int {
+		n := 0
+		for id := range linked {
+			if _, ok := result.AssetIDs[id]; !ok {
+				n++
+			}
+		}
+		return n
+	}
+	if missing() == 0 {
+		return nil
+	}
+	if err := c.fetchAssetsPage(ctx, result, "hidden", nil); err != nil {
+		return fmt.Errorf("fetch hidden assets: %w", err)
+	}
+	n := missing()
+	if n == 0 {
+		return nil
+	}
+	c.logger.Info("fetching live photo videos one by one", "videos", n)
+	for id := range linked {
+		if _, ok := result.AssetIDs[id]; ok {
+			continue
+		}
+		var asset Asset
+		if err := c.sendJSON(ctx, http.MethodGet, "/api/assets/"+url.PathEscape(id), nil, &asset); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// The video may be gone, so its file, if any, is a stray.
+			c.logger.Warn("cannot fetch live photo video", "asset_id", id, "error", err)
+			continue
+		}
+		addAsset(result, asset)
+	}
+	return nil
+}
+
+// addAsset merges asset into result. Assets already in it are skipped.
+func addAsset(result *AllAssetsResult, asset Asset) {
+	if asset.ID != "" {
+		if _, ok := result.AssetIDs[asset.ID]; ok {
+			return
+		}
+	}
+	if asset.OriginalPath != "" {
+		result.AssetPaths[asset.OriginalPath] = struct{}{}
+	}
+	if asset.SidecarPath != "" {
+		result.SidecarPaths[asset.SidecarPath] = struct{}{}
+	}
+	if asset.ID != "" {
+		result.AssetIDs[asset.ID] = struct{}{}
+	}
+	if asset.OwnerID != "" {
+		result.UserIDs[asset.OwnerID] = struct{}{}
+	}
+	if asset.OriginalPath != "" && asset.OriginalFileName != "" {
+		f := AssetFile{
+			ID:               asset.ID,
+			OwnerID:          asset.OwnerID,
+			OriginalPath:     asset.OriginalPath,
+			OriginalFileName: asset.OriginalFileName,
+			Checksum:         asset.Checksum,
+		}
+		if asset.ExifInfo != nil {
+			f.Size = asset.ExifInfo.FileSizeInByte
+		}
+		result.Files = append(result.Files, f)
+	}
+}
+
+// fetchAssetsPage paginates through the search endpoint, restricted to the
+// given visibility unless it is empty, and merges results into the provided
+// AllAssetsResult. The live photo videos the assets link to are added to
+// linked, if not nil.
+func (c *Client) fetchAssetsPage(ctx context.Context, result *AllAssetsResult, visibility string, linked map[string]struct{}) error {
+	bar := progress.FromContext(ctx)
 	page := 1
 	for {
 		if err := ctx.Err(); err != nil {
@@ -137,8 +340,10 @@ func (c *Client) fetchAssetsPage(ctx context.Context, result *AllAssetsResult) e
 		}
 
 		reqBody := SearchMetadataRequest{
-			Page: page,
-			Size: defaultPageSize,
+			Page:       page,
+			Size:       defaultPageSize,
+			WithExif:   true,
+			Visibility: visibility,
 		}
 
 		body, err := json.Marshal(reqBody)
@@ -154,7 +359,7 @@ func (c *Client) fetchAssetsPage(ctx context.Context, result *AllAssetsResult) e
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("x-api-key", c.apiKey)
 
-		resp, err := c.httpClient.Do(req)
+		resp, err := c.do(req)
 		if err != nil {
 			return fmt.Errorf("http request page %d: %w", page, err)
 		}
@@ -176,20 +381,16 @@ func (c *Client) fetchAssetsPage(ctx context.Context, result *AllAssetsResult) e
 		}
 
 		for _, asset := range searchResp.Assets.Items {
-			if asset.OriginalPath != "" {
-				result.AssetPaths[asset.OriginalPath] = struct{}{}
-			}
-			if asset.ID != "" {
-				result.AssetIDs[asset.ID] = struct{}{}
-			}
-			if asset.OwnerID != "" {
-				result.UserIDs[asset.OwnerID] = struct{}{}
+			addAsset(result, asset)
+			if linked != nil && asset.LivePhotoVideoID != "" {
+				linked[asset.LivePhotoVideoID] = struct{}{}
 			}
 		}
 
+		bar.Add(len(searchResp.Assets.Items))
 		c.logger.Debug("fetched asset page",
 			"page", page,
-			"count", searchResp.Assets.Count,
+			"assets", searchResp.Assets.Count,
 			"total_paths_so_far", len(result.AssetPaths),
 		)
 
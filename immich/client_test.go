@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
@@ -256,3 +257,172 @@ func TestFetchAllAssets_CollectsMultipleOwners(t *testing.T) {
 		t.Error("missing bob/photo1.jpg")
 	}
 }
+
+func TestFetchAllUsers_Paginates(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("withDeleted") != "true" {
+			t.Errorf("expected withDeleted=true, got %q", r.URL.RawQuery)
+		}
+		var users []User
+		switch r.URL.Query().Get("page") {
+		case "1":
+			for i := 0; i < defaultPageSize; i++ {
+				users = append(users, User{ID: fmt.Sprintf("user-%d", i)})
+			}
+		case "2":
+			users = []User{{ID: "user-last", DeletedAt: strPtr("2024-01-01T00:00:00.000Z")}}
+		}
+		json.NewEncoder(w).Encode(users)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "admin-key", testLogger())
+	users, err := client.FetchAllUsers(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(users) != defaultPageSize+1 {
+		t.Fatalf("expected %d users, got %d", defaultPageSize+1, len(users))
+	}
+	if users[len(users)-1].DeletedAt == nil {
+		t.Error("expected deleted user to carry deletedAt")
+	}
+}
+
+func TestFetchAllUsers_ServerIgnoresPagination(t *testing.T) {
+	callCount := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+		users := make([]User, defaultPageSize)
+		for i := range users {
+			users[i] = User{ID: fmt.Sprintf("user-%d", i)}
+		}
+		json.NewEncoder(w).Encode(users)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "admin-key", testLogger())
+	users, err := client.FetchAllUsers(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(users) != defaultPageSize {
+		t.Errorf("expected %d users, got %d", defaultPageSize, len(users))
+	}
+	if callCount != 2 {
+		t.Errorf("expected 2 API calls, got %d", callCount)
+	}
+}
+
+func TestFetchAllUsers_RetriesRateLimit(t *testing.T) {
+	callCount := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+		if callCount == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		json.NewEncoder(w).Encode([]User{{ID: "user-1"}})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "admin-key", testLogger())
+	users, err := client.FetchAllUsers(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(users) != 1 {
+		t.Errorf("expected 1 user, got %d", len(users))
+	}
+	if callCount != 2 {
+		t.Errorf("expected 2 API calls, got %d", callCount)
+	}
+}
+
+func TestFetchAllAssets_CollectsFileNamesSizesChecksumsAndSidecars(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req SearchMetadataRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.WithExif {
+			t.Error("expected withExif in the search request")
+		}
+		resp := SearchMetadataResponse{
+			Assets: SearchAssets{
+				Count: 2,
+				Items: []Asset{
+					{ID: "a", OwnerID: "u", OriginalPath: "/data/library/u/IMG_1.jpg", OriginalFileName: "IMG_1.jpg",
+						Checksum: "qZk+NkcGgWq6PiVxeFDCbJzQ2J0=", ExifInfo: &ExifInfo{FileSizeInByte: 1234}},
+					{ID: "b", OwnerID: "u", OriginalPath: "/data/library/u/IMG_2.jpg", OriginalFileName: "IMG_2.jpg",
+						SidecarPath: "/data/library/u/IMG_2.jpg.xmp"},
+				},
+			},
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-key", testLogger())
+	result, err := client.FetchAllAssets(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []AssetFile{
+		{ID: "a", OwnerID: "u", OriginalPath: "/data/library/u/IMG_1.jpg", OriginalFileName: "IMG_1.jpg", Size: 1234, Checksum: "qZk+NkcGgWq6PiVxeFDCbJzQ2J0="},
+		{ID: "b", OwnerID: "u", OriginalPath: "/data/library/u/IMG_2.jpg", OriginalFileName: "IMG_2.jpg"},
+	}
+	if len(result.Files) != len(want) {
+		t.Fatalf("expected %d files, got %+v", len(want), result.Files)
+	}
+	for i := range want {
+		if result.Files[i] != want[i] {
+			t.Errorf("file %d: expected %+v, got %+v", i, want[i], result.Files[i])
+		}
+	}
+	if _, ok := result.SidecarPaths["/data/library/u/IMG_2.jpg.xmp"]; !ok || len(result.SidecarPaths) != 1 {
+		t.Errorf("expected the sidecar path of b, got %v", result.SidecarPaths)
+	}
+}
+
+func TestFetchAllAssets_FetchesLivePhotoVideos(t *testing.T) {
+	var fetched []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fetched = append(fetched, r.URL.Path)
+			if r.URL.Path != "/api/assets/w" {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(Asset{ID: "w", OwnerID: "u", OriginalPath: "/data/library/u/IMG_2.mov", OriginalFileName: "IMG_2.mov"})
+			return
+		}
+		var req SearchMetadataRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		items := []Asset{
+			{ID: "a", OwnerID: "u", OriginalPath: "/data/library/u/IMG_1.heic", OriginalFileName: "IMG_1.heic", LivePhotoVideoID: "v"},
+			{ID: "b", OwnerID: "u", OriginalPath: "/data/library/u/IMG_2.heic", OriginalFileName: "IMG_2.heic", LivePhotoVideoID: "w"},
+		}
+		if req.Visibility == "hidden" {
+			items = []Asset{{ID: "v", OwnerID: "u", OriginalPath: "/data/library/u/IMG_1.mov", OriginalFileName: "IMG_1.mov"}}
+		}
+		json.NewEncoder(w).Encode(SearchMetadataResponse{Assets: SearchAssets{Count: len(items), Items: items}})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-key", testLogger())
+	result, err := client.FetchAllAssets(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, p := range []string{"/data/library/u/IMG_1.mov", "/data/library/u/IMG_2.mov"} {
+		if _, ok := result.AssetPaths[p]; !ok {
+			t.Errorf("expected the live photo video %s, got %v", p, result.AssetPaths)
+		}
+	}
+	if len(result.AssetIDs) != 4 || len(result.Files) != 4 {
+		t.Errorf("expected 4 assets, got %v and %d files", result.AssetIDs, len(result.Files))
+	}
+	if len(fetched) != 1 || fetched[0] != "/api/assets/w" {
+		t.Errorf("expected only the video the hidden search missed to be fetched, got %v", fetched)
+	}
+}
@@ -5,12 +5,27 @@ import (
 	"fmt"
 
 	"github.com/jackc/pgx/v5"
+
+	"github.com/goeland86/immich-stray-finder/progress"
+	"github.com/goeland86/immich-stray-finder/runstats"
+	"github.com/goeland86/immich-stray-finder/tracing"
 )
 
-// FetchAllAssetsFromDB queries PostgreSQL directly for all active assets.
+// FetchAllAssetsFromDB queries PostgreSQL directly for all active assets,
+// and the videos of active live photos whatever their own state.
 // This bypasses the Immich API limitation where search/metadata is scoped to
 // the calling user only, allowing true multi-user stray detection in admin mode.
-func FetchAllAssetsFromDB(ctx context.Context, dbURL string) (*AllAssetsResult, error) {
+func FetchAllAssetsFromDB(ctx context.Context, dbURL string) (_ *AllAssetsResult, err error) {
+	scanned := 0
+	ctx, span := tracing.StartKind(ctx, "db.fetch_assets", tracing.KindClient, "db.system", "postgresql")
+	defer func() {
+		if err != nil {
+			runstats.AddDBError()
+		}
+		span.SetAttrs("rows", scanned)
+		span.EndErr(&err)
+	}()
+
 	conn, err := pgx.Connect(ctx, dbURL)
 	if err != nil {
 		return nil, fmt.Errorf("connect to database: %w", err)
@@ -18,23 +33,35 @@ func FetchAllAssetsFromDB(ctx context.Context, dbURL string) (*AllAssetsResult,
 	defer conn.Close(ctx)
 
 	rows, err := conn.Query(ctx,
-		`SELECT id, "ownerId", "originalPath" FROM asset WHERE "deletedAt" IS NULL AND status = 'active'`)
+		`SELECT a.id, a."ownerId", a."originalPath", a."originalFileName", COALESCE(e."fileSizeInByte", 0),
+		        COALESCE(encode(a.checksum, 'base64'), '')
+		 FROM asset a LEFT JOIN asset_exif e ON e."assetId" = a.id
+		 WHERE (a."deletedAt" IS NULL AND a.status = 'active')
+		    OR a.id IN (SELECT l."livePhotoVideoId" FROM asset l
+		                WHERE l."deletedAt" IS NULL AND l.status = 'active' AND l."livePhotoVideoId" IS NOT NULL)`)
 	if err != nil {
 		return nil, fmt.Errorf("query assets: %w", err)
 	}
 	defer rows.Close()
 
 	result := &AllAssetsResult{
-		AssetPaths: make(map[string]struct{}),
-		AssetIDs:   make(map[string]struct{}),
-		UserIDs:    make(map[string]struct{}),
+		AssetPaths:   make(map[string]struct{}),
+		SidecarPaths: make(map[string]struct{}),
+		AssetIDs:     make(map[string]struct{}),
+		PersonIDs:    make(map[string]struct{}),
+		UserIDs:      make(map[string]struct{}),
 	}
 
+	bar := progress.FromContext(ctx)
 	for rows.Next() {
-		var id, ownerID, originalPath string
-		if err := rows.Scan(&id, &ownerID, &originalPath); err != nil {
+		var id, ownerID, originalPath, originalFileName, checksum string
+		var size int64
+		if err := rows.Scan(&id, &ownerID, &originalPath, &originalFileName, &size, &checksum); err != nil {
 			return nil, fmt.Errorf("scan row: %w", err)
 		}
+		runstats.AddDBRow()
+		bar.Add(1)
+		scanned++
 		if originalPath != "" {
 			result.AssetPaths[originalPath] = struct{}{}
 		}
@@ -44,10 +71,161 @@ func FetchAllAssetsFromDB(ctx context.Context, dbURL string) (*AllAssetsResult,
 		if ownerID != "" {
 			result.UserIDs[ownerID] = struct{}{}
 		}
+		if originalPath != "" && originalFileName != "" {
+			result.Files = append(result.Files, AssetFile{
+				ID:               id,
+				OwnerID:          ownerID,
+				OriginalPath:     originalPath,
+				OriginalFileName: originalFileName,
+				Size:             size,
+				Checksum:         checksum,
+			})
+		}
 	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("iterate rows: %w", err)
 	}
+	rows.Close()
 
+	if err := fetchSidecarPaths(ctx, conn, result.SidecarPaths); err != nil {
+		return nil, err
+	}
+	span.SetAttrs("sidecars", len(result.SidecarPaths))
+	if result.ExclusionPatterns, err = fetchExclusionPatterns(ctx, conn); err != nil {
+		return nil, err
+	}
+	if err := fetchPersonIDs(ctx, conn, result.PersonIDs); err != nil {
+		return nil, err
+	}
+	span.SetAttrs("people", len(result.PersonIDs))
 	return result, nil
 }
+
+// fetchPersonIDs adds the IDs of all people to ids.
+func fetchPersonIDs(ctx context.Context, conn *pgx.Conn, ids map[string]struct{}) error {
+	rows, err := conn.Query(ctx, `SELECT id FROM person`)
+	if err != nil {
+		return fmt.Errorf("query people: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return fmt.Errorf("scan row: %w", err)
+		}
+		runstats.AddDBRow()
+		ids[id] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate rows: %w", err)
+	}
+	return nil
+}
+
+// fetchExclusionPatterns returns the exclusion patterns of all libraries,
+// each once.
+func fetchExclusionPatterns(ctx context.Context, conn *pgx.Conn) ([]string, error) {
+	rows, err := conn.Query(ctx,
+		`SELECT DISTINCT unnest("exclusionPatterns") FROM library WHERE "deletedAt" IS NULL`)
+	if err != nil {
+		return nil, fmt.Errorf("query library exclusion patterns: %w", err)
+	}
+	defer rows.Close()
+	var patterns []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		runstats.AddDBRow()
+		patterns = append(patterns, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
+	return patterns, nil
+}
+
+// fetchSidecarPaths adds the XMP sidecar paths of active assets to paths.
+// Immich keeps them in the asset."sidecarPath" column, or in asset_file
+// rows of type sidecar since the column was dropped.
+func fetchSidecarPaths(ctx context.Context, conn *pgx.Conn, paths map[string]struct{}) error {
+	var hasColumn bool
+	if err := conn.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM information_schema.columns
+		 WHERE table_schema = current_schema() AND table_name = 'asset' AND column_name = 'sidecarPath')`).Scan(&hasColumn); err != nil {
+		return fmt.Errorf("query sidecar column: %w", err)
+	}
+	query := `SELECT f.path
+		 FROM asset_file f JOIN asset a ON a.id = f."assetId"
+		 WHERE a."deletedAt" IS NULL AND a.status = 'active' AND f.type::text = 'sidecar'`
+	if hasColumn {
+		query = `SELECT a."sidecarPath"
+		 FROM asset a
+		 WHERE a."deletedAt" IS NULL AND a.status = 'active' AND COALESCE(a."sidecarPath", '') <> ''`
+	}
+	rows, err := conn.Query(ctx, query)
+	if err != nil {
+		return fmt.Errorf("query sidecars: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return fmt.Errorf("scan row: %w", err)
+		}
+		runstats.AddDBRow()
+		paths[p] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate rows: %w", err)
+	}
+	return nil
+}
+
+// FetchDerivativesFromDB queries PostgreSQL for the files Immich generated
+// for active assets: the thumbnails and previews in asset_file, and the
+// encoded videos.
+func FetchDerivativesFromDB(ctx context.Context, dbURL string) (_ []DerivativeFile, err error) {
+	ctx, span := tracing.StartKind(ctx, "db.fetch_derivatives", tracing.KindClient, "db.system", "postgresql")
+	defer func() {
+		if err != nil {
+			runstats.AddDBError()
+		}
+		span.EndErr(&err)
+	}()
+
+	conn, err := pgx.Connect(ctx, dbURL)
+	if err != nil {
+		return nil, fmt.Errorf("connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	rows, err := conn.Query(ctx,
+		`SELECT f."assetId", f.type::text, f.path
+		 FROM asset_file f JOIN asset a ON a.id = f."assetId"
+		 WHERE a."deletedAt" IS NULL AND a.status = 'active' AND f.path <> ''
+		 UNION ALL
+		 SELECT a.id, 'encoded-video', a."encodedVideoPath"
+		 FROM asset a
+		 WHERE a."deletedAt" IS NULL AND a.status = 'active' AND COALESCE(a."encodedVideoPath", '') <> ''`)
+	if err != nil {
+		return nil, fmt.Errorf("query derivatives: %w", err)
+	}
+	defer rows.Close()
+
+	var files []DerivativeFile
+	for rows.Next() {
+		var f DerivativeFile
+		if err := rows.Scan(&f.AssetID, &f.Kind, &f.Path); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		runstats.AddDBRow()
+		files = append(files, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rows: %w", err)
+	}
+	span.SetAttrs("rows", len(files))
+	return files, nil
+}
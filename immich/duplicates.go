@@ -0,0 +1,110 @@
+package immich
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// DuplicateGroup is a set of assets Immich's duplicate detection considers
+// to show the same picture.
+type DuplicateGroup struct {
+	ID     string  `json:"duplicateId"`
+	Assets []Asset `json:"assets"`
+}
+
+// FetchDuplicates returns the calling user's duplicate groups.
+func (c *Client) FetchDuplicates(ctx context.Context) ([]DuplicateGroup, error) {
+	var groups []DuplicateGroup
+	if err := c.sendJSON(ctx, http.MethodGet, "/api/duplicates", nil, &groups); err != nil {
+		return nil, fmt.Errorf("fetch duplicates: %w", err)
+	}
+	return groups, nil
+}
+
+// DuplicateIndex finds assets by content: those of duplicate groups, or
+// every asset for --match-checksums.
+type DuplicateIndex struct {
+	byChecksum map[string]Asset
+	// sizes holds the known file sizes. When some asset's size is unknown,
+	// anySize is set and every file is a candidate.
+	sizes   map[int64]struct{}
+	anySize bool
+}
+
+// NewDuplicateIndex indexes the assets of groups by checksum.
+func NewDuplicateIndex(groups []DuplicateGroup) *DuplicateIndex {
+	x := &DuplicateIndex{byChecksum: make(map[string]Asset), sizes: make(map[int64]struct{})}
+	for _, g := range groups {
+		for _, a := range g.Assets {
+			var size int64
+			if a.ExifInfo != nil {
+				size = a.ExifInfo.FileSizeInByte
+			}
+			x.add(a, size)
+		}
+	}
+	return x
+}
+
+// NewChecksumIndex indexes the originals of files by checksum.
+func NewChecksumIndex(files []AssetFile) *DuplicateIndex {
+	x := &DuplicateIndex{byChecksum: make(map[string]Asset), sizes: make(map[int64]struct{})}
+	for _, f := range files {
+		x.add(Asset{OwnerID: f.OwnerID, OriginalPath: f.OriginalPath, OriginalFileName: f.OriginalFileName, Checksum: f.Checksum}, f.Size)
+	}
+	return x
+}
+
+// add indexes a, whose original has the given size, or 0 if unknown.
+func (x *DuplicateIndex) add(a Asset, size int64) {
+	if a.Checksum == "" {
+		return
+	}
+	x.byChecksum[a.Checksum] = a
+	if size > 0 {
+		x.sizes[size] = struct{}{}
+	} else {
+		x.anySize = true
+	}
+}
+
+// Len returns the number of indexed assets.
+func (x *DuplicateIndex) Len() int {
+	return len(x.byChecksum)
+}
+
+// MayContain reports whether a file of the given size can match an indexed
+// asset, so files of other sizes need not be hashed.
+func (x *DuplicateIndex) MayContain(size int64) bool {
+	if x.anySize {
+		return len(x.byChecksum) > 0
+	}
+	_, ok := x.sizes[size]
+	return ok
+}
+
+// Lookup returns the indexed asset with the given checksum.
+func (x *DuplicateIndex) Lookup(checksum string) (Asset, bool) {
+	a, ok := x.byChecksum[checksum]
+	return a, ok
+}
+
+// FileChecksum computes the checksum Immich records for the file at path:
+// the base64-encoded SHA-1 of its content.
+func FileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,81 @@
+package immich
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetchDuplicates(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/api/duplicates" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(`[{"duplicateId":"g1","assets":[
+			{"id":"a1","originalPath":"/data/library/admin/a.jpg","checksum":"qZk+NkcGgWq6PiVxeFDCbJzQ2J0=","exifInfo":{"fileSizeInByte":3}},
+			{"id":"a2","originalPath":"/data/library/admin/b.jpg","checksum":"other"}]}]`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-key", testLogger())
+	groups, err := client.FetchDuplicates(context.Background())
+	if err != nil {
+		t.Fatalf("FetchDuplicates: %v", err)
+	}
+	if len(groups) != 1 || len(groups[0].Assets) != 2 || groups[0].Assets[0].Checksum == "" {
+		t.Fatalf("got %+v", groups)
+	}
+}
+
+func TestDuplicateIndex(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stray.jpg")
+	os.WriteFile(path, []byte("abc"), 0o644)
+	sum, err := FileChecksum(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SHA-1 of "abc", base64-encoded.
+	if sum != "qZk+NkcGgWq6PiVxeFDCbJzQ2J0=" {
+		t.Errorf("FileChecksum = %q", sum)
+	}
+
+	x := NewDuplicateIndex([]DuplicateGroup{{ID: "g1", Assets: []Asset{
+		{ID: "a1", OriginalPath: "/data/library/admin/a.jpg", Checksum: sum, ExifInfo: &ExifInfo{FileSizeInByte: 3}},
+		{ID: "a2", OriginalPath: "/data/library/admin/b.jpg", Checksum: "other", ExifInfo: &ExifInfo{FileSizeInByte: 5}},
+	}}})
+	if x.Len() != 2 {
+		t.Errorf("Len = %d", x.Len())
+	}
+	if !x.MayContain(3) || x.MayContain(4) {
+		t.Error("MayContain should only accept the indexed sizes")
+	}
+	if a, ok := x.Lookup(sum); !ok || a.ID != "a1" {
+		t.Errorf("Lookup = %+v, %v", a, ok)
+	}
+
+	// An asset without a known size makes every size a candidate.
+	x = NewDuplicateIndex([]DuplicateGroup{{Assets: []Asset{{ID: "a3", Checksum: "x"}}}})
+	if !x.MayContain(4) {
+		t.Error("MayContain(4) = false with an unsized asset")
+	}
+}
+
+func TestChecksumIndex(t *testing.T) {
+	x := NewChecksumIndex([]AssetFile{
+		{OriginalPath: "/data/library/admin/a.jpg", Checksum: "sum-a", Size: 3},
+		{OriginalPath: "/data/library/admin/b.jpg", Size: 5},
+	})
+	if x.Len() != 1 {
+		t.Errorf("Len = %d, want only the asset with a checksum", x.Len())
+	}
+	if !x.MayContain(3) || x.MayContain(5) {
+		t.Error("MayContain should only accept the sizes of assets with checksums")
+	}
+	if a, ok := x.Lookup("sum-a"); !ok || a.OriginalPath != "/data/library/admin/a.jpg" {
+		t.Errorf("Lookup = %+v, %v", a, ok)
+	}
+}
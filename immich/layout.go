@@ -0,0 +1,136 @@
+package immich
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// StorageTemplate is the storage template setting of the server, which
+// decides whether originals are moved from upload/ to library/.
+type StorageTemplate struct {
+	Enabled  bool   `json:"enabled"`
+	Template string `json:"template"`
+}
+
+// templateVars maps the variables of a storage template to patterns of what
+// they render to. Month names are localized, and album names and dates are
+// whatever the album has.
+var templateVars = map[string]string{
+	"y": `\d{4}`, "yy": `\d{2}`,
+	"M": `\d{1,2}`, "MM": `\d{2}`, "MMM": `[^/]+`, "MMMM": `[^/]+`,
+	"W": `\d{1,2}`, "WW": `\d{2}`,
+	"d": `\d{1,2}`, "dd": `\d{2}`,
+	"h": `\d{1,2}`, "hh": `\d{2}`, "H": `\d{1,2}`, "HH": `\d{2}`,
+	"m": `\d{1,2}`, "mm": `\d{2}`,
+	"s": `\d{1,2}`, "ss": `\d{2}`, "SSS": `\d{3}`,
+	"filename": `[^/]+`, "ext": `[^/]+`,
+	"filetype": `(?:IMG|VID)`, "filetypefull": `(?:IMAGE|VIDEO)`,
+	"assetId":      `[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`,
+	"assetIdShort": `[0-9a-f]{12}`,
+	"album":        `[^/]*`,
+}
+
+// templateTag matches a variable or block helper of a storage template.
+var templateTag = regexp.MustCompile(`\{\{\s*([^}]*?)\s*\}\}`)
+
+// Pattern returns a regular expression matching the paths of originals the
+// template puts in place, relative to their owner's directory in library/:
+// the rendered template, a "+1"-style suffix Immich adds when the name is
+// taken, and the file's extension. Conditional blocks, {{#if album}} and
+// the like, match either branch.
+func (t StorageTemplate) Pattern() (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	// open holds, for each block not yet closed, whether it has an else.
+	var open []bool
+	at := 0
+	for _, m := range templateTag.FindAllStringSubmatchIndex(t.Template, -1) {
+		b.WriteString(regexp.QuoteMeta(t.Template[at:m[0]]))
+		at = m[1]
+		tag := t.Template[m[2]:m[3]]
+		switch {
+		case strings.HasPrefix(tag, "#if ") || strings.HasPrefix(tag, "#unless "):
+			b.WriteString("(?:")
+			open = append(open, false)
+		case tag == "else" && len(open) > 0:
+			b.WriteString("|")
+			open[len(open)-1] = true
+		case (tag == "/if" || tag == "/unless") && len(open) > 0:
+			b.WriteString(")")
+			if !open[len(open)-1] {
+				b.WriteString("?")
+			}
+			open = open[:len(open)-1]
+		case strings.HasPrefix(tag, "album-startDate-") || strings.HasPrefix(tag, "album-endDate-"):
+			b.WriteString(`[^/]*`)
+		case templateVars[tag] != "":
+			b.WriteString(templateVars[tag])
+		default:
+			return nil, fmt.Errorf("storage template %q: unsupported {{%s}}", t.Template, tag)
+		}
+	}
+	if len(open) > 0 {
+		return nil, fmt.Errorf("storage template %q: unclosed block", t.Template)
+	}
+	b.WriteString(regexp.QuoteMeta(t.Template[at:]))
+	b.WriteString(`(?:\+\d+)?\.[^/.]+$`)
+	return regexp.Compile(b.String())
+}
+
+// SampleAssets returns up to n of the calling user's assets, for working
+// out the layout of the library from their paths.
+func (c *Client) SampleAssets(ctx context.Context, n int) ([]Asset, error) {
+	var resp SearchMetadataResponse
+	if err := c.sendJSON(ctx, http.MethodPost, "/api/search/metadata", SearchMetadataRequest{Page: 1, Size: n}, &resp); err != nil {
+		return nil, fmt.Errorf("sample assets: %w", err)
+	}
+	return resp.Assets.Items, nil
+}
+
+// FetchStorageTemplate returns the storage template setting of the server.
+// It needs an admin key.
+func (c *Client) FetchStorageTemplate(ctx context.Context) (*StorageTemplate, error) {
+	var config struct {
+		StorageTemplate StorageTemplate `json:"storageTemplate"`
+	}
+	if err := c.sendJSON(ctx, http.MethodGet, "/api/system-config", nil, &config); err != nil {
+		return nil, fmt.Errorf("fetch system config: %w", err)
+	}
+	return &config.StorageTemplate, nil
+}
+
+// MediaPrefix returns the part of originalPath, the path of an asset owned
+// by the user with ID ownerID, before the user's directory in upload/ or in
+// library/, named dirs: the media location Immich stores its paths below,
+// such as "/data/" or "/usr/src/app/upload/". It reports false for paths
+// outside both, like those of external libraries.
+func MediaPrefix(originalPath, ownerID string, dirs ...string) (string, bool) {
+	// Some versions store paths relative to the working directory.
+	s, relative := originalPath, !strings.HasPrefix(originalPath, "/")
+	if relative {
+		s = "/" + s
+	}
+	markers := []string{"/upload/" + ownerID + "/"}
+	for _, dir := range dirs {
+		if dir != "" {
+			markers = append(markers, "/library/"+dir+"/")
+		}
+	}
+	at := -1
+	for _, m := range markers {
+		if i := strings.Index(s, m); i >= 0 && (at < 0 || i < at) {
+			at = i
+		}
+	}
+	if at < 0 {
+		return "", false
+	}
+	prefix := s[:at+1]
+	if relative {
+		prefix = prefix[1:]
+	}
+	return prefix, true
+}
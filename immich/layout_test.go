@@ -0,0 +1,84 @@
+package immich
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMediaPrefix(t *testing.T) {
+	const owner = "0b6f1c1e-5f3a-4c1e-9d4b-3a1f2e9c8d7a"
+	tests := []struct {
+		path   string
+		prefix string
+		ok     bool
+	}{
+		{"/data/library/admin/2024/IMG_0001.jpg", "/data/", true},
+		{"/data/upload/" + owner + "/ab/cd/x.jpg", "/data/", true},
+		{"/usr/src/app/upload/upload/" + owner + "/ab/cd/x.jpg", "/usr/src/app/upload/", true},
+		{"/usr/src/app/upload/library/admin/upload/x.jpg", "/usr/src/app/upload/", true},
+		{"upload/library/admin/2024/x.jpg", "upload/", true},
+		{"library/admin/x.jpg", "", true},
+		{"/mnt/photos/2019/x.jpg", "", false},
+		{"/data/library/bob/x.jpg", "", false},
+	}
+	for _, tt := range tests {
+		prefix, ok := MediaPrefix(tt.path, owner, "admin")
+		if prefix != tt.prefix || ok != tt.ok {
+			t.Errorf("MediaPrefix(%q) = %q, %v, want %q, %v", tt.path, prefix, ok, tt.prefix, tt.ok)
+		}
+	}
+}
+
+func TestSampleAssets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req SearchMetadataRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || r.URL.Path != "/api/search/metadata" || req.Size != 5 {
+			t.Errorf("unexpected request %s %+v (%v)", r.URL.Path, req, err)
+		}
+		json.NewEncoder(w).Encode(SearchMetadataResponse{Assets: SearchAssets{Count: 1, Items: []Asset{{ID: "a", OriginalPath: "/data/library/admin/x.jpg"}}}})
+	}))
+	defer server.Close()
+
+	assets, err := NewClient(server.URL, "test-key", testLogger()).SampleAssets(context.Background(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assets) != 1 || assets[0].OriginalPath != "/data/library/admin/x.jpg" {
+		t.Errorf("unexpected assets: %+v", assets)
+	}
+}
+
+func TestStorageTemplatePattern(t *testing.T) {
+	tests := []struct {
+		template string
+		path     string
+		ok       bool
+	}{
+		{"{{y}}/{{y}}-{{MM}}-{{dd}}/{{filename}}", "2024/2024-03-07/IMG_0001.jpg", true},
+		{"{{y}}/{{y}}-{{MM}}-{{dd}}/{{filename}}", "2024/2024-03-07/IMG_0001+1.jpg", true},
+		{"{{y}}/{{y}}-{{MM}}-{{dd}}/{{filename}}", "2024/03/07/IMG_0001.jpg", false},
+		{"{{y}}/{{y}}-{{MM}}-{{dd}}/{{filename}}", "IMG_0001.jpg", false},
+		{"{{y}}/{{#if album}}{{album}}{{else}}Other/{{MM}}{{/if}}/{{filename}}", "2024/Holidays/x.jpg", true},
+		{"{{y}}/{{#if album}}{{album}}{{else}}Other/{{MM}}{{/if}}/{{filename}}", "2024/Other/03/x.jpg", true},
+		{"{{filetype}}/{{assetIdShort}}", "IMG/0a1b2c3d4e5f.heic", true},
+		{"{{filetype}}/{{assetIdShort}}", "IMG/x.heic", false},
+	}
+	for _, tt := range tests {
+		re, err := StorageTemplate{Template: tt.template}.Pattern()
+		if err != nil {
+			t.Fatalf("Pattern(%q): %v", tt.template, err)
+		}
+		if ok := re.MatchString(tt.path); ok != tt.ok {
+			t.Errorf("Pattern(%q) matches %q = %v, want %v", tt.template, tt.path, ok, tt.ok)
+		}
+	}
+
+	for _, bad := range []string{"{{y}}/{{nope}}", "{{#if album}}{{album}}"} {
+		if _, err := (StorageTemplate{Template: bad}).Pattern(); err == nil {
+			t.Errorf("Pattern(%q) succeeded, want an error", bad)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package immich
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/readonly"
+)
+
+// deviceID identifies assets this tool uploads, for Immich's duplicate
+// detection and for users browsing by device.
+const deviceID = "immich-stray-finder"
+
+// Upload is the result of uploading an asset.
+type Upload struct {
+	ID string `json:"id"`
+	// Status is "created", or "duplicate" when Immich already had an asset
+	// with the same content; ID is then the existing asset.
+	Status string `json:"status"`
+}
+
+// UploadAsset uploads data as a new asset named name. deviceAssetID must be
+// stable for the same content, so repeated uploads are recognized.
+func (c *Client) UploadAsset(ctx context.Context, name string, data []byte, deviceAssetID string, modTime time.Time) (*Upload, error) {
+	if err := readonly.Check("upload asset"); err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	ts := modTime.UTC().Format(time.RFC3339)
+	for _, f := range [][2]string{
+		{"deviceAssetId", deviceAssetID},
+		{"deviceId", deviceID},
+		{"fileCreatedAt", ts},
+		{"fileModifiedAt", ts},
+		{"filename", name},
+	} {
+		if err := mw.WriteField(f[0], f[1]); err != nil {
+			return nil, fmt.Errorf("build upload: %w", err)
+		}
+	}
+	part, err := mw.CreateFormFile("assetData", name)
+	if err != nil {
+		return nil, fmt.Errorf("build upload: %w", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		return nil, fmt.Errorf("build upload: %w", err)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("build upload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/assets", &body)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	var up Upload
+	if err := c.send(req, &up, http.StatusOK, http.StatusCreated); err != nil {
+		return nil, fmt.Errorf("upload %s: %w", name, err)
+	}
+	return &up, nil
+}
+
+// SetAssetDescription sets the description shown under an asset.
+func (c *Client) SetAssetDescription(ctx context.Context, id, description string) error {
+	if err := readonly.Check("update asset"); err != nil {
+		return err
+	}
+	body := map[string]string{"description": description}
+	if err := c.sendJSON(ctx, http.MethodPut, "/api/assets/"+url.PathEscape(id), body, nil); err != nil {
+		return fmt.Errorf("set description of asset %s: %w", id, err)
+	}
+	return nil
+}
+
+// AssetState is what the review cares about of an asset.
+type AssetState struct {
+	ID         string `json:"id"`
+	IsFavorite bool   `json:"isFavorite"`
+	IsTrashed  bool   `json:"isTrashed"`
+}
+
+// FetchAssetState returns the state of the asset id, or nil when Immich no
+// longer has it or the API key cannot see it.
+func (c *Client) FetchAssetState(ctx context.Context, id string) (*AssetState, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/assets/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("x-api-key", c.apiKey)
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadRequest, http.StatusNotFound:
+		// Immich answers 400 for assets that do not exist or the key has
+		// no access to.
+		return nil, nil
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("fetch asset %s: API returned status %d: %s", id, resp.StatusCode, msg)
+	}
+	var st AssetState
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return nil, fmt.Errorf("fetch asset %s: decode response: %w", id, err)
+	}
+	return &st, nil
+}
+
+// Album is an Immich album.
+type Album struct {
+	ID   string `json:"id"`
+	Name string `json:"albumName"`
+}
+
+// EnsureAlbum returns the ID of the calling user's album named name,
+// creating it with description if it does not exist.
+func (c *Client) EnsureAlbum(ctx context.Context, name, description string) (string, error) {
+	var albums []Album
+	if err := c.sendJSON(ctx, http.MethodGet, "/api/albums", nil, &albums); err != nil {
+		return "", fmt.Errorf("list albums: %w", err)
+	}
+	for _, a := range albums {
+		if a.Name == name {
+			return a.ID, nil
+		}
+	}
+
+	if err := readonly.Check("create album"); err != nil {
+		return "", err
+	}
+	var created Album
+	body := map[string]string{"albumName": name, "description": description}
+	if err := c.sendJSON(ctx, http.MethodPost, "/api/albums", body, &created); err != nil {
+		return "", fmt.Errorf("create album %q: %w", name, err)
+	}
+	c.logger.Info("created album", "name", name, "id", created.ID)
+	return created.ID, nil
+}
+
+// AddToAlbum adds assets to an album. Assets already in it are ignored.
+func (c *Client) AddToAlbum(ctx context.Context, albumID string, assetIDs []string) error {
+	if err := readonly.Check("add assets to album"); err != nil {
+		return err
+	}
+	body := map[string][]string{"ids": assetIDs}
+	if err := c.sendJSON(ctx, http.MethodPut, "/api/albums/"+url.PathEscape(albumID)+"/assets", body, nil); err != nil {
+		return fmt.Errorf("add assets to album %s: %w", albumID, err)
+	}
+	return nil
+}
+
+// EnsureTag returns the ID of the tag with the given value (which may be a
+// hierarchy like "parent/child"), creating it if needed.
+func (c *Client) EnsureTag(ctx context.Context, value string) (string, error) {
+	if err := readonly.Check("create tag"); err != nil {
+		return "", err
+	}
+	var tags []struct {
+		ID    string `json:"id"`
+		Value string `json:"value"`
+	}
+	body := map[string][]string{"tags": {value}}
+	if err := c.sendJSON(ctx, http.MethodPut, "/api/tags", body, &tags); err != nil {
+		return "", fmt.Errorf("upsert tag %q: %w", value, err)
+	}
+	for _, t := range tags {
+		if t.Value == value {
+			return t.ID, nil
+		}
+	}
+	return "", fmt.Errorf("upsert tag %q: not in response", value)
+}
+
+// TagAssets adds a tag to assets.
+func (c *Client) TagAssets(ctx context.Context, tagID string, assetIDs []string) error {
+	if err := readonly.Check("tag assets"); err != nil {
+		return err
+	}
+	body := map[string][]string{"ids": assetIDs}
+	if err := c.sendJSON(ctx, http.MethodPut, "/api/tags/"+url.PathEscape(tagID)+"/assets", body, nil); err != nil {
+		return fmt.Errorf("tag assets: %w", err)
+	}
+	return nil
+}
+
+// sendJSON sends in (if not nil) as a JSON body to path and decodes the
+// response into out (if not nil). Any 2xx status is success.
+func (c *Client) sendJSON(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("marshal request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.send(req, out)
+}
+
+// send authenticates and sends req and decodes a JSON response into out,
+// if not nil. The response status must be one of ok, or any 2xx status
+// when ok is empty.
+func (c *Client) send(req *http.Request, out any, ok ...int) error {
+	req.Header.Set("x-api-key", c.apiKey)
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	good := resp.StatusCode/100 == 2
+	if len(ok) > 0 {
+		good = false
+		for _, code := range ok {
+			good = good || resp.StatusCode == code
+		}
+	}
+	if !good {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package immich
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUploadAsset(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/assets" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("x-api-key") != "test-key" {
+			t.Errorf("unexpected api key: %s", r.Header.Get("x-api-key"))
+		}
+		if got := r.FormValue("deviceAssetId"); got != "dev-1" {
+			t.Errorf("deviceAssetId = %q", got)
+		}
+		if got := r.FormValue("deviceId"); got != deviceID {
+			t.Errorf("deviceId = %q", got)
+		}
+		if got := r.FormValue("fileCreatedAt"); got != "2024-01-02T03:04:05Z" {
+			t.Errorf("fileCreatedAt = %q", got)
+		}
+		f, hdr, err := r.FormFile("assetData")
+		if err != nil {
+			t.Fatalf("assetData: %v", err)
+		}
+		data, _ := io.ReadAll(f)
+		if hdr.Filename != "preview.jpg" || string(data) != "jpeg" {
+			t.Errorf("assetData = %s %q", hdr.Filename, data)
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(Upload{ID: "asset-1", Status: "created"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-key", testLogger())
+	up, err := client.UploadAsset(context.Background(), "preview.jpg", []byte("jpeg"), "dev-1",
+		time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("UploadAsset: %v", err)
+	}
+	if up.ID != "asset-1" || up.Status != "created" {
+		t.Errorf("got %+v", up)
+	}
+}
+
+func TestEnsureAlbum(t *testing.T) {
+	var created bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/albums":
+			json.NewEncoder(w).Encode([]Album{{ID: "a1", Name: "Holidays"}})
+		case r.Method == http.MethodPost && r.URL.Path == "/api/albums":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["albumName"] != "Stray review" {
+				t.Errorf("albumName = %q", body["albumName"])
+			}
+			created = true
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(Album{ID: "a2", Name: body["albumName"]})
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, "test-key", testLogger())
+
+	id, err := client.EnsureAlbum(context.Background(), "Holidays", "")
+	if err != nil || id != "a1" || created {
+		t.Errorf("existing album: id %q, created %v, err %v", id, created, err)
+	}
+	id, err = client.EnsureAlbum(context.Background(), "Stray review", "previews")
+	if err != nil || id != "a2" || !created {
+		t.Errorf("new album: id %q, created %v, err %v", id, created, err)
+	}
+}
+
+func TestEnsureTagAndTagAssets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/api/tags":
+			json.NewEncoder(w).Encode([]map[string]string{{"id": "t1", "value": "stray-review"}})
+		case r.Method == http.MethodPut && r.URL.Path == "/api/tags/t1/assets":
+			var body map[string][]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if len(body["ids"]) != 2 {
+				t.Errorf("ids = %v", body["ids"])
+			}
+			json.NewEncoder(w).Encode([]any{})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, "test-key", testLogger())
+
+	id, err := client.EnsureTag(context.Background(), "stray-review")
+	if err != nil || id != "t1" {
+		t.Fatalf("EnsureTag = %q, %v", id, err)
+	}
+	if err := client.TagAssets(context.Background(), id, []string{"x", "y"}); err != nil {
+		t.Errorf("TagAssets: %v", err)
+	}
+	if err := client.TagAssets(context.Background(), "missing", []string{"x"}); err == nil {
+		t.Error("expected an error for a failed request")
+	}
+}
+
+func TestFetchAssetState(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/assets/fav":
+			json.NewEncoder(w).Encode(map[string]any{"id": "fav", "isFavorite": true, "isTrashed": false})
+		case "/api/assets/gone":
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Not found or no asset.read access"})
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, "test-key", testLogger())
+
+	st, err := client.FetchAssetState(context.Background(), "fav")
+	if err != nil || st == nil || !st.IsFavorite || st.IsTrashed {
+		t.Errorf("favorite: %+v, %v", st, err)
+	}
+	if st, err := client.FetchAssetState(context.Background(), "gone"); err != nil || st != nil {
+		t.Errorf("deleted: %+v, %v", st, err)
+	}
+	if _, err := client.FetchAssetState(context.Background(), "broken"); err == nil {
+		t.Error("expected an error for a server error")
+	}
+}
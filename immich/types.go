@@ -7,6 +7,9 @@ type SearchMetadataRequest struct {
 	Page     int  `json:"page"`
 	Size     int  `json:"size"`
 	WithExif bool `json:"withExif,omitempty"`
+	// Visibility restricts the search to assets with this visibility, e.g.
+	// "hidden" for the videos of live photos, which are left out otherwise.
+	Visibility string `json:"visibility,omitempty"`
 }
 
 // SearchMetadataResponse wraps the paginated response from the search endpoint.
@@ -29,6 +32,20 @@ type Asset struct {
 	OriginalPath     string `json:"originalPath"`
 	OriginalFileName string `json:"originalFileName"`
 	Type             string `json:"type"`
+	// Checksum is the base64-encoded SHA-1 of the original file.
+	Checksum string `json:"checksum,omitempty"`
+	// SidecarPath is the asset's XMP sidecar, for the Immich versions
+	// whose API returns it.
+	SidecarPath string `json:"sidecarPath,omitempty"`
+	// LivePhotoVideoID is the hidden video asset of a live photo.
+	LivePhotoVideoID string    `json:"livePhotoVideoId,omitempty"`
+	ExifInfo         *ExifInfo `json:"exifInfo,omitempty"`
+}
+
+// ExifInfo is the subset of an asset's EXIF data the tool uses. It is only
+// returned when the search request sets WithExif.
+type ExifInfo struct {
+	FileSizeInByte int64 `json:"fileSizeInByte"`
 }
 
 // User represents a user returned by the Immich API.
@@ -36,14 +53,60 @@ type User struct {
 	ID           string `json:"id"`
 	Name         string `json:"name"`
 	StorageLabel string `json:"storageLabel"`
+	// DeletedAt is set for soft-deleted users awaiting removal. Their files
+	// remain on disk until Immich purges them.
+	DeletedAt *string `json:"deletedAt,omitempty"`
 }
 
 // AllAssetsResult bundles the three sets needed for directory-aware matching.
 type AllAssetsResult struct {
 	// AssetPaths contains all originalPath values from Immich assets.
 	AssetPaths map[string]struct{}
+	// SidecarPaths contains the paths of the assets' XMP sidecars, which
+	// sit next to the originals.
+	SidecarPaths map[string]struct{}
 	// AssetIDs contains all asset UUIDs.
 	AssetIDs map[string]struct{}
+	// PersonIDs contains the UUIDs of the people recognized in the assets,
+	// whose face thumbnails are stored under thumbs/.
+	PersonIDs map[string]struct{}
 	// UserIDs contains all known user UUIDs.
 	UserIDs map[string]struct{}
+	// Files describes each asset's original, for matching strays by file
+	// name when their path does not match.
+	Files []AssetFile
+	// ExclusionPatterns are the exclusion patterns of Immich's libraries,
+	// globs of files Immich deliberately ignores.
+	ExclusionPatterns []string
+}
+
+// Library is an Immich library, as returned by GET /api/libraries.
+type Library struct {
+	ID                string   `json:"id"`
+	Name              string   `json:"name"`
+	ImportPaths       []string `json:"importPaths"`
+	ExclusionPatterns []string `json:"exclusionPatterns"`
+}
+
+// AssetFile identifies an asset's original file by owner, name, size, and
+// content.
+type AssetFile struct {
+	ID               string
+	OwnerID          string
+	OriginalPath     string
+	OriginalFileName string
+	// Size is the file size in bytes, or 0 when Immich has not extracted it.
+	Size int64
+	// Checksum is the base64-encoded SHA-1 of the original file.
+	Checksum string
+}
+
+// DerivativeFile is a file Immich generated for an asset: a thumbnail,
+// preview, or encoded video.
+type DerivativeFile struct {
+	AssetID string
+	// Kind is the asset_file type, e.g. thumbnail or preview, or
+	// encoded-video.
+	Kind string
+	Path string
 }
@@ -0,0 +1,114 @@
+// Package logfile writes logs to a file that rotates by size, so a daemon
+// on a NAS does not depend on an external logrotate.
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Writer appends to a log file. Before a write would take the file past
+// its size limit, the file is renamed to NAME.1, an existing NAME.1 to
+// NAME.2, and so on, dropping the oldest beyond the number of backups, and
+// a new file is started. It is safe for concurrent use.
+type Writer struct {
+	path    string
+	maxSize int64
+	backups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// Open opens the log file at path for appending, creating it and its
+// directory if needed. maxSize is the size in bytes at which the file is
+// rotated, or 0 for no limit; backups is the number of rotated files kept.
+func Open(path string, maxSize int64, backups int) (*Writer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create log directory: %w", err)
+	}
+	w := &Writer{path: path, maxSize: maxSize, backups: backups}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("stat log file: %w", err)
+	}
+	w.f, w.size = f, info.Size()
+	return nil
+}
+
+// Write appends p to the log file, rotating it first if p would not fit.
+// A single write larger than the limit is not split.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		// A failed rotation left no file open; try again.
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups up by one and starts a new file.
+func (w *Writer) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return fmt.Errorf("close log file: %w", err)
+	}
+	w.f = nil
+	if w.backups == 0 {
+		if err := os.Remove(w.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove log file: %w", err)
+		}
+		return w.open()
+	}
+	for i := w.backups - 1; i >= 1; i-- {
+		err := os.Rename(backup(w.path, i), backup(w.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("rotate log file: %w", err)
+		}
+	}
+	if err := os.Rename(w.path, backup(w.path, 1)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("rotate log file: %w", err)
+	}
+	return w.open()
+}
+
+// backup returns the name of the i-th rotated file.
+func backup(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// Close closes the log file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
@@ -0,0 +1,84 @@
+package logfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func read(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "strays.log")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// An earlier run's log is appended to.
+	if err := os.WriteFile(path, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n", "a line longer than the limit\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string]string{
+		"strays.log":   "a line longer than the limit\n",
+		"strays.log.1": "four\nfive\n",
+		"strays.log.2": "two\nthree\n",
+	} {
+		if got := read(t, filepath.Join(filepath.Dir(path), name)); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("more backups than configured are kept: %v", err)
+	}
+}
+
+func TestNoBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "strays.log")
+	w, err := Open(path, 8, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.Write([]byte("first\n"))
+	w.Write([]byte("second\n"))
+	if got := read(t, path); got != "second\n" {
+		t.Errorf("log = %q", got)
+	}
+	matches, _ := filepath.Glob(path + ".*")
+	if len(matches) != 0 {
+		t.Errorf("backups kept: %v", matches)
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "strays.log")
+	w, err := Open(path, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for range 100 {
+		w.Write([]byte("line\n"))
+	}
+	if got := read(t, path); got != strings.Repeat("line\n", 100) {
+		t.Errorf("log has %d bytes", len(got))
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAgeFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    time.Duration
+		wantErr string
+	}{
+		{"days", []string{"--min-age=90d"}, 90 * 24 * time.Hour, ""},
+		{"weeks", []string{"--older-than=2w"}, 14 * 24 * time.Hour, ""},
+		{"hours", []string{"--min-age=36h"}, 36 * time.Hour, ""},
+		{"go duration", []string{"--older-than=1h30m"}, 90 * time.Minute, ""},
+		{"unset", nil, 0, ""},
+		{"not a number of days", []string{"--min-age=xd"}, 0, "want e.g. 90d"},
+		{"unknown unit", []string{"--older-than=3y"}, 0, "want e.g. 90d"},
+		{"empty", []string{"--min-age="}, 0, "want e.g. 90d"},
+		{"same value both ways", []string{"--min-age=14d", "--older-than=2w"}, 14 * 24 * time.Hour, ""},
+		{"repeated flag", []string{"--min-age=1h", "--min-age=2h"}, 2 * time.Hour, ""},
+		{"conflict", []string{"--min-age=1h", "--older-than=90d"}, 0, "conflicts with --min-age=1h0m0s"},
+		{"conflict the other way", []string{"--older-than=90d", "--min-age=1h"}, 0, "conflicts with --older-than=2160h0m0s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config
+			fs := newFlagSet("scan", &cfg)
+			addRunFlags(fs, &cfg)
+			fs.SetOutput(io.Discard)
+			err := fs.Parse(tt.args)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one mentioning %q", err, tt.wantErr)
+			case tt.wantErr == "" && cfg.minAge != tt.want:
+				t.Errorf("minAge = %s, want %s", cfg.minAge, tt.want)
+			}
+		})
+	}
+}
+
+func TestAgeFlagWrapsParseError(t *testing.T) {
+	var d time.Duration
+	err := ageFlag{d: &d}.Set("xd")
+	if !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("Set(%q) error = %v, want the parse error wrapped", "xd", err)
+	}
+}
@@ -3,89 +3,630 @@ package matcher
 import (
 	"log/slog"
 	"path"
-	"regexp"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/ignore"
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/scanner"
+)
+
+// Category describes why a file counts as untracked.
+type Category string
+
+const (
+	// CategoryOriginal is a file under library/ or upload/ whose path is not
+	// an asset's originalPath.
+	CategoryOriginal Category = "original"
+	// CategoryDerivative is a thumbnail or encoded video whose asset UUID is
+	// unknown.
+	CategoryDerivative Category = "derivative"
+	// CategoryProfile is a profile image of an unknown user.
+	CategoryProfile Category = "profile"
+	// CategoryUnmanaged is a file in a top-level directory Immich does not
+	// manage.
+	CategoryUnmanaged Category = "unmanaged"
+	// CategoryBackup is an Immich database dump outside backups/, e.g. left
+	// behind by a manual copy or a changed backup location.
+	CategoryBackup Category = "backup"
+	// CategorySidecar is a sidecar-style file, like an XMP sidecar, next to
+	// a tracked original that Immich has no record of it for.
+	CategorySidecar Category = "sidecar"
+	// CategoryJunk is litter left by operating systems and NAS software,
+	// like .DS_Store files or Synology's @eaDir thumbnails, which never
+	// holds anything of value.
+	CategoryJunk Category = "junk"
+	// CategoryTakeout is what a Google Takeout import left next to a
+	// tracked original: the JSON file of its metadata, or an edited copy
+	// Immich did not import.
+	CategoryTakeout Category = "takeout"
+	// CategoryCompanion is a RAW file next to a tracked JPEG of the same
+	// name, or a JPEG next to a tracked RAW file: the other half of a
+	// RAW+JPEG pair the camera wrote.
+	CategoryCompanion Category = "companion"
 )
 
-// uuidRegex matches a standard UUID (8-4-4-4-12 hex digits).
-var uuidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+// Categories lists every category.
+var Categories = []Category{CategoryOriginal, CategoryDerivative, CategoryProfile, CategoryUnmanaged, CategoryBackup, CategorySidecar, CategoryJunk, CategoryTakeout, CategoryCompanion}
 
 // UntrackedFile represents a file on disk that is not tracked by Immich.
 type UntrackedFile struct {
 	// RelPath is the relative path of the untracked file (forward-slash separated).
 	RelPath string
+	// Category is the kind of stray.
+	Category Category
+	// Size is the file size in bytes.
+	Size int64
+	// ModTime is the file's last modification time.
+	ModTime time.Time
+	// Dev is the ID of the device holding the file.
+	Dev uint64
+	// ProbablyTrackedAs is the originalPath of an asset with the same owner,
+	// original file name, and size, when the file-name fallback found one.
+	// Such files are usually copies left behind by a storage template change.
+	ProbablyTrackedAs string
+	// DuplicateOf is the library-relative path of an asset in one of
+	// Immich's duplicate groups with the same content, when
+	// --immich-duplicates or --match-checksums found one.
+	DuplicateOf string
+	// MissingOriginalOf is the library-relative path of an asset with the
+	// same content whose original is missing from disk, when
+	// --immich-duplicates or --match-checksums found one. The file can
+	// stand in for it.
+	MissingOriginalOf string
+	// UnknownContent is set by --match-checksums on originals identical to
+	// no asset, which need a look before they are deleted.
+	UnknownContent bool
+	// CopyOf is the relative path of another stray with the same content
+	// listed before this one, when --group-identical found one. The first
+	// stray of a group has none.
+	CopyOf string
+	// SidecarOf is the relative path of the tracked original a sidecar
+	// belongs to, for CategorySidecar.
+	SidecarOf string
+	// TakeoutOf is the relative path of the tracked original a Google
+	// Takeout leftover belongs to, for CategoryTakeout.
+	TakeoutOf string
+	// CompanionOf is the relative path of the tracked other half of a
+	// RAW+JPEG pair, for CategoryCompanion.
+	CompanionOf string
+	// Probe is "ok" or "corrupt" when --probe tried to decode the file,
+	// and ProbeError why a corrupt one did not decode.
+	Probe      string
+	ProbeError string
+}
+
+// FileNameKey identifies an asset by owner directory, original file name,
+// and size, for the file-name fallback.
+type FileNameKey struct {
+	Owner string
+	Name  string
+	Size  int64
 }
 
 // MatchContext holds all the data needed for directory-aware matching.
 type MatchContext struct {
-	// AssetPaths contains all originalPath values (prefix-stripped) from Immich.
+	// AssetPaths contains the match keys of all originalPath values from
+	// Immich, as produced by Normalizer.AssetKey.
 	AssetPaths map[string]struct{}
+	// SidecarPaths contains the match keys of the assets' XMP sidecars,
+	// which are tracked like originals. Nil means none are known.
+	SidecarPaths map[string]struct{}
 	// AssetIDs contains all known asset UUIDs.
 	AssetIDs map[string]struct{}
+	// PersonIDs contains all known person UUIDs, for the face thumbnails
+	// under thumbs/. Nil means none are known.
+	PersonIDs map[string]struct{}
 	// UserIDs contains all known user UUIDs.
 	UserIDs map[string]struct{}
+	// Normalizer converts disk paths into the same key space as AssetPaths.
+	// Nil means disk paths are compared as-is.
+	Normalizer *paths.Normalizer
+	// Workers is the number of goroutines FindUntracked uses. Zero means
+	// runtime.GOMAXPROCS(0).
+	Workers int
+	// FileNames enables the file-name fallback for strays under library/.
+	// It maps each asset's FileNameKey to its originalPath; fill it with
+	// AddFileName. Nil disables the fallback.
+	FileNames map[FileNameKey]string
+	// SidecarExts lists the extensions, with the dot, of sidecar-style files
+	// paired with an adjacent tracked original: "photo.jpg.xmp" or
+	// "photo.xmp" next to "photo.jpg". Call IndexSidecars after filling
+	// AssetPaths. Empty disables the pairing.
+	SidecarExts []string
+	// Takeout pairs Google Takeout leftovers, see takeoutOf, with the
+	// tracked originals in AssetPaths.
+	Takeout bool
+	// Exclusions are the exclusion patterns of Immich's libraries. Strays
+	// under library/ and upload/ matching one, with the Normalizer's prefix
+	// in front as in Immich, are files Immich deliberately ignores and are
+	// not reported.
+	Exclusions []string
+
+	// MinAge skips strays modified less than this long before they are
+	// matched, like uploads Immich has not registered yet.
+	MinAge time.Duration
+	// UploadGrace is the MinAge of strays named like uploads in progress,
+	// see IsUploadStaging, when it is longer.
+	UploadGrace time.Duration
+	// IgnoreJunk leaves junk files, see IsJunk, out of the strays instead
+	// of reporting them as CategoryJunk.
+	IgnoreJunk bool
+	// Ignore holds the .strayignore rules of the library. Strays they
+	// ignore are not reported; the scanner already skips most of them.
+	Ignore *ignore.Rules
+	// Filter limits matching to the paths of --include and --exclude;
+	// other files are neither tracked nor strays.
+	Filter *paths.Filter
+	// OnlyExts, when not empty, limits the strays reported to files with
+	// one of these extensions, and SkipExts leaves out files with one of
+	// those. Both are compared case-insensitively and start with a dot.
+	OnlyExts []string
+	SkipExts []string
+
+	// excluded counts the strays skipped because of Exclusions, recent
+	// those skipped because of MinAge, junk those skipped because of
+	// IgnoreJunk, ignored those skipped because of Ignore, and
+	// filteredExt those skipped because of OnlyExts and SkipExts.
+	excluded    atomic.Int64
+	recent      atomic.Int64
+	junk        atomic.Int64
+	ignored     atomic.Int64
+	filteredExt atomic.Int64
+	// stems maps the match key of each original without its extension to
+	// that extension, for pairing "photo.xmp" with "photo.jpg".
+	stems map[string]string
+}
+
+// AddFileName records an asset for the file-name fallback. ownerDir is the
+// owner's directory under library/: the storage label, or the user ID when
+// no label is set. Assets of unknown size are skipped, since a name alone is
+// too weak a match.
+func (m *MatchContext) AddFileName(ownerDir, originalFileName, originalPath string, size int64) {
+	if size <= 0 || ownerDir == "" || originalFileName == "" {
+		return
+	}
+	if m.FileNames == nil {
+		m.FileNames = make(map[FileNameKey]string)
+	}
+	m.FileNames[FileNameKey{
+		Owner: m.Normalizer.Key(ownerDir),
+		Name:  m.Normalizer.Key(originalFileName),
+		Size:  size,
+	}] = originalPath
+}
+
+// probableMatch returns the originalPath of an asset the untracked library
+// file f probably duplicates, or "".
+func (m *MatchContext) probableMatch(f scanner.File) string {
+	if m.FileNames == nil || paths.TopDir(f.RelPath) != "library" {
+		return ""
+	}
+	return m.FileNames[FileNameKey{
+		Owner: m.Normalizer.Key(paths.Owner(f.RelPath)),
+		Name:  m.Normalizer.Key(path.Base(f.RelPath)),
+		Size:  f.Size,
+	}]
+}
+
+// IndexSidecars prepares the sidecar and Takeout pairing for the originals
+// in AssetPaths. It does nothing when SidecarExts is empty and Takeout is
+// unset.
+func (m *MatchContext) IndexSidecars() {
+	if len(m.SidecarExts) == 0 && !m.Takeout {
+		return
+	}
+	m.stems = make(map[string]string, len(m.AssetPaths))
+	for key := range m.AssetPaths {
+		ext := path.Ext(key)
+		if ext == "" {
+			continue
+		}
+		stem := strings.TrimSuffix(key, ext)
+		// Two originals differing only in extension share the sidecar;
+		// pick one deterministically.
+		if prev, ok := m.stems[stem]; !ok || ext < prev {
+			m.stems[stem] = ext
+		}
+	}
+}
+
+// sidecarOf returns the relative path of the tracked original the untracked
+// library file f sits next to as a sidecar, or "".
+func (m *MatchContext) sidecarOf(f scanner.File) string {
+	ext := path.Ext(f.RelPath)
+	if ext == "" || !slices.ContainsFunc(m.SidecarExts, func(e string) bool { return strings.EqualFold(e, ext) }) {
+		return ""
+	}
+	return m.pairedOriginal(strings.TrimSuffix(f.RelPath, ext))
+}
+
+// pairedOriginal returns the relative path of the tracked original base
+// names, either in full or without its extension, or "".
+func (m *MatchContext) pairedOriginal(base string) string {
+	key := m.Normalizer.Key(base)
+	if _, ok := m.AssetPaths[key]; ok {
+		return base
+	}
+	if orig, ok := m.stems[key]; ok {
+		return base + orig
+	}
+	return ""
+}
+
+// takeoutMetadata is what Google Takeout appends to a photo's name for the
+// JSON file of its metadata, before ".json". Long names cut it short.
+const takeoutMetadata = ".supplemental-metadata"
+
+// takeoutEdited are the suffixes Google Takeout appends to the names of
+// edited copies, in the languages it is most often exported in.
+var takeoutEdited = []string{"-edited", "-bearbeitet", "-modifié", "-editado", "-modificato", "-bewerkt"}
+
+// takeoutOf returns the relative path of the tracked original the
+// untracked library file f was exported with by Google Takeout, or "". It
+// recognizes the JSON metadata of IMG_0001.jpg, named IMG_0001.jpg.json,
+// IMG_0001.json, or IMG_0001.jpg.supplemental-metadata.json, and of its
+// namesake IMG_0001(1).jpg, named IMG_0001.jpg(1).json, as well as edited
+// copies like IMG_0001-edited.jpg, whatever the original's extension.
+func (m *MatchContext) takeoutOf(f scanner.File) string {
+	if !m.Takeout {
+		return ""
+	}
+	dir, name := path.Split(f.RelPath)
+	if base, ok := strings.CutSuffix(name, ".json"); ok {
+		dup := ""
+		if i := strings.LastIndexByte(base, '('); i > 0 && strings.HasSuffix(base, ")") && isDigits(base[i+1:len(base)-1]) {
+			base, dup = base[:i], base[i:]
+		}
+		if i := strings.LastIndexByte(base, '.'); i > 0 && len(base)-i >= 2 && strings.HasPrefix(takeoutMetadata, base[i:]) {
+			base = base[:i]
+		}
+		if dup != "" {
+			ext := path.Ext(base)
+			base = strings.TrimSuffix(base, ext) + dup + ext
+		}
+		return m.pairedOriginal(dir + base)
+	}
+	ext := path.Ext(name)
+	for _, suffix := range takeoutEdited {
+		if stem, ok := strings.CutSuffix(strings.TrimSuffix(name, ext), suffix); ok {
+			if orig := m.pairedOriginal(dir + stem + ext); orig != "" {
+				return orig
+			}
+			return m.pairedOriginal(dir + stem)
+		}
+	}
+	return ""
+}
+
+// rawExts and jpegExts are the extensions of the two halves of a RAW+JPEG
+// pair, in lower case.
+var (
+	rawExts  = []string{".cr2", ".cr3", ".nef", ".nrw", ".arw", ".dng", ".raf", ".orf", ".rw2", ".pef", ".srw"}
+	jpegExts = []string{".jpg", ".jpeg"}
+)
+
+// companionOf returns the relative path of the tracked JPEG the untracked
+// RAW file f was shot with, or of the tracked RAW file if f is a JPEG, or
+// "". Both have the same name up to the extension, compared in lower and
+// upper case.
+func (m *MatchContext) companionOf(f scanner.File) string {
+	ext := path.Ext(f.RelPath)
+	var exts []string
+	switch lower := strings.ToLower(ext); {
+	case slices.Contains(rawExts, lower):
+		exts = jpegExts
+	case slices.Contains(jpegExts, lower):
+		exts = rawExts
+	default:
+		return ""
+	}
+	stem := strings.TrimSuffix(f.RelPath, ext)
+	for _, e := range exts {
+		for _, c := range []string{e, strings.ToUpper(e)} {
+			if _, ok := m.AssetPaths[m.Normalizer.Key(stem+c)]; ok {
+				return stem + c
+			}
+		}
+	}
+	return ""
+}
+
+// isDigits reports whether s is a non-empty string of ASCII digits.
+func isDigits(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+// excludedBy returns the first of Exclusions that the library-relative
+// path rel matches, or "".
+func (m *MatchContext) excludedBy(rel string) string {
+	if len(m.Exclusions) == 0 {
+		return ""
+	}
+	abs := "/" + rel
+	if m.Normalizer != nil && m.Normalizer.Prefix != "" {
+		abs = strings.TrimSuffix(m.Normalizer.Prefix, "/") + abs
+	}
+	for _, pattern := range m.Exclusions {
+		if paths.MatchExclusion(pattern, abs) {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// Excluded returns the number of strays FindUntracked skipped because they
+// match one of Exclusions.
+func (m *MatchContext) Excluded() int64 {
+	return m.excluded.Load()
+}
+
+// DefaultUploadGrace is how long a stray named like an upload in progress
+// is skipped by default: Immich writes an upload to
+// upload/<userId>/xx/yy/<uuid>.<ext> and only records the asset once the
+// file is complete.
+const DefaultUploadGrace = time.Hour
+
+// IsUploadStaging reports whether relPath is named like a file Immich is
+// receiving: a UUID with an extension two levels below a user's directory
+// in upload/.
+func IsUploadStaging(relPath string) bool {
+	parts := strings.Split(relPath, "/")
+	if len(parts) != 5 || parts[0] != "upload" || !isValidUUID(parts[1]) {
+		return false
+	}
+	name := parts[4]
+	return len(name) > 36 && name[36] == '.' && isValidUUID(name[:36])
+}
+
+// tooRecent reports whether the stray f may still be being written at now.
+func (m *MatchContext) tooRecent(f scanner.File, now time.Time) bool {
+	age := m.MinAge
+	if IsUploadStaging(f.RelPath) {
+		age = max(age, m.UploadGrace)
+	}
+	return age > 0 && now.Sub(f.ModTime) < age
+}
+
+// Recent returns the number of strays FindUntracked skipped because they
+// were modified too recently.
+func (m *MatchContext) Recent() int64 {
+	return m.recent.Load()
+}
+
+// Ignored returns the number of strays FindUntracked skipped because of
+// the .strayignore rules in Ignore.
+func (m *MatchContext) Ignored() int64 {
+	return m.ignored.Load()
 }
 
+// extWanted reports whether the extension of relPath passes OnlyExts and
+// SkipExts.
+func (m *MatchContext) extWanted(relPath string) bool {
+	ext := path.Ext(relPath)
+	has := func(e string) bool { return strings.EqualFold(e, ext) }
+	if len(m.OnlyExts) > 0 && !slices.ContainsFunc(m.OnlyExts, has) {
+		return false
+	}
+	return ext == "" || !slices.ContainsFunc(m.SkipExts, has)
+}
+
+// FilteredByExt returns the number of strays FindUntracked skipped because
+// of OnlyExts or SkipExts.
+func (m *MatchContext) FilteredByExt() int64 {
+	return m.filteredExt.Load()
+}
+
+// IgnoredJunk returns the number of junk files FindUntracked skipped
+// because of IgnoreJunk.
+func (m *MatchContext) IgnoredJunk() int64 {
+	return m.junk.Load()
+}
+
+// minChunk is the smallest number of files handed to a single worker; below
+// this the goroutine overhead outweighs the map lookups.
+const minChunk = 4096
+
 // FindUntracked compares filesystem paths against Immich data and returns
 // files that are not tracked by Immich.
 //
-// diskFiles: relative paths from the filesystem scan (forward-slash normalized).
+// diskFiles: files from the filesystem scan (forward-slash normalized paths).
 // mctx: match context containing asset paths, asset IDs, and user IDs.
-func FindUntracked(diskFiles []string, mctx *MatchContext, logger *slog.Logger) []UntrackedFile {
-	var untracked []UntrackedFile
+func FindUntracked(diskFiles []scanner.File, mctx *MatchContext, logger *slog.Logger) []UntrackedFile {
+	workers := mctx.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, (len(diskFiles)+minChunk-1)/minChunk)
 
-	for _, relPath := range diskFiles {
-		if !isKnown(relPath, mctx) {
-			untracked = append(untracked, UntrackedFile{RelPath: relPath})
-			logger.Debug("found untracked file", "path", relPath)
+	var untracked []UntrackedFile
+	if workers <= 1 {
+		untracked = findUntrackedChunk(diskFiles, mctx, logger)
+	} else {
+		// Split into contiguous chunks so the result keeps the input order.
+		results := make([][]UntrackedFile, workers)
+		chunk := (len(diskFiles) + workers - 1) / workers
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			lo := w * chunk
+			hi := min(lo+chunk, len(diskFiles))
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[w] = findUntrackedChunk(diskFiles[lo:hi], mctx, logger)
+			}()
+		}
+		wg.Wait()
+		for _, r := range results {
+			untracked = append(untracked, r...)
 		}
 	}
 
-	logger.Info("matching complete", "untracked_found", len(untracked))
+	logger.Debug("matched batch", "files", len(diskFiles), "untracked", len(untracked), "workers", max(workers, 1))
+	return untracked
+}
+
+// findUntrackedChunk matches a slice of files sequentially. The match context
+// is only read, so chunks can run concurrently.
+func findUntrackedChunk(diskFiles []scanner.File, mctx *MatchContext, logger *slog.Logger) []UntrackedFile {
+	var untracked []UntrackedFile
+	now := time.Now()
+	for _, f := range diskFiles {
+		if !mctx.Filter.Keep(f.RelPath) {
+			continue
+		}
+		if cat, known := classify(f.RelPath, mctx); !known {
+			if mctx.Ignore.Ignored(f.RelPath) {
+				mctx.ignored.Add(1)
+				logger.Debug("skipping file matching a .strayignore pattern", "path", f.RelPath)
+				continue
+			}
+			if !mctx.extWanted(f.RelPath) {
+				mctx.filteredExt.Add(1)
+				continue
+			}
+			if cat == CategoryOriginal {
+				if pattern := mctx.excludedBy(f.RelPath); pattern != "" {
+					mctx.excluded.Add(1)
+					logger.Debug("skipping file excluded by a library pattern", "path", f.RelPath, "pattern", pattern)
+					continue
+				}
+			}
+			if mctx.tooRecent(f, now) {
+				mctx.recent.Add(1)
+				logger.Debug("skipping recently modified file", "path", f.RelPath, "mtime", f.ModTime)
+				continue
+			}
+			if cat != CategoryBackup && IsJunk(f.RelPath) {
+				if mctx.IgnoreJunk {
+					mctx.junk.Add(1)
+					logger.Debug("skipping junk file", "path", f.RelPath)
+					continue
+				}
+				cat = CategoryJunk
+			}
+			u := UntrackedFile{
+				RelPath:  f.RelPath,
+				Category: cat,
+				Size:     f.Size,
+				ModTime:  f.ModTime,
+				Dev:      f.Dev,
+			}
+			if cat == CategoryOriginal {
+				if u.SidecarOf = mctx.sidecarOf(f); u.SidecarOf != "" {
+					u.Category = CategorySidecar
+				} else if u.TakeoutOf = mctx.takeoutOf(f); u.TakeoutOf != "" {
+					u.Category = CategoryTakeout
+				} else if u.CompanionOf = mctx.companionOf(f); u.CompanionOf != "" {
+					u.Category = CategoryCompanion
+				} else {
+					u.ProbablyTrackedAs = mctx.probableMatch(f)
+				}
+			}
+			untracked = append(untracked, u)
+			logger.Debug("found untracked file", "path", f.RelPath, "category", u.Category,
+				"probably_tracked_as", u.ProbablyTrackedAs, "sidecar_of", u.SidecarOf, "takeout_of", u.TakeoutOf, "companion_of", u.CompanionOf)
+		}
+	}
 	return untracked
 }
 
-// isKnown dispatches by top-level directory to determine whether a file is
-// tracked by Immich.
-func isKnown(relPath string, mctx *MatchContext) bool {
+// classify dispatches by top-level directory to determine whether a file is
+// tracked by Immich. For untracked files it also returns the stray category.
+func classify(relPath string, mctx *MatchContext) (Category, bool) {
 	// .immich marker files can appear in any directory (library/.immich,
 	// thumbs/.immich, etc.) and are always considered known.
 	if path.Base(relPath) == ".immich" {
-		return true
+		return "", true
+	}
+	// The scanner skips backups/, so any dump seen here is misplaced.
+	if IsDatabaseDump(relPath) {
+		return CategoryBackup, false
 	}
 
-	topDir := strings.SplitN(relPath, "/", 2)[0]
-
-	switch topDir {
+	switch paths.TopDir(relPath) {
 	case "library", "upload":
-		// Exact path match against originalPath set.
-		_, ok := mctx.AssetPaths[relPath]
-		return ok
+		// Exact path match against the originalPath and sidecar sets.
+		key := mctx.Normalizer.Key(relPath)
+		if _, ok := mctx.AssetPaths[key]; ok {
+			return CategoryOriginal, true
+		}
+		_, ok := mctx.SidecarPaths[key]
+		return CategoryOriginal, ok
+
+	case "thumbs":
+		// Extract the asset UUID from the filename; face thumbnails, named
+		// "{personId}.jpeg", carry a person UUID instead.
+		return CategoryDerivative, matchByFileUUID(relPath, mctx.AssetIDs) || matchByFileUUID(relPath, mctx.PersonIDs)
 
-	case "thumbs", "encoded-video":
+	case "encoded-video":
 		// Extract asset UUID from filename.
-		return matchByAssetID(relPath, mctx.AssetIDs)
+		return CategoryDerivative, matchByFileUUID(relPath, mctx.AssetIDs)
 
 	case "profile":
 		// Extract user UUID from path.
-		return matchByUserID(relPath, mctx.UserIDs)
+		return CategoryProfile, matchByUserID(relPath, mctx.UserIDs)
 
 	default:
 		// Unknown top-level directories are flagged as untracked.
-		return false
+		return CategoryUnmanaged, false
 	}
 }
 
-// matchByAssetID extracts a UUID from the filename and checks it against
-// the set of known asset IDs. Thumbnail files are named like
-// "{assetId}-thumbnail.webp" and encoded videos like "{assetId}.mp4".
-func matchByAssetID(relPath string, assetIDs map[string]struct{}) bool {
+// junkNames are the names of files operating systems leave behind, compared
+// case-insensitively.
+var junkNames = []string{".DS_Store", "Thumbs.db", "ehthumbs.db", "desktop.ini"}
+
+// junkDirs are the directories NAS software and macOS fill with metadata
+// and thumbnails of the files next to them.
+var junkDirs = []string{"@eaDir", ".AppleDouble", ".@__thumb"}
+
+// IsJunk reports whether relPath is operating system or NAS litter: a
+// .DS_Store, Thumbs.db, or desktop.ini file, an AppleDouble "._" file, or
+// anything in a Synology @eaDir, .AppleDouble, or QNAP .@__thumb directory.
+func IsJunk(relPath string) bool {
+	segs := strings.Split(relPath, "/")
+	name := segs[len(segs)-1]
+	if strings.HasPrefix(name, "._") {
+		return true
+	}
+	for _, j := range junkNames {
+		if strings.EqualFold(name, j) {
+			return true
+		}
+	}
+	for _, dir := range segs[:len(segs)-1] {
+		if slices.Contains(junkDirs, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDatabaseDump reports whether relPath is named like one of Immich's
+// database dumps, e.g. "immich-db-backup-1713820800000.sql.gz" or
+// "immich-db-backup-20250513T020000-v1.132.3-pg14.17.sql.gz".
+func IsDatabaseDump(relPath string) bool {
+	name := path.Base(relPath)
+	return strings.HasPrefix(name, "immich-db-backup-") &&
+		(strings.HasSuffix(name, ".sql.gz") || strings.HasSuffix(name, ".sql"))
+}
+
+// matchByFileUUID extracts a UUID from the filename and checks it against
+// a set of known IDs. Thumbnail files are named like
+// "{assetId}-thumbnail.webp", encoded videos like "{assetId}.mp4", and face
+// thumbnails like "{personId}.jpeg".
+func matchByFileUUID(relPath string, ids map[string]struct{}) bool {
 	filename := path.Base(relPath)
 	uuid := extractUUID(filename)
 	if uuid == "" {
 		return false
 	}
-	_, ok := assetIDs[uuid]
+	_, ok := ids[uuid]
 	return ok
 }
 
@@ -120,7 +661,35 @@ func extractUUID(s string) string {
 	return ""
 }
 
+// IsUUID reports whether s is a UUID in the form Immich uses for IDs.
+func IsUUID(s string) bool {
+	return isValidUUID(s)
+}
+
 // isValidUUID checks whether a string is a valid UUID (8-4-4-4-12 hex).
+// It is a hand-rolled byte check rather than a regexp because it runs for
+// every thumbnail and encoded video, which can number in the millions.
 func isValidUUID(s string) bool {
-	return uuidRegex.MatchString(s)
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < 36; i++ {
+		c := s[i]
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !isHex(c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isHex reports whether c is an ASCII hexadecimal digit.
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
 }
@@ -1,15 +1,32 @@
 package matcher
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"regexp"
 	"testing"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/ignore"
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/scanner"
 )
 
 func testLogger() *slog.Logger {
 	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
 }
 
+// files wraps relative paths as scan results.
+func files(relPaths ...string) []scanner.File {
+	out := make([]scanner.File, len(relPaths))
+	for i, p := range relPaths {
+		out[i] = scanner.File{RelPath: p}
+	}
+	return out
+}
+
 func newMatchContext() *MatchContext {
 	return &MatchContext{
 		AssetPaths: make(map[string]struct{}),
@@ -28,7 +45,7 @@ func TestFindUntracked_LibraryExactMatch(t *testing.T) {
 		"library/admin/2024/photo2.JPG",
 	}
 
-	untracked := FindUntracked(diskFiles, mctx, testLogger())
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
 	if len(untracked) != 0 {
 		t.Errorf("expected 0 untracked, got %d: %v", len(untracked), untracked)
 	}
@@ -43,7 +60,7 @@ func TestFindUntracked_LibraryUntracked(t *testing.T) {
 		"library/admin/2024/stray.png",
 	}
 
-	untracked := FindUntracked(diskFiles, mctx, testLogger())
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
 	if len(untracked) != 1 {
 		t.Fatalf("expected 1 untracked, got %d", len(untracked))
 	}
@@ -60,12 +77,252 @@ func TestFindUntracked_UploadExactMatch(t *testing.T) {
 		"upload/library/admin/2024/photo1.jpg",
 	}
 
-	untracked := FindUntracked(diskFiles, mctx, testLogger())
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
 	if len(untracked) != 0 {
 		t.Errorf("expected 0 untracked, got %d", len(untracked))
 	}
 }
 
+func TestFindUntracked_SidecarsTracked(t *testing.T) {
+	mctx := newMatchContext()
+	mctx.AssetPaths["library/admin/2024/photo1.jpg"] = struct{}{}
+	mctx.SidecarPaths = map[string]struct{}{"library/admin/2024/photo1.jpg.xmp": {}}
+
+	diskFiles := []string{
+		"library/admin/2024/photo1.jpg",
+		"library/admin/2024/photo1.jpg.xmp",
+		"library/admin/2024/photo2.jpg.xmp",
+	}
+
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
+	if len(untracked) != 1 || untracked[0].RelPath != "library/admin/2024/photo2.jpg.xmp" {
+		t.Errorf("expected only the sidecar of no asset to be untracked, got %v", untracked)
+	}
+}
+
+func TestFindUntracked_SidecarPairing(t *testing.T) {
+	mctx := newMatchContext()
+	mctx.AssetPaths["library/admin/2024/photo1.jpg"] = struct{}{}
+	mctx.AssetPaths["library/admin/2024/photo2.heic"] = struct{}{}
+	mctx.SidecarExts = []string{".xmp"}
+	mctx.IndexSidecars()
+
+	diskFiles := []string{
+		"library/admin/2024/photo1.jpg.xmp",
+		"library/admin/2024/photo2.XMP",
+		"library/admin/2024/photo3.jpg.xmp",
+		"library/admin/2024/photo1.jpg.aae",
+	}
+
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
+	want := []struct {
+		cat       Category
+		sidecarOf string
+	}{
+		{CategorySidecar, "library/admin/2024/photo1.jpg"},
+		{CategorySidecar, "library/admin/2024/photo2.heic"},
+		{CategoryOriginal, ""},
+		{CategoryOriginal, ""},
+	}
+	if len(untracked) != len(want) {
+		t.Fatalf("expected %d untracked, got %v", len(want), untracked)
+	}
+	for i, w := range want {
+		if untracked[i].Category != w.cat || untracked[i].SidecarOf != w.sidecarOf {
+			t.Errorf("%s: got %s of %q, want %s of %q", untracked[i].RelPath,
+				untracked[i].Category, untracked[i].SidecarOf, w.cat, w.sidecarOf)
+		}
+	}
+}
+
+func TestFindUntracked_TakeoutLeftovers(t *testing.T) {
+	mctx := newMatchContext()
+	mctx.AssetPaths["library/admin/Takeout/IMG_0001.jpg"] = struct{}{}
+	mctx.AssetPaths["library/admin/Takeout/IMG_0001(1).jpg"] = struct{}{}
+	mctx.AssetPaths["library/admin/Takeout/IMG_0002.heic"] = struct{}{}
+	mctx.Takeout = true
+	mctx.IndexSidecars()
+
+	diskFiles := []string{
+		"library/admin/Takeout/IMG_0001.jpg.json",
+		"library/admin/Takeout/IMG_0001.jpg.supplemental-metadata.json",
+		"library/admin/Takeout/IMG_0001.jpg.supplemen.json",
+		"library/admin/Takeout/IMG_0001.jpg(1).json",
+		"library/admin/Takeout/IMG_0002.json",
+		"library/admin/Takeout/IMG_0001-edited.jpg",
+		"library/admin/Takeout/IMG_0002-bearbeitet.jpg",
+		"library/admin/Takeout/IMG_0003.jpg.json",
+		"library/admin/Takeout/notes-edited.txt",
+	}
+
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
+	want := []string{
+		"library/admin/Takeout/IMG_0001.jpg",
+		"library/admin/Takeout/IMG_0001.jpg",
+		"library/admin/Takeout/IMG_0001.jpg",
+		"library/admin/Takeout/IMG_0001(1).jpg",
+		"library/admin/Takeout/IMG_0002.heic",
+		"library/admin/Takeout/IMG_0001.jpg",
+		"library/admin/Takeout/IMG_0002.heic",
+		"",
+		"",
+	}
+	if len(untracked) != len(want) {
+		t.Fatalf("expected %d untracked, got %v", len(want), untracked)
+	}
+	for i, w := range want {
+		cat := CategoryTakeout
+		if w == "" {
+			cat = CategoryOriginal
+		}
+		if untracked[i].Category != cat || untracked[i].TakeoutOf != w {
+			t.Errorf("%s: got %s of %q, want %s of %q", untracked[i].RelPath,
+				untracked[i].Category, untracked[i].TakeoutOf, cat, w)
+		}
+	}
+}
+
+func TestFindUntracked_RawJpegCompanions(t *testing.T) {
+	mctx := newMatchContext()
+	mctx.AssetPaths["library/admin/2024/IMG_0001.JPG"] = struct{}{}
+	mctx.AssetPaths["library/admin/2024/DSC_0002.nef"] = struct{}{}
+
+	diskFiles := []string{
+		"library/admin/2024/IMG_0001.CR2",
+		"library/admin/2024/DSC_0002.jpg",
+		"library/admin/2024/IMG_0003.CR2",
+		"library/admin/2024/IMG_0001.MOV",
+	}
+
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
+	want := []string{"library/admin/2024/IMG_0001.JPG", "library/admin/2024/DSC_0002.nef", "", ""}
+	if len(untracked) != len(want) {
+		t.Fatalf("expected %d untracked, got %v", len(want), untracked)
+	}
+	for i, w := range want {
+		cat := CategoryCompanion
+		if w == "" {
+			cat = CategoryOriginal
+		}
+		if untracked[i].Category != cat || untracked[i].CompanionOf != w {
+			t.Errorf("%s: got %s of %q, want %s of %q", untracked[i].RelPath,
+				untracked[i].Category, untracked[i].CompanionOf, cat, w)
+		}
+	}
+}
+
+func TestFindUntracked_StrayIgnore(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, ignore.FileName), []byte("notes/\n*.txt\n"), 0o644)
+	mctx := newMatchContext()
+	mctx.Ignore = ignore.New(root, nil, testLogger())
+
+	untracked := FindUntracked(files("library/admin/notes/a.jpg", "library/admin/readme.txt", "library/admin/b.jpg"), mctx, testLogger())
+	if len(untracked) != 1 || untracked[0].RelPath != "library/admin/b.jpg" {
+		t.Errorf("expected only b.jpg to be reported, got %v", untracked)
+	}
+	if mctx.Ignored() != 2 {
+		t.Errorf("expected 2 ignored, got %d", mctx.Ignored())
+	}
+}
+
+func TestFindUntracked_ExtensionFilters(t *testing.T) {
+	diskFiles := []string{"library/admin/a.MP4", "library/admin/b.jpg", "library/admin/b.jpg.xmp", "library/admin/README"}
+
+	mctx := newMatchContext()
+	mctx.OnlyExts = []string{".mp4", ".mov"}
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
+	if len(untracked) != 1 || untracked[0].RelPath != "library/admin/a.MP4" || mctx.FilteredByExt() != 3 {
+		t.Errorf("expected only the video to be reported, got %v and %d filtered", untracked, mctx.FilteredByExt())
+	}
+
+	mctx = newMatchContext()
+	mctx.SkipExts = []string{".xmp"}
+	untracked = FindUntracked(files(diskFiles...), mctx, testLogger())
+	if len(untracked) != 3 || mctx.FilteredByExt() != 1 {
+		t.Errorf("expected the sidecar to be skipped, got %v", untracked)
+	}
+}
+
+func TestFindUntracked_LibraryExclusions(t *testing.T) {
+	mctx := newMatchContext()
+	mctx.Normalizer = &paths.Normalizer{Prefix: "/data/"}
+	mctx.Exclusions = []string{"**/@eaDir/**", "/data/upload/**/*.tmp"}
+
+	diskFiles := []string{
+		"library/admin/@eaDir/photo1.jpg/SYNOPHOTO_THUMB_M.jpg",
+		"upload/u1/ab/photo2.tmp",
+		"library/admin/photo3.tmp",
+		"thumbs/@eaDir/x.webp",
+	}
+
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
+	if len(untracked) != 2 || untracked[0].RelPath != "library/admin/photo3.tmp" || untracked[1].RelPath != "thumbs/@eaDir/x.webp" {
+		t.Errorf("expected the excluded originals to be skipped, got %v", untracked)
+	}
+	if mctx.Excluded() != 2 {
+		t.Errorf("expected 2 excluded, got %d", mctx.Excluded())
+	}
+}
+
+func TestFindUntracked_MinAge(t *testing.T) {
+	mctx := newMatchContext()
+	mctx.MinAge = 10 * time.Minute
+	mctx.UploadGrace = time.Hour
+	user := "aaaaaaaa-1111-2222-3333-444444444444"
+	staging := "upload/" + user + "/bb/cc/bbccdddd-1111-2222-3333-444444444444.jpg"
+	now := time.Now()
+
+	diskFiles := []scanner.File{
+		{RelPath: "library/admin/new.jpg", ModTime: now.Add(-time.Minute)},
+		{RelPath: "library/admin/old.jpg", ModTime: now.Add(-20 * time.Minute)},
+		{RelPath: staging, ModTime: now.Add(-20 * time.Minute)},
+		{RelPath: "upload/" + user + "/bb/cc/IMG_0001.jpg", ModTime: now.Add(-20 * time.Minute)},
+	}
+
+	untracked := FindUntracked(diskFiles, mctx, testLogger())
+	if len(untracked) != 2 || untracked[0].RelPath != "library/admin/old.jpg" || untracked[1].RelPath != diskFiles[3].RelPath {
+		t.Errorf("expected the recent file and the upload in progress to be skipped, got %v", untracked)
+	}
+	if mctx.Recent() != 2 {
+		t.Errorf("expected 2 recent, got %d", mctx.Recent())
+	}
+	if !IsUploadStaging(staging) || IsUploadStaging("library/admin/bbccdddd-1111-2222-3333-444444444444.jpg") {
+		t.Error("IsUploadStaging misjudged an upload path")
+	}
+}
+
+func TestFindUntracked_Junk(t *testing.T) {
+	diskFiles := []string{
+		"library/admin/.DS_Store",
+		"library/admin/2024/THUMBS.DB",
+		"library/admin/2024/._IMG_0001.jpg",
+		"library/admin/2024/@eaDir/IMG_0001.jpg/SYNOPHOTO_THUMB_XL.jpg",
+		"library/admin/2024/IMG_0002.jpg",
+	}
+
+	untracked := FindUntracked(files(diskFiles...), newMatchContext(), testLogger())
+	if len(untracked) != 5 {
+		t.Fatalf("expected 5 untracked, got %d: %v", len(untracked), untracked)
+	}
+	for _, u := range untracked {
+		want := CategoryJunk
+		if u.RelPath == "library/admin/2024/IMG_0002.jpg" {
+			want = CategoryOriginal
+		}
+		if u.Category != want {
+			t.Errorf("%s: expected category %s, got %s", u.RelPath, want, u.Category)
+		}
+	}
+
+	mctx := newMatchContext()
+	mctx.IgnoreJunk = true
+	untracked = FindUntracked(files(diskFiles...), mctx, testLogger())
+	if len(untracked) != 1 || mctx.IgnoredJunk() != 4 {
+		t.Errorf("expected junk to be ignored, got %v and %d ignored", untracked, mctx.IgnoredJunk())
+	}
+}
+
 func TestFindUntracked_ThumbsTrackedByAssetID(t *testing.T) {
 	mctx := newMatchContext()
 	mctx.AssetIDs["aaaaaaaa-1111-2222-3333-444444444444"] = struct{}{}
@@ -76,7 +333,7 @@ func TestFindUntracked_ThumbsTrackedByAssetID(t *testing.T) {
 		"thumbs/user-uuid/bbbbbbbb-1111-2222-3333-444444444444-preview.jpeg",
 	}
 
-	untracked := FindUntracked(diskFiles, mctx, testLogger())
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
 	if len(untracked) != 0 {
 		t.Errorf("expected 0 untracked, got %d: %v", len(untracked), untracked)
 	}
@@ -91,7 +348,7 @@ func TestFindUntracked_ThumbsStray(t *testing.T) {
 		"thumbs/user-uuid/cccccccc-1111-2222-3333-444444444444-thumbnail.webp",
 	}
 
-	untracked := FindUntracked(diskFiles, mctx, testLogger())
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
 	if len(untracked) != 1 {
 		t.Fatalf("expected 1 untracked, got %d", len(untracked))
 	}
@@ -100,6 +357,23 @@ func TestFindUntracked_ThumbsStray(t *testing.T) {
 	}
 }
 
+func TestFindUntracked_FaceThumbsTrackedByPersonID(t *testing.T) {
+	mctx := newMatchContext()
+	personID := "cccccccc-1111-2222-3333-444444444444"
+	mctx.PersonIDs = map[string]struct{}{personID: {}}
+
+	diskFiles := []string{
+		"thumbs/user1/cc/cc/" + personID + ".jpeg",
+		"thumbs/user1/dd/dd/dddddddd-1111-2222-3333-444444444444.jpeg",
+		"encoded-video/user1/cc/cc/" + personID + ".mp4",
+	}
+
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
+	if len(untracked) != 2 || untracked[0].RelPath != diskFiles[1] || untracked[1].RelPath != diskFiles[2] {
+		t.Errorf("expected only the face thumbnail of a known person to be tracked, got %v", untracked)
+	}
+}
+
 func TestFindUntracked_EncodedVideoTracked(t *testing.T) {
 	mctx := newMatchContext()
 	mctx.AssetIDs["aaaaaaaa-1111-2222-3333-444444444444"] = struct{}{}
@@ -108,7 +382,7 @@ func TestFindUntracked_EncodedVideoTracked(t *testing.T) {
 		"encoded-video/user-uuid/aaaaaaaa-1111-2222-3333-444444444444.mp4",
 	}
 
-	untracked := FindUntracked(diskFiles, mctx, testLogger())
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
 	if len(untracked) != 0 {
 		t.Errorf("expected 0 untracked, got %d", len(untracked))
 	}
@@ -122,7 +396,7 @@ func TestFindUntracked_ProfileTrackedByUserID(t *testing.T) {
 		"profile/aaaaaaaa-1111-2222-3333-444444444444/profile-image.jpg",
 	}
 
-	untracked := FindUntracked(diskFiles, mctx, testLogger())
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
 	if len(untracked) != 0 {
 		t.Errorf("expected 0 untracked, got %d", len(untracked))
 	}
@@ -137,7 +411,7 @@ func TestFindUntracked_ProfileStray(t *testing.T) {
 		"profile/bbbbbbbb-1111-2222-3333-444444444444/profile-image.jpg",
 	}
 
-	untracked := FindUntracked(diskFiles, mctx, testLogger())
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
 	if len(untracked) != 1 {
 		t.Fatalf("expected 1 untracked, got %d", len(untracked))
 	}
@@ -153,7 +427,7 @@ func TestFindUntracked_ImmichMarkerAlwaysKnown(t *testing.T) {
 		".immich",
 	}
 
-	untracked := FindUntracked(diskFiles, mctx, testLogger())
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
 	if len(untracked) != 0 {
 		t.Errorf("expected .immich to be known, got %d untracked", len(untracked))
 	}
@@ -170,7 +444,7 @@ func TestFindUntracked_ImmichMarkerInSubdirectories(t *testing.T) {
 		"profile/.immich",
 	}
 
-	untracked := FindUntracked(diskFiles, mctx, testLogger())
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
 	if len(untracked) != 0 {
 		t.Errorf("expected all .immich markers to be known, got %d untracked: %v", len(untracked), untracked)
 	}
@@ -183,7 +457,7 @@ func TestFindUntracked_UnknownTopLevelDir(t *testing.T) {
 		"unknown/some/file.txt",
 	}
 
-	untracked := FindUntracked(diskFiles, mctx, testLogger())
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
 	if len(untracked) != 1 {
 		t.Fatalf("expected 1 untracked for unknown dir, got %d", len(untracked))
 	}
@@ -209,7 +483,7 @@ func TestFindUntracked_MixedDirectories(t *testing.T) {
 		"unknown/file.dat",                                                            // unknown dir → untracked
 	}
 
-	untracked := FindUntracked(diskFiles, mctx, testLogger())
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
 
 	untrackedPaths := make(map[string]bool)
 	for _, u := range untracked {
@@ -244,7 +518,7 @@ func TestFindUntracked_EmptyInputs(t *testing.T) {
 	}
 
 	// Disk files but empty match context.
-	untracked = FindUntracked([]string{"library/a.jpg"}, mctx, testLogger())
+	untracked = FindUntracked(files("library/a.jpg"), mctx, testLogger())
 	if len(untracked) != 1 {
 		t.Errorf("expected 1 untracked for empty match context, got %d", len(untracked))
 	}
@@ -281,6 +555,9 @@ func TestIsValidUUID(t *testing.T) {
 		{"", false},
 		{"aaaaaaaa11112222333344444444444", false},  // no dashes
 		{"aaaaaaaa-1111-2222-3333-44444444444g", false}, // invalid hex
+		{"aaaaaaa-a1111-2222-3333-444444444444", false},  // misplaced dash
+		{"aaaaaaaa-1111-2222-3333-4444444444444", false}, // too long
+		{"aaaaaaaa-1111-2222-3333-44444444444-", false},  // trailing dash
 	}
 
 	for _, tt := range tests {
@@ -290,3 +567,160 @@ func TestIsValidUUID(t *testing.T) {
 		}
 	}
 }
+
+func TestFindUntracked_ParallelPreservesOrder(t *testing.T) {
+	mctx := newMatchContext()
+	mctx.Workers = 4
+
+	var diskFiles []string
+	var want []string
+	for i := 0; i < 5*minChunk; i++ {
+		p := fmt.Sprintf("library/admin/%06d.jpg", i)
+		diskFiles = append(diskFiles, p)
+		if i%3 == 0 {
+			mctx.AssetPaths[p] = struct{}{}
+		} else {
+			want = append(want, p)
+		}
+	}
+
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
+	if len(untracked) != len(want) {
+		t.Fatalf("expected %d untracked, got %d", len(want), len(untracked))
+	}
+	for i, u := range untracked {
+		if u.RelPath != want[i] {
+			t.Fatalf("result %d: expected %q, got %q", i, want[i], u.RelPath)
+		}
+	}
+}
+
+func BenchmarkFindUntracked(b *testing.B) {
+	mctx := newMatchContext()
+	var diskFiles []string
+	for i := 0; i < 200000; i++ {
+		id := fmt.Sprintf("%08x-1111-2222-3333-444444444444", i)
+		mctx.AssetIDs[id] = struct{}{}
+		diskFiles = append(diskFiles, "thumbs/user/"+id[:2]+"/"+id+"-thumbnail.webp")
+	}
+	in := files(diskFiles...)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		FindUntracked(in, mctx, testLogger())
+	}
+}
+
+// uuidRegex is the reference pattern isValidUUID replaced.
+var uuidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func TestIsValidUUID_AgreesWithRegex(t *testing.T) {
+	base := []byte("aaaaaaaa-1111-2222-3333-444444444444")
+	probes := []byte("0aF-gz/ .")
+	for i := range base {
+		for _, c := range probes {
+			b := append([]byte(nil), base...)
+			b[i] = c
+			s := string(b)
+			if got, want := isValidUUID(s), uuidRegex.MatchString(s); got != want {
+				t.Errorf("isValidUUID(%q) = %v, regex says %v", s, got, want)
+			}
+		}
+	}
+}
+
+func BenchmarkIsValidUUID(b *testing.B) {
+	const s = "aaaaaaaa-1111-2222-3333-444444444444"
+	b.Run("bytes", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			isValidUUID(s)
+		}
+	})
+	b.Run("regexp", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			uuidRegex.MatchString(s)
+		}
+	})
+}
+
+func TestFindUntracked_NormalizerFoldsDiskPaths(t *testing.T) {
+	norm := &paths.Normalizer{Prefix: "/data/", FoldUnicode: true, FoldCase: true}
+	mctx := newMatchContext()
+	mctx.Normalizer = norm
+	mctx.AssetPaths = norm.KeySet(map[string]struct{}{
+		"/data/library/admin/Caf\u00e9.JPG": {},
+	})
+
+	untracked := FindUntracked(files("library/admin/cafe\u0301.jpg"), mctx, testLogger())
+	if len(untracked) != 0 {
+		t.Errorf("expected folded paths to match, got %d untracked", len(untracked))
+	}
+}
+
+func TestFindUntracked_Categories(t *testing.T) {
+	mctx := newMatchContext()
+	untracked := FindUntracked(files(
+		"library/admin/a.jpg",
+		"thumbs/user/aa/aaaaaaaa-1111-2222-3333-444444444444-thumbnail.webp",
+		"profile/aaaaaaaa-1111-2222-3333-444444444444/p.jpg",
+		"random/file.txt",
+	), mctx, testLogger())
+
+	want := []Category{CategoryOriginal, CategoryDerivative, CategoryProfile, CategoryUnmanaged}
+	if len(untracked) != len(want) {
+		t.Fatalf("expected %d untracked, got %d", len(want), len(untracked))
+	}
+	for i, u := range untracked {
+		if u.Category != want[i] {
+			t.Errorf("%s: expected category %q, got %q", u.RelPath, want[i], u.Category)
+		}
+	}
+}
+
+func TestFindUntracked_FileNameFallback(t *testing.T) {
+	mctx := newMatchContext()
+	mctx.AssetPaths["library/alice/2024/01/IMG_1.jpg"] = struct{}{}
+	mctx.AddFileName("alice", "IMG_1.jpg", "/data/library/alice/2024/01/IMG_1.jpg", 100)
+	mctx.AddFileName("alice", "IMG_2.jpg", "/data/library/alice/2024/01/IMG_2.jpg", 0) // unknown size
+
+	disk := []scanner.File{
+		{RelPath: "library/alice/old/IMG_1.jpg", Size: 100}, // same owner, name, size
+		{RelPath: "library/alice/old/IMG_1b.jpg", Size: 100},
+		{RelPath: "library/bob/old/IMG_1.jpg", Size: 100}, // other owner
+		{RelPath: "library/alice/other/IMG_1.jpg", Size: 99},
+		{RelPath: "library/alice/old/IMG_2.jpg", Size: 100},
+		{RelPath: "upload/alice/IMG_1.jpg", Size: 100}, // only library/ is considered
+	}
+	untracked := FindUntracked(disk, mctx, testLogger())
+	if len(untracked) != len(disk) {
+		t.Fatalf("expected all %d files untracked, got %d", len(disk), len(untracked))
+	}
+	for i, u := range untracked {
+		want := ""
+		if i == 0 {
+			want = "/data/library/alice/2024/01/IMG_1.jpg"
+		}
+		if u.ProbablyTrackedAs != want {
+			t.Errorf("%s: expected ProbablyTrackedAs %q, got %q", u.RelPath, want, u.ProbablyTrackedAs)
+		}
+	}
+}
+
+func TestFindUntracked_MisplacedDatabaseDumps(t *testing.T) {
+	mctx := newMatchContext()
+	diskFiles := []string{
+		"library/admin/immich-db-backup-1713820800000.sql.gz",
+		"immich-db-backup-20250513T020000-v1.132.3-pg14.17.sql.gz",
+		"upload/immich-db-backup-notes.txt",
+	}
+
+	untracked := FindUntracked(files(diskFiles...), mctx, testLogger())
+	want := []Category{CategoryBackup, CategoryBackup, CategoryOriginal}
+	if len(untracked) != len(want) {
+		t.Fatalf("expected %d untracked, got %d", len(want), len(untracked))
+	}
+	for i, u := range untracked {
+		if u.Category != want[i] {
+			t.Errorf("%s: expected category %q, got %q", u.RelPath, want[i], u.Category)
+		}
+	}
+}
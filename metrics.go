@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/metrics"
+	"github.com/goeland86/immich-stray-finder/runstats"
+)
+
+// metricPrefix namespaces every exported metric.
+const metricPrefix = "immich_stray_finder_"
+
+func gauge(name, help string, value float64) metrics.Family {
+	return metrics.New(metricPrefix+name, metrics.Gauge, help, value)
+}
+
+func counter(name, help string, value float64) metrics.Family {
+	return metrics.New(metricPrefix+name, metrics.Counter, help, value)
+}
+
+// buildInfo is the conventional constant metric carrying the version.
+func buildInfo() metrics.Family {
+	f := gauge("build_info", "Version of immich-stray-finder; always 1.", 1)
+	f.Samples[0].Labels = [][2]string{{"version", buildVersion()}}
+	return f
+}
+
+// runFamilies describes the last finished run. The stray gauges come from
+// lastSuccess, which may be nil, so that a failed run does not drop them to
+// zero on a graph.
+func runFamilies(last, lastSuccess *runStatus) []metrics.Family {
+	success := 0.0
+	if last.Error == "" {
+		success = 1
+	}
+	fams := []metrics.Family{
+		gauge("last_run_timestamp_seconds", "When the last run finished, as a Unix timestamp.", float64(last.FinishedAt.Unix())),
+		gauge("last_run_success", "Whether the last run succeeded (1) or failed (0).", success),
+		gauge("last_run_duration_seconds", "How long the last run took.", last.Duration),
+	}
+	if lastSuccess == nil {
+		return fams
+	}
+	return append(fams,
+		gauge("last_success_timestamp_seconds", "When the last successful run finished, as a Unix timestamp.", float64(lastSuccess.FinishedAt.Unix())),
+		gauge("assets", "Assets fetched from Immich by the last successful run.", float64(lastSuccess.AssetsFetched)),
+		gauge("files_scanned", "Library files scanned by the last successful run.", float64(lastSuccess.FilesScanned)),
+		gauge("untracked_files", "Untracked files found by the last successful run.", float64(lastSuccess.Untracked)),
+		gauge("untracked_bytes", "Total size of the untracked files found by the last successful run.", float64(lastSuccess.UntrackedBytes)),
+		gauge("last_success_quarantined_bytes", "Bytes moved to the quarantine by the last successful run.", float64(lastSuccess.QuarantinedBytes)),
+	)
+}
+
+// counterFamilies reports the work done since the process started.
+func counterFamilies(c runstats.Counters, runs, failures int, quarantined int64) []metrics.Family {
+	return []metrics.Family{
+		counter("runs_total", "Runs finished, successful or not.", float64(runs)),
+		counter("run_failures_total", "Runs that failed.", float64(failures)),
+		counter("quarantined_bytes_total", "Bytes moved to the quarantine.", float64(quarantined)),
+		counter("api_requests_total", "HTTP requests sent to Immich.", float64(c.APICalls)),
+		counter("api_errors_total", "HTTP requests to Immich that failed or got a server error.", float64(c.APIErrors)),
+		counter("db_errors_total", "Failed queries of the Immich database.", float64(c.DBErrors)),
+	}
+}
+
+// pushJob is the Pushgateway job one-shot runs push to by default.
+const pushJob = "immich_stray_finder"
+
+// oneShotFamilies describes a single run, for --metrics-file and
+// --pushgateway-url. usage is the work the run did, so the counters cover
+// this run only.
+func oneShotFamilies(rs *runStatus, usage runstats.Counters) []metrics.Family {
+	runs, failures, lastSuccess := 1, 0, rs
+	if rs.Error != "" {
+		failures, lastSuccess = 1, nil
+	}
+	fams := []metrics.Family{buildInfo()}
+	fams = append(fams, runFamilies(rs, lastSuccess)...)
+	return append(fams, counterFamilies(usage, runs, failures, rs.QuarantinedBytes)...)
+}
+
+// exportRunMetrics writes the metrics of a finished run to --metrics-file
+// and pushes them to --pushgateway-url, as configured. Failures are only
+// logged: monitoring must not fail the run it monitors.
+func exportRunMetrics(ctx context.Context, cfg *config, rs *runStatus, usage runstats.Counters, logger *slog.Logger) {
+	if cfg.metricsFile == "" && cfg.pushURL == "" {
+		return
+	}
+	fams := oneShotFamilies(rs, usage)
+	if cfg.metricsFile != "" {
+		if err := metrics.WriteFile(cfg.metricsFile, fams); err != nil {
+			logger.Warn("failed to write metrics file", "file", cfg.metricsFile, "error", err)
+		}
+	}
+	if cfg.pushURL != "" {
+		// Push even when the run was interrupted, so the failure shows up.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		// A failed run keeps the stray gauges of the last successful push,
+		// as the /metrics endpoint does.
+		if err := metrics.Push(ctx, cfg.pushURL, pushJob, fams, rs.Error == ""); err != nil {
+			logger.Warn("failed to push metrics", "error", err)
+		} else {
+			logger.Info("pushed metrics to Pushgateway")
+		}
+	}
+}
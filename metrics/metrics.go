@@ -0,0 +1,157 @@
+// Package metrics writes the Prometheus text exposition format, for the
+// /metrics endpoint of the long-running commands, node_exporter's textfile
+// collector, and the Pushgateway. It covers the handful of gauges and counters this
+// tool exports, not the full client library.
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric types.
+const (
+	Gauge   = "gauge"
+	Counter = "counter"
+)
+
+// Family is a metric with its samples.
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// Sample is one value of a metric family.
+type Sample struct {
+	// Labels are name/value pairs, written in order.
+	Labels [][2]string
+	Value  float64
+}
+
+// New returns a family with a single unlabeled sample.
+func New(name, typ, help string, value float64) Family {
+	return Family{Name: name, Help: help, Type: typ, Samples: []Sample{{Value: value}}}
+}
+
+// Write writes the families to w in the text exposition format.
+func Write(w io.Writer, families []Family) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.Name, escapeHelp(f.Help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.Name, f.Type)
+		for _, s := range f.Samples {
+			bw.WriteString(f.Name)
+			if len(s.Labels) > 0 {
+				bw.WriteByte('{')
+				for i, l := range s.Labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					fmt.Fprintf(bw, "%s=\"%s\"", l[0], escapeLabel(l[1]))
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(formatValue(s.Value))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteFile replaces the file at path with the families. The file is
+// renamed into place, so the textfile collector never reads a partial one.
+func WriteFile(path string, families []Family) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("create metrics file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := Write(tmp, families); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write metrics file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write metrics file: %w", err)
+	}
+	// CreateTemp makes the file private; the collector may run as another user.
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("write metrics file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write metrics file: %w", err)
+	}
+	return nil
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	case v == math.Trunc(v) && math.Abs(v) < 1e15:
+		// Byte counts read better without an exponent.
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// Push sends families to a Pushgateway group. With replace, they replace
+// every metric of the group (PUT); otherwise only metrics of the same
+// names are replaced and the others are kept (POST). url is the
+// Pushgateway's base URL, or a full grouping key URL ending in
+// /metrics/job/<job>[/<label>/<value>...]; a base URL gets job appended.
+func Push(ctx context.Context, url, job string, families []Family, replace bool) error {
+	if !strings.Contains(url, "/metrics/job/") {
+		url = strings.TrimRight(url, "/") + "/metrics/job/" + neturl.PathEscape(job)
+	}
+	var body bytes.Buffer
+	if err := Write(&body, families); err != nil {
+		return err
+	}
+	method := http.MethodPost
+	if replace {
+		method = http.MethodPut
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, &body)
+	if err != nil {
+		return fmt.Errorf("push metrics: %w", err)
+	}
+	req.Header.Set("Content-Type", ContentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("push metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("push metrics: Pushgateway returned status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	families := []Family{
+		New("stray_files", Gauge, "Untracked files.\nFrom the last run.", 42),
+		{
+			Name: "build_info",
+			Help: `Build information, with a \ in it.`,
+			Type: Gauge,
+			Samples: []Sample{
+				{Labels: [][2]string{{"version", `v1 "beta"`}, {"path", `C:\x` + "\n"}}, Value: 1},
+			},
+		},
+		New("duration_seconds", Gauge, "Duration.", 1.5),
+		New("bytes_total", Counter, "Bytes.", 5<<30),
+		New("ratio", Gauge, "Ratio.", math.Inf(1)),
+	}
+	var sb strings.Builder
+	if err := Write(&sb, families); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP stray_files Untracked files.\nFrom the last run.
+# TYPE stray_files gauge
+stray_files 42
+# HELP build_info Build information, with a \\ in it.
+# TYPE build_info gauge
+build_info{version="v1 \"beta\"",path="C:\\x\n"} 1
+# HELP duration_seconds Duration.
+# TYPE duration_seconds gauge
+duration_seconds 1.5
+# HELP bytes_total Bytes.
+# TYPE bytes_total counter
+bytes_total 5368709120
+# HELP ratio Ratio.
+# TYPE ratio gauge
+ratio +Inf
+`
+	if got := sb.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stray.prom")
+	os.WriteFile(path, []byte("old"), 0o644)
+	if err := WriteFile(path, []Family{New("x", Gauge, "X.", 1)}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(data), "x 1\n") {
+		t.Errorf("unexpected content %q", data)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("temporary file left behind: %v", entries)
+	}
+}
+
+func TestPush(t *testing.T) {
+	var gotMethod, gotPath, gotType, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotMethod, gotPath, gotType, gotBody = r.Method, r.URL.Path, r.Header.Get("Content-Type"), string(body)
+	}))
+	defer server.Close()
+
+	fams := []Family{New("x", Gauge, "X.", 1)}
+	if err := Push(context.Background(), server.URL+"/", "stray finder", fams, true); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if gotMethod != http.MethodPut || gotPath != "/metrics/job/stray finder" || gotType != ContentType || !strings.HasSuffix(gotBody, "x 1\n") {
+		t.Errorf("got %s %s %q %q", gotMethod, gotPath, gotType, gotBody)
+	}
+
+	// A grouping key in the URL is used as given.
+	if err := Push(context.Background(), server.URL+"/metrics/job/nas/instance/a", "ignored", fams, false); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if gotMethod != http.MethodPost || gotPath != "/metrics/job/nas/instance/a" {
+		t.Errorf("got %s %s", gotMethod, gotPath)
+	}
+}
+
+func TestPush_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad metric", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	err := Push(context.Background(), server.URL, "job", []Family{New("x", Gauge, "X.", 1)}, true)
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("expected a status error, got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/immich"
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/progress"
+)
+
+// cmdMissing lists the assets whose files are missing from the library:
+// the reverse of a scan, which lists the files no asset points to.
+func cmdMissing(ctx context.Context, args []string) int {
+	var cfg config
+	fs := newFlagSet("missing", &cfg)
+	fs.BoolVar(&cfg.derivatives, "derivatives", false, "Also check the thumbnails, previews, and encoded videos of the assets; needs --db-url")
+	fs.BoolVar(&cfg.foldCase, "fold-case", false, "Compare file names case-insensitively (for case-insensitive storage)")
+	addUnicodeFlag(fs, &cfg)
+	fs.StringVar(&cfg.output, "output", "text", "text lists the missing files on stderr; json prints them as a JSON report on stdout")
+	if ok, code := parseFlags(fs, &cfg, args); !ok {
+		return code
+	}
+	if !requireConnection(fs, &cfg) {
+		return exitError
+	}
+	if cfg.output != "text" && cfg.output != "json" {
+		fmt.Fprintf(os.Stderr, "Error: --output must be text or json, not %q\n", cfg.output)
+		return exitError
+	}
+	if cfg.derivatives && cfg.dbURL == "" {
+		fmt.Fprintln(os.Stderr, "Error: --derivatives needs --db-url")
+		return exitError
+	}
+	logger := newLogger(&cfg)
+
+	if err := discoverLayout(ctx, &cfg, logger); err != nil {
+		logger.Error("fatal error", "error", err)
+		return exitError
+	}
+	rep, err := findMissing(ctx, &cfg, logger)
+	if err != nil {
+		logger.Error("fatal error", "error", err)
+		return exitError
+	}
+	if cfg.output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			logger.Error("fatal error", "error", err)
+			return exitError
+		}
+		return exitOK
+	}
+	printMissing(rep)
+	return exitOK
+}
+
+// missingReport is the result of the missing command.
+type missingReport struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Assets      int       `json:"assets_checked"`
+	Files       int       `json:"files_checked"`
+	// Unchecked counts the files whose path does not start with
+	// --path-prefix, so they cannot be looked for in the library.
+	Unchecked int           `json:"unchecked,omitempty"`
+	Missing   []missingFile `json:"missing"`
+}
+
+// missingFile is a file an asset points to that is not in the library.
+type missingFile struct {
+	AssetID string `json:"asset_id"`
+	OwnerID string `json:"owner_id,omitempty"`
+	// Kind is original, or with --derivatives thumbnail, preview,
+	// encoded-video, and the like.
+	Kind string `json:"kind"`
+	// Path is relative to --library-path.
+	Path string `json:"path"`
+}
+
+// findMissing fetches the assets and looks for their files.
+func findMissing(ctx context.Context, cfg *config, logger *slog.Logger) (*missingReport, error) {
+	if err := checkLibrary(cfg); err != nil {
+		return nil, err
+	}
+	checkMarkers(cfg, logger)
+	result, err := fetchAssetFiles(ctx, cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]missingFile, 0, len(result.Files))
+	for _, f := range result.Files {
+		files = append(files, missingFile{AssetID: f.ID, OwnerID: f.OwnerID, Kind: "original", Path: f.OriginalPath})
+	}
+	if cfg.derivatives {
+		derived, err := immich.FetchDerivativesFromDB(ctx, cfg.dbURL)
+		if err != nil {
+			return nil, fmt.Errorf("fetch derivatives: %w", err)
+		}
+		for _, d := range derived {
+			files = append(files, missingFile{AssetID: d.AssetID, Kind: d.Kind, Path: d.Path})
+		}
+	}
+	logger.Info("checking asset files", "assets", len(result.Files), "files", len(files))
+
+	rep := &missingReport{GeneratedAt: time.Now().UTC(), Assets: len(result.Files), Files: len(files), Missing: []missingFile{}}
+	lib := &libraryLookup{root: cfg.libraryPath, mounts: cfg.mounts, norm: cfg.normalizer(), dirs: make(map[string]map[string]bool)}
+	bar := progress.Track("checking files", "files", int64(len(files)))
+	defer bar.Finish()
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		bar.Add(1)
+		rel := cfg.libraryRel(f.Path)
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			rep.Unchecked++
+			continue
+		}
+		found, err := lib.exists(rel)
+		if err != nil {
+			logger.Warn("cannot check asset file", "path", rel, "asset_id", f.AssetID, "error", err)
+			continue
+		}
+		if !found {
+			f.Path = rel
+			rep.Missing = append(rep.Missing, f)
+		}
+	}
+	if rep.Unchecked > 0 {
+		logger.Warn("asset paths outside --path-prefix were not checked", "files", rep.Unchecked, "path_prefix", cfg.pathPrefix)
+	}
+	logger.Info("checked asset files", "files", len(files), "missing", len(rep.Missing))
+	return rep, nil
+}
+
+// fetchAssetFiles fetches the assets, from the database with --db-url or
+// else the API key owner's from the API, for the commands that check the
+// files of the assets rather than scan the library.
+func fetchAssetFiles(ctx context.Context, cfg *config, logger *slog.Logger) (*immich.AllAssetsResult, error) {
+	bar := progress.Track("fetching assets", "assets", 0)
+	defer bar.Finish()
+	var result *immich.AllAssetsResult
+	var err error
+	if cfg.dbURL != "" {
+		logger.Info("fetching all assets from database", "db", cfg.dbURL)
+		result, err = immich.FetchAllAssetsFromDB(progress.NewContext(ctx, bar), cfg.dbURL)
+	} else {
+		logger.Info("fetching asset paths from Immich", "url", cfg.immichURL)
+		result, err = immich.NewClient(cfg.immichURL, cfg.apiKey, logger).FetchAllAssets(progress.NewContext(ctx, bar))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetch assets: %w", err)
+	}
+	return result, nil
+}
+
+// libraryLookup finds files in the library by library-relative path. A
+// name missing as given is looked for among its directory's entries with
+// norm applied to both, so NFD names on disk match the NFC paths Immich
+// stores, as in a scan.
+type libraryLookup struct {
+	root   string
+	mounts []paths.Mount
+	norm   *paths.Normalizer
+	// dirs caches the normalized entry names of the directories listed.
+	dirs map[string]map[string]bool
+}
+
+func (l *libraryLookup) exists(rel string) (bool, error) {
+	_, err := os.Lstat(paths.Join(l.root, l.mounts, rel))
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	dir := path.Dir(rel)
+	names, ok := l.dirs[dir]
+	if !ok {
+		entries, err := os.ReadDir(paths.Join(l.root, l.mounts, dir))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return false, err
+		}
+		names = make(map[string]bool, len(entries))
+		for _, e := range entries {
+			names[l.norm.Key(e.Name())] = true
+		}
+		l.dirs[dir] = names
+	}
+	return names[l.norm.Key(path.Base(rel))], nil
+}
+
+// printMissing lists the missing files on stderr.
+func printMissing(rep *missingReport) {
+	if len(rep.Missing) == 0 {
+		fmt.Fprintf(stderr, "\nAll %d file(s) of %d asset(s) are in the library.\n", rep.Files-rep.Unchecked, rep.Assets)
+		return
+	}
+	fmt.Fprintf(stderr, "\n%d of %d file(s) of %d asset(s) are missing from the library:\n", len(rep.Missing), rep.Files-rep.Unchecked, rep.Assets)
+	for _, f := range rep.Missing {
+		fmt.Fprintf(stderr, "  %-13s %s  (asset %s)\n", f.Kind, f.Path, f.AssetID)
+	}
+}
@@ -0,0 +1,374 @@
+package mover
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/readonly"
+	"github.com/goeland86/immich-stray-finder/throttle"
+)
+
+// Archive is a tar file strays are streamed into instead of a directory
+// tree, compressed according to its extension: .tar.zst or .tzst with the
+// zstd command, .tar.gz or .tgz with gzip, or plain .tar. It is written
+// next to its final name and only renamed into place by Close, so a
+// complete archive never holds a truncated stream.
+type Archive struct {
+	path string
+	f    *os.File
+	// zw compresses into f; cmd is the zstd process behind it, if any.
+	zw  io.WriteCloser
+	cmd *exec.Cmd
+	tw  *tar.Writer
+	// names holds the names written so far, which must be unique.
+	names map[string]bool
+	// broken is set once a failed write left the stream unusable.
+	broken bool
+	// limit, if set, paces the writes.
+	limit *throttle.Limiter
+}
+
+// archiveCompression returns the compression selected by the extension of
+// path: "zstd", "gzip", or "" for none.
+func archiveCompression(path string) (string, error) {
+	name := strings.ToLower(filepath.Base(path))
+	switch {
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		return "zstd", nil
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "gzip", nil
+	case strings.HasSuffix(name, ".tar"):
+		return "", nil
+	}
+	return "", fmt.Errorf("archive %s: name must end in .tar.zst, .tar.gz, or .tar", path)
+}
+
+// ValidArchivePath reports whether path names an archive CreateArchive can
+// write, and that it does not exist yet.
+func ValidArchivePath(path string) error {
+	comp, err := archiveCompression(path)
+	if err != nil {
+		return err
+	}
+	if comp == "zstd" {
+		if _, err := exec.LookPath("zstd"); err != nil {
+			return fmt.Errorf("archive %s: zstd compression needs the zstd command: %w", path, err)
+		}
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return fmt.Errorf("archive %s already exists", path)
+	}
+	return nil
+}
+
+// CreateArchive starts writing the archive at path, which must not exist.
+func CreateArchive(path string) (*Archive, error) {
+	if err := ValidArchivePath(path); err != nil {
+		return nil, err
+	}
+	if err := readonly.Check("write archive"); err != nil {
+		return nil, err
+	}
+	comp, _ := archiveCompression(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create archive directory: %w", err)
+	}
+	f, err := os.OpenFile(partialPath(path), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("create archive: %w", err)
+	}
+
+	a := &Archive{path: path, f: f, names: make(map[string]bool)}
+	switch comp {
+	case "zstd":
+		a.cmd = exec.Command("zstd", "-q", "-c", "-T0")
+		a.cmd.Stdout = f
+		a.cmd.Stderr = os.Stderr
+		if a.zw, err = a.cmd.StdinPipe(); err == nil {
+			err = a.cmd.Start()
+		}
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, fmt.Errorf("start zstd: %w", err)
+		}
+	case "gzip":
+		a.zw = gzip.NewWriter(f)
+	default:
+		a.zw = nopCloser{f}
+	}
+	a.tw = tar.NewWriter(a.zw)
+	return a, nil
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// Path returns the final path of the archive.
+func (a *Archive) Path() string {
+	return a.path
+}
+
+// add streams the file at src into the archive as name, and returns the
+// SHA-256 of what was written.
+func (a *Archive) add(name, src string) (string, error) {
+	if a.names[name] {
+		return "", fmt.Errorf("%s is already in the archive", name)
+	}
+	a.names[name] = true
+	f, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("archive %s: not a regular file", src)
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return "", err
+	}
+	hdr.Name = name
+	hdr.Uname, hdr.Gname = "", ""
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		a.broken = true
+		return "", fmt.Errorf("write archive: %w", err)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(throttle.Writer(a.tw, a.limit), h), f); err != nil {
+		a.broken = true
+		return "", fmt.Errorf("write archive: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Close completes the archive, flushes it to disk, and renames it into
+// place.
+func (a *Archive) Close() error {
+	err := a.tw.Close()
+	if cerr := a.zw.Close(); err == nil {
+		err = cerr
+	}
+	if a.cmd != nil {
+		if werr := a.cmd.Wait(); err == nil && werr != nil {
+			err = fmt.Errorf("zstd: %w", werr)
+		}
+	}
+	if err == nil {
+		err = a.f.Sync()
+	}
+	if cerr := a.f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(a.f.Name(), a.path)
+	}
+	if err == nil {
+		err = syncDir(filepath.Dir(a.path))
+	}
+	if err != nil {
+		os.Remove(a.f.Name())
+		return fmt.Errorf("finish archive %s: %w", a.path, err)
+	}
+	return nil
+}
+
+// Abort stops writing the archive and removes what was written.
+func (a *Archive) Abort() {
+	a.zw.Close()
+	if a.cmd != nil {
+		a.cmd.Wait()
+	}
+	a.f.Close()
+	os.Remove(a.f.Name())
+}
+
+// removeArchived removes src, the stray of the archived entry e, unless it
+// changed since it was archived. It reports whether src is gone.
+func removeArchived(e ManifestEntry, src string, logger *slog.Logger) (bool, error) {
+	info, err := os.Lstat(src)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("stat %s: %w", src, err)
+	}
+	if info.Size() != e.Size || !info.ModTime().Equal(e.ModTime) {
+		logger.Warn("stray changed after it was archived, keeping it", "src", src, "archive", e.Archive)
+		return false, nil
+	}
+	if err := readonly.Check("remove archived stray"); err != nil {
+		return false, err
+	}
+	if err := os.Remove(src); err != nil {
+		return false, fmt.Errorf("remove %s: %w", src, err)
+	}
+	logger.Info("archived file", "src", src, "archive", e.Archive, "name", e.Dest)
+	return true, nil
+}
+
+// extractArchived restores the archived entries, all from the same
+// archive, to their place in the library. It reads the archive once and
+// returns the sources of the entries it could not restore.
+func extractArchived(entries []ManifestEntry, libraryPath string, mounts []paths.Mount, dryRun bool, logger *slog.Logger) (restored []ManifestEntry, skipped []string, err error) {
+	archive := entries[0].Archive
+	wanted := make(map[string]ManifestEntry, len(entries))
+	for _, e := range entries {
+		dst := paths.Join(libraryPath, mounts, e.Source)
+		if _, err := os.Lstat(dst); err == nil {
+			logger.Warn("original location is occupied, not restoring", "path", dst)
+			skipped = append(skipped, e.Source)
+			continue
+		}
+		wanted[e.Dest] = e
+	}
+	if len(wanted) == 0 {
+		return nil, skipped, nil
+	}
+
+	r, closeArchive, err := openArchive(archive)
+	if errors.Is(err, fs.ErrNotExist) {
+		logger.Warn("archive to restore from is gone, cannot restore", "path", archive)
+		for _, e := range wanted {
+			skipped = append(skipped, e.Source)
+		}
+		return nil, skipped, nil
+	}
+	if err != nil {
+		return nil, skipped, err
+	}
+	defer closeArchive()
+
+	for len(wanted) > 0 {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return restored, skipped, fmt.Errorf("read archive %s: %w", archive, err)
+		}
+		e, ok := wanted[hdr.Name]
+		if !ok {
+			continue
+		}
+		delete(wanted, hdr.Name)
+		dst := paths.Join(libraryPath, mounts, e.Source)
+		if dryRun {
+			logger.Info("[dry-run] would restore from archive", "archive", archive, "name", hdr.Name, "dst", dst)
+			restored = append(restored, e)
+			continue
+		}
+		ok, err = extractFile(r, hdr, dst, e)
+		if err != nil {
+			return restored, skipped, fmt.Errorf("restore %s from %s: %w", dst, archive, err)
+		}
+		if !ok {
+			logger.Warn("archived copy differs from the file the manifest recorded, not restoring", "archive", archive, "name", hdr.Name)
+			skipped = append(skipped, e.Source)
+			continue
+		}
+		logger.Info("restored file from archive", "archive", archive, "name", hdr.Name, "dst", dst)
+		restored = append(restored, e)
+	}
+	for _, e := range wanted {
+		logger.Warn("file is missing from the archive, cannot restore", "archive", archive, "name", e.Dest)
+		skipped = append(skipped, e.Source)
+	}
+	return restored, skipped, nil
+}
+
+// extractFile writes the current file of r to dst, provided its checksum
+// matches e. It reports whether it did.
+func extractFile(r io.Reader, hdr *tar.Header, dst string, e ManifestEntry) (bool, error) {
+	if err := readonly.Check("restore file"); err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return false, fmt.Errorf("create directory %s: %w", filepath.Dir(dst), err)
+	}
+	tmp := partialPath(dst)
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, hdr.FileInfo().Mode().Perm())
+	if err != nil {
+		return false, err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && e.SHA256 != "" && hex.EncodeToString(h.Sum(nil)) != e.SHA256 {
+		os.Remove(tmp)
+		return false, nil
+	}
+	if err == nil {
+		mtime := hdr.ModTime
+		if !e.ModTime.IsZero() {
+			mtime = e.ModTime
+		}
+		os.Chtimes(tmp, time.Time{}, mtime)
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	return true, nil
+}
+
+// openArchive opens the archive at path for reading, decompressing it
+// according to its extension.
+func openArchive(path string) (*tar.Reader, func(), error) {
+	comp, err := archiveCompression(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch comp {
+	case "zstd":
+		cmd := exec.Command("zstd", "-q", "-d", "-c")
+		cmd.Stdin = f
+		cmd.Stderr = os.Stderr
+		out, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("start zstd: %w", err)
+		}
+		return tar.NewReader(out), func() {
+			out.Close()
+			cmd.Wait()
+			f.Close()
+		}, nil
+	case "gzip":
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("read archive %s: %w", path, err)
+		}
+		return tar.NewReader(zr), func() { f.Close() }, nil
+	}
+	return tar.NewReader(f), func() { f.Close() }, nil
+}
@@ -0,0 +1,72 @@
+package mover
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveOrphans_Archive(t *testing.T) {
+	for _, name := range []string{"strays.tar", "strays.tar.gz", "strays.tar.zst"} {
+		t.Run(name, func(t *testing.T) {
+			if filepath.Ext(name) == ".zst" {
+				if _, err := exec.LookPath("zstd"); err != nil {
+					t.Skip("zstd not installed")
+				}
+			}
+			lib, quarantine := t.TempDir(), t.TempDir()
+			archive := filepath.Join(t.TempDir(), name)
+			os.MkdirAll(filepath.Join(lib, "thumbs", "u1"), 0o755)
+			os.WriteFile(filepath.Join(lib, "thumbs", "u1", "a.webp"), []byte("thumb a"), 0o644)
+			os.WriteFile(filepath.Join(lib, "b.jpg"), []byte("photo b"), 0o644)
+
+			sum, err := MoveOrphans(items("thumbs/u1/a.webp", "b.jpg"), lib, quarantine, Options{RunID: "run1", ArchivePath: archive}, testLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sum.Archived != 2 || sum.ArchivedBytes != 14 {
+				t.Errorf("unexpected summary: %+v", sum)
+			}
+			if _, err := os.Stat(filepath.Join(lib, "b.jpg")); !os.IsNotExist(err) {
+				t.Error("expected the stray to be removed from the library")
+			}
+			if _, err := os.Stat(archive); err != nil {
+				t.Fatalf("expected the archive to be written: %v", err)
+			}
+			if runs, _ := PendingRuns(quarantine); len(runs) != 0 {
+				t.Errorf("expected no pending runs, got %v", runs)
+			}
+
+			if _, err := MoveOrphans(nil, lib, quarantine, Options{RunID: "run2", ArchivePath: archive}, testLogger()); err == nil {
+				t.Error("expected an existing archive not to be overwritten")
+			}
+
+			rsum, err := Restore(lib, quarantine, RestoreOptions{RunID: "run1"}, testLogger())
+			if err != nil || rsum.Restored != 2 || len(rsum.Skipped) != 0 {
+				t.Fatalf("expected both files to be restored, got %+v, %v", rsum, err)
+			}
+			data, err := os.ReadFile(filepath.Join(lib, "thumbs", "u1", "a.webp"))
+			if err != nil || string(data) != "thumb a" {
+				t.Errorf("unexpected restored content %q, %v", data, err)
+			}
+		})
+	}
+}
+
+func TestMoveOrphans_ArchiveKeepsStraysOnFailure(t *testing.T) {
+	lib, quarantine := t.TempDir(), t.TempDir()
+	archive := filepath.Join(t.TempDir(), "strays.tar")
+	os.WriteFile(filepath.Join(lib, "a.jpg"), []byte("a"), 0o644)
+	os.Mkdir(filepath.Join(lib, "dir.jpg"), 0o755)
+
+	if _, err := MoveOrphans(items("a.jpg", "dir.jpg"), lib, quarantine, Options{RunID: "run1", ArchivePath: archive}, testLogger()); err == nil {
+		t.Fatal("expected archiving a directory to fail")
+	}
+	if _, err := os.Stat(filepath.Join(lib, "a.jpg")); err != nil {
+		t.Error("expected a.jpg to stay in the library")
+	}
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Error("expected the incomplete archive to be removed")
+	}
+}
@@ -0,0 +1,70 @@
+package mover
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goeland86/immich-stray-finder/audit"
+)
+
+func TestAuditLog_RecordsMovesAndPurges(t *testing.T) {
+	lib, quarantine := t.TempDir(), t.TempDir()
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	os.MkdirAll(filepath.Join(lib, "upload", "u1"), 0o755)
+	os.WriteFile(filepath.Join(lib, "upload", "u1", "a.jpg"), []byte("photo a"), 0o644)
+	os.WriteFile(filepath.Join(lib, "upload", "u1", "b.jpg"), []byte("photo a"), 0o644)
+	os.WriteFile(filepath.Join(lib, "immich-db-backup-1.sql.gz"), []byte("dump"), 0o644)
+	os.MkdirAll(filepath.Join(lib, BackupsDir), 0o755)
+
+	log, err := audit.Open(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	opts := Options{RunID: "run1", Dedupe: true, Audit: log}
+	if _, err := MoveOrphans(items("upload/u1/a.jpg"), lib, quarantine, opts, testLogger()); err != nil {
+		t.Fatal(err)
+	}
+	opts.RunID = "run2"
+	if _, err := MoveOrphans(items("upload/u1/b.jpg"), lib, quarantine, opts, testLogger()); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := RelocateBackups([]string{"immich-db-backup-1.sql.gz"}, lib, opts, testLogger()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Purge(quarantine, PurgeOptions{RunID: "run1", Audit: log}, testLogger()); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := audit.Load(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("photo a"))
+	photo := hex.EncodeToString(sum[:])
+	quarantined := filepath.Join(quarantine, "upload", "u1", "a.jpg")
+	want := []audit.Entry{
+		{RunID: "run1", Action: ActionMoved, Source: filepath.Join(lib, "upload", "u1", "a.jpg"), Dest: quarantined, Size: 7, SHA256: photo},
+		{RunID: "run2", Action: ActionDeduplicated, Source: filepath.Join(lib, "upload", "u1", "b.jpg"), DuplicateOf: quarantined, Size: 7, SHA256: photo},
+		{RunID: "run2", Action: ActionRelocated, Source: filepath.Join(lib, "immich-db-backup-1.sql.gz"), Dest: filepath.Join(lib, BackupsDir, "immich-db-backup-1.sql.gz"), Size: 4},
+		{RunID: "run1", Action: ActionPurged, Source: quarantined, Size: 7, SHA256: photo},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, e := range entries {
+		if e.Time.IsZero() {
+			t.Errorf("entry %d has no time", i)
+		}
+		e.Time = want[i].Time
+		if i == 2 {
+			e.SHA256 = "" // the dump's digest is not worth spelling out
+		}
+		if e != want[i] {
+			t.Errorf("entry %d:\n got %+v\nwant %+v", i, e, want[i])
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package mover
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/goeland86/immich-stray-finder/audit"
+	"github.com/goeland86/immich-stray-finder/paths"
+)
+
+// BackupsDir is where Immich keeps its database dumps.
+const BackupsDir = "backups"
+
+// RelocateBackups moves misplaced Immich database dumps into the library's
+// backups/ directory, where Immich's own retention manages them. They are
+// not quarantined and no manifest is written: they belong to Immich, not to
+// any asset. A dump whose name is already taken in backups/, or that
+// vanished before it could be moved, is left alone and returned in skipped.
+// Of opts, only DryRun, RunID, Audit, and Mounts apply; backups/ may be
+// one of the mounts.
+func RelocateBackups(relPaths []string, libraryPath string, opts Options, logger *slog.Logger) (moved int, skipped []string, err error) {
+	backups := paths.Join(libraryPath, opts.Mounts, BackupsDir)
+	for _, relPath := range relPaths {
+		src := paths.Join(libraryPath, opts.Mounts, relPath)
+		dst := filepath.Join(backups, path.Base(relPath))
+
+		if _, err := os.Lstat(dst); err == nil {
+			logger.Warn("a backup with this name already exists, leaving the misplaced copy", "src", src, "dst", dst)
+			skipped = append(skipped, relPath)
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return moved, skipped, fmt.Errorf("stat %s: %w", dst, err)
+		}
+
+		if opts.DryRun {
+			logger.Info("[dry-run] would relocate database dump", "src", src, "dst", dst)
+			moved++
+			continue
+		}
+		entry := audit.Entry{RunID: opts.RunID, Action: ActionRelocated, Source: src, Dest: dst}
+		var ferr error
+		if opts.Audit != nil {
+			entry.Size, entry.SHA256, ferr = fileDigest(src)
+		}
+		if ferr == nil {
+			ferr = moveFile(src, dst, false, nil, logger)
+		}
+		if ferr != nil {
+			if isVanished(src, ferr) {
+				skipped = append(skipped, relPath)
+				continue
+			}
+			return moved, skipped, fmt.Errorf("relocate %s -> %s: %w", src, dst, ferr)
+		}
+		logger.Info("relocated database dump", "src", src, "dst", dst)
+		moved++
+		if err := opts.Audit.Record(entry); err != nil {
+			return moved, skipped, err
+		}
+	}
+	return moved, skipped, nil
+}
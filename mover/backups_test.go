@@ -0,0 +1,86 @@
+package mover
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goeland86/immich-stray-finder/paths"
+)
+
+func TestRelocateBackups(t *testing.T) {
+	lib := t.TempDir()
+	os.MkdirAll(filepath.Join(lib, "library", "admin"), 0o755)
+	os.MkdirAll(filepath.Join(lib, "backups"), 0o755)
+	os.WriteFile(filepath.Join(lib, "library", "admin", "immich-db-backup-1.sql.gz"), []byte("dump1"), 0o644)
+	os.WriteFile(filepath.Join(lib, "immich-db-backup-2.sql.gz"), []byte("dump2"), 0o644)
+	os.WriteFile(filepath.Join(lib, "backups", "immich-db-backup-2.sql.gz"), []byte("existing"), 0o644)
+
+	moved, skipped, err := RelocateBackups([]string{
+		"library/admin/immich-db-backup-1.sql.gz",
+		"immich-db-backup-2.sql.gz",
+	}, lib, Options{}, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if moved != 1 || len(skipped) != 1 || skipped[0] != "immich-db-backup-2.sql.gz" {
+		t.Errorf("expected 1 moved and the name clash skipped, got %d moved, skipped %v", moved, skipped)
+	}
+	if data, _ := os.ReadFile(filepath.Join(lib, "backups", "immich-db-backup-1.sql.gz")); string(data) != "dump1" {
+		t.Error("expected dump relocated into backups/")
+	}
+	if data, _ := os.ReadFile(filepath.Join(lib, "backups", "immich-db-backup-2.sql.gz")); string(data) != "existing" {
+		t.Error("existing backup must not be overwritten")
+	}
+}
+
+func TestRelocateBackups_Vanished(t *testing.T) {
+	lib := t.TempDir()
+	os.WriteFile(filepath.Join(lib, "immich-db-backup-2.sql.gz"), []byte("dump2"), 0o644)
+
+	// The first dump vanishes between the scan and the move; the second
+	// must still be relocated.
+	moved, skipped, err := RelocateBackups([]string{
+		"immich-db-backup-1.sql.gz",
+		"immich-db-backup-2.sql.gz",
+	}, lib, Options{}, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if moved != 1 || len(skipped) != 1 || skipped[0] != "immich-db-backup-1.sql.gz" {
+		t.Errorf("expected 1 moved and the vanished dump skipped, got %d moved, skipped %v", moved, skipped)
+	}
+	if data, _ := os.ReadFile(filepath.Join(lib, "backups", "immich-db-backup-2.sql.gz")); string(data) != "dump2" {
+		t.Error("expected the second dump relocated into backups/")
+	}
+}
+
+func TestRelocateBackups_Mount(t *testing.T) {
+	lib, backups := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(lib, "immich-db-backup-1.sql.gz"), []byte("dump"), 0o644)
+
+	opts := Options{Mounts: []paths.Mount{{Rel: "backups", Dir: backups}}}
+	moved, _, err := RelocateBackups([]string{"immich-db-backup-1.sql.gz"}, lib, opts, testLogger())
+	if err != nil || moved != 1 {
+		t.Fatalf("expected 1 relocation, got %d, %v", moved, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(backups, "immich-db-backup-1.sql.gz")); string(data) != "dump" {
+		t.Error("expected the dump relocated into the backups/ mount")
+	}
+	if _, err := os.Stat(filepath.Join(lib, "backups")); err == nil {
+		t.Error("backups/ must not be created below the library root")
+	}
+}
+
+func TestRelocateBackups_DryRun(t *testing.T) {
+	lib := t.TempDir()
+	os.WriteFile(filepath.Join(lib, "immich-db-backup-1.sql.gz"), []byte("dump"), 0o644)
+
+	moved, _, err := RelocateBackups([]string{"immich-db-backup-1.sql.gz"}, lib, Options{DryRun: true}, testLogger())
+	if err != nil || moved != 1 {
+		t.Fatalf("expected 1 would-be relocation, got %d, %v", moved, err)
+	}
+	if _, err := os.Stat(filepath.Join(lib, "immich-db-backup-1.sql.gz")); err != nil {
+		t.Error("dry run must not move the dump")
+	}
+}
@@ -0,0 +1,79 @@
+package mover
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+)
+
+// ConflictPolicy decides what happens to a stray whose destination is
+// already taken, e.g. by a stray an earlier run quarantined under the same
+// name.
+type ConflictPolicy string
+
+const (
+	// ConflictError stops the run. It is the default.
+	ConflictError ConflictPolicy = "error"
+	// ConflictSkip leaves the stray in the library and records it as
+	// ActionSkipped.
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictRename places the stray next to the taken destination, with
+	// the start of its checksum, or the time if that name is taken too,
+	// appended to its name.
+	ConflictRename ConflictPolicy = "rename"
+	// ConflictOverwrite replaces the file at the destination.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+)
+
+// ParseConflictPolicy parses skip, rename, overwrite, or error. The empty
+// string is ConflictError.
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(s); p {
+	case ConflictError, ConflictSkip, ConflictRename, ConflictOverwrite:
+		return p, nil
+	case "":
+		return ConflictError, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy %q, want skip, rename, overwrite, or error", s)
+}
+
+// resolveConflict applies opts.OnConflict to entry, whose destination dst
+// is taken; taken reports whether another destination is. The entry
+// returned records the decision in Conflict, and has ActionSkipped if the
+// stray is to stay where it is.
+func resolveConflict(entry ManifestEntry, src, dst string, taken func(dest string) (bool, error), opts Options, logger *slog.Logger) (ManifestEntry, error) {
+	switch opts.OnConflict {
+	case ConflictSkip:
+		logger.Warn("destination already exists, leaving the stray in place", "src", src, "dst", dst)
+		entry.Action, entry.Conflict = ActionSkipped, ConflictSkip
+		return entry, nil
+	case ConflictOverwrite:
+		logger.Warn("destination already exists, replacing it", "src", src, "dst", dst)
+		entry.Conflict = ConflictOverwrite
+		return entry, nil
+	case ConflictRename:
+		ext := path.Ext(entry.Dest)
+		base := strings.TrimSuffix(entry.Dest, ext)
+		var suffixes []string
+		if len(entry.SHA256) >= 8 {
+			suffixes = append(suffixes, entry.SHA256[:8])
+		}
+		suffixes = append(suffixes, time.Now().UTC().Format("20060102T150405Z"))
+		for _, suffix := range suffixes {
+			dest := base + "." + suffix + ext
+			found, err := taken(dest)
+			if err != nil {
+				return entry, err
+			}
+			if !found {
+				logger.Warn("destination already exists, renaming the stray", "src", src, "dst", dst, "dest", dest)
+				entry.Dest, entry.Conflict = dest, ConflictRename
+				return entry, nil
+			}
+		}
+		return entry, fmt.Errorf("move %s -> %s: destination and its renamed alternatives already exist", src, dst)
+	}
+	return entry, fmt.Errorf("move %s -> %s: destination already exists", src, dst)
+}
@@ -0,0 +1,82 @@
+package mover
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMoveOrphans_OnConflict(t *testing.T) {
+	tests := []struct {
+		policy     ConflictPolicy
+		wantErr    bool
+		wantAction string
+		// wantQuarantined is the content of a.jpg in the quarantine.
+		wantQuarantined string
+		wantInLibrary   bool
+	}{
+		{policy: "", wantErr: true, wantQuarantined: "earlier", wantInLibrary: true},
+		{policy: ConflictError, wantErr: true, wantQuarantined: "earlier", wantInLibrary: true},
+		{policy: ConflictSkip, wantAction: ActionSkipped, wantQuarantined: "earlier", wantInLibrary: true},
+		{policy: ConflictRename, wantAction: ActionMoved, wantQuarantined: "earlier"},
+		{policy: ConflictOverwrite, wantAction: ActionMoved, wantQuarantined: "stray"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			lib, quarantine := t.TempDir(), t.TempDir()
+			os.WriteFile(filepath.Join(lib, "a.jpg"), []byte("stray"), 0o644)
+			os.WriteFile(filepath.Join(quarantine, "a.jpg"), []byte("earlier"), 0o644)
+
+			sum, err := MoveOrphans(items("a.jpg"), lib, quarantine, Options{RunID: "run1", OnConflict: tt.policy}, testLogger())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MoveOrphans() error = %v, want error %v", err, tt.wantErr)
+			}
+			if data, _ := os.ReadFile(filepath.Join(quarantine, "a.jpg")); string(data) != tt.wantQuarantined {
+				t.Errorf("quarantined a.jpg = %q, want %q", data, tt.wantQuarantined)
+			}
+			if _, err := os.Stat(filepath.Join(lib, "a.jpg")); (err == nil) != tt.wantInLibrary {
+				t.Errorf("stray in library = %v, want %v", err == nil, tt.wantInLibrary)
+			}
+			if tt.wantErr {
+				return
+			}
+			entries, err := LoadRun(quarantine, "run1")
+			if err != nil || len(entries) != 1 {
+				t.Fatalf("expected one manifest entry, got %+v, %v", entries, err)
+			}
+			e := entries[0]
+			if e.Action != tt.wantAction || e.Conflict != tt.policy {
+				t.Errorf("manifest entry %+v, want action %s and conflict %s", e, tt.wantAction, tt.policy)
+			}
+			if tt.policy == ConflictSkip && len(sum.Skipped) != 1 {
+				t.Errorf("expected the stray to be reported as skipped, got %+v", sum)
+			}
+			if tt.policy == ConflictRename {
+				if want := "a." + e.SHA256[:8] + ".jpg"; e.Dest != want {
+					t.Errorf("renamed destination = %q, want %q", e.Dest, want)
+				}
+				if data, _ := os.ReadFile(filepath.Join(quarantine, e.Dest)); string(data) != "stray" {
+					t.Errorf("renamed stray = %q, want %q", data, "stray")
+				}
+				// A second stray with the same content gets a time suffix.
+				os.WriteFile(filepath.Join(lib, "a.jpg"), []byte("stray"), 0o644)
+				sum, err := MoveOrphans(items("a.jpg"), lib, quarantine, Options{RunID: "run2", OnConflict: tt.policy}, testLogger())
+				if err != nil || len(sum.Entries) != 1 || strings.Contains(sum.Entries[0].Dest, e.SHA256[:8]) {
+					t.Errorf("expected a time suffix, got %+v, %v", sum, err)
+				}
+			}
+		})
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	for in, want := range map[string]ConflictPolicy{"": ConflictError, "skip": ConflictSkip, "rename": ConflictRename} {
+		if got, err := ParseConflictPolicy(in); err != nil || got != want {
+			t.Errorf("ParseConflictPolicy(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseConflictPolicy("clobber"); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
@@ -0,0 +1,100 @@
+package mover
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+
+	"github.com/goeland86/immich-stray-finder/readonly"
+)
+
+// deleteImmichDuplicate deletes src if it is still byte-for-byte identical
+// to twin, the original of an Immich asset. It reports false, leaving src
+// alone, when twin is gone or differs: the stray is then moved as usual.
+// It refuses with an error when src and twin are the same file, as they
+// are through a hardlink, or on storage that ignores case or Unicode
+// normalization in names, where deleting src would delete the original.
+func deleteImmichDuplicate(src, twin string, dryRun bool, logger *slog.Logger) (bool, error) {
+	if si, err := os.Stat(src); err == nil {
+		if ti, err := os.Stat(twin); err == nil && os.SameFile(si, ti) {
+			return false, fmt.Errorf("refusing to delete %s: it is the same file as the Immich original %s", src, twin)
+		}
+	}
+	same, err := sameContent(src, twin)
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, serr := os.Lstat(src); serr != nil {
+			return false, err
+		}
+		logger.Warn("Immich duplicate is gone, moving the stray instead", "src", src, "duplicate_of", twin)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("compare %s with %s: %w", src, twin, err)
+	}
+	if !same {
+		logger.Warn("stray no longer matches its Immich duplicate, moving it instead", "src", src, "duplicate_of", twin)
+		return false, nil
+	}
+
+	if dryRun {
+		logger.Info("[dry-run] would delete duplicate of Immich asset", "src", src, "duplicate_of", twin)
+		return true, nil
+	}
+	if err := readonly.Check("delete duplicate"); err != nil {
+		return false, err
+	}
+	if err := os.Remove(src); err != nil {
+		return false, fmt.Errorf("delete duplicate %s: %w", src, err)
+	}
+	logger.Info("deleted duplicate of Immich asset", "src", src, "duplicate_of", twin)
+	return true, nil
+}
+
+// sameContent reports whether the files at a and b have the same content.
+func sameContent(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	ia, err := fa.Stat()
+	if err != nil {
+		return false, err
+	}
+	ib, err := fb.Stat()
+	if err != nil {
+		return false, err
+	}
+	if ia.Size() != ib.Size() {
+		return false, nil
+	}
+
+	bufA, bufB := make([]byte, 64<<10), make([]byte, 64<<10)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		endA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		endB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		switch {
+		case errA != nil && !endA:
+			return false, errA
+		case errB != nil && !endB:
+			return false, errB
+		case endA || endB:
+			return endA && endB, nil
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package mover
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveOrphans_DeleteImmichDuplicates(t *testing.T) {
+	libDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	os.MkdirAll(filepath.Join(libDir, "library", "admin"), 0o755)
+	os.MkdirAll(filepath.Join(libDir, "upload"), 0o755)
+	os.WriteFile(filepath.Join(libDir, "library", "admin", "a.jpg"), []byte("same"), 0o644)
+	os.WriteFile(filepath.Join(libDir, "upload", "same.jpg"), []byte("same"), 0o644)
+	// Changed since the scan hashed it: must be moved, not deleted.
+	os.WriteFile(filepath.Join(libDir, "upload", "changed.jpg"), []byte("diff"), 0o644)
+
+	strays := []Item{
+		{RelPath: "upload/same.jpg", DuplicateOf: "library/admin/a.jpg"},
+		{RelPath: "upload/changed.jpg", DuplicateOf: "library/admin/a.jpg"},
+	}
+	sum, err := MoveOrphans(strays, libDir, dstDir, Options{DeleteDuplicates: true, RunID: "20240101T000000Z"}, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sum.ImmichDuplicates != 1 || sum.Moved != 1 {
+		t.Errorf("expected 1 deleted and 1 moved, got %+v", sum)
+	}
+	if _, err := os.Stat(filepath.Join(libDir, "upload", "same.jpg")); !os.IsNotExist(err) {
+		t.Error("identical stray should have been deleted")
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "upload", "changed.jpg")); err != nil {
+		t.Error("changed stray should have been quarantined")
+	}
+
+	// Restore copies the deleted stray back from the Immich original.
+	rsum, err := Restore(libDir, dstDir, RestoreOptions{}, testLogger())
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if rsum.Restored != 2 {
+		t.Errorf("expected 2 restored, got %+v", rsum)
+	}
+	if data, err := os.ReadFile(filepath.Join(libDir, "upload", "same.jpg")); err != nil || string(data) != "same" {
+		t.Errorf("same.jpg not restored: %q, %v", data, err)
+	}
+}
+
+func TestMoveOrphans_ImmichDuplicateSameFile(t *testing.T) {
+	libDir := t.TempDir()
+	os.WriteFile(filepath.Join(libDir, "a.jpg"), []byte("same"), 0o644)
+	if err := os.Link(filepath.Join(libDir, "a.jpg"), filepath.Join(libDir, "b.jpg")); err != nil {
+		t.Skipf("hardlinks not supported: %v", err)
+	}
+
+	for _, item := range []Item{
+		{RelPath: "b.jpg", DuplicateOf: "a.jpg"},
+		{RelPath: "a.jpg", DuplicateOf: "a.jpg"},
+	} {
+		_, err := MoveOrphans([]Item{item}, libDir, t.TempDir(), Options{DeleteDuplicates: true, RunID: "20240101T000000Z"}, testLogger())
+		if err == nil {
+			t.Errorf("%s: expected deleting the Immich original itself to be refused", item.RelPath)
+		}
+		for _, name := range []string{"a.jpg", "b.jpg"} {
+			if data, err := os.ReadFile(filepath.Join(libDir, name)); err != nil || string(data) != "same" {
+				t.Errorf("%s: %s should be untouched: %q, %v", item.RelPath, name, data, err)
+			}
+		}
+	}
+}
+
+func TestMoveOrphans_ImmichDuplicatesNeedOptIn(t *testing.T) {
+	libDir := t.TempDir()
+	dstDir := t.TempDir()
+	os.WriteFile(filepath.Join(libDir, "a.jpg"), []byte("same"), 0o644)
+	os.WriteFile(filepath.Join(libDir, "b.jpg"), []byte("same"), 0o644)
+
+	sum, err := MoveOrphans([]Item{{RelPath: "b.jpg", DuplicateOf: "a.jpg"}}, libDir, dstDir, Options{}, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sum.Moved != 1 || sum.ImmichDuplicates != 0 {
+		t.Errorf("expected the stray to be moved, got %+v", sum)
+	}
+}
+
+func TestMoveOrphans_Relink(t *testing.T) {
+	lib, dst := t.TempDir(), t.TempDir()
+	os.MkdirAll(filepath.Join(lib, "upload", "u1"), 0o755)
+	os.WriteFile(filepath.Join(lib, "upload", "u1", "a.jpg"), []byte("photo a"), 0o644)
+	os.WriteFile(filepath.Join(lib, "upload", "u1", "b.jpg"), []byte("photo b"), 0o644)
+	// b.jpg's asset got its original back since the scan.
+	os.MkdirAll(filepath.Join(lib, "library", "alice"), 0o755)
+	os.WriteFile(filepath.Join(lib, "library", "alice", "b.jpg"), []byte("photo b"), 0o644)
+	items := []Item{
+		{RelPath: "upload/u1/a.jpg", RelinkTo: "library/alice/2024/a.jpg"},
+		{RelPath: "upload/u1/b.jpg", RelinkTo: "library/alice/b.jpg"},
+	}
+
+	sum, err := MoveOrphans(items, lib, dst, Options{RunID: "run1", Relink: true}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Relinked != 1 || sum.Moved != 1 {
+		t.Errorf("expected 1 relinked and 1 moved, got %+v", sum)
+	}
+	if data, err := os.ReadFile(filepath.Join(lib, "library", "alice", "2024", "a.jpg")); err != nil || string(data) != "photo a" {
+		t.Errorf("expected a.jpg at the asset's original path, got %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "upload", "u1", "b.jpg")); err != nil {
+		t.Errorf("expected b.jpg to be quarantined: %v", err)
+	}
+
+	rsum, err := Restore(lib, dst, RestoreOptions{RunID: "run1"}, testLogger())
+	if err != nil || rsum.Restored != 2 {
+		t.Fatalf("expected both strays to be restored, got %+v, %v", rsum, err)
+	}
+	if _, err := os.Stat(filepath.Join(lib, "upload", "u1", "a.jpg")); err != nil {
+		t.Errorf("expected a.jpg back at its stray path: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(lib, "library", "alice", "2024", "a.jpg")); !os.IsNotExist(err) {
+		t.Error("expected a.jpg to be gone from the asset's original path")
+	}
+}
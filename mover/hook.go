@@ -0,0 +1,84 @@
+package mover
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// SuspiciousCategory replaces a stray's category when a Hook rejects it, so
+// layouts using {category} can tell such files apart.
+const SuspiciousCategory = "suspicious"
+
+// Hook is an external command run on each stray before it is quarantined,
+// e.g. a virus scanner such as clamdscan. A non-zero exit marks the stray
+// suspicious.
+type Hook struct {
+	// Args is the command line. An argument "{}" is replaced by the
+	// stray's absolute path; without one, the path is appended.
+	Args []string
+	// Timeout bounds a single invocation. A hook that times out marks the
+	// stray suspicious.
+	Timeout time.Duration
+}
+
+// ParseHook splits a command line on whitespace. Quoting is not supported;
+// wrap commands that need it in a script.
+func ParseHook(cmdline string, timeout time.Duration) (*Hook, error) {
+	args := strings.Fields(cmdline)
+	if len(args) == 0 {
+		return nil, errors.New("empty hook command")
+	}
+	if timeout <= 0 {
+		return nil, fmt.Errorf("hook timeout must be positive, got %s", timeout)
+	}
+	return &Hook{Args: args, Timeout: timeout}, nil
+}
+
+// Check runs the hook on the file at path. It returns a non-empty verdict
+// describing why the hook rejected the file: the first line of its output,
+// or its exit status when it printed nothing. The error is only set when
+// the hook could not be run at all, e.g. because the command is missing.
+func (h *Hook) Check(path string) (verdict string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+	defer cancel()
+
+	args := make([]string, 0, len(h.Args)+1)
+	substituted := false
+	for _, a := range h.Args {
+		if a == "{}" {
+			a, substituted = path, true
+		}
+		args = append(args, a)
+	}
+	if !substituted {
+		args = append(args, path)
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	// Don't wait for children of a killed hook that still hold its output.
+	cmd.WaitDelay = time.Second
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		return fmt.Sprintf("hook timed out after %s", h.Timeout), nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		for _, line := range strings.Split(out.String(), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				return line, nil
+			}
+		}
+		return exitErr.ProcessState.String(), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("run hook %s: %w", args[0], err)
+	}
+	return "", nil
+}
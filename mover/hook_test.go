@@ -0,0 +1,93 @@
+//go:build unix
+
+package mover
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeScanner rejects files containing "EICAR", like a virus scanner.
+var fakeScanner = &Hook{
+	Args:    []string{"sh", "-c", `if grep -q EICAR "$0"; then echo "$0: Eicar-Signature FOUND"; exit 1; fi`},
+	Timeout: 10 * time.Second,
+}
+
+func TestMoveOrphans_HookRoutesSuspiciousFiles(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	os.MkdirAll(filepath.Join(srcDir, "upload"), 0o755)
+	os.WriteFile(filepath.Join(srcDir, "upload", "clean.jpg"), []byte("photo"), 0o644)
+	os.WriteFile(filepath.Join(srcDir, "upload", "bad.jpg"), []byte("X5O EICAR"), 0o644)
+
+	layout, _ := ParseLayout("{category}/{relpath}")
+	sum, err := MoveOrphans([]Item{
+		{RelPath: "upload/bad.jpg", Category: "unmanaged"},
+		{RelPath: "upload/clean.jpg", Category: "unmanaged"},
+	}, srcDir, dstDir, Options{Layout: layout, Hook: fakeScanner, SuspiciousDir: "suspicious"}, testLogger())
+	if err != nil {
+		t.Fatalf("MoveOrphans: %v", err)
+	}
+	if sum.Moved != 2 || sum.Suspicious != 1 {
+		t.Errorf("moved %d, suspicious %d; want 2 and 1", sum.Moved, sum.Suspicious)
+	}
+
+	bad := sum.Entries[0]
+	if bad.Dest != "suspicious/suspicious/upload/bad.jpg" {
+		t.Errorf("suspicious file placed at %q", bad.Dest)
+	}
+	if !strings.Contains(bad.Suspicious, "Eicar-Signature FOUND") {
+		t.Errorf("verdict = %q, want the scanner's output", bad.Suspicious)
+	}
+	if got := sum.Entries[1]; got.Dest != "unmanaged/upload/clean.jpg" || got.Suspicious != "" {
+		t.Errorf("clean file: %+v", got)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "suspicious", "suspicious", "upload", "bad.jpg")); err != nil {
+		t.Errorf("suspicious file not moved: %v", err)
+	}
+}
+
+func TestMoveOrphans_HookNotRunInDryRun(t *testing.T) {
+	srcDir := t.TempDir()
+	os.WriteFile(filepath.Join(srcDir, "bad.jpg"), []byte("EICAR"), 0o644)
+
+	sum, err := MoveOrphans(items("bad.jpg"), srcDir, t.TempDir(), Options{DryRun: true, Hook: fakeScanner}, testLogger())
+	if err != nil {
+		t.Fatalf("MoveOrphans: %v", err)
+	}
+	if sum.Suspicious != 0 || sum.Entries[0].Dest != "bad.jpg" {
+		t.Errorf("dry run ran the hook: %+v", sum.Entries[0])
+	}
+}
+
+func TestHookCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f")
+	os.WriteFile(path, nil, 0o644)
+
+	tests := []struct {
+		name    string
+		hook    *Hook
+		verdict string
+		wantErr bool
+	}{
+		{"accepts", &Hook{Args: []string{"true"}, Timeout: time.Second}, "", false},
+		{"silent rejection", &Hook{Args: []string{"false"}, Timeout: time.Second}, "exit status 1", false},
+		{"placeholder", &Hook{Args: []string{"sh", "-c", `echo "got $1"; exit 2`, "sh", "{}"}, Timeout: time.Second}, "got " + path, false},
+		{"timeout", &Hook{Args: []string{"sh", "-c", "sleep 5"}, Timeout: 100 * time.Millisecond}, "hook timed out after 100ms", false},
+		{"missing command", &Hook{Args: []string{"no-such-scanner-command"}, Timeout: time.Second}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict, err := tt.hook.Check(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if verdict != tt.verdict {
+				t.Errorf("verdict = %q, want %q", verdict, tt.verdict)
+			}
+		})
+	}
+}
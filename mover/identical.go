@@ -0,0 +1,84 @@
+package mover
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/goeland86/immich-stray-finder/readonly"
+)
+
+// IdenticalPolicy decides what happens to a stray identical to one an
+// earlier stray of the same run was moved into the quarantine as. Repeated
+// failed imports leave many copies of the same files behind.
+type IdenticalPolicy string
+
+const (
+	// IdenticalKeep quarantines every copy on its own. It is the default.
+	IdenticalKeep IdenticalPolicy = "keep"
+	// IdenticalLink places the copy at its own destination as a hardlink
+	// of the first one, so it takes no space, and removes it from the
+	// library.
+	IdenticalLink IdenticalPolicy = "link"
+	// IdenticalDelete deletes the copy, recording it as ActionDeduplicated
+	// with the first one as DuplicateOf, as Dedupe does for copies
+	// quarantined by earlier runs.
+	IdenticalDelete IdenticalPolicy = "delete"
+)
+
+// ParseIdenticalPolicy parses keep, link, or delete. The empty string is
+// IdenticalKeep.
+func ParseIdenticalPolicy(s string) (IdenticalPolicy, error) {
+	switch p := IdenticalPolicy(s); p {
+	case IdenticalKeep, IdenticalLink, IdenticalDelete:
+		return p, nil
+	case "":
+		return IdenticalKeep, nil
+	}
+	return "", fmt.Errorf("unknown identical stray policy %q, want keep, link, or delete", s)
+}
+
+// firstCopies maps content hashes to the first stray of the run moved
+// into the quarantine with that content.
+type firstCopies map[string]ManifestEntry
+
+// lookup returns the first stray moved with the given hash and size.
+func (f firstCopies) lookup(sum string, size int64) (ManifestEntry, bool) {
+	e, ok := f[sum]
+	if !ok || e.Size != size {
+		return ManifestEntry{}, false
+	}
+	return e, true
+}
+
+// remember records e if it is the first stray moved with its content.
+func (f firstCopies) remember(e ManifestEntry) {
+	if f == nil || e.Action != ActionMoved || e.Suspicious != "" || e.SHA256 == "" {
+		return
+	}
+	if _, ok := f[e.SHA256]; !ok {
+		f[e.SHA256] = e
+	}
+}
+
+// linkIdentical replaces moving src to dst with hardlinking first, the
+// quarantined file with the same content, to dst and removing src. If
+// first is on another filesystem, or gone, src is moved instead.
+func linkIdentical(src, dst, first string, opts Options, logger *slog.Logger) error {
+	if err := readonly.Check("link identical stray"); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("create directory %s: %w", filepath.Dir(dst), err)
+	}
+	if err := os.Link(first, dst); err != nil {
+		logger.Debug("cannot link identical stray, moving it", "src", src, "first", first, "error", err)
+		return moveFile(src, dst, opts.Verify, opts.IOLimit, logger)
+	}
+	if err := os.Remove(src); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("remove %s: %w", src, err)
+	}
+	return nil
+}
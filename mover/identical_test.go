@@ -0,0 +1,71 @@
+package mover
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveOrphans_IdenticalStrays(t *testing.T) {
+	for _, policy := range []IdenticalPolicy{IdenticalLink, IdenticalDelete} {
+		t.Run(string(policy), func(t *testing.T) {
+			lib, dst := t.TempDir(), t.TempDir()
+			os.MkdirAll(filepath.Join(lib, "upload", "a"), 0o755)
+			os.MkdirAll(filepath.Join(lib, "upload", "b"), 0o755)
+			os.WriteFile(filepath.Join(lib, "upload", "a", "1.jpg"), []byte("same"), 0o644)
+			os.WriteFile(filepath.Join(lib, "upload", "b", "1.jpg"), []byte("same"), 0o644)
+			os.WriteFile(filepath.Join(lib, "upload", "b", "2.jpg"), []byte("other"), 0o644)
+			items := []Item{{RelPath: "upload/a/1.jpg"}, {RelPath: "upload/b/1.jpg"}, {RelPath: "upload/b/2.jpg"}}
+
+			sum, err := MoveOrphans(items, lib, dst, Options{RunID: "run1", Identical: policy}, testLogger())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			first, err := os.Stat(filepath.Join(dst, "upload", "a", "1.jpg"))
+			if err != nil {
+				t.Fatalf("first copy not quarantined: %v", err)
+			}
+			copyPath := filepath.Join(dst, "upload", "b", "1.jpg")
+			switch policy {
+			case IdenticalLink:
+				if sum.Moved != 3 || sum.IdenticalLinked != 1 {
+					t.Errorf("expected 3 moved, 1 as a link, got %+v", sum)
+				}
+				if info, err := os.Stat(copyPath); err != nil || !os.SameFile(first, info) {
+					t.Errorf("copy should be a hardlink of the first one: %v", err)
+				}
+			case IdenticalDelete:
+				if sum.Moved != 2 || sum.Deduplicated != 1 {
+					t.Errorf("expected 2 moved and 1 deduplicated, got %+v", sum)
+				}
+				if _, err := os.Stat(copyPath); !os.IsNotExist(err) {
+					t.Error("copy should not be quarantined")
+				}
+			}
+			if _, err := os.Stat(filepath.Join(lib, "upload", "b", "1.jpg")); !os.IsNotExist(err) {
+				t.Error("copy should be gone from the library")
+			}
+
+			// Either way, restore puts every copy back.
+			rsum, err := Restore(lib, dst, RestoreOptions{}, testLogger())
+			if err != nil {
+				t.Fatalf("restore: %v", err)
+			}
+			if rsum.Restored != 3 {
+				t.Errorf("expected 3 restored, got %+v", rsum)
+			}
+			for _, rel := range []string{"upload/a/1.jpg", "upload/b/1.jpg"} {
+				if data, err := os.ReadFile(filepath.Join(lib, filepath.FromSlash(rel))); err != nil || string(data) != "same" {
+					t.Errorf("%s not restored: %q, %v", rel, data, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMoveOrphans_IdenticalRejectsCopy(t *testing.T) {
+	_, err := MoveOrphans(nil, t.TempDir(), t.TempDir(), Options{Copy: true, Identical: IdenticalLink}, testLogger())
+	if err == nil {
+		t.Error("expected an error combining copying with linking identical strays")
+	}
+}
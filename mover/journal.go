@@ -0,0 +1,355 @@
+package mover
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/readonly"
+)
+
+// journalExt names the journal of a move run next to its manifest. The
+// journal exists only while the run is unfinished.
+const journalExt = ".journal"
+
+// journalRecord is one line of a journal. The first line holds the plan,
+// the strays the run set out to handle; every other line the entry of a
+// stray whose file is about to be moved or deleted.
+type journalRecord struct {
+	Plan  []Item         `json:"plan,omitempty"`
+	Entry *ManifestEntry `json:"entry,omitempty"`
+}
+
+// journalWriter records the progress of a run, so that a run killed
+// halfway through a file can be resumed: the manifest tells which strays
+// were handled, and the journal which one was in flight.
+type journalWriter struct {
+	path string
+	f    *os.File
+}
+
+func journalPath(targetDir, runID string) string {
+	return filepath.Join(targetDir, ManifestDir, runID+journalExt)
+}
+
+// createJournal starts the journal of runID with the plan items, replacing
+// the journal of an earlier attempt at the same run.
+func createJournal(targetDir, runID string, items []Item) (*journalWriter, error) {
+	j := &journalWriter{path: journalPath(targetDir, runID)}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0o755); err != nil {
+		return nil, fmt.Errorf("create manifest directory: %w", err)
+	}
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("create journal: %w", err)
+	}
+	j.f = f
+	if items == nil {
+		items = []Item{}
+	}
+	if err := j.append(journalRecord{Plan: items}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// intend records that e is about to be carried out. It does nothing on a
+// nil journal, as in dry-run mode.
+func (j *journalWriter) intend(e ManifestEntry) error {
+	if j == nil {
+		return nil
+	}
+	return j.append(journalRecord{Entry: &e})
+}
+
+func (j *journalWriter) append(r journalRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("marshal journal record: %w", err)
+	}
+	if _, err := j.f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	return nil
+}
+
+func (j *journalWriter) close() error {
+	if j == nil || j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+// finish removes the journal of a run that handled its whole plan.
+func (j *journalWriter) finish() error {
+	if j == nil {
+		return nil
+	}
+	if err := j.close(); err != nil {
+		return fmt.Errorf("close journal: %w", err)
+	}
+	if err := os.Remove(j.path); err != nil {
+		return fmt.Errorf("remove journal: %w", err)
+	}
+	return nil
+}
+
+// PendingRuns returns the IDs of the runs in targetDir that were
+// interrupted before handling every stray they set out to, oldest first.
+func PendingRuns(targetDir string) ([]string, error) {
+	names, err := os.ReadDir(filepath.Join(targetDir, ManifestDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read manifest directory: %w", err)
+	}
+	var runs []string
+	for _, n := range names {
+		if runID, ok := strings.CutSuffix(n.Name(), journalExt); ok && !n.IsDir() {
+			runs = append(runs, runID)
+		}
+	}
+	return runs, nil
+}
+
+// Resume settles the stray the interrupted run runID was handling when it
+// stopped and returns the strays of its plan it never got to, for
+// MoveOrphans to handle with the same run ID. A file found both in the
+// library and in the quarantine is only removed from the library once the
+// two are known to be identical. With nothing left to do, the journal is
+// removed.
+func Resume(runID, libraryPath, targetDir string, opts Options, logger *slog.Logger) ([]Item, error) {
+	if runID == "" || strings.ContainsAny(runID, `/\`) || runID == "." || runID == ".." {
+		return nil, fmt.Errorf("invalid run ID %q", runID)
+	}
+	plan, inFlight, err := loadJournal(journalPath(targetDir, runID))
+	if err != nil {
+		return nil, err
+	}
+	recorded, err := LoadRun(targetDir, runID)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	done := make(map[string]bool, len(recorded))
+	for _, e := range recorded {
+		done[e.Source] = true
+	}
+
+	manifest := newManifestWriter(targetDir, runID)
+	defer manifest.close()
+	for _, e := range inFlight {
+		if done[e.Source] {
+			continue
+		}
+		outcome, err := settle(e, libraryPath, targetDir, opts.Mounts, logger)
+		if err != nil {
+			return nil, err
+		}
+		switch outcome {
+		case settledPending:
+			continue
+		case settledDone:
+			e.Time = time.Now().UTC()
+			if err := manifest.write(e); err != nil {
+				return nil, err
+			}
+			if err := opts.Audit.Record(auditEntry(runID, libraryPath, targetDir, opts.Mounts, e)); err != nil {
+				return nil, err
+			}
+		}
+		done[e.Source] = true
+	}
+	if err := manifest.close(); err != nil {
+		return nil, err
+	}
+
+	var remaining []Item
+	for _, item := range plan {
+		if !done[item.RelPath] {
+			remaining = append(remaining, item)
+		}
+	}
+	if len(remaining) == 0 {
+		if err := os.Remove(journalPath(targetDir, runID)); err != nil {
+			return nil, fmt.Errorf("remove journal: %w", err)
+		}
+	}
+	return remaining, nil
+}
+
+// Outcomes of settle.
+const (
+	// settledPending is a stray that still needs handling.
+	settledPending = iota
+	// settledDone is a stray that was handled but not recorded.
+	settledDone
+	// settledVanished is a stray found neither in the library nor in the
+	// quarantine.
+	settledVanished
+)
+
+// settle works out whether the in-flight entry e was carried out, and
+// completes a move that got as far as the copy.
+func settle(e ManifestEntry, libraryPath, targetDir string, mounts []paths.Mount, logger *slog.Logger) (int, error) {
+	src := paths.Join(libraryPath, mounts, e.Source)
+	srcExists, err := exists(src)
+	if err != nil {
+		return 0, err
+	}
+	if e.Remote != "" {
+		return settleRemote(e, src, logger)
+	}
+	if e.Action == ActionCopied || e.Action == ActionLinked {
+		return settleCopy(e, src, targetDir)
+	}
+	if e.Action == ActionArchived {
+		// Archived strays are only recorded as in flight once the archive
+		// is complete.
+		if _, err := removeArchived(e, src, logger); err != nil {
+			return 0, err
+		}
+		return settledDone, nil
+	}
+	if e.Action != ActionMoved && e.Action != ActionRelinked {
+		// A deletion either happened or not.
+		if srcExists {
+			return settledPending, nil
+		}
+		return settledDone, nil
+	}
+
+	root := targetDir
+	if e.Action == ActionRelinked {
+		root = libraryPath
+	}
+	dst := filepath.Join(root, filepath.FromSlash(e.Dest))
+	dstExists, err := exists(dst)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case srcExists && dstExists:
+		// The copy is complete, since it is only renamed into place once
+		// written; the run stopped before removing the original.
+		size, sum, err := fileDigest(dst)
+		if err != nil {
+			return 0, fmt.Errorf("hash %s: %w", dst, err)
+		}
+		_, srcSum, err := fileDigest(src)
+		if err != nil {
+			return 0, fmt.Errorf("hash %s: %w", src, err)
+		}
+		if size != e.Size || sum != srcSum {
+			return 0, fmt.Errorf("cannot resume run: %s and %s both exist and differ", src, dst)
+		}
+		if err := readonly.Check("finish move"); err != nil {
+			return 0, err
+		}
+		if err := os.Remove(src); err != nil {
+			return 0, fmt.Errorf("remove %s: %w", src, err)
+		}
+		logger.Info("finished interrupted move", "src", src, "dst", dst)
+		return settledDone, nil
+	case srcExists:
+		// The move never happened, but part of a copy may be left over.
+		if err := os.Remove(partialPath(dst)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return 0, fmt.Errorf("remove partial copy: %w", err)
+		}
+		return settledPending, nil
+	case dstExists:
+		logger.Info("recorded interrupted move", "src", src, "dst", dst)
+		return settledDone, nil
+	default:
+		logger.Warn("stray of interrupted move is gone from both the library and the quarantine", "src", src, "dst", dst)
+		return settledVanished, nil
+	}
+}
+
+// settleCopy settles a copy or hardlink, which is complete once its
+// destination exists: a copy is only renamed into place once written.
+func settleCopy(e ManifestEntry, src, targetDir string) (int, error) {
+	dst := filepath.Join(targetDir, filepath.FromSlash(e.Dest))
+	dstExists, err := exists(dst)
+	if err != nil {
+		return 0, err
+	}
+	if dstExists {
+		return settledDone, nil
+	}
+	if err := os.Remove(partialPath(dst)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("remove partial copy: %w", err)
+	}
+	srcExists, err := exists(src)
+	if err != nil {
+		return 0, err
+	}
+	if !srcExists {
+		return settledVanished, nil
+	}
+	return settledPending, nil
+}
+
+// exists reports whether path exists, without following a final symlink.
+func exists(path string) (bool, error) {
+	_, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// loadJournal reads the plan of a journal and the entries that were in
+// flight, the last one per stray. A truncated last line, left by a run
+// killed mid-write, is ignored: its file was not touched yet.
+func loadJournal(path string) ([]Item, []ManifestEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open journal: %w", err)
+	}
+	defer f.Close()
+
+	var plan []Item
+	var entries []ManifestEntry
+	last := make(map[string]int)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<30)
+	for line := 1; sc.Scan(); line++ {
+		var r journalRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			if line == 1 {
+				return nil, nil, fmt.Errorf("parse %s: %w", path, err)
+			}
+			break
+		}
+		switch {
+		case line == 1:
+			plan = r.Plan
+		case r.Entry != nil:
+			if i, ok := last[r.Entry.Source]; ok {
+				entries[i] = *r.Entry
+			} else {
+				last[r.Entry.Source] = len(entries)
+				entries = append(entries, *r.Entry)
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return plan, entries, nil
+}
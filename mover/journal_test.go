@@ -0,0 +1,109 @@
+package mover
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMoveOrphans_KeepsJournalOnlyWhileUnfinished(t *testing.T) {
+	lib, quarantine := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(lib, "a.jpg"), []byte("a"), 0o644)
+	os.WriteFile(filepath.Join(lib, "b.jpg"), []byte("b"), 0o644)
+	// b.jpg cannot be moved: its destination is taken.
+	os.WriteFile(filepath.Join(quarantine, "b.jpg"), []byte("other"), 0o644)
+
+	if _, err := MoveOrphans(items("a.jpg", "b.jpg"), lib, quarantine, Options{RunID: "run1"}, testLogger()); err == nil {
+		t.Fatal("expected the move of b.jpg to fail")
+	}
+	if runs, _ := PendingRuns(quarantine); !slices.Equal(runs, []string{"run1"}) {
+		t.Fatalf("expected run1 to be pending, got %v", runs)
+	}
+
+	os.Remove(filepath.Join(quarantine, "b.jpg"))
+	remaining, err := Resume("run1", lib, quarantine, Options{}, testLogger())
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if len(remaining) != 1 || remaining[0].RelPath != "b.jpg" {
+		t.Fatalf("expected only b.jpg to remain, got %+v", remaining)
+	}
+	if _, err := MoveOrphans(remaining, lib, quarantine, Options{RunID: "run1"}, testLogger()); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	if runs, _ := PendingRuns(quarantine); len(runs) != 0 {
+		t.Errorf("expected no pending runs, got %v", runs)
+	}
+	entries, err := LoadRun(quarantine, "run1")
+	if err != nil || len(entries) != 2 {
+		t.Errorf("expected both strays in the manifest, got %+v, %v", entries, err)
+	}
+}
+
+func TestResume_SettlesInFlightStrays(t *testing.T) {
+	lib, quarantine := t.TempDir(), t.TempDir()
+	for _, name := range []string{"done.jpg", "copied.jpg", "partial.jpg", "untouched.jpg"} {
+		os.WriteFile(filepath.Join(lib, name), []byte(name), 0o644)
+	}
+	plan := items("done.jpg", "copied.jpg", "partial.jpg", "untouched.jpg")
+
+	// Simulate a run killed while handling its strays: done.jpg was moved
+	// and recorded, copied.jpg was copied but not yet removed, and
+	// partial.jpg was being copied.
+	j, err := createJournal(quarantine, "run1", plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newManifestWriter(quarantine, "run1")
+	for _, name := range []string{"done.jpg", "copied.jpg", "partial.jpg"} {
+		j.intend(ManifestEntry{Action: ActionMoved, Source: name, Dest: name, Size: int64(len(name))})
+	}
+	os.Rename(filepath.Join(lib, "done.jpg"), filepath.Join(quarantine, "done.jpg"))
+	m.write(ManifestEntry{Action: ActionMoved, Source: "done.jpg", Dest: "done.jpg"})
+	m.close()
+	os.WriteFile(filepath.Join(quarantine, "copied.jpg"), []byte("copied.jpg"), 0o644)
+	os.WriteFile(partialPath(filepath.Join(quarantine, "partial.jpg")), []byte("par"), 0o644)
+	j.close()
+
+	remaining, err := Resume("run1", lib, quarantine, Options{}, testLogger())
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	var got []string
+	for _, item := range remaining {
+		got = append(got, item.RelPath)
+	}
+	if !slices.Equal(got, []string{"partial.jpg", "untouched.jpg"}) {
+		t.Errorf("expected partial.jpg and untouched.jpg to remain, got %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(lib, "copied.jpg")); !os.IsNotExist(err) {
+		t.Error("expected the original of the completed copy to be removed")
+	}
+	if _, err := os.Stat(partialPath(filepath.Join(quarantine, "partial.jpg"))); !os.IsNotExist(err) {
+		t.Error("expected the partial copy to be removed")
+	}
+	entries, _ := LoadRun(quarantine, "run1")
+	if len(entries) != 2 || entries[1].Source != "copied.jpg" {
+		t.Errorf("expected copied.jpg to be recorded, got %+v", entries)
+	}
+}
+
+func TestResume_RefusesDifferingCopies(t *testing.T) {
+	lib, quarantine := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(lib, "a.jpg"), []byte("library"), 0o644)
+	os.WriteFile(filepath.Join(quarantine, "a.jpg"), []byte("quarantine"), 0o644)
+	j, err := createJournal(quarantine, "run1", items("a.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.intend(ManifestEntry{Action: ActionMoved, Source: "a.jpg", Dest: "a.jpg", Size: 7})
+	j.close()
+
+	if _, err := Resume("run1", lib, quarantine, Options{}, testLogger()); err == nil {
+		t.Error("expected an error when the two copies differ")
+	}
+	if _, err := os.Stat(filepath.Join(lib, "a.jpg")); err != nil {
+		t.Errorf("expected the original to be kept: %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package mover
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/paths"
+)
+
+// DefaultLayout mirrors the library structure inside the target dir.
+const DefaultLayout = "{relpath}"
+
+// Layout is a parsed quarantine layout template. Placeholders:
+//
+//	{relpath}      the stray's library-relative path (alias {original_path})
+//	{dir}          the directory part of {relpath}
+//	{name}         the file name
+//	{stem}, {ext}  the file name without / only its extension (with dot)
+//	{top}          the top-level library directory (library, thumbs, ...)
+//	{category}     the stray category (original, derivative, ...)
+//	{user}         Item.User, or the owning storage label or user ID if the
+//	               path has one
+//	{sha256}       the file's SHA-256; {sha256:N} uses the first N hex digits
+//	{run_id}       the ID of the run, e.g. 20240601T030005Z
+//	{run_date}     the UTC date the run started, e.g. 2024-06-01
+//
+// Empty values render as "_". Templates containing {sha256} hash every file.
+type Layout struct {
+	parts     []layoutPart
+	needsHash bool
+}
+
+type layoutPart struct {
+	literal string
+	name    string
+	width   int
+}
+
+var layoutVars = map[string]bool{
+	"relpath": true, "original_path": true, "dir": true, "name": true, "stem": true,
+	"ext": true, "top": true, "category": true, "user": true, "sha256": true,
+	"run_id": true, "run_date": true,
+}
+
+// ParseLayout parses and validates a layout template.
+func ParseLayout(tmpl string) (*Layout, error) {
+	l := &Layout{}
+	rest := tmpl
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			l.parts = append(l.parts, layoutPart{literal: rest})
+			break
+		}
+		if open > 0 {
+			l.parts = append(l.parts, layoutPart{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("layout %q: unterminated placeholder", tmpl)
+		}
+		name, widthStr, hasWidth := strings.Cut(rest[open+1:open+end], ":")
+		if !layoutVars[name] {
+			return nil, fmt.Errorf("layout %q: unknown placeholder {%s}", tmpl, name)
+		}
+		part := layoutPart{name: name}
+		if hasWidth {
+			w, err := strconv.Atoi(widthStr)
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("layout %q: invalid width in {%s:%s}", tmpl, name, widthStr)
+			}
+			part.width = w
+		}
+		if name == "sha256" {
+			l.needsHash = true
+		}
+		l.parts = append(l.parts, part)
+		rest = rest[open+end+1:]
+	}
+	if len(l.parts) == 0 {
+		return nil, fmt.Errorf("layout must not be empty")
+	}
+	return l, nil
+}
+
+// NeedsHash reports whether rendering requires the file's SHA-256.
+func (l *Layout) NeedsHash() bool {
+	return l.needsHash
+}
+
+// Render returns the forward-slash destination path, relative to the target
+// dir, for item moved by run runID. sha256 may be empty unless NeedsHash is
+// true.
+func (l *Layout) Render(item Item, sha256, runID string) (string, error) {
+	var runDate string
+	if t, err := time.Parse(runIDFormat, runID); err == nil {
+		runDate = t.Format(time.DateOnly)
+	}
+	name := path.Base(item.RelPath)
+	ext := path.Ext(name)
+	user := item.User
+	if user == "" {
+		user = paths.Owner(item.RelPath)
+	}
+	values := map[string]string{
+		"relpath":       item.RelPath,
+		"original_path": item.RelPath,
+		"dir":           path.Dir(item.RelPath),
+		"name":          name,
+		"stem":          strings.TrimSuffix(name, ext),
+		"ext":           ext,
+		"top":           paths.TopDir(item.RelPath),
+		"category":      item.Category,
+		"user":          user,
+		"sha256":        sha256,
+		"run_id":        runID,
+		"run_date":      runDate,
+	}
+
+	var b strings.Builder
+	for _, p := range l.parts {
+		if p.name == "" {
+			b.WriteString(p.literal)
+			continue
+		}
+		v := values[p.name]
+		if p.width > 0 && len(v) > p.width {
+			v = v[:p.width]
+		}
+		if v == "" || v == "." {
+			v = "_"
+		}
+		b.WriteString(v)
+	}
+
+	dst := path.Clean(b.String())
+	if path.IsAbs(dst) || dst == "." || dst == ".." || strings.HasPrefix(dst, "../") {
+		return "", fmt.Errorf("layout renders %q outside the target dir for %s", dst, item.RelPath)
+	}
+	if paths.TopDir(dst) == ManifestDir {
+		return "", fmt.Errorf("layout renders %q into the manifest directory", dst)
+	}
+	return dst, nil
+}
@@ -0,0 +1,124 @@
+package mover
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLayout_Render(t *testing.T) {
+	item := Item{RelPath: "library/alice/2024/IMG_1.JPG", Category: "original"}
+	const sum = "abcdef0123456789"
+
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{DefaultLayout, "library/alice/2024/IMG_1.JPG"},
+		{"{category}/{user}/{original_path}", "original/alice/library/alice/2024/IMG_1.JPG"},
+		{"{sha256:2}/{sha256}{ext}", "ab/abcdef0123456789.JPG"},
+		{"{top}/{stem}-{sha256:8}{ext}", "library/IMG_1-abcdef01.JPG"},
+		{"{user}/{name}", "alice/IMG_1.JPG"},
+		{"{run_date}/{user}/{relpath}", "2024-06-01/alice/library/alice/2024/IMG_1.JPG"},
+		{"{run_id}/{sha256}{ext}", "20240601T030005Z/abcdef0123456789.JPG"},
+	}
+	for _, tt := range tests {
+		l, err := ParseLayout(tt.tmpl)
+		if err != nil {
+			t.Fatalf("ParseLayout(%q): %v", tt.tmpl, err)
+		}
+		got, err := l.Render(item, sum, "20240601T030005Z")
+		if err != nil {
+			t.Fatalf("Render(%q): %v", tt.tmpl, err)
+		}
+		if got != tt.want {
+			t.Errorf("Render(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestLayout_EmptyValuesAndEscapes(t *testing.T) {
+	l, _ := ParseLayout("{user}/{name}")
+	got, err := l.Render(Item{RelPath: "stray.txt"}, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "_/stray.txt" {
+		t.Errorf("expected missing user to render as _, got %q", got)
+	}
+	item := Item{RelPath: "upload/0b7d-uuid/ab/cd/a.jpg", User: "alice"}
+	if got, _ := l.Render(item, "", ""); got != "alice/a.jpg" {
+		t.Errorf("expected {user} to be the item's user, got %q", got)
+	}
+
+	l, _ = ParseLayout("{run_date}/{name}")
+	if got, _ := l.Render(Item{RelPath: "a.jpg"}, "", "run1"); got != "_/a.jpg" {
+		t.Errorf("expected a run ID that is not a time to have no date, got %q", got)
+	}
+
+	l, _ = ParseLayout("../{name}")
+	if _, err := l.Render(Item{RelPath: "a.jpg"}, "", ""); err == nil {
+		t.Error("expected error for a layout escaping the target dir")
+	}
+	l, _ = ParseLayout(".manifests/{name}")
+	if _, err := l.Render(Item{RelPath: "a.jpg"}, "", ""); err == nil {
+		t.Error("expected error for a layout writing into the manifest directory")
+	}
+}
+
+func TestParseLayout_Errors(t *testing.T) {
+	for _, tmpl := range []string{"", "{nope}", "{name", "{sha256:x}", "{sha256:0}"} {
+		if _, err := ParseLayout(tmpl); err == nil {
+			t.Errorf("ParseLayout(%q): expected error", tmpl)
+		}
+	}
+}
+
+func TestMoveOrphans_Layout(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	os.MkdirAll(filepath.Join(srcDir, "library", "alice"), 0o755)
+	os.WriteFile(filepath.Join(srcDir, "library", "alice", "a.jpg"), []byte("a"), 0o644)
+	os.WriteFile(filepath.Join(srcDir, "library", "alice", "b.jpg"), []byte("b"), 0o644)
+
+	layout, err := ParseLayout("{category}/{user}/{name}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	strays := []Item{
+		{RelPath: "library/alice/a.jpg", Category: "original"},
+		{RelPath: "library/alice/b.jpg", Category: "original"},
+	}
+	if _, err := MoveOrphans(strays, srcDir, dstDir, Options{RunID: "run-1", Layout: layout}, testLogger()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dstDir, "original", "alice", "a.jpg")); err != nil {
+		t.Errorf("expected templated destination: %v", err)
+	}
+	entries, err := LoadRun(dstDir, "run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Dest != "original/alice/a.jpg" {
+		t.Errorf("expected manifest to record templated dest, got %+v", entries)
+	}
+}
+
+func TestMoveOrphans_LayoutCollision(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	for _, dir := range []string{"x", "y"} {
+		os.MkdirAll(filepath.Join(srcDir, dir), 0o755)
+		os.WriteFile(filepath.Join(srcDir, dir, "same.jpg"), []byte(dir), 0o644)
+	}
+
+	layout, _ := ParseLayout("{name}")
+	_, err := MoveOrphans(items("x/same.jpg", "y/same.jpg"), srcDir, dstDir, Options{Layout: layout}, testLogger())
+	if err == nil {
+		t.Fatal("expected error when two strays render to the same destination")
+	}
+	if _, err := os.Stat(filepath.Join(srcDir, "y", "same.jpg")); err != nil {
+		t.Error("colliding stray must be left in place")
+	}
+}
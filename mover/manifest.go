@@ -0,0 +1,258 @@
+package mover
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ManifestDir is the directory inside the target dir that holds one manifest
+// per run. It is never treated as quarantined content.
+const ManifestDir = ".manifests"
+
+// Manifest actions.
+const (
+	ActionMoved        = "moved"
+	ActionDeduplicated = "deduplicated"
+	// ActionImmichDuplicate is a stray deleted because it was identical to
+	// the original of an Immich asset in a duplicate group.
+	ActionImmichDuplicate = "immich-duplicate"
+	// ActionDeleted is a stray deleted outright instead of quarantined.
+	ActionDeleted = "deleted"
+	// ActionCopied is a stray copied into the quarantine and left in the
+	// library.
+	ActionCopied = "copied"
+	// ActionLinked is a stray hardlinked into the quarantine; purging it
+	// also deletes the original in the library.
+	ActionLinked = "linked"
+	// ActionArchived is a stray written to an archive and removed from the
+	// library.
+	ActionArchived = "archived"
+	// ActionSkipped is a stray left in the library because its destination
+	// was taken and the conflict policy was ConflictSkip.
+	ActionSkipped = "skipped"
+	// ActionPruned is a library directory removed because the run left it
+	// empty; Source is the directory.
+	ActionPruned = "pruned"
+	// ActionRelinked is a stray moved to where the missing original of the
+	// asset with its content belongs; Dest is relative to the library.
+	ActionRelinked = "relinked"
+)
+
+// Actions that only appear in the audit log.
+const (
+	// ActionRelocated is a database dump moved into backups/.
+	ActionRelocated = "relocated"
+	ActionRestored  = "restored"
+	ActionPurged    = "purged"
+)
+
+// ManifestEntry records what happened to a single stray.
+type ManifestEntry struct {
+	Action string `json:"action"`
+	// Source is the stray's path relative to the library root.
+	Source string `json:"source"`
+	// Dest is the quarantined path relative to the target dir, or for
+	// ActionArchived the file's name in Archive.
+	Dest string `json:"dest,omitempty"`
+	// Archive is the path of the archive an archived stray was written to.
+	Archive string `json:"archive,omitempty"`
+	// Remote is the remote target, e.g. "s3://bucket/strays", a stray was
+	// uploaded to; Dest is then relative to it.
+	Remote string `json:"remote,omitempty"`
+	// DuplicateOf is the already-quarantined copy a deduplicated stray
+	// matched, or for ActionImmichDuplicate the asset's original relative
+	// to the library root. For ActionMoved it is the identical stray of
+	// the run that the moved one was placed as a hardlink of.
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	Size        int64  `json:"size"`
+	// ModTime is the stray's modification time in the library.
+	ModTime time.Time `json:"mtime,omitzero"`
+	// SHA256 is the stray's checksum. It is always recorded for files
+	// actually moved or deleted; dry runs only hash when they must.
+	SHA256 string `json:"sha256,omitempty"`
+	// Conflict is the policy applied because the destination was taken:
+	// ConflictSkip, ConflictRename (Dest is then the new name), or
+	// ConflictOverwrite.
+	Conflict ConflictPolicy `json:"conflict,omitempty"`
+	// Suspicious is the pre-move hook's verdict on a stray it rejected.
+	Suspicious string    `json:"suspicious,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// manifestWriter appends entries to a run's manifest as they happen, so the
+// manifest is complete up to the last finished file even if the run dies.
+type manifestWriter struct {
+	path string
+	f    *os.File
+}
+
+func newManifestWriter(targetDir, runID string) *manifestWriter {
+	return &manifestWriter{path: ManifestPath(targetDir, runID)}
+}
+
+func (w *manifestWriter) write(e ManifestEntry) error {
+	if w.f == nil {
+		if err := os.MkdirAll(filepath.Dir(w.path), 0o755); err != nil {
+			return fmt.Errorf("create manifest directory: %w", err)
+		}
+		f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("open manifest: %w", err)
+		}
+		w.f = f
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal manifest entry: %w", err)
+	}
+	if _, err := w.f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	return nil
+}
+
+func (w *manifestWriter) close() error {
+	if w.f == nil {
+		return nil
+	}
+	return w.f.Close()
+}
+
+// LoadManifests reads every manifest in targetDir, oldest run first.
+func LoadManifests(targetDir string) ([]ManifestEntry, error) {
+	runs, err := ListRuns(targetDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ManifestEntry
+	for _, runID := range runs {
+		loaded, err := LoadRun(targetDir, runID)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, loaded...)
+	}
+	return entries, nil
+}
+
+// ListRuns returns the IDs of all runs with a manifest in targetDir, oldest
+// first (run IDs start with a UTC timestamp and sort chronologically).
+func ListRuns(targetDir string) ([]string, error) {
+	names, err := os.ReadDir(filepath.Join(targetDir, ManifestDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read manifest directory: %w", err)
+	}
+
+	var runs []string
+	for _, n := range names {
+		if runID, ok := strings.CutSuffix(n.Name(), ".jsonl"); ok && !n.IsDir() {
+			runs = append(runs, runID)
+		}
+	}
+	return runs, nil
+}
+
+// LoadRun reads the manifest of a single run.
+func LoadRun(targetDir, runID string) ([]ManifestEntry, error) {
+	if runID == "" || strings.ContainsAny(runID, `/\`) || runID == "." || runID == ".." {
+		return nil, fmt.Errorf("invalid run ID %q", runID)
+	}
+	return loadManifest(ManifestPath(targetDir, runID))
+}
+
+// ManifestPath returns where the manifest of the run runID is kept.
+func ManifestPath(targetDir, runID string) string {
+	return filepath.Join(targetDir, ManifestDir, runID+".jsonl")
+}
+
+func loadManifest(path string) ([]ManifestEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open manifest: %w", err)
+	}
+	defer f.Close()
+
+	var entries []ManifestEntry
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e ManifestEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			// A run killed mid-write leaves a truncated last line.
+			return entries, fmt.Errorf("parse %s line %d: %w", path, line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// quarantineIndex maps content hashes to files quarantined by earlier runs.
+type quarantineIndex map[string]ManifestEntry
+
+// buildQuarantineIndex indexes all hashed entries of previous runs that
+// placed a file in the quarantine.
+func buildQuarantineIndex(targetDir string) (quarantineIndex, error) {
+	entries, err := LoadManifests(targetDir)
+	if err != nil {
+		return nil, err
+	}
+	idx := make(quarantineIndex)
+	for _, e := range entries {
+		if (e.Action == ActionMoved || e.Action == ActionCopied || e.Action == ActionLinked) && e.SHA256 != "" && e.Remote == "" {
+			idx[e.SHA256] = e
+		}
+	}
+	return idx, nil
+}
+
+// lookup returns the quarantined copy with the given hash and size, provided
+// it is still present in targetDir.
+func (idx quarantineIndex) lookup(targetDir, sum string, size int64) (ManifestEntry, bool) {
+	e, ok := idx[sum]
+	if !ok || e.Size != size {
+		return ManifestEntry{}, false
+	}
+	info, err := os.Stat(filepath.Join(targetDir, filepath.FromSlash(e.Dest)))
+	if err != nil || info.Size() != size {
+		return ManifestEntry{}, false
+	}
+	return e, true
+}
+
+// hashFile streams path through SHA-256 and returns the hex digest.
+func hashFile(path string) (string, error) {
+	_, sum, err := fileDigest(path)
+	return sum, err
+}
+
+// fileDigest returns the size and SHA-256 of path.
+func fileDigest(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,101 @@
+package mover
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveOrphans_WritesManifest(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	os.MkdirAll(filepath.Join(srcDir, "upload"), 0o755)
+	os.WriteFile(filepath.Join(srcDir, "upload", "a.jpg"), []byte("aaaa"), 0o644)
+
+	_, err := MoveOrphans(items("upload/a.jpg"), srcDir, dstDir, Options{RunID: "run-1"}, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries, err := LoadManifests(dstDir)
+	if err != nil {
+		t.Fatalf("load manifests: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 manifest entry, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Action != ActionMoved || e.Source != "upload/a.jpg" || e.Dest != "upload/a.jpg" || e.Size != 4 {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, ManifestDir, "run-1.jsonl")); err != nil {
+		t.Errorf("expected manifest named after run ID: %v", err)
+	}
+}
+
+func TestMoveOrphans_DedupeAcrossRuns(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	opts := Options{Dedupe: true}
+
+	os.MkdirAll(filepath.Join(srcDir, "upload"), 0o755)
+	os.WriteFile(filepath.Join(srcDir, "upload", "first.jpg"), []byte("same bytes"), 0o644)
+	opts.RunID = "run-1"
+	if _, err := MoveOrphans(items("upload/first.jpg"), srcDir, dstDir, opts, testLogger()); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+
+	// The same content reappears under a different name in a later run.
+	os.WriteFile(filepath.Join(srcDir, "upload", "again.jpg"), []byte("same bytes"), 0o644)
+	os.WriteFile(filepath.Join(srcDir, "upload", "other.jpg"), []byte("different"), 0o644)
+	opts.RunID = "run-2"
+	if _, err := MoveOrphans(items("upload/again.jpg", "upload/other.jpg"), srcDir, dstDir, opts, testLogger()); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(srcDir, "upload", "again.jpg")); !os.IsNotExist(err) {
+		t.Error("duplicate source should have been deleted")
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "upload", "again.jpg")); !os.IsNotExist(err) {
+		t.Error("duplicate should not be stored twice in the quarantine")
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "upload", "other.jpg")); err != nil {
+		t.Error("distinct file should have been quarantined")
+	}
+
+	entries, _ := LoadManifests(dstDir)
+	var deduped int
+	for _, e := range entries {
+		if e.Action == ActionDeduplicated {
+			deduped++
+			if e.DuplicateOf != "upload/first.jpg" {
+				t.Errorf("expected duplicate_of first.jpg, got %q", e.DuplicateOf)
+			}
+		}
+	}
+	if deduped != 1 {
+		t.Errorf("expected 1 deduplicated entry, got %d", deduped)
+	}
+}
+
+func TestMoveOrphans_DedupeIgnoresMissingQuarantineCopy(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	opts := Options{Dedupe: true, RunID: "run-1"}
+
+	os.WriteFile(filepath.Join(srcDir, "a.jpg"), []byte("same"), 0o644)
+	MoveOrphans(items("a.jpg"), srcDir, dstDir, opts, testLogger())
+
+	// Someone cleaned the quarantine by hand; the manifest is now stale.
+	os.Remove(filepath.Join(dstDir, "a.jpg"))
+
+	os.WriteFile(filepath.Join(srcDir, "b.jpg"), []byte("same"), 0o644)
+	opts.RunID = "run-2"
+	if _, err := MoveOrphans(items("b.jpg"), srcDir, dstDir, opts, testLogger()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "b.jpg")); err != nil {
+		t.Error("expected b.jpg to be moved when the earlier copy is gone")
+	}
+}
@@ -0,0 +1,25 @@
+package mover
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// copyMetadata gives dst, a fresh copy of src, the metadata src has beyond
+// its mode: its owner when running as root, its extended attributes where
+// the platform and filesystem support them, and its access and
+// modification times, which are set last since the others would not
+// change them.
+func copyMetadata(src, dst string, info fs.FileInfo) error {
+	if err := copyOwner(dst, info); err != nil {
+		return fmt.Errorf("preserve owner: %w", err)
+	}
+	if err := copyXattrs(src, dst); err != nil {
+		return fmt.Errorf("preserve extended attributes: %w", err)
+	}
+	if err := os.Chtimes(dst, accessTime(info), info.ModTime()); err != nil {
+		return fmt.Errorf("preserve timestamps: %w", err)
+	}
+	return nil
+}
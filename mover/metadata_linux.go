@@ -0,0 +1,68 @@
+package mover
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// accessTime returns the access time of the file described by info.
+func accessTime(info fs.FileInfo) time.Time {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime()
+	}
+	return time.Unix(st.Atim.Unix())
+}
+
+// copyOwner gives dst the owner and group of the file described by info.
+// Only root may do so, so it does nothing for anyone else.
+func copyOwner(dst string, info fs.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || os.Geteuid() != 0 {
+		return nil
+	}
+	return os.Lchown(dst, int(st.Uid), int(st.Gid))
+}
+
+// copyXattrs copies the extended attributes of src to dst. Attributes the
+// destination does not support, or this user may not set, such as
+// trusted.* ones, are left out.
+func copyXattrs(src, dst string) error {
+	size, err := syscall.Listxattr(src, nil)
+	if err != nil || size == 0 {
+		if xattrUnsupported(err) {
+			return nil
+		}
+		return err
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Listxattr(src, buf); err != nil {
+		return err
+	}
+	for name := range strings.SplitSeq(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		n, err := syscall.Getxattr(src, name, nil)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", name, err)
+		}
+		value := make([]byte, n)
+		if n, err = syscall.Getxattr(src, name, value); err != nil {
+			return fmt.Errorf("read %s: %w", name, err)
+		}
+		if err := syscall.Setxattr(dst, name, value[:n], 0); err != nil && !xattrUnsupported(err) {
+			return fmt.Errorf("write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// xattrUnsupported reports whether err means an extended attribute cannot
+// be stored rather than that something went wrong.
+func xattrUnsupported(err error) bool {
+	return errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EOPNOTSUPP) ||
+		errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES)
+}
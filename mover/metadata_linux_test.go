@@ -0,0 +1,44 @@
+package mover
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCopyFile_PreservesMetadata(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "a.jpg"), filepath.Join(dir, "b.jpg")
+	os.WriteFile(src, []byte("photo"), 0o640)
+	xattrs := syscall.Setxattr(src, "user.immich.test", []byte("kept"), 0) == nil
+	atime, mtime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC), time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	os.Chtimes(src, atime, mtime)
+
+	if err := copyFile(src, dst, nil); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("mtime = %v, want %v", info.ModTime(), mtime)
+	}
+	if got := accessTime(info); !got.Equal(atime) {
+		t.Errorf("atime = %v, want %v", got, atime)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("mode = %v, want 0640", info.Mode().Perm())
+	}
+	if !xattrs {
+		t.Log("filesystem does not support user extended attributes")
+		return
+	}
+	value := make([]byte, 16)
+	n, err := syscall.Getxattr(dst, "user.immich.test", value)
+	if err != nil || string(value[:n]) != "kept" {
+		t.Errorf("extended attribute = %q, %v, want %q", value[:n], err, "kept")
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package mover
+
+import (
+	"io/fs"
+	"time"
+)
+
+// accessTime is not portable; the access time is set to the modification
+// time instead.
+func accessTime(info fs.FileInfo) time.Time {
+	return info.ModTime()
+}
+
+// copyOwner is only supported on Linux.
+func copyOwner(dst string, info fs.FileInfo) error {
+	return nil
+}
+
+// copyXattrs is only supported on Linux.
+func copyXattrs(src, dst string) error {
+	return nil
+}
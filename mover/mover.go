@@ -1,69 +1,911 @@
 package mover
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/audit"
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/progress"
+	"github.com/goeland86/immich-stray-finder/readonly"
+	"github.com/goeland86/immich-stray-finder/throttle"
 )
 
-// MoveOrphans relocates orphan files from libraryPath to targetDir,
-// preserving directory structure. If dryRun is true, only logs what
-// would be moved without actually moving anything.
+// Options controls how MoveOrphans relocates files.
+type Options struct {
+	// DryRun only logs what would be moved without touching anything.
+	DryRun bool
+	// Dedupe hashes each stray and deletes it instead of moving it when an
+	// identical file was already quarantined by a previous run.
+	Dedupe bool
+	// RunID names this run's manifest. Defaults to the current UTC time.
+	RunID string
+	// Layout places each stray inside targetDir. Nil means DefaultLayout,
+	// which mirrors the library structure.
+	Layout *Layout
+	// Hook, if set, checks each stray before it is moved. Strays it rejects
+	// are placed under SuspiciousDir inside targetDir, with category
+	// SuspiciousCategory. Hooks are not run in dry-run mode.
+	Hook          *Hook
+	SuspiciousDir string
+	// DeleteDuplicates deletes strays whose Item.DuplicateOf original is
+	// still identical, instead of moving them.
+	DeleteDuplicates bool
+	// Relink moves strays with an Item.RelinkTo to that path in the
+	// library, as long as it is still free, instead of quarantining them.
+	Relink bool
+	// Delete deletes strays instead of moving them. Strays the hook
+	// rejects are still moved to SuspiciousDir.
+	Delete bool
+	// Copy copies strays into targetDir and leaves them in the library.
+	// It cannot be combined with Dedupe, DeleteDuplicates, or Delete.
+	Copy bool
+	// Link hardlinks strays into targetDir, which must be on the same
+	// filesystem, and leaves them in the library until they are purged.
+	// It cannot be combined with Copy or with what Copy excludes.
+	Link bool
+	// ArchivePath, if set, names an archive the strays are written to
+	// instead of targetDir, which still holds the manifests. Strays are
+	// only removed from the library once the archive is complete. It
+	// cannot be combined with Copy, Link, or Delete.
+	ArchivePath string
+	// Remote, if set, is the quarantine strays are uploaded to instead of
+	// targetDir, which still holds the manifests and journal. Strays are
+	// only removed once their upload is verified. It cannot be combined
+	// with Link, ArchivePath, or Dedupe.
+	Remote *Remote
+	// Verify copies strays even where a rename would do, and only removes
+	// them once the copy's checksum matches. Copies made with Copy are
+	// checked too. Moves across filesystems are always checked.
+	Verify bool
+	// IOLimit, if set, limits how many bytes per second copies, archives,
+	// and uploads write.
+	IOLimit *throttle.Limiter
+	// PruneEmptyDirs removes the library directories that the strays
+	// taken out of the library leave empty, except top-level ones, and
+	// records them as ActionPruned.
+	PruneEmptyDirs bool
+	// KeepGoing logs a stray that could not be handled, e.g. for lack of
+	// permission, and goes on with the next one instead of stopping the
+	// run. The failures are listed in Summary.Failed, and MoveOrphans
+	// returns an error once it handled the others.
+	KeepGoing bool
+	// OnConflict decides what happens when a stray's destination is
+	// taken. The zero value is ConflictError.
+	OnConflict ConflictPolicy
+	// Identical decides what happens to a stray identical to one moved
+	// earlier in the run. The zero value is IdenticalKeep. Other policies
+	// cannot be combined with Copy, Link, ArchivePath, Remote, or Delete.
+	Identical IdenticalPolicy
+	// MinAge, if set, leaves strays in the library that were modified less
+	// than MinAge before they are handled, or since they were scanned, as
+	// Immich may be writing them. They are listed in Summary.Changed.
+	MinAge time.Duration
+	// Progress, if set, counts the strays handled.
+	Progress *progress.Bar
+	// Audit, if set, records every file moved or deleted, with its
+	// checksum.
+	Audit *audit.Log
+	// Mounts are the directories of the library kept outside libraryPath,
+	// e.g. thumbs/ on another disk.
+	Mounts []paths.Mount
+
+	// journal records each stray before its file is touched; nil in
+	// dry-run mode.
+	journal *journalWriter
+	// archive is the archive being written to ArchivePath.
+	archive *Archive
+	// firsts holds the first stray moved with each content, unless
+	// Identical is IdenticalKeep.
+	firsts firstCopies
+}
+
+// Item is a stray to relocate.
+type Item struct {
+	// RelPath is the forward-slash path relative to the library root
+	// (matching Immich's originalPath).
+	RelPath string `json:"path"`
+	// Category is the matcher's classification, available to layouts.
+	Category string `json:"category,omitempty"`
+	// User is the user the stray belongs to, for the {user} placeholder of
+	// layouts. Empty means the owner segment of RelPath.
+	User string `json:"user,omitempty"`
+	// DuplicateOf is the library-relative path of an Immich asset's
+	// original with the same content, if one is known.
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	// RelinkTo is the library-relative path of an Immich asset's original
+	// that is missing from disk and had the stray's content, if one is
+	// known.
+	RelinkTo string `json:"relink_to,omitempty"`
+	// Delete deletes this stray instead of moving it, as Options.Delete
+	// does for all of them. It cannot be combined with Options.Copy,
+	// Options.Link, or Options.ArchivePath.
+	Delete bool `json:"delete,omitempty"`
+	// ModTime is the stray's modification time when it was scanned, for
+	// Options.MinAge. Zero means unknown.
+	ModTime time.Time `json:"mtime,omitzero"`
+}
+
+// Summary counts what MoveOrphans did. In dry-run mode the counts describe
+// what would have happened.
+type Summary struct {
+	Moved        int
+	Deduplicated int
+	// ImmichDuplicates counts strays deleted as duplicates of Immich assets.
+	ImmichDuplicates int
+	// Relinked counts strays put back as the missing originals of assets.
+	Relinked int
+	// IdenticalLinked counts the moved strays placed as hardlinks of an
+	// identical stray moved earlier in the run, with IdenticalLink.
+	IdenticalLinked int
+	// Deleted counts strays deleted outright, and DeletedBytes their size.
+	Deleted      int
+	DeletedBytes int64
+	// MovedBytes is the total size of the moved strays.
+	MovedBytes int64
+	// Copied counts strays copied with Options.Copy, and CopiedBytes their
+	// size.
+	Copied      int
+	CopiedBytes int64
+	// Linked counts strays hardlinked with Options.Link, and LinkedBytes
+	// their size.
+	Linked      int
+	LinkedBytes int64
+	// Archived counts strays written to Options.ArchivePath, and
+	// ArchivedBytes their size.
+	Archived      int
+	ArchivedBytes int64
+	// AlreadyLinked lists strays an earlier run already linked to the
+	// same destination; they are left as they are.
+	AlreadyLinked []string
+	// Skipped lists strays left in the library because their destination
+	// was taken, with ConflictSkip.
+	Skipped []string
+	// Suspicious counts moved or copied strays the hook rejected.
+	Suspicious int
+	// Entries describes what was (or would be) done to each stray, in
+	// order. Vanished strays have no entry.
+	Entries []ManifestEntry
+	// Vanished lists strays that disappeared between the scan and the move,
+	// e.g. because Immich or a user deleted them during a long run.
+	Vanished []string
+	// Changed lists strays left in the library because they were modified
+	// too recently or since the scan, with Options.MinAge.
+	Changed []string
+	// Failed lists the strays Options.KeepGoing went past.
+	Failed []Failure
+	// Pruned counts the directories removed with Options.PruneEmptyDirs.
+	Pruned int
+}
+
+// Failure is a stray that could not be handled, and why.
+type Failure struct {
+	RelPath string
+	Err     error
+}
+
+// errAlreadyLinked is returned by moveOne for a stray that is hardlinked to
+// its destination already.
+var errAlreadyLinked = errors.New("already linked")
+
+// errChanged is returned by moveOne for a stray modified too recently or
+// since the scan, with Options.MinAge.
+var errChanged = errors.New("modified recently")
+
+// NewRunID returns an ID for a run starting now: its UTC time, which sorts
+// runs in the order they happened.
+func NewRunID() string {
+	return time.Now().UTC().Format(runIDFormat)
+}
+
+// runIDFormat is the time layout of the run IDs NewRunID returns.
+const runIDFormat = "20060102T150405Z"
+
+// MoveOrphans relocates orphan files from libraryPath to targetDir, placing
+// each according to opts.Layout. If opts.DryRun is true, only logs what
+// would be moved without actually moving anything. Every action is recorded
+// in a per-run manifest under targetDir/.manifests/, next to a journal that
+// lets Resume pick up a run that was killed; the journal is removed once
+// every stray was handled.
 //
-// relPaths are forward-slash relative paths (matching Immich's originalPath).
-func MoveOrphans(relPaths []string, libraryPath, targetDir string, dryRun bool, logger *slog.Logger) error {
-	for _, relPath := range relPaths {
+// A stray whose source no longer exists is skipped and reported in
+// Summary.Vanished rather than aborting the batch. The summary is returned
+// even when an error stops the run early.
+func MoveOrphans(items []Item, libraryPath, targetDir string, opts Options, logger *slog.Logger) (*Summary, error) {
+	sum := &Summary{}
+	if (opts.Copy || opts.Link) && (opts.Dedupe || opts.DeleteDuplicates || opts.Delete || opts.Relink) {
+		return sum, errors.New("copying or linking cannot be combined with deduplication, deletion, or relinking")
+	}
+	if opts.Copy && opts.Link {
+		return sum, errors.New("copying and linking cannot be combined")
+	}
+	if opts.ArchivePath != "" && (opts.Copy || opts.Link || opts.Delete) {
+		return sum, errors.New("archiving cannot be combined with copying, linking, or deletion")
+	}
+	if opts.Remote != nil && (opts.Link || opts.ArchivePath != "" || opts.Dedupe) {
+		return sum, errors.New("a remote target cannot be combined with linking, archiving, or deduplication")
+	}
+	if (opts.Copy || opts.Link || opts.ArchivePath != "") && slices.ContainsFunc(items, func(it Item) bool { return it.Delete }) {
+		return sum, errors.New("deleting strays cannot be combined with copying, linking, or archiving")
+	}
+	if opts.Identical != "" && opts.Identical != IdenticalKeep {
+		if opts.Copy || opts.Link || opts.ArchivePath != "" || opts.Remote != nil || opts.Delete {
+			return sum, errors.New("linking or deleting identical strays cannot be combined with copying, linking, archiving, a remote target, or deletion")
+		}
+		opts.firsts = make(firstCopies)
+	}
+
+	var idx quarantineIndex
+	if opts.Dedupe {
+		var err error
+		if idx, err = buildQuarantineIndex(targetDir); err != nil {
+			return sum, fmt.Errorf("load quarantine manifests: %w", err)
+		}
+		logger.Debug("loaded quarantine index", "hashed_files", len(idx))
+	}
+
+	if opts.RunID == "" {
+		opts.RunID = NewRunID()
+	}
+	runID := opts.RunID
+	if opts.Layout == nil {
+		opts.Layout, _ = ParseLayout(DefaultLayout)
+	}
+	manifest := newManifestWriter(targetDir, runID)
+	defer manifest.close()
+	if !opts.DryRun {
+		if err := readonly.Check("write journal"); err != nil {
+			return sum, err
+		}
+		journal, err := createJournal(targetDir, runID, items)
+		if err != nil {
+			return sum, err
+		}
+		defer journal.close()
+		opts.journal = journal
+	}
+	if opts.ArchivePath != "" && !opts.DryRun {
+		archive, err := CreateArchive(opts.ArchivePath)
+		if err != nil {
+			return sum, err
+		}
+		archive.limit = opts.IOLimit
+		opts.archive = archive
+		defer func() {
+			if opts.archive != nil {
+				opts.archive.Abort()
+			}
+		}()
+	}
+	var archived []ManifestEntry
+
+	for _, item := range items {
 		// Convert forward-slash relative path to OS path.
-		srcRel := filepath.FromSlash(relPath)
-		src := filepath.Join(libraryPath, srcRel)
-		dst := filepath.Join(targetDir, srcRel)
+		src := paths.Join(libraryPath, opts.Mounts, item.RelPath)
 
-		if dryRun {
-			logger.Info("[dry-run] would move", "src", src, "dst", dst)
+		entry, err := moveOne(item, src, libraryPath, targetDir, idx, opts, logger)
+		opts.Progress.Add(1)
+		if isVanished(src, err) {
+			logger.Warn("stray vanished before it could be moved, skipping", "src", src)
+			sum.Vanished = append(sum.Vanished, item.RelPath)
+			continue
+		}
+		if errors.Is(err, errChanged) {
+			logger.Warn("stray was modified recently, leaving it in place", "src", src)
+			sum.Changed = append(sum.Changed, item.RelPath)
 			continue
 		}
+		if errors.Is(err, errAlreadyLinked) {
+			logger.Debug("stray is already linked into the quarantine", "src", src)
+			sum.AlreadyLinked = append(sum.AlreadyLinked, item.RelPath)
+			continue
+		}
+		if err != nil {
+			if !opts.KeepGoing || errors.Is(err, readonly.ErrReadOnly) || opts.archive != nil && opts.archive.broken {
+				return sum, err
+			}
+			logger.Error("failed to handle stray, going on with the next", "src", src, "error", err)
+			sum.Failed = append(sum.Failed, Failure{RelPath: item.RelPath, Err: err})
+			continue
+		}
+
+		sum.Entries = append(sum.Entries, entry)
+		opts.firsts.remember(entry)
+		switch entry.Action {
+		case ActionMoved:
+			sum.Moved++
+			sum.MovedBytes += entry.Size
+			if entry.Suspicious != "" {
+				sum.Suspicious++
+			}
+			if entry.DuplicateOf != "" {
+				sum.IdenticalLinked++
+			}
+		case ActionDeduplicated:
+			sum.Deduplicated++
+		case ActionImmichDuplicate:
+			sum.ImmichDuplicates++
+		case ActionRelinked:
+			sum.Relinked++
+		case ActionDeleted:
+			sum.Deleted++
+			sum.DeletedBytes += entry.Size
+		case ActionCopied:
+			sum.Copied++
+			sum.CopiedBytes += entry.Size
+			if entry.Suspicious != "" {
+				sum.Suspicious++
+			}
+		case ActionLinked:
+			sum.Linked++
+			sum.LinkedBytes += entry.Size
+			if entry.Suspicious != "" {
+				sum.Suspicious++
+			}
+		case ActionSkipped:
+			sum.Skipped = append(sum.Skipped, entry.Source)
+		case ActionArchived:
+			sum.Archived++
+			sum.ArchivedBytes += entry.Size
+			if entry.Suspicious != "" {
+				sum.Suspicious++
+			}
+			if !opts.DryRun {
+				// Recorded once the archive is complete.
+				archived = append(archived, entry)
+				continue
+			}
+		}
+		if !opts.DryRun {
+			if err := manifest.write(entry); err != nil {
+				return sum, err
+			}
+			if entry.Action == ActionSkipped {
+				continue
+			}
+			if err := opts.Audit.Record(auditEntry(runID, libraryPath, targetDir, opts.Mounts, entry)); err != nil {
+				return sum, err
+			}
+		}
+	}
+	if opts.archive != nil {
+		if err := opts.archive.Close(); err != nil {
+			return sum, err
+		}
+		opts.archive = nil
+		if err := sealArchived(archived, libraryPath, targetDir, runID, manifest, opts, logger); err != nil {
+			return sum, err
+		}
+	}
+	if opts.PruneEmptyDirs && !opts.DryRun {
+		pruned, err := pruneEmptyDirs(sum.Entries, libraryPath, opts.Mounts, logger)
+		if err != nil {
+			return sum, err
+		}
+		for _, e := range pruned {
+			e.Time = time.Now().UTC()
+			if err := manifest.write(e); err != nil {
+				return sum, err
+			}
+		}
+		sum.Pruned = len(pruned)
+		if sum.Pruned > 0 {
+			logger.Info("removed directories left empty", "dirs", sum.Pruned)
+		}
+	}
+	if err := manifest.close(); err != nil {
+		return sum, err
+	}
+	if err := opts.journal.finish(); err != nil {
+		return sum, err
+	}
+	if len(sum.Failed) > 0 {
+		return sum, fmt.Errorf("%d of %d stray(s) could not be handled", len(sum.Failed), len(items))
+	}
+	return sum, nil
+}
+
+// sealArchived removes the strays written to the now complete archive from
+// the library and records them. A stray that changed since it was archived
+// is kept.
+func sealArchived(entries []ManifestEntry, libraryPath, targetDir, runID string, manifest *manifestWriter, opts Options, logger *slog.Logger) error {
+	// Once these intents are written, Resume knows the archive is complete.
+	for _, e := range entries {
+		if err := opts.journal.intend(e); err != nil {
+			return err
+		}
+	}
+	for _, e := range entries {
+		src := paths.Join(libraryPath, opts.Mounts, e.Source)
+		if _, err := removeArchived(e, src, logger); err != nil {
+			return err
+		}
+		e.Time = time.Now().UTC()
+		if err := manifest.write(e); err != nil {
+			return err
+		}
+		if err := opts.Audit.Record(auditEntry(runID, libraryPath, targetDir, opts.Mounts, e)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditEntry describes what a manifest entry did, with absolute paths.
+func auditEntry(runID, libraryPath, targetDir string, mounts []paths.Mount, e ManifestEntry) audit.Entry {
+	a := audit.Entry{
+		Time:   e.Time,
+		RunID:  runID,
+		Action: e.Action,
+		Source: paths.Join(libraryPath, mounts, e.Source),
+		Size:   e.Size,
+		SHA256: e.SHA256,
+	}
+	switch {
+	case e.Remote != "":
+		a.Dest = remoteURL(e.Remote, e.Dest)
+	case e.Action == ActionMoved, e.Action == ActionCopied, e.Action == ActionLinked:
+		a.Dest = filepath.Join(targetDir, filepath.FromSlash(e.Dest))
+	case e.Action == ActionArchived:
+		// A file in an archive is named as if the archive were a directory.
+		a.Dest = filepath.Join(e.Archive, filepath.FromSlash(e.Dest))
+	case e.Action == ActionDeduplicated:
+		a.DuplicateOf = filepath.Join(targetDir, filepath.FromSlash(e.DuplicateOf))
+	case e.Action == ActionImmichDuplicate:
+		a.DuplicateOf = paths.Join(libraryPath, mounts, e.DuplicateOf)
+	case e.Action == ActionRelinked:
+		a.Dest = paths.Join(libraryPath, mounts, e.Dest)
+	}
+	return a
+}
+
+// moveOne handles a single stray and returns the manifest entry describing
+// what was (or, in dry-run mode, would be) done.
+func moveOne(item Item, src, libraryPath, targetDir string, idx quarantineIndex, opts Options, logger *slog.Logger) (ManifestEntry, error) {
+	entry := ManifestEntry{Action: ActionMoved, Source: item.RelPath}
+
+	info, err := os.Lstat(src)
+	if err != nil {
+		return entry, fmt.Errorf("stat %s: %w", src, err)
+	}
+	entry.Size = info.Size()
+	entry.ModTime = info.ModTime().UTC()
+	if opts.MinAge > 0 && (time.Since(info.ModTime()) < opts.MinAge || !item.ModTime.IsZero() && !item.ModTime.Equal(info.ModTime())) {
+		return entry, errChanged
+	}
+
+	// Check before deduplicating: a rejected file must not be deleted as a
+	// duplicate of a quarantined one that passed an older hook.
+	if opts.Hook != nil {
+		if opts.DryRun {
+			logger.Info("[dry-run] would run pre-move hook", "src", src)
+		} else {
+			verdict, err := opts.Hook.Check(src)
+			if err != nil {
+				return entry, err
+			}
+			if verdict != "" {
+				logger.Warn("pre-move hook rejected file", "src", src, "verdict", verdict)
+				entry.Suspicious = verdict
+				item.Category = SuspiciousCategory
+			}
+		}
+	}
+
+	// Hash before anything can delete the file, so the manifest and audit
+	// log can vouch for the content of every stray handled.
+	if !opts.DryRun || opts.Dedupe || opts.firsts != nil || opts.Layout.NeedsHash() {
+		hash, err := hashFile(src)
+		if err != nil {
+			return entry, fmt.Errorf("hash %s: %w", src, err)
+		}
+		entry.SHA256 = hash
+	}
+
+	if opts.Relink && item.RelinkTo != "" && entry.Suspicious == "" {
+		relinked, err := relink(&entry, item.RelinkTo, src, libraryPath, opts, logger)
+		if relinked || err != nil {
+			return entry, err
+		}
+	}
+
+	if opts.DeleteDuplicates && item.DuplicateOf != "" && entry.Suspicious == "" {
+		twin := paths.Join(libraryPath, opts.Mounts, item.DuplicateOf)
+		intent := entry
+		intent.Action, intent.DuplicateOf = ActionImmichDuplicate, item.DuplicateOf
+		if err := opts.journal.intend(intent); err != nil {
+			return entry, err
+		}
+		deleted, err := deleteImmichDuplicate(src, twin, opts.DryRun, logger)
+		if err != nil {
+			return entry, err
+		}
+		if deleted {
+			entry.Action, entry.DuplicateOf = ActionImmichDuplicate, item.DuplicateOf
+			entry.Time = time.Now().UTC()
+			return entry, nil
+		}
+	}
+
+	if opts.Dedupe && entry.Suspicious == "" {
+		if prev, ok := idx.lookup(targetDir, entry.SHA256, info.Size()); ok {
+			intent := entry
+			intent.Action, intent.DuplicateOf = ActionDeduplicated, prev.Dest
+			if err := opts.journal.intend(intent); err != nil {
+				return entry, err
+			}
+			if err := dedupe(src, prev, opts.DryRun, logger); err != nil {
+				return entry, err
+			}
+			entry.Action, entry.Dest, entry.DuplicateOf = ActionDeduplicated, "", prev.Dest
+			entry.Time = time.Now().UTC()
+			return entry, nil
+		}
+	}
+
+	if opts.Identical == IdenticalDelete && entry.Suspicious == "" {
+		if first, ok := opts.firsts.lookup(entry.SHA256, info.Size()); ok {
+			intent := entry
+			intent.Action, intent.DuplicateOf = ActionDeduplicated, first.Dest
+			if err := opts.journal.intend(intent); err != nil {
+				return entry, err
+			}
+			if err := dedupe(src, first, opts.DryRun, logger); err != nil {
+				return entry, err
+			}
+			entry.Action, entry.Dest, entry.DuplicateOf = ActionDeduplicated, "", first.Dest
+			entry.Time = time.Now().UTC()
+			return entry, nil
+		}
+	}
+
+	if (opts.Delete || item.Delete) && entry.Suspicious == "" {
+		if opts.DryRun {
+			logger.Info("[dry-run] would delete", "src", src)
+			entry.Action = ActionDeleted
+			return entry, nil
+		}
+		intent := entry
+		intent.Action = ActionDeleted
+		if err := opts.journal.intend(intent); err != nil {
+			return entry, err
+		}
+		if err := readonly.Check("delete stray"); err != nil {
+			return entry, err
+		}
+		if err := os.Remove(src); err != nil {
+			return entry, fmt.Errorf("delete %s: %w", src, err)
+		}
+		logger.Info("deleted stray", "src", src)
+		entry.Action, entry.Time = ActionDeleted, time.Now().UTC()
+		return entry, nil
+	}
+
+	entry.Dest, err = opts.Layout.Render(item, entry.SHA256, opts.RunID)
+	if err != nil {
+		return entry, err
+	}
+	if entry.Suspicious != "" {
+		entry.Dest = path.Join(opts.SuspiciousDir, entry.Dest)
+	}
+	if opts.ArchivePath != "" {
+		entry.Action, entry.Archive = ActionArchived, opts.ArchivePath
+		if opts.DryRun {
+			logger.Info("[dry-run] would archive", "src", src, "archive", opts.ArchivePath, "name", entry.Dest)
+			return entry, nil
+		}
+		sum, err := opts.archive.add(entry.Dest, src)
+		if err != nil {
+			return entry, fmt.Errorf("archive %s: %w", src, err)
+		}
+		if entry.SHA256 != "" && sum != entry.SHA256 {
+			return entry, fmt.Errorf("archive %s: file changed while it was being archived", src)
+		}
+		entry.SHA256 = sum
+		logger.Debug("added file to archive", "src", src, "name", entry.Dest)
+		return entry, nil
+	}
+	if opts.Remote != nil {
+		return uploadOne(entry, src, opts, logger)
+	}
+
+	dst := filepath.Join(targetDir, filepath.FromSlash(entry.Dest))
+	if dstInfo, err := os.Lstat(dst); err == nil {
+		if opts.Link && os.SameFile(info, dstInfo) {
+			return entry, errAlreadyLinked
+		}
+		taken := func(dest string) (bool, error) {
+			return exists(filepath.Join(targetDir, filepath.FromSlash(dest)))
+		}
+		if entry, err = resolveConflict(entry, src, dst, taken, opts, logger); err != nil || entry.Action == ActionSkipped {
+			return entry, err
+		}
+		if entry.Conflict == ConflictOverwrite && !opts.DryRun {
+			if err := readonly.Check("overwrite quarantined file"); err != nil {
+				return entry, err
+			}
+			if err := os.Remove(dst); err != nil {
+				return entry, fmt.Errorf("remove %s: %w", dst, err)
+			}
+		}
+		dst = filepath.Join(targetDir, filepath.FromSlash(entry.Dest))
+	}
+
+	if opts.Copy {
+		entry.Action = ActionCopied
+		if opts.DryRun {
+			logger.Info("[dry-run] would copy", "src", src, "dst", dst)
+			return entry, nil
+		}
+		if err := opts.journal.intend(entry); err != nil {
+			return entry, err
+		}
+		if err := placeCopy(src, dst, opts.Verify, opts.IOLimit); err != nil {
+			logger.Error("failed to copy file", "src", src, "dst", dst, "error", err)
+			return entry, fmt.Errorf("copy %s -> %s: %w", src, dst, err)
+		}
+		entry.Time = time.Now().UTC()
+		logger.Info("copied file", "src", src, "dst", dst)
+		return entry, nil
+	}
+
+	if opts.Link {
+		entry.Action = ActionLinked
+		if opts.DryRun {
+			logger.Info("[dry-run] would link", "src", src, "dst", dst)
+			return entry, nil
+		}
+		if err := opts.journal.intend(entry); err != nil {
+			return entry, err
+		}
+		if err := linkFile(src, dst); err != nil {
+			logger.Error("failed to link file", "src", src, "dst", dst, "error", err)
+			return entry, fmt.Errorf("link %s -> %s: %w", src, dst, err)
+		}
+		entry.Time = time.Now().UTC()
+		logger.Info("linked file", "src", src, "dst", dst)
+		return entry, nil
+	}
+
+	var first ManifestEntry
+	if opts.Identical == IdenticalLink && entry.Suspicious == "" {
+		first, _ = opts.firsts.lookup(entry.SHA256, info.Size())
+		entry.DuplicateOf = first.Dest
+	}
+	if opts.DryRun {
+		if first.Dest != "" {
+			logger.Info("[dry-run] would move as a hardlink of an identical stray", "src", src, "dst", dst, "duplicate_of", first.Dest)
+			return entry, nil
+		}
+		logger.Info("[dry-run] would move", "src", src, "dst", dst)
+		return entry, nil
+	}
 
-		if err := moveFile(src, dst, logger); err != nil {
+	if err := opts.journal.intend(entry); err != nil {
+		return entry, err
+	}
+	if first.Dest != "" {
+		if err := linkIdentical(src, dst, filepath.Join(targetDir, filepath.FromSlash(first.Dest)), opts, logger); err != nil {
 			logger.Error("failed to move file", "src", src, "dst", dst, "error", err)
-			return fmt.Errorf("move %s -> %s: %w", src, dst, err)
+			return entry, fmt.Errorf("move %s -> %s: %w", src, dst, err)
 		}
+		entry.Time = time.Now().UTC()
+		logger.Info("moved file as a hardlink of an identical stray", "src", src, "dst", dst, "duplicate_of", first.Dest)
+		return entry, nil
+	}
+	if err := moveFile(src, dst, opts.Verify, opts.IOLimit, logger); err != nil {
+		logger.Error("failed to move file", "src", src, "dst", dst, "error", err)
+		return entry, fmt.Errorf("move %s -> %s: %w", src, dst, err)
+	}
+	entry.Time = time.Now().UTC()
+
+	logger.Info("moved file", "src", src, "dst", dst)
+	return entry, nil
+}
+
+// relink moves src to rel, the path in the library of an asset's missing
+// original with the same content, and records that in entry. It reports
+// false, leaving src alone, if something took rel's place since the scan:
+// the stray is then moved as usual.
+func relink(entry *ManifestEntry, rel, src, libraryPath string, opts Options, logger *slog.Logger) (bool, error) {
+	dst := paths.Join(libraryPath, opts.Mounts, rel)
+	taken, err := exists(dst)
+	if err != nil {
+		return false, err
+	}
+	if taken {
+		logger.Warn("asset's original is back, moving the stray instead", "src", src, "original", dst)
+		return false, nil
+	}
+	intent := *entry
+	intent.Action, intent.Dest = ActionRelinked, rel
+	if opts.DryRun {
+		logger.Info("[dry-run] would relink stray as missing original", "src", src, "dst", dst)
+		*entry = intent
+		return true, nil
+	}
+	if err := opts.journal.intend(intent); err != nil {
+		return false, err
+	}
+	if err := moveFile(src, dst, opts.Verify, opts.IOLimit, logger); err != nil {
+		return false, fmt.Errorf("relink %s -> %s: %w", src, dst, err)
+	}
+	*entry = intent
+	entry.Time = time.Now().UTC()
+	logger.Info("relinked stray as missing original", "src", src, "dst", dst)
+	return true, nil
+}
 
-		logger.Info("moved file", "src", src, "dst", dst)
+// isVanished reports whether err was caused by src no longer existing.
+func isVanished(src string, err error) bool {
+	if !errors.Is(err, fs.ErrNotExist) {
+		return false
 	}
+	_, statErr := os.Lstat(src)
+	return errors.Is(statErr, fs.ErrNotExist)
+}
+
+// dedupe deletes src because an identical copy is already quarantined.
+func dedupe(src string, prev ManifestEntry, dryRun bool, logger *slog.Logger) error {
+	if dryRun {
+		logger.Info("[dry-run] would delete duplicate of quarantined file", "src", src, "duplicate_of", prev.Dest)
+		return nil
+	}
+	if err := readonly.Check("delete duplicate"); err != nil {
+		return err
+	}
+	if err := os.Remove(src); err != nil {
+		return fmt.Errorf("delete duplicate %s: %w", src, err)
+	}
+	logger.Info("deleted duplicate of quarantined file", "src", src, "duplicate_of", prev.Dest)
 	return nil
 }
 
 // moveFile moves src to dst. It tries os.Rename first for efficiency,
-// falling back to copy+delete for cross-device moves.
-func moveFile(src, dst string, logger *slog.Logger) error {
+// falling back to copy+delete for cross-device moves, or always copying
+// with verify. The copy is written next to dst and renamed into place once
+// complete and identical to src, so dst never holds a partial file.
+func moveFile(src, dst string, verify bool, limit *throttle.Limiter, logger *slog.Logger) error {
+	if err := readonly.Check("move file"); err != nil {
+		return err
+	}
+
 	// Ensure destination directory exists.
 	dstDir := filepath.Dir(dst)
 	if err := os.MkdirAll(dstDir, 0o755); err != nil {
 		return fmt.Errorf("create directory %s: %w", dstDir, err)
 	}
 
-	// Try rename first (same filesystem).
-	err := os.Rename(src, dst)
-	if err == nil {
-		return nil
+	// Try rename first (same filesystem), unless asked to verify a copy.
+	if !verify {
+		err := os.Rename(src, dst)
+		if err == nil {
+			return nil
+		}
+		logger.Debug("rename failed, falling back to copy+delete",
+			"src", src, "dst", dst, "error", err,
+		)
 	}
 
-	logger.Debug("rename failed, falling back to copy+delete",
-		"src", src, "dst", dst, "error", err,
-	)
+	// Fallback: copy, check the copy, then delete.
+	if err := renameCopy(src, dst, true, limit); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
 
-	// Fallback: copy then delete.
-	if err := copyFile(src, dst); err != nil {
+// placeCopy copies src to dst, creating dst's directory, and leaves src in
+// place. With verify, the copy is checked as renameCopy does.
+func placeCopy(src, dst string, verify bool, limit *throttle.Limiter) error {
+	if err := readonly.Check("copy file"); err != nil {
 		return err
 	}
+	dstDir := filepath.Dir(dst)
+	if err := os.MkdirAll(dstDir, 0o755); err != nil {
+		return fmt.Errorf("create directory %s: %w", dstDir, err)
+	}
+	return renameCopy(src, dst, verify, limit)
+}
 
-	return os.Remove(src)
+// linkFile creates dst as a hardlink to src, creating dst's directory.
+func linkFile(src, dst string) error {
+	if err := readonly.Check("link file"); err != nil {
+		return err
+	}
+	dstDir := filepath.Dir(dst)
+	if err := os.MkdirAll(dstDir, 0o755); err != nil {
+		return fmt.Errorf("create directory %s: %w", dstDir, err)
+	}
+	if err := os.Link(src, dst); err != nil {
+		if errors.Is(err, syscall.EXDEV) {
+			return fmt.Errorf("%w; hardlinks need the target directory on the same filesystem as the library", err)
+		}
+		return err
+	}
+	return nil
 }
 
-// copyFile copies src to dst, preserving file permissions.
-func copyFile(src, dst string) error {
+// renameCopy copies src next to dst and renames the copy into place once
+// complete and flushed to disk, so dst never holds a partial file, even
+// after a crash. With verify, the copy is
+// only renamed into place if its checksum matches that of src.
+func renameCopy(src, dst string, verify bool, limit *throttle.Limiter) error {
+	tmp := partialPath(dst)
+	if err := copyFile(src, tmp, limit); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if verify {
+		if err := verifyCopy(src, tmp); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("rename copy into place: %w", err)
+	}
+	// Make the rename durable before the caller removes the original.
+	return syncDir(filepath.Dir(dst))
+}
+
+// syncDir flushes the directory entries of dir to disk, so a file renamed
+// into it survives a crash. Windows cannot sync directories.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("sync directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// verifyCopy checks that the copy dst holds the same content as src.
+func verifyCopy(src, dst string) error {
+	size, sum, err := fileDigest(src)
+	if err != nil {
+		return fmt.Errorf("verify copy: %w", err)
+	}
+	copySize, copySum, err := fileDigest(dst)
+	if err != nil {
+		return fmt.Errorf("verify copy: %w", err)
+	}
+	if copySize != size || copySum != sum {
+		return fmt.Errorf("verify copy: checksum of the copy does not match %s", src)
+	}
+	return nil
+}
+
+// partialPath is where moveFile copies to before renaming the copy to dst.
+func partialPath(dst string) string {
+	dir, name := filepath.Split(dst)
+	return filepath.Join(dir, "."+name+".partial")
+}
+
+// copyFile copies src to dst, at most as fast as limit allows, and flushes
+// it to disk, preserving file permissions and, as copyMetadata does, the
+// rest of its metadata.
+func copyFile(src, dst string, limit *throttle.Limiter) error {
+	if err := readonly.Check("copy file"); err != nil {
+		return err
+	}
+
 	srcFile, err := os.Open(src)
 	if err != nil {
 		return fmt.Errorf("open source: %w", err)
@@ -81,9 +923,15 @@ func copyFile(src, dst string) error {
 	}
 	defer dstFile.Close()
 
-	if _, err := io.Copy(dstFile, srcFile); err != nil {
+	if _, err := io.Copy(throttle.Writer(dstFile, limit), srcFile); err != nil {
 		return fmt.Errorf("copy data: %w", err)
 	}
+	if err := dstFile.Sync(); err != nil {
+		return fmt.Errorf("sync destination: %w", err)
+	}
+	if err := dstFile.Close(); err != nil {
+		return err
+	}
 
-	return dstFile.Close()
+	return copyMetadata(src, dst, srcInfo)
 }
@@ -5,12 +5,22 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func testLogger() *slog.Logger {
 	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
 }
 
+// items wraps relative paths as uncategorized move items.
+func items(relPaths ...string) []Item {
+	out := make([]Item, len(relPaths))
+	for i, p := range relPaths {
+		out[i] = Item{RelPath: p}
+	}
+	return out
+}
+
 func TestMoveOrphans_DryRun(t *testing.T) {
 	srcDir := t.TempDir()
 	dstDir := t.TempDir()
@@ -22,7 +32,7 @@ func TestMoveOrphans_DryRun(t *testing.T) {
 
 	relPaths := []string{"upload/2024/photo.JPG"}
 
-	err := MoveOrphans(relPaths, srcDir, dstDir, true, testLogger())
+	_, err := MoveOrphans(items(relPaths...), srcDir, dstDir, Options{DryRun: true}, testLogger())
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -51,7 +61,7 @@ func TestMoveOrphans_ActualMove(t *testing.T) {
 
 	relPaths := []string{"upload/2024/photo.JPG"}
 
-	err := MoveOrphans(relPaths, srcDir, dstDir, false, testLogger())
+	_, err := MoveOrphans(items(relPaths...), srcDir, dstDir, Options{}, testLogger())
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -83,7 +93,7 @@ func TestMoveOrphans_PreservesDirectoryStructure(t *testing.T) {
 
 	relPaths := []string{"upload/lib/admin/2024/01/img.JPG"}
 
-	err := MoveOrphans(relPaths, srcDir, dstDir, false, testLogger())
+	_, err := MoveOrphans(items(relPaths...), srcDir, dstDir, Options{}, testLogger())
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -105,7 +115,7 @@ func TestMoveOrphans_MultipleFiles(t *testing.T) {
 
 	relPaths := []string{"a/f1.JPG", "b/f2.PNG"}
 
-	err := MoveOrphans(relPaths, srcDir, dstDir, false, testLogger())
+	_, err := MoveOrphans(items(relPaths...), srcDir, dstDir, Options{}, testLogger())
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -117,3 +127,296 @@ func TestMoveOrphans_MultipleFiles(t *testing.T) {
 		}
 	}
 }
+
+func TestMoveOrphans_VanishedSourceIsSkipped(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	os.WriteFile(filepath.Join(srcDir, "present.jpg"), []byte("1"), 0o644)
+	relPaths := []string{"gone.jpg", "present.jpg"}
+
+	sum, err := MoveOrphans(items(relPaths...), srcDir, dstDir, Options{}, testLogger())
+	if err != nil {
+		t.Fatalf("vanished file should not abort the batch: %v", err)
+	}
+	if sum.Moved != 1 {
+		t.Errorf("expected 1 moved, got %d", sum.Moved)
+	}
+	if len(sum.Vanished) != 1 || sum.Vanished[0] != "gone.jpg" {
+		t.Errorf("expected gone.jpg to be reported as vanished, got %v", sum.Vanished)
+	}
+	if len(sum.Entries) != 1 || sum.Entries[0].Source != "present.jpg" || sum.Entries[0].Action != ActionMoved {
+		t.Errorf("expected a single moved entry for present.jpg, got %+v", sum.Entries)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "present.jpg")); err != nil {
+		t.Error("expected present.jpg to be moved")
+	}
+}
+
+func TestMoveOrphans_MinAgeLeavesRecentStrays(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	old := time.Now().Add(-time.Hour)
+	for _, name := range []string{"old.jpg", "new.jpg", "changed.jpg"} {
+		os.WriteFile(filepath.Join(srcDir, name), []byte("1"), 0o644)
+		os.Chtimes(filepath.Join(srcDir, name), old, old)
+	}
+	os.Chtimes(filepath.Join(srcDir, "new.jpg"), time.Now(), time.Now())
+	scanned := []Item{
+		{RelPath: "old.jpg", ModTime: old},
+		{RelPath: "new.jpg"},
+		// Scanned before it was last written to.
+		{RelPath: "changed.jpg", ModTime: old.Add(-time.Minute)},
+	}
+
+	sum, err := MoveOrphans(scanned, srcDir, dstDir, Options{MinAge: 10 * time.Minute}, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sum.Moved != 1 || len(sum.Changed) != 2 || sum.Changed[0] != "new.jpg" || sum.Changed[1] != "changed.jpg" {
+		t.Errorf("expected only old.jpg to be moved, got %d moved and %v changed", sum.Moved, sum.Changed)
+	}
+	if _, err := os.Stat(filepath.Join(srcDir, "new.jpg")); err != nil {
+		t.Error("expected new.jpg to stay in the library")
+	}
+}
+
+func TestMoveOrphans_DeletesItemsMarkedForDeletion(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	os.WriteFile(filepath.Join(srcDir, ".DS_Store"), []byte("junk"), 0o644)
+	os.WriteFile(filepath.Join(srcDir, "photo.jpg"), []byte("photo"), 0o644)
+	items := []Item{{RelPath: ".DS_Store", Delete: true}, {RelPath: "photo.jpg"}}
+
+	sum, err := MoveOrphans(items, srcDir, dstDir, Options{}, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sum.Moved != 1 || sum.Deleted != 1 {
+		t.Errorf("expected 1 moved and 1 deleted, got %+v", sum)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, ".DS_Store")); !os.IsNotExist(err) {
+		t.Error("deleted stray should not be quarantined")
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "photo.jpg")); err != nil {
+		t.Errorf("photo.jpg not quarantined: %v", err)
+	}
+
+	if _, err := MoveOrphans(items, srcDir, dstDir, Options{Copy: true}, testLogger()); err == nil {
+		t.Error("expected an error combining copying with deleting strays")
+	}
+}
+
+func TestMoveOrphans_LargeFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("hashing a 5 GiB file takes several seconds")
+	}
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	// A sparse file past 4 GiB, the size a 32-bit int overflows at.
+	const size = 5 << 30
+	os.MkdirAll(filepath.Join(srcDir, "upload"), 0o755)
+	srcFile := filepath.Join(srcDir, "upload", "video.mp4")
+	os.WriteFile(srcFile, nil, 0o644)
+	if err := os.Truncate(srcFile, size); err != nil {
+		t.Skipf("cannot create sparse file: %v", err)
+	}
+
+	sum, err := MoveOrphans(items("upload/video.mp4"), srcDir, dstDir, Options{}, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sum.Entries) != 1 || sum.Entries[0].Size != size {
+		t.Fatalf("expected one entry of %d bytes, got %+v", int64(size), sum.Entries)
+	}
+	if sum.MovedBytes != size {
+		t.Errorf("MovedBytes = %d, want %d", sum.MovedBytes, int64(size))
+	}
+	info, err := os.Stat(filepath.Join(dstDir, "upload", "video.mp4"))
+	if err != nil {
+		t.Fatalf("destination missing: %v", err)
+	}
+	if info.Size() != size {
+		t.Errorf("destination size = %d, want %d", info.Size(), int64(size))
+	}
+}
+
+func TestMoveOrphans_Delete(t *testing.T) {
+	lib, quarantine := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(lib, "a.jpg"), []byte("photo"), 0o644)
+
+	sum, err := MoveOrphans(items("a.jpg"), lib, quarantine, Options{RunID: "run1", Delete: true, DryRun: true}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Deleted != 1 {
+		t.Errorf("expected 1 file to be deleted in the dry run, got %d", sum.Deleted)
+	}
+	if _, err := os.Stat(filepath.Join(lib, "a.jpg")); err != nil {
+		t.Fatalf("dry run deleted the file: %v", err)
+	}
+
+	sum, err = MoveOrphans(items("a.jpg"), lib, quarantine, Options{RunID: "run1", Delete: true}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Deleted != 1 || sum.DeletedBytes != 5 || sum.Moved != 0 {
+		t.Errorf("unexpected summary: %+v", sum)
+	}
+	if _, err := os.Stat(filepath.Join(lib, "a.jpg")); !os.IsNotExist(err) {
+		t.Error("expected the stray to be deleted")
+	}
+	entries, err := LoadRun(quarantine, "run1")
+	if err != nil || len(entries) != 1 || entries[0].Action != ActionDeleted {
+		t.Errorf("expected a deleted entry in the manifest, got %+v, %v", entries, err)
+	}
+	rsum, err := Restore(lib, quarantine, RestoreOptions{RunID: "run1"}, testLogger())
+	if err != nil || rsum.Restored != 0 || len(rsum.Skipped) != 1 {
+		t.Errorf("expected restore to skip the deleted file, got %+v, %v", rsum, err)
+	}
+}
+
+func TestMoveOrphans_Copy(t *testing.T) {
+	lib, quarantine := t.TempDir(), t.TempDir()
+	os.MkdirAll(filepath.Join(lib, "upload"), 0o755)
+	os.WriteFile(filepath.Join(lib, "upload", "a.jpg"), []byte("photo"), 0o644)
+
+	sum, err := MoveOrphans(items("upload/a.jpg"), lib, quarantine, Options{RunID: "run1", Copy: true}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Copied != 1 || sum.CopiedBytes != 5 || sum.Moved != 0 {
+		t.Errorf("unexpected summary: %+v", sum)
+	}
+	for _, dir := range []string{lib, quarantine} {
+		if data, err := os.ReadFile(filepath.Join(dir, "upload", "a.jpg")); err != nil || string(data) != "photo" {
+			t.Errorf("expected the stray in %s, got %q, %v", dir, data, err)
+		}
+	}
+	entries, err := LoadRun(quarantine, "run1")
+	if err != nil || len(entries) != 1 || entries[0].Action != ActionCopied {
+		t.Errorf("expected a copied entry in the manifest, got %+v, %v", entries, err)
+	}
+
+	psum, err := Purge(quarantine, PurgeOptions{RunID: "run1"}, testLogger())
+	if err != nil || psum.Deleted != 1 {
+		t.Errorf("expected purge to delete the copy, got %+v, %v", psum, err)
+	}
+	if _, err := os.Stat(filepath.Join(lib, "upload", "a.jpg")); err != nil {
+		t.Errorf("purge touched the original: %v", err)
+	}
+
+	if _, err := MoveOrphans(items("upload/a.jpg"), lib, quarantine, Options{Copy: true, Dedupe: true}, testLogger()); err == nil {
+		t.Error("expected copying with deduplication to be refused")
+	}
+}
+
+func TestMoveOrphans_Verify(t *testing.T) {
+	lib, quarantine := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(lib, "a.jpg"), []byte("photo"), 0o644)
+	before, _ := os.Stat(filepath.Join(lib, "a.jpg"))
+
+	if _, err := MoveOrphans(items("a.jpg"), lib, quarantine, Options{RunID: "run1", Verify: true}, testLogger()); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(filepath.Join(quarantine, "a.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(before, after) {
+		t.Error("expected the stray to be copied rather than renamed")
+	}
+	if _, err := os.Stat(filepath.Join(lib, "a.jpg")); !os.IsNotExist(err) {
+		t.Error("expected the stray to be removed from the library")
+	}
+}
+
+func TestMoveOrphans_KeepGoing(t *testing.T) {
+	lib, quarantine := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(lib, "a.jpg"), []byte("a"), 0o644)
+	os.Mkdir(filepath.Join(lib, "dir.jpg"), 0o755)
+	os.WriteFile(filepath.Join(lib, "b.jpg"), []byte("b"), 0o644)
+
+	sum, err := MoveOrphans(items("a.jpg", "dir.jpg", "b.jpg"), lib, quarantine, Options{RunID: "run1", KeepGoing: true}, testLogger())
+	if err == nil {
+		t.Error("expected the run to fail")
+	}
+	if sum.Moved != 2 || len(sum.Failed) != 1 || sum.Failed[0].RelPath != "dir.jpg" {
+		t.Errorf("expected 2 moved and dir.jpg failed, got %+v", sum)
+	}
+	if runs, _ := PendingRuns(quarantine); len(runs) != 0 {
+		t.Errorf("expected the run to be complete, got pending %v", runs)
+	}
+
+	os.WriteFile(filepath.Join(lib, "c.jpg"), []byte("c"), 0o644)
+	sum, err = MoveOrphans(items("dir.jpg", "c.jpg"), lib, quarantine, Options{RunID: "run2"}, testLogger())
+	if err == nil || sum.Moved != 0 {
+		t.Errorf("expected the run to stop at the first failure without --keep-going, got %+v, %v", sum, err)
+	}
+}
+
+func TestVerifyCopy(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	os.WriteFile(a, []byte("photo"), 0o644)
+	os.WriteFile(b, []byte("photo"), 0o644)
+	if err := verifyCopy(a, b); err != nil {
+		t.Errorf("verifyCopy() of identical files = %v", err)
+	}
+	os.WriteFile(b, []byte("phot0"), 0o644)
+	if err := verifyCopy(a, b); err == nil {
+		t.Error("expected a differing copy to fail verification")
+	}
+}
+
+func TestMoveOrphans_Link(t *testing.T) {
+	lib, quarantine := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(lib, "a.jpg"), []byte("photo"), 0o644)
+	os.WriteFile(filepath.Join(lib, "b.jpg"), []byte("other"), 0o644)
+
+	sum, err := MoveOrphans(items("a.jpg", "b.jpg"), lib, quarantine, Options{RunID: "run1", Link: true}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Linked != 2 || sum.LinkedBytes != 10 {
+		t.Errorf("unexpected summary: %+v", sum)
+	}
+	orig, _ := os.Stat(filepath.Join(lib, "a.jpg"))
+	link, err := os.Stat(filepath.Join(quarantine, "a.jpg"))
+	if err != nil || !os.SameFile(orig, link) {
+		t.Fatalf("expected a hardlink in the quarantine: %v", err)
+	}
+
+	// The strays are still in the library, so the next run finds them again.
+	sum, err = MoveOrphans(items("a.jpg", "b.jpg"), lib, quarantine, Options{RunID: "run2", Link: true}, testLogger())
+	if err != nil || sum.Linked != 0 || len(sum.AlreadyLinked) != 2 {
+		t.Errorf("expected both strays to be recognized as linked, got %+v, %v", sum, err)
+	}
+
+	if _, err := Purge(quarantine, PurgeOptions{}, testLogger()); err == nil {
+		t.Error("expected purging links without the library path to fail")
+	}
+	rsum, err := Restore(lib, quarantine, RestoreOptions{RunID: "run1", Glob: "b.jpg"}, testLogger())
+	if err != nil || rsum.Restored != 1 {
+		t.Errorf("expected b.jpg to be restored, got %+v, %v", rsum, err)
+	}
+	if _, err := os.Stat(filepath.Join(quarantine, "b.jpg")); !os.IsNotExist(err) {
+		t.Error("expected restore to remove the link")
+	}
+
+	psum, err := Purge(quarantine, PurgeOptions{LibraryPath: lib}, testLogger())
+	if err != nil || psum.Deleted != 1 {
+		t.Errorf("expected a.jpg to be purged, got %+v, %v", psum, err)
+	}
+	for _, p := range []string{filepath.Join(lib, "a.jpg"), filepath.Join(quarantine, "a.jpg")} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be deleted", p)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(lib, "b.jpg")); err != nil {
+		t.Error("expected the restored b.jpg to stay in the library")
+	}
+}
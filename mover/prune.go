@@ -0,0 +1,72 @@
+package mover
+
+import (
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/readonly"
+)
+
+// removedFromLibrary reports whether e took its stray out of the library.
+func removedFromLibrary(e ManifestEntry) bool {
+	switch e.Action {
+	case ActionMoved, ActionDeduplicated, ActionImmichDuplicate, ActionDeleted, ActionArchived, ActionRelinked:
+		return true
+	}
+	return false
+}
+
+// pruneEmptyDirs removes the library directories that held the strays of
+// entries and are empty now, deepest first, and returns an ActionPruned
+// entry for each. Top-level directories such as library/ or upload/, and
+// the directories of mounts, are never removed.
+func pruneEmptyDirs(entries []ManifestEntry, libraryPath string, mounts []paths.Mount, logger *slog.Logger) ([]ManifestEntry, error) {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, e := range entries {
+		if !removedFromLibrary(e) {
+			continue
+		}
+		for dir := path.Dir(e.Source); strings.Contains(dir, "/") && !seen[dir]; dir = path.Dir(dir) {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		return nil, nil
+	}
+	if err := readonly.Check("prune empty directories"); err != nil {
+		return nil, err
+	}
+	// Children before their parents.
+	slices.SortFunc(dirs, func(a, b string) int {
+		if d := strings.Count(b, "/") - strings.Count(a, "/"); d != 0 {
+			return d
+		}
+		return strings.Compare(a, b)
+	})
+
+	var pruned []ManifestEntry
+	for _, dir := range dirs {
+		if slices.ContainsFunc(mounts, func(m paths.Mount) bool { return m.Rel == dir }) {
+			// The mount point itself stays.
+			continue
+		}
+		full := paths.Join(libraryPath, mounts, dir)
+		info, err := os.Lstat(full)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		// Only ever removes an empty directory.
+		if err := os.Remove(full); err != nil {
+			continue
+		}
+		logger.Debug("removed empty directory", "dir", full)
+		pruned = append(pruned, ManifestEntry{Action: ActionPruned, Source: dir})
+	}
+	return pruned, nil
+}
@@ -0,0 +1,63 @@
+package mover
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveOrphans_PruneEmptyDirs(t *testing.T) {
+	lib, dst := t.TempDir(), t.TempDir()
+	for _, p := range []string{"upload/u1/ab/cd/a.jpg", "upload/u1/ab/ef/b.jpg", "upload/u1/ab/ef/keep.jpg", "library/u1/c.jpg"} {
+		os.MkdirAll(filepath.Join(lib, filepath.Dir(p)), 0o755)
+		os.WriteFile(filepath.Join(lib, p), []byte(p), 0o644)
+	}
+
+	sum, err := MoveOrphans(items("upload/u1/ab/cd/a.jpg", "upload/u1/ab/ef/b.jpg", "library/u1/c.jpg"), lib, dst, Options{RunID: "run1", PruneEmptyDirs: true}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// upload/u1/ab/cd and library/u1 are emptied; upload/u1/ab/ef still
+	// holds keep.jpg, so it and its parents stay.
+	if sum.Pruned != 2 {
+		t.Errorf("expected 2 directories pruned, got %d", sum.Pruned)
+	}
+	for _, dir := range []string{"upload/u1/ab/cd", "library/u1"} {
+		if _, err := os.Stat(filepath.Join(lib, dir)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be pruned", dir)
+		}
+	}
+	for _, dir := range []string{"upload/u1/ab/ef", "library"} {
+		if _, err := os.Stat(filepath.Join(lib, dir)); err != nil {
+			t.Errorf("expected %s to be kept: %v", dir, err)
+		}
+	}
+
+	// With c.jpg gone from the quarantine, only the pruned entry can bring
+	// back library/u1.
+	os.Remove(filepath.Join(dst, "library", "u1", "c.jpg"))
+	rsum, err := Restore(lib, dst, RestoreOptions{RunID: "run1"}, testLogger())
+	if err != nil || rsum.Restored != 2 {
+		t.Fatalf("expected a.jpg and b.jpg to be restored, got %+v, %v", rsum, err)
+	}
+	if _, err := os.Stat(filepath.Join(lib, "upload", "u1", "ab", "cd", "a.jpg")); err != nil {
+		t.Errorf("expected a.jpg to be restored into its pruned directory: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(lib, "library", "u1")); err != nil || !info.IsDir() {
+		t.Errorf("expected library/u1 to be created again, got %v", err)
+	}
+}
+
+func TestMoveOrphans_PruneEmptyDirsDryRun(t *testing.T) {
+	lib, dst := t.TempDir(), t.TempDir()
+	os.MkdirAll(filepath.Join(lib, "upload", "u1"), 0o755)
+	os.WriteFile(filepath.Join(lib, "upload", "u1", "a.jpg"), []byte("a"), 0o644)
+
+	sum, err := MoveOrphans(items("upload/u1/a.jpg"), lib, dst, Options{RunID: "run1", DryRun: true, PruneEmptyDirs: true}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Pruned != 0 {
+		t.Errorf("expected nothing pruned in a dry run, got %d", sum.Pruned)
+	}
+}
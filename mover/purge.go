@@ -0,0 +1,207 @@
+package mover
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/audit"
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/readonly"
+)
+
+// PurgeOptions controls Purge.
+type PurgeOptions struct {
+	// LibraryPath is the library root, needed to purge hardlinked strays,
+	// whose original is deleted along with the link.
+	LibraryPath string
+	// Mounts are the directories of the library kept outside LibraryPath.
+	Mounts []paths.Mount
+	// RunID limits the purge to files quarantined by one run. Empty means
+	// every run.
+	RunID string
+	// Before, if set, limits the purge to files quarantined before it,
+	// according to the manifests. A file that strays of a later run were
+	// deduplicated against counts as quarantined when the newest of them
+	// was, since it is their only copy.
+	Before time.Time
+	// DryRun only logs what would be deleted.
+	DryRun bool
+	// Audit, if set, records every file deleted.
+	Audit *audit.Log
+}
+
+// PurgeSummary counts what Purge did.
+type PurgeSummary struct {
+	Deleted int
+	Bytes   int64
+	// Kept counts quarantined files left in place because they are not
+	// older than PurgeOptions.Before.
+	Kept int
+	// Changed counts files left in place because their size no longer
+	// matches the manifest.
+	Changed int
+}
+
+// Purge deletes quarantined files recorded in the manifests of targetDir and
+// removes directories left empty. Files in targetDir that no manifest
+// mentions are never touched, and manifests are kept as a record. For a
+// hardlinked stray, the original in the library is deleted too, provided it
+// is still the same file. Strays uploaded to a remote target are deleted
+// from the remote.
+func Purge(targetDir string, opts PurgeOptions, logger *slog.Logger) (*PurgeSummary, error) {
+	runs := []string{opts.RunID}
+	if opts.RunID == "" {
+		var err error
+		if runs, err = ListRuns(targetDir); err != nil {
+			return nil, err
+		}
+	}
+
+	// Load every manifest before deleting anything, so a broken one stops
+	// the purge before it starts.
+	entries := make([][]ManifestEntry, len(runs))
+	for i, runID := range runs {
+		var err error
+		if entries[i], err = LoadRun(targetDir, runID); err != nil {
+			return nil, err
+		}
+		if opts.LibraryPath == "" && slices.ContainsFunc(entries[i], func(e ManifestEntry) bool { return e.Action == ActionLinked }) {
+			return nil, fmt.Errorf("run %s hardlinked strays; purging them needs the library path", runID)
+		}
+	}
+
+	// References can come from any run, not only the one being purged.
+	var refs map[string]time.Time
+	if !opts.Before.IsZero() {
+		all, err := LoadManifests(targetDir)
+		if err != nil {
+			return nil, err
+		}
+		refs = lastReferenced(all)
+	}
+
+	sum := &PurgeSummary{}
+	for i, runID := range runs {
+		for _, e := range entries[i] {
+			if e.Action != ActionMoved && e.Action != ActionCopied && e.Action != ActionLinked {
+				continue
+			}
+			quarantined := e.Time
+			if r, ok := refs[e.Dest]; ok && r.After(quarantined) {
+				quarantined = r
+			}
+			if !opts.Before.IsZero() && !quarantined.Before(opts.Before) {
+				// Remote files are not looked up just to be counted.
+				if _, err := os.Lstat(filepath.Join(targetDir, filepath.FromSlash(e.Dest))); err == nil || e.Remote != "" {
+					sum.Kept++
+				}
+				continue
+			}
+			if err := purgeOne(targetDir, runID, e, opts, sum, logger); err != nil {
+				return sum, err
+			}
+		}
+	}
+	return sum, nil
+}
+
+// lastReferenced returns, for each quarantined file that strays were
+// deduplicated against, when the newest of them was.
+func lastReferenced(entries []ManifestEntry) map[string]time.Time {
+	refs := make(map[string]time.Time)
+	for _, e := range entries {
+		if e.Action != ActionDeduplicated || e.DuplicateOf == "" {
+			continue
+		}
+		if e.Time.After(refs[e.DuplicateOf]) {
+			refs[e.DuplicateOf] = e.Time
+		}
+	}
+	return refs
+}
+
+// purgeOne deletes the quarantined copy of e, if it is still there.
+func purgeOne(targetDir, runID string, e ManifestEntry, opts PurgeOptions, sum *PurgeSummary, logger *slog.Logger) error {
+	if e.Remote != "" {
+		return purgeRemote(runID, e, opts, sum, logger)
+	}
+	path := filepath.Join(targetDir, filepath.FromSlash(e.Dest))
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil // already purged or restored
+	}
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", path, err)
+	}
+	if info.Size() != e.Size {
+		// Another file took the place of a restored or purged one.
+		logger.Warn("quarantined file differs from the one the manifest recorded, not deleting", "path", path)
+		sum.Changed++
+		return nil
+	}
+
+	var orig string
+	if e.Action == ActionLinked {
+		orig = paths.Join(opts.LibraryPath, opts.Mounts, e.Source)
+		if origInfo, err := os.Lstat(orig); err != nil || !os.SameFile(info, origInfo) {
+			orig = "" // already gone, or replaced since
+		}
+	}
+
+	if opts.DryRun {
+		logger.Info("[dry-run] would delete quarantined file", "path", path)
+		if orig != "" {
+			logger.Info("[dry-run] would delete linked original", "path", orig)
+		}
+	} else {
+		if err := readonly.Check("purge quarantine"); err != nil {
+			return err
+		}
+		// Delete the original first: if the purge stops in between, the
+		// link is left for the next one.
+		if orig != "" {
+			entry := audit.Entry{RunID: runID, Action: ActionPurged, Source: orig, Size: e.Size, SHA256: e.SHA256}
+			if err := os.Remove(orig); err != nil {
+				return fmt.Errorf("delete %s: %w", orig, err)
+			}
+			logger.Info("deleted linked original", "path", orig)
+			if err := opts.Audit.Record(entry); err != nil {
+				return err
+			}
+		}
+		entry := audit.Entry{RunID: runID, Action: ActionPurged, Source: path}
+		if opts.Audit != nil {
+			if entry.Size, entry.SHA256, err = fileDigest(path); err != nil {
+				return fmt.Errorf("hash %s: %w", path, err)
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("delete %s: %w", path, err)
+		}
+		logger.Info("deleted quarantined file", "path", path)
+		removeEmptyParents(filepath.Dir(path), targetDir)
+		if err := opts.Audit.Record(entry); err != nil {
+			return err
+		}
+	}
+	sum.Deleted++
+	sum.Bytes += info.Size()
+	return nil
+}
+
+// removeEmptyParents removes dir and its ancestors while they are empty,
+// stopping at (and never removing) root.
+func removeEmptyParents(dir, root string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && len(dir) > len(root); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return // not empty, or not removable
+		}
+	}
+}
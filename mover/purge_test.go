@@ -0,0 +1,143 @@
+package mover
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPurge_DeletesOnlyManifestFiles(t *testing.T) {
+	libDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	os.MkdirAll(filepath.Join(libDir, "upload", "2024"), 0o755)
+	os.WriteFile(filepath.Join(libDir, "upload", "2024", "a.jpg"), []byte("abc"), 0o644)
+	MoveOrphans(items("upload/2024/a.jpg"), libDir, dstDir, Options{RunID: "run-1"}, testLogger())
+
+	// Something the user put there by hand must survive.
+	os.WriteFile(filepath.Join(dstDir, "notes.txt"), []byte("keep"), 0o644)
+
+	sum, err := Purge(dstDir, PurgeOptions{}, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sum.Deleted != 1 || sum.Bytes != 3 {
+		t.Errorf("expected 1 file / 3 bytes deleted, got %+v", sum)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "upload")); !os.IsNotExist(err) {
+		t.Error("expected emptied directories to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "notes.txt")); err != nil {
+		t.Error("unrelated file was deleted")
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, ManifestDir, "run-1.jsonl")); err != nil {
+		t.Error("manifest should be kept as a record")
+	}
+}
+
+func TestPurge_DryRun(t *testing.T) {
+	libDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	os.WriteFile(filepath.Join(libDir, "a.jpg"), []byte("abc"), 0o644)
+	MoveOrphans(items("a.jpg"), libDir, dstDir, Options{RunID: "run-1"}, testLogger())
+
+	sum, err := Purge(dstDir, PurgeOptions{DryRun: true}, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sum.Deleted != 1 {
+		t.Errorf("expected 1 file reported, got %d", sum.Deleted)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "a.jpg")); err != nil {
+		t.Error("dry-run must not delete anything")
+	}
+}
+
+func TestPurge_Before(t *testing.T) {
+	libDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	os.WriteFile(filepath.Join(libDir, "old.jpg"), []byte("old"), 0o644)
+	os.WriteFile(filepath.Join(libDir, "new.jpg"), []byte("new"), 0o644)
+	MoveOrphans(items("old.jpg"), libDir, dstDir, Options{RunID: "run-1"}, testLogger())
+	cutoff := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	MoveOrphans(items("new.jpg"), libDir, dstDir, Options{RunID: "run-2"}, testLogger())
+
+	sum, err := Purge(dstDir, PurgeOptions{Before: cutoff}, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sum.Deleted != 1 || sum.Kept != 1 {
+		t.Errorf("expected 1 file deleted and 1 kept, got %+v", sum)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "new.jpg")); err != nil {
+		t.Error("a file quarantined after the cutoff was deleted")
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "old.jpg")); !os.IsNotExist(err) {
+		t.Error("expected the older file to be deleted")
+	}
+}
+
+func TestPurge_BeforeKeepsDeduplicatedAgainst(t *testing.T) {
+	libDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	os.WriteFile(filepath.Join(libDir, "old.jpg"), []byte("same"), 0o644)
+	MoveOrphans(items("old.jpg"), libDir, dstDir, Options{RunID: "run-1", Dedupe: true}, testLogger())
+	cutoff := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	// A later stray with the same content is deleted from the library,
+	// leaving old.jpg as its only copy.
+	os.WriteFile(filepath.Join(libDir, "new.jpg"), []byte("same"), 0o644)
+	MoveOrphans(items("new.jpg"), libDir, dstDir, Options{RunID: "run-2", Dedupe: true}, testLogger())
+	if _, err := os.Stat(filepath.Join(libDir, "new.jpg")); !os.IsNotExist(err) {
+		t.Fatal("expected the second stray to be deduplicated")
+	}
+
+	for _, runID := range []string{"", "run-1"} {
+		sum, err := Purge(dstDir, PurgeOptions{RunID: runID, Before: cutoff}, testLogger())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if sum.Deleted != 0 || sum.Kept != 1 {
+			t.Errorf("run %q: expected the referenced file kept, got %+v", runID, sum)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "old.jpg")); err != nil {
+		t.Error("a file a recent stray was deduplicated against was deleted")
+	}
+
+	// Once the reference is old enough too, the file goes.
+	sum, err := Purge(dstDir, PurgeOptions{Before: time.Now()}, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sum.Deleted != 1 {
+		t.Errorf("expected the file deleted after its last reference expired, got %+v", sum)
+	}
+}
+
+func TestPurge_LeavesReplacedFiles(t *testing.T) {
+	libDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	os.WriteFile(filepath.Join(libDir, "a.jpg"), []byte("a"), 0o644)
+	MoveOrphans(items("a.jpg"), libDir, dstDir, Options{RunID: "run-1"}, testLogger())
+	// a.jpg was taken out of the quarantine and a different file moved in
+	// under the same name.
+	os.WriteFile(filepath.Join(dstDir, "a.jpg"), []byte("another"), 0o644)
+
+	sum, err := Purge(dstDir, PurgeOptions{RunID: "run-1"}, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sum.Deleted != 0 || sum.Changed != 1 {
+		t.Errorf("expected the replaced file to be left, got %+v", sum)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "a.jpg")); err != nil {
+		t.Error("expected the replacing file to be kept")
+	}
+}
@@ -0,0 +1,393 @@
+package mover
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/audit"
+	"github.com/goeland86/immich-stray-finder/readonly"
+)
+
+// rcloneCommand is the rclone binary remote targets are reached with.
+var rcloneCommand = "rclone"
+
+// Remote is a quarantine outside the local filesystem: an S3 bucket or an
+// rclone remote. Files are transferred with the rclone command, which must
+// be installed and, for rclone remotes, configured.
+type Remote struct {
+	// spec is the target as given, e.g. "s3://bucket/strays".
+	spec string
+	// root is the rclone path of the quarantine's root.
+	root string
+}
+
+// ParseRemote parses a remote target: s3://bucket/prefix, or
+// rclone:remote:path for a remote configured in rclone. It returns nil for
+// anything else, which is a local directory.
+func ParseRemote(target string) (*Remote, error) {
+	switch {
+	case strings.HasPrefix(target, "s3://"):
+		bucket := strings.Trim(strings.TrimPrefix(target, "s3://"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("remote %q: missing bucket", target)
+		}
+		// An on-the-fly rclone backend, configured from the environment.
+		return &Remote{spec: target, root: ":s3:" + bucket}, nil
+	case strings.HasPrefix(target, "rclone:"):
+		root := strings.TrimSuffix(strings.TrimPrefix(target, "rclone:"), "/")
+		if !strings.Contains(root, ":") {
+			return nil, fmt.Errorf("remote %q: want rclone:REMOTE:PATH", target)
+		}
+		return &Remote{spec: target, root: root}, nil
+	}
+	return nil, nil
+}
+
+// String returns the target as it was given.
+func (r *Remote) String() string {
+	return r.spec
+}
+
+// remoteURL names dest on the remote target spec, for logs and the audit
+// trail.
+func remoteURL(spec, dest string) string {
+	return strings.TrimSuffix(spec, "/") + "/" + dest
+}
+
+// path returns the rclone path of dest, a path relative to the root.
+func (r *Remote) path(dest string) string {
+	if strings.HasSuffix(r.root, ":") {
+		return r.root + dest
+	}
+	return r.root + "/" + path.Clean(dest)
+}
+
+// errRemoteNotFound is returned by stat for a missing object.
+var errRemoteNotFound = errors.New("not found on remote")
+
+// remoteObject is what rclone lsjson reports about a file.
+type remoteObject struct {
+	Size   int64             `json:"Size"`
+	IsDir  bool              `json:"IsDir"`
+	Hashes map[string]string `json:"Hashes"`
+}
+
+// stat returns the size and MD5, if the remote knows it, of dest.
+func (r *Remote) stat(dest string) (*remoteObject, error) {
+	out, err := r.rclone("lsjson", "--stat", "--hash", "--hash-type", "MD5", r.path(dest))
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && (exitErr.ExitCode() == 3 || exitErr.ExitCode() == 4) {
+		// rclone's exit codes for a missing directory or file.
+		return nil, errRemoteNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var obj remoteObject
+	if err := json.Unmarshal(out, &obj); err != nil {
+		return nil, fmt.Errorf("parse rclone lsjson output: %w", err)
+	}
+	if obj.IsDir {
+		return nil, fmt.Errorf("%s is a directory", r.path(dest))
+	}
+	return &obj, nil
+}
+
+// exists reports whether dest exists on the remote.
+func (r *Remote) exists(dest string) (bool, error) {
+	_, err := r.stat(dest)
+	if errors.Is(err, errRemoteNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// upload copies src to dest, at most at bwlimit bytes per second unless
+// that is 0, and checks that the remote holds the same size and, where the
+// remote computes one, the same MD5 as src.
+func (r *Remote) upload(src, dest string, bwlimit float64) error {
+	args := []string{"copyto", src, r.path(dest)}
+	if bwlimit > 0 {
+		args = append(args, "--bwlimit", strconv.FormatInt(max(int64(bwlimit), 1), 10)+"B")
+	}
+	if _, err := r.rclone(args...); err != nil {
+		return err
+	}
+	return r.verify(src, dest)
+}
+
+// verify checks that dest on the remote matches the local file src.
+func (r *Remote) verify(src, dest string) error {
+	obj, err := r.stat(dest)
+	if err != nil {
+		return fmt.Errorf("verify upload: %w", err)
+	}
+	size, sum, err := fileMD5(src)
+	if err != nil {
+		return fmt.Errorf("verify upload: %w", err)
+	}
+	if obj.Size != size {
+		return fmt.Errorf("verify upload: %s has %d bytes, want %d", r.path(dest), obj.Size, size)
+	}
+	if remote := obj.Hashes["md5"]; remote != "" && remote != sum {
+		return fmt.Errorf("verify upload: %s has MD5 %s, want %s", r.path(dest), remote, sum)
+	}
+	return nil
+}
+
+// download copies dest from the remote to dst.
+func (r *Remote) download(dest, dst string) error {
+	_, err := r.rclone("copyto", r.path(dest), dst)
+	return err
+}
+
+// remove deletes dest from the remote.
+func (r *Remote) remove(dest string) error {
+	_, err := r.rclone("deletefile", r.path(dest))
+	return err
+}
+
+// rclone runs rclone with args and returns its standard output. Its error
+// output is included in the error.
+func (r *Remote) rclone(args ...string) ([]byte, error) {
+	cmd := exec.Command(rcloneCommand, args...)
+	cmd.Env = os.Environ()
+	if os.Getenv("RCLONE_S3_ENV_AUTH") == "" {
+		// Let s3:// targets use the usual AWS_* variables.
+		cmd.Env = append(cmd.Env, "RCLONE_S3_ENV_AUTH=true")
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return out, fmt.Errorf("rclone %s: %w: %s", args[0], err, msg)
+		}
+		return out, fmt.Errorf("rclone %s: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// fileMD5 returns the size and MD5 of path, the checksum most remotes
+// report.
+func fileMD5(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := md5.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// uploadOne moves, or with opts.Copy copies, src to entry.Dest on
+// opts.Remote. The source is only removed once the upload is verified.
+func uploadOne(entry ManifestEntry, src string, opts Options, logger *slog.Logger) (ManifestEntry, error) {
+	r := opts.Remote
+	entry.Remote = r.String()
+	verb := "upload"
+	if opts.Copy {
+		entry.Action, verb = ActionCopied, "copy"
+	}
+	dst := r.path(entry.Dest)
+	if opts.DryRun {
+		logger.Info("[dry-run] would "+verb, "src", src, "dst", dst)
+		return entry, nil
+	}
+
+	found, err := r.exists(entry.Dest)
+	if err != nil {
+		return entry, fmt.Errorf("%s %s -> %s: %w", verb, src, dst, err)
+	}
+	// replacing is set when the upload overwrites an object this call did
+	// not create, which a failed upload must leave alone.
+	replacing := false
+	if found {
+		// rclone replaces the destination when overwriting.
+		taken := entry.Dest
+		if entry, err = resolveConflict(entry, src, dst, r.exists, opts, logger); err != nil || entry.Action == ActionSkipped {
+			return entry, err
+		}
+		dst = r.path(entry.Dest)
+		replacing = entry.Dest == taken
+	}
+	if err := opts.journal.intend(entry); err != nil {
+		return entry, err
+	}
+	if err := readonly.Check("upload file"); err != nil {
+		return entry, err
+	}
+	if err := r.upload(src, entry.Dest, opts.IOLimit.Rate()); err != nil {
+		// Don't leave a bad copy for a resumed run to trip over.
+		if !replacing {
+			r.remove(entry.Dest)
+		}
+		logger.Error("failed to upload file", "src", src, "dst", dst, "error", err)
+		return entry, fmt.Errorf("%s %s -> %s: %w", verb, src, dst, err)
+	}
+	if !opts.Copy {
+		if err := os.Remove(src); err != nil {
+			return entry, fmt.Errorf("remove %s: %w", src, err)
+		}
+	}
+	entry.Time = time.Now().UTC()
+	logger.Info("uploaded file", "src", src, "dst", dst, "removed_source", !opts.Copy)
+	return entry, nil
+}
+
+// settleRemote works out whether the in-flight upload e was carried out,
+// and completes a move whose upload finished.
+func settleRemote(e ManifestEntry, src string, logger *slog.Logger) (int, error) {
+	r, err := ParseRemote(e.Remote)
+	if err != nil || r == nil {
+		return 0, fmt.Errorf("journal entry for %s: invalid remote %q", e.Source, e.Remote)
+	}
+	srcExists, err := exists(src)
+	if err != nil {
+		return 0, err
+	}
+	found, err := r.exists(e.Dest)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case srcExists && found:
+		if e.Action == ActionCopied {
+			return settledDone, nil
+		}
+		if err := r.verify(src, e.Dest); err != nil {
+			return 0, fmt.Errorf("cannot resume run: %s and %s both exist and differ: %w", src, r.path(e.Dest), err)
+		}
+		if err := readonly.Check("finish move"); err != nil {
+			return 0, err
+		}
+		if err := os.Remove(src); err != nil {
+			return 0, fmt.Errorf("remove %s: %w", src, err)
+		}
+		logger.Info("finished interrupted upload", "src", src, "dst", r.path(e.Dest))
+		return settledDone, nil
+	case srcExists:
+		return settledPending, nil
+	case found:
+		logger.Info("recorded interrupted upload", "src", src, "dst", r.path(e.Dest))
+		return settledDone, nil
+	default:
+		logger.Warn("stray of interrupted upload is gone from both the library and the remote", "src", src, "dst", r.path(e.Dest))
+		return settledVanished, nil
+	}
+}
+
+// restoreRemote downloads the uploaded entry e back to dst and removes it
+// from the remote. It reports false, without an error, when the remote
+// copy is gone or no longer matches its recorded checksum.
+func restoreRemote(e ManifestEntry, dst string, dryRun bool, logger *slog.Logger) (bool, error) {
+	r, err := ParseRemote(e.Remote)
+	if err != nil || r == nil {
+		return false, fmt.Errorf("manifest entry for %s: invalid remote %q", e.Source, e.Remote)
+	}
+	src := r.path(e.Dest)
+	obj, err := r.stat(e.Dest)
+	if errors.Is(err, errRemoteNotFound) {
+		logger.Warn("copy to restore from is gone, cannot restore", "path", src)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if obj.Size != e.Size {
+		logger.Warn("copy to restore from differs from the file the manifest recorded, not restoring", "path", src)
+		return false, nil
+	}
+	if dryRun {
+		logger.Info("[dry-run] would restore", "src", src, "dst", dst)
+		return true, nil
+	}
+
+	if err := readonly.Check("restore file"); err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return false, fmt.Errorf("create directory %s: %w", filepath.Dir(dst), err)
+	}
+	tmp := partialPath(dst)
+	if err := r.download(e.Dest, tmp); err != nil {
+		os.Remove(tmp)
+		return false, fmt.Errorf("restore %s -> %s: %w", src, dst, err)
+	}
+	if e.SHA256 != "" {
+		if _, sum, err := fileDigest(tmp); err != nil || sum != e.SHA256 {
+			os.Remove(tmp)
+			logger.Warn("copy to restore from differs from the file the manifest recorded, not restoring", "path", src)
+			return false, nil
+		}
+	}
+	if !e.ModTime.IsZero() {
+		os.Chtimes(tmp, time.Time{}, e.ModTime)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return false, fmt.Errorf("restore %s -> %s: %w", src, dst, err)
+	}
+	logger.Info("restored file", "src", src, "dst", dst)
+	if err := r.remove(e.Dest); err != nil {
+		// The copy left behind is deleted by the next purge.
+		logger.Warn("could not remove restored file from the remote", "path", src, "error", err)
+	}
+	return true, nil
+}
+
+// purgeRemote deletes the uploaded entry e from the remote, if it is still
+// there, as purgeOne does for local files.
+func purgeRemote(runID string, e ManifestEntry, opts PurgeOptions, sum *PurgeSummary, logger *slog.Logger) error {
+	r, err := ParseRemote(e.Remote)
+	if err != nil || r == nil {
+		return fmt.Errorf("manifest entry for %s: invalid remote %q", e.Source, e.Remote)
+	}
+	path := r.path(e.Dest)
+	obj, err := r.stat(e.Dest)
+	if errors.Is(err, errRemoteNotFound) {
+		return nil // already purged or restored
+	}
+	if err != nil {
+		return err
+	}
+	if obj.Size != e.Size {
+		logger.Warn("quarantined file differs from the one the manifest recorded, not deleting", "path", path)
+		sum.Changed++
+		return nil
+	}
+	if opts.DryRun {
+		logger.Info("[dry-run] would delete quarantined file", "path", path)
+	} else {
+		if err := readonly.Check("purge quarantine"); err != nil {
+			return err
+		}
+		if err := r.remove(e.Dest); err != nil {
+			return fmt.Errorf("delete %s: %w", path, err)
+		}
+		logger.Info("deleted quarantined file", "path", path)
+		entry := audit.Entry{RunID: runID, Action: ActionPurged, Source: remoteURL(e.Remote, e.Dest), Size: e.Size, SHA256: e.SHA256}
+		if err := opts.Audit.Record(entry); err != nil {
+			return err
+		}
+	}
+	sum.Deleted++
+	sum.Bytes += obj.Size
+	return nil
+}
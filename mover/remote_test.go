@@ -0,0 +1,149 @@
+package mover
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRemote(t *testing.T) {
+	tests := []struct {
+		target, root string
+		wantErr      bool
+	}{
+		{target: "./immich-orphans"},
+		{target: "/srv/quarantine"},
+		{target: "s3://bucket/strays/", root: ":s3:bucket/strays"},
+		{target: "s3://bucket", root: ":s3:bucket"},
+		{target: "s3://", wantErr: true},
+		{target: "rclone:b2:photos/strays", root: "b2:photos/strays"},
+		{target: "rclone:b2:", root: "b2:"},
+		{target: "rclone:b2", wantErr: true},
+	}
+	for _, tt := range tests {
+		r, err := ParseRemote(tt.target)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRemote(%q) error = %v, want error %v", tt.target, err, tt.wantErr)
+			continue
+		}
+		switch {
+		case tt.root == "" && r != nil:
+			t.Errorf("ParseRemote(%q) = %+v, want a local target", tt.target, r)
+		case tt.root != "" && (r == nil || r.root != tt.root):
+			t.Errorf("ParseRemote(%q) = %+v, want root %q", tt.target, r, tt.root)
+		}
+	}
+}
+
+// fakeRclone installs a stand-in for rclone that serves the remote "fake:"
+// from the local filesystem. With FAKE_RCLONE_CORRUPT set, uploads are
+// truncated; with FAKE_RCLONE_FAIL set, they fail without writing.
+func fakeRclone(t *testing.T) {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), "rclone")
+	os.WriteFile(script, []byte(`#!/bin/sh
+p() { echo "${1#fake:}"; }
+case "$1" in
+copyto)
+	[ -z "$FAKE_RCLONE_FAIL" ] || exit 1
+	dst=$(p "$3")
+	mkdir -p "$(dirname "$dst")" || exit 1
+	if [ -n "$FAKE_RCLONE_CORRUPT" ]; then head -c 1 "$(p "$2")" >"$dst"; else cp "$(p "$2")" "$dst"; fi ;;
+lsjson)
+	f=$(p "$6")
+	[ -f "$f" ] || exit 3
+	printf '{"Size":%d,"IsDir":false,"Hashes":{"md5":"%s"}}' "$(stat -c %s "$f")" "$(md5sum <"$f" | cut -d' ' -f1)" ;;
+deletefile)
+	rm "$(p "$2")" ;;
+*)
+	exit 1 ;;
+esac
+`), 0o755)
+	old := rcloneCommand
+	rcloneCommand = script
+	t.Cleanup(func() { rcloneCommand = old })
+}
+
+func TestMoveOrphans_Remote(t *testing.T) {
+	fakeRclone(t)
+	lib, manifests, bucket := t.TempDir(), t.TempDir(), t.TempDir()
+	remote, err := ParseRemote("rclone:fake:" + bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(lib, "upload", "u1"), 0o755)
+	os.WriteFile(filepath.Join(lib, "upload", "u1", "a.jpg"), []byte("photo a"), 0o644)
+	os.WriteFile(filepath.Join(lib, "b.jpg"), []byte("photo b"), 0o644)
+
+	sum, err := MoveOrphans(items("upload/u1/a.jpg", "b.jpg"), lib, manifests, Options{RunID: "run1", Remote: remote}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Moved != 2 {
+		t.Errorf("expected 2 files moved, got %+v", sum)
+	}
+	if _, err := os.Stat(filepath.Join(lib, "b.jpg")); !os.IsNotExist(err) {
+		t.Error("expected the stray to be removed from the library")
+	}
+	if data, err := os.ReadFile(filepath.Join(bucket, "upload", "u1", "a.jpg")); err != nil || string(data) != "photo a" {
+		t.Errorf("expected the stray on the remote, got %q, %v", data, err)
+	}
+	entries, err := LoadRun(manifests, "run1")
+	if err != nil || len(entries) != 2 || entries[0].Remote != remote.String() {
+		t.Fatalf("expected the manifest to record the remote, got %+v, %v", entries, err)
+	}
+
+	rsum, err := Restore(lib, manifests, RestoreOptions{RunID: "run1", Glob: "a.jpg"}, testLogger())
+	if err != nil || rsum.Restored != 1 {
+		t.Fatalf("expected a.jpg to be restored, got %+v, %v", rsum, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(lib, "upload", "u1", "a.jpg")); err != nil || string(data) != "photo a" {
+		t.Errorf("unexpected restored content %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(bucket, "upload", "u1", "a.jpg")); !os.IsNotExist(err) {
+		t.Error("expected the restored file to be removed from the remote")
+	}
+
+	psum, err := Purge(manifests, PurgeOptions{}, testLogger())
+	if err != nil || psum.Deleted != 1 || psum.Bytes != 7 {
+		t.Fatalf("expected b.jpg to be purged, got %+v, %v", psum, err)
+	}
+	if _, err := os.Stat(filepath.Join(bucket, "b.jpg")); !os.IsNotExist(err) {
+		t.Error("expected the purged file to be removed from the remote")
+	}
+}
+
+func TestMoveOrphans_RemoteKeepsStrayOnFailedVerification(t *testing.T) {
+	fakeRclone(t)
+	t.Setenv("FAKE_RCLONE_CORRUPT", "1")
+	lib, manifests, bucket := t.TempDir(), t.TempDir(), t.TempDir()
+	remote, _ := ParseRemote("rclone:fake:" + bucket)
+	os.WriteFile(filepath.Join(lib, "a.jpg"), []byte("photo a"), 0o644)
+
+	if _, err := MoveOrphans(items("a.jpg"), lib, manifests, Options{RunID: "run1", Remote: remote}, testLogger()); err == nil {
+		t.Fatal("expected a truncated upload to fail verification")
+	}
+	if _, err := os.Stat(filepath.Join(lib, "a.jpg")); err != nil {
+		t.Error("expected the stray to stay in the library")
+	}
+}
+
+func TestMoveOrphans_RemoteKeepsOverwrittenObjectOnFailedUpload(t *testing.T) {
+	fakeRclone(t)
+	t.Setenv("FAKE_RCLONE_FAIL", "1")
+	lib, manifests, bucket := t.TempDir(), t.TempDir(), t.TempDir()
+	remote, _ := ParseRemote("rclone:fake:" + bucket)
+	os.WriteFile(filepath.Join(lib, "a.jpg"), []byte("photo a"), 0o644)
+	os.WriteFile(filepath.Join(bucket, "a.jpg"), []byte("earlier"), 0o644)
+
+	opts := Options{RunID: "run1", Remote: remote, OnConflict: ConflictOverwrite}
+	if _, err := MoveOrphans(items("a.jpg"), lib, manifests, opts, testLogger()); err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+	if data, err := os.ReadFile(filepath.Join(bucket, "a.jpg")); err != nil || string(data) != "earlier" {
+		t.Errorf("expected the earlier object to stay on the remote, got %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(lib, "a.jpg")); err != nil {
+		t.Error("expected the stray to stay in the library")
+	}
+}
@@ -0,0 +1,276 @@
+package mover
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/audit"
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/readonly"
+)
+
+// RestoreOptions controls Restore.
+type RestoreOptions struct {
+	// RunID selects the run to undo. Empty means the most recent run.
+	RunID string
+	// Glob, if set, limits the restore to files whose library path matches
+	// it, as paths.Match does.
+	Glob string
+	// DryRun only logs what would be restored.
+	DryRun bool
+	// Audit, if set, records every file restored.
+	Audit *audit.Log
+	// Mounts are the directories of the library kept outside libraryPath.
+	Mounts []paths.Mount
+}
+
+// RestoreSummary counts what Restore did.
+type RestoreSummary struct {
+	RunID    string
+	Restored int
+	// Skipped lists library paths that could not be restored because the
+	// original location is occupied again, the quarantined copy is gone or
+	// no longer matches its recorded checksum, or the file was deleted
+	// outright.
+	Skipped []string
+}
+
+// Restore undoes a previous run using its manifest: moved files go back to
+// their original location, and deduplicated files are copied back from the
+// quarantined copy or Immich original they matched. Files are never
+// overwritten, and a copy whose checksum differs from the one recorded is
+// left alone. Relinked files are moved back from the asset's original,
+// archived files are extracted from their archive, and uploaded files are
+// downloaded from their remote. Restored files get back their recorded
+// modification time, and directories the run pruned are created again.
+func Restore(libraryPath, targetDir string, opts RestoreOptions, logger *slog.Logger) (*RestoreSummary, error) {
+	if opts.Glob != "" && !paths.ValidPattern(opts.Glob) {
+		return nil, fmt.Errorf("invalid glob %q", opts.Glob)
+	}
+	runID := opts.RunID
+	if runID == "" {
+		runs, err := ListRuns(targetDir)
+		if err != nil {
+			return nil, err
+		}
+		if len(runs) == 0 {
+			return nil, fmt.Errorf("no move manifests found in %s", targetDir)
+		}
+		runID = runs[len(runs)-1]
+	}
+
+	entries, err := LoadRun(targetDir, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := &RestoreSummary{RunID: runID}
+	// Archived strays are extracted afterwards, reading each archive once.
+	archived := make(map[string][]ManifestEntry)
+	// Undo in reverse order so a deduplicated entry is restored before the
+	// quarantined copy it points to could be moved away.
+	for _, e := range slices.Backward(entries) {
+		if opts.Glob != "" && !paths.Match(opts.Glob, e.Source) {
+			continue
+		}
+		dst := paths.Join(libraryPath, opts.Mounts, e.Source)
+
+		if e.Remote != "" && e.Action == ActionMoved {
+			if _, err := os.Lstat(dst); err == nil {
+				logger.Warn("original location is occupied, not restoring", "path", dst)
+				sum.Skipped = append(sum.Skipped, e.Source)
+				continue
+			}
+			ok, err := restoreRemote(e, dst, opts.DryRun, logger)
+			if err != nil {
+				return sum, err
+			}
+			if !ok {
+				sum.Skipped = append(sum.Skipped, e.Source)
+				continue
+			}
+			sum.Restored++
+			if !opts.DryRun {
+				if err := opts.Audit.Record(audit.Entry{RunID: runID, Action: ActionRestored, Source: remoteURL(e.Remote, e.Dest), Dest: dst, Size: e.Size, SHA256: e.SHA256}); err != nil {
+					return sum, err
+				}
+			}
+			continue
+		}
+
+		var src string
+		switch e.Action {
+		case ActionMoved, ActionLinked:
+			src = filepath.Join(targetDir, filepath.FromSlash(e.Dest))
+		case ActionDeduplicated:
+			src = filepath.Join(targetDir, filepath.FromSlash(e.DuplicateOf))
+		case ActionImmichDuplicate:
+			src = paths.Join(libraryPath, opts.Mounts, e.DuplicateOf)
+		case ActionRelinked:
+			src = paths.Join(libraryPath, opts.Mounts, e.Dest)
+		case ActionDeleted:
+			logger.Warn("file was deleted outright, cannot restore", "path", dst)
+			sum.Skipped = append(sum.Skipped, e.Source)
+			continue
+		case ActionCopied:
+			logger.Debug("file was copied and never left the library", "path", dst)
+			continue
+		case ActionArchived:
+			archived[e.Archive] = append(archived[e.Archive], e)
+			continue
+		case ActionPruned:
+			if err := restoreDir(dst, opts.DryRun, logger); err != nil {
+				return sum, err
+			}
+			continue
+		default:
+			continue
+		}
+
+		if e.Action == ActionLinked {
+			unlinked, err := unlinkQuarantined(src, dst, opts.DryRun, logger)
+			if err != nil {
+				return sum, err
+			}
+			if unlinked {
+				sum.Restored++
+				if err := opts.Audit.Record(audit.Entry{RunID: runID, Action: ActionRestored, Source: src, Dest: dst, Size: e.Size, SHA256: e.SHA256}); err != nil {
+					return sum, err
+				}
+				continue
+			}
+		}
+
+		if _, err := os.Lstat(dst); err == nil {
+			logger.Warn("original location is occupied, not restoring", "path", dst)
+			sum.Skipped = append(sum.Skipped, e.Source)
+			continue
+		}
+		if _, err := os.Lstat(src); errors.Is(err, fs.ErrNotExist) {
+			logger.Warn("copy to restore from is gone, cannot restore", "path", src)
+			sum.Skipped = append(sum.Skipped, e.Source)
+			continue
+		}
+
+		entry := audit.Entry{RunID: runID, Action: ActionRestored, Source: src, Dest: dst}
+		if e.SHA256 != "" || opts.Audit != nil {
+			if entry.Size, entry.SHA256, err = fileDigest(src); err != nil {
+				return sum, fmt.Errorf("hash %s: %w", src, err)
+			}
+			if e.SHA256 != "" && (entry.SHA256 != e.SHA256 || entry.Size != e.Size) {
+				logger.Warn("copy to restore from differs from the file the manifest recorded, not restoring", "path", src)
+				sum.Skipped = append(sum.Skipped, e.Source)
+				continue
+			}
+		}
+
+		if opts.DryRun {
+			logger.Info("[dry-run] would restore", "src", src, "dst", dst)
+			sum.Restored++
+			continue
+		}
+
+		if e.Action != ActionMoved && e.Action != ActionLinked && e.Action != ActionRelinked {
+			err = restoreCopy(src, dst)
+		} else {
+			err = moveFile(src, dst, false, nil, logger)
+		}
+		if err != nil {
+			return sum, fmt.Errorf("restore %s -> %s: %w", src, dst, err)
+		}
+		if !e.ModTime.IsZero() {
+			if err := os.Chtimes(dst, time.Time{}, e.ModTime); err != nil {
+				logger.Warn("could not restore modification time", "path", dst, "error", err)
+			}
+		}
+		logger.Info("restored file", "src", src, "dst", dst)
+		sum.Restored++
+		if err := opts.Audit.Record(entry); err != nil {
+			return sum, err
+		}
+	}
+
+	for _, archive := range slices.Sorted(maps.Keys(archived)) {
+		restored, skipped, err := extractArchived(archived[archive], libraryPath, opts.Mounts, opts.DryRun, logger)
+		sum.Restored += len(restored)
+		sum.Skipped = append(sum.Skipped, skipped...)
+		if err != nil {
+			return sum, err
+		}
+		if opts.DryRun {
+			continue
+		}
+		for _, e := range restored {
+			entry := audit.Entry{RunID: runID, Action: ActionRestored, Source: filepath.Join(archive, filepath.FromSlash(e.Dest)), Dest: paths.Join(libraryPath, opts.Mounts, e.Source), Size: e.Size, SHA256: e.SHA256}
+			if err := opts.Audit.Record(entry); err != nil {
+				return sum, err
+			}
+		}
+	}
+	return sum, nil
+}
+
+// unlinkQuarantined undoes a hardlink by removing the quarantined link
+// when the original is still the same file. It reports whether it did so;
+// otherwise the link is restored like a moved file.
+func unlinkQuarantined(link, orig string, dryRun bool, logger *slog.Logger) (bool, error) {
+	linkInfo, err := os.Lstat(link)
+	if err != nil {
+		return false, nil
+	}
+	origInfo, err := os.Lstat(orig)
+	if err != nil || !os.SameFile(linkInfo, origInfo) {
+		return false, nil
+	}
+	if dryRun {
+		logger.Info("[dry-run] would remove quarantined link to original", "path", link)
+		return true, nil
+	}
+	if err := readonly.Check("remove link"); err != nil {
+		return false, err
+	}
+	if err := os.Remove(link); err != nil {
+		return false, fmt.Errorf("remove %s: %w", link, err)
+	}
+	logger.Info("removed quarantined link to original", "path", link, "original", orig)
+	return true, nil
+}
+
+// restoreCopy recreates a deleted duplicate from its twin, renaming the
+// copy into place once complete.
+func restoreCopy(src, dst string) error {
+	if err := readonly.Check("restore file"); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("create directory %s: %w", filepath.Dir(dst), err)
+	}
+	return renameCopy(src, dst, false, nil)
+}
+
+// restoreDir creates again a directory the run pruned, unless a restored
+// file already did.
+func restoreDir(dir string, dryRun bool, logger *slog.Logger) error {
+	if _, err := os.Lstat(dir); err == nil {
+		return nil
+	}
+	if dryRun {
+		logger.Info("[dry-run] would create pruned directory", "path", dir)
+		return nil
+	}
+	if err := readonly.Check("restore directory"); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create directory %s: %w", dir, err)
+	}
+	logger.Debug("created pruned directory", "path", dir)
+	return nil
+}
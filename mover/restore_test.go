@@ -0,0 +1,144 @@
+package mover
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRestore_UndoesLatestRun(t *testing.T) {
+	libDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	os.MkdirAll(filepath.Join(libDir, "upload", "2024"), 0o755)
+	os.WriteFile(filepath.Join(libDir, "upload", "2024", "a.jpg"), []byte("a"), 0o644)
+	MoveOrphans(items("upload/2024/a.jpg"), libDir, dstDir, Options{RunID: "20240101T000000Z"}, testLogger())
+
+	os.WriteFile(filepath.Join(libDir, "b.jpg"), []byte("b"), 0o644)
+	MoveOrphans(items("b.jpg"), libDir, dstDir, Options{RunID: "20240201T000000Z"}, testLogger())
+
+	sum, err := Restore(libDir, dstDir, RestoreOptions{}, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sum.RunID != "20240201T000000Z" || sum.Restored != 1 {
+		t.Errorf("expected latest run with 1 file restored, got %+v", sum)
+	}
+	if _, err := os.Stat(filepath.Join(libDir, "b.jpg")); err != nil {
+		t.Error("expected b.jpg back in the library")
+	}
+	if _, err := os.Stat(filepath.Join(libDir, "upload", "2024", "a.jpg")); !os.IsNotExist(err) {
+		t.Error("earlier run should not have been restored")
+	}
+}
+
+func TestRestore_DoesNotOverwrite(t *testing.T) {
+	libDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	os.WriteFile(filepath.Join(libDir, "a.jpg"), []byte("old"), 0o644)
+	MoveOrphans(items("a.jpg"), libDir, dstDir, Options{RunID: "run-1"}, testLogger())
+	os.WriteFile(filepath.Join(libDir, "a.jpg"), []byte("new"), 0o644)
+
+	sum, err := Restore(libDir, dstDir, RestoreOptions{RunID: "run-1"}, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sum.Restored != 0 || len(sum.Skipped) != 1 {
+		t.Errorf("expected the occupied path to be skipped, got %+v", sum)
+	}
+	data, _ := os.ReadFile(filepath.Join(libDir, "a.jpg"))
+	if string(data) != "new" {
+		t.Errorf("library file was overwritten: %q", data)
+	}
+}
+
+func TestRestore_RecreatesDeduplicatedFiles(t *testing.T) {
+	libDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	os.WriteFile(filepath.Join(libDir, "a.jpg"), []byte("same"), 0o644)
+	MoveOrphans(items("a.jpg"), libDir, dstDir, Options{Dedupe: true, RunID: "run-1"}, testLogger())
+	os.WriteFile(filepath.Join(libDir, "b.jpg"), []byte("same"), 0o644)
+	MoveOrphans(items("b.jpg"), libDir, dstDir, Options{Dedupe: true, RunID: "run-2"}, testLogger())
+
+	if _, err := Restore(libDir, dstDir, RestoreOptions{RunID: "run-2"}, testLogger()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(libDir, "b.jpg"))
+	if err != nil || string(data) != "same" {
+		t.Errorf("expected b.jpg to be recreated from the quarantined twin, got %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "a.jpg")); err != nil {
+		t.Error("the quarantined twin must stay in place")
+	}
+}
+
+func TestRestore_RejectsBadRunID(t *testing.T) {
+	if _, err := Restore(t.TempDir(), t.TempDir(), RestoreOptions{RunID: "../evil"}, testLogger()); err == nil {
+		t.Error("expected error for run ID with path separators")
+	}
+}
+
+func TestRestore_Glob(t *testing.T) {
+	libDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	os.MkdirAll(filepath.Join(libDir, "upload", "u1"), 0o755)
+	for _, name := range []string{"upload/u1/a.jpg", "upload/u1/b.mp4", "c.jpg"} {
+		os.WriteFile(filepath.Join(libDir, filepath.FromSlash(name)), []byte(name), 0o644)
+	}
+	MoveOrphans(items("upload/u1/a.jpg", "upload/u1/b.mp4", "c.jpg"), libDir, dstDir, Options{RunID: "run-1"}, testLogger())
+
+	sum, err := Restore(libDir, dstDir, RestoreOptions{Glob: "upload/u1/*.jpg"}, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sum.Restored != 1 || len(sum.Skipped) != 0 {
+		t.Errorf("expected only a.jpg to be restored, got %+v", sum)
+	}
+	if _, err := os.Stat(filepath.Join(libDir, "upload", "u1", "a.jpg")); err != nil {
+		t.Error("expected a.jpg back in the library")
+	}
+	for _, name := range []string{"upload/u1/b.mp4", "c.jpg"} {
+		if _, err := os.Stat(filepath.Join(dstDir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("expected %s to stay quarantined", name)
+		}
+	}
+
+	if _, err := Restore(libDir, dstDir, RestoreOptions{Glob: "["}, testLogger()); err == nil {
+		t.Error("expected an error for a malformed glob")
+	}
+}
+
+func TestRestore_ChecksChecksumAndModTime(t *testing.T) {
+	libDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	mtime := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		p := filepath.Join(libDir, name)
+		os.WriteFile(p, []byte(name), 0o644)
+		os.Chtimes(p, mtime, mtime)
+	}
+	MoveOrphans(items("a.jpg", "b.jpg"), libDir, dstDir, Options{RunID: "run-1"}, testLogger())
+	entries, _ := LoadRun(dstDir, "run-1")
+	if len(entries) != 2 || entries[0].SHA256 == "" || !entries[0].ModTime.Equal(mtime) {
+		t.Fatalf("expected checksum and mtime in the manifest, got %+v", entries)
+	}
+	// Same size, different content.
+	os.WriteFile(filepath.Join(dstDir, "b.jpg"), []byte("B.jpg"), 0o644)
+
+	sum, err := Restore(libDir, dstDir, RestoreOptions{}, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sum.Restored != 1 || len(sum.Skipped) != 1 || sum.Skipped[0] != "b.jpg" {
+		t.Errorf("expected a.jpg restored and b.jpg skipped, got %+v", sum)
+	}
+	info, err := os.Stat(filepath.Join(libDir, "a.jpg"))
+	if err != nil || !info.ModTime().Equal(mtime) {
+		t.Errorf("expected a.jpg back with its modification time, got %v, %v", info, err)
+	}
+}
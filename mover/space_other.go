@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd)
+
+package mover
+
+// FreeSpace is not available on this platform.
+func FreeSpace(path string) (free int64, dev uint64, ok bool) {
+	return 0, 0, false
+}
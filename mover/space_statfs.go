@@ -0,0 +1,38 @@
+//go:build linux || darwin || freebsd
+
+package mover
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// FreeSpace returns the bytes available to this user on the filesystem
+// that holds path, or will hold it once created, and the ID of its device.
+func FreeSpace(path string) (free int64, dev uint64, ok bool) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return 0, 0, false
+	}
+	// Walk up to the nearest directory that exists.
+	info, err := os.Stat(path)
+	for errors.Is(err, fs.ErrNotExist) && filepath.Dir(path) != path {
+		path = filepath.Dir(path)
+		info, err = os.Stat(path)
+	}
+	if err != nil {
+		return 0, 0, false
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, false
+	}
+	sys, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int64(st.Bavail) * int64(st.Bsize), uint64(sys.Dev), true
+}
@@ -0,0 +1,23 @@
+package mover
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	free, dev, ok := FreeSpace(dir)
+	if !ok {
+		t.Skip("free space not available on this platform")
+	}
+	if free <= 0 {
+		t.Errorf("FreeSpace(%q) = %d, want a positive number", dir, free)
+	}
+	// A target that does not exist yet is created on its parent's
+	// filesystem.
+	_, newDev, ok := FreeSpace(filepath.Join(dir, "not", "yet"))
+	if !ok || newDev != dev {
+		t.Errorf("FreeSpace of a missing directory = %d, %v, want device %d", newDev, ok, dev)
+	}
+}
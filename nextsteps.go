@@ -0,0 +1,243 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/goeland86/immich-stray-finder/matcher"
+	"github.com/goeland86/immich-stray-finder/mover"
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/redact"
+	"github.com/goeland86/immich-stray-finder/report"
+)
+
+// notRerun are flags that do not carry over from a scan to the suggested
+// follow-up commands.
+var notRerun = map[string]bool{
+	"categories": true, "fail-on-untracked": true, "sample": true, "sample-seed": true,
+	"move": true, "yes": true, "output": true, "porcelain": true, "notify": true,
+}
+
+// secretFlags are shown as placeholders in suggested commands.
+var secretFlags = map[string]string{
+	"api-key":     "<api-key>",
+	"webhook-url": "<webhook-url>",
+}
+
+// rerunArgs returns the flags set on fs, for suggested command lines, with
+// secrets replaced by placeholders. values holds the values of the flags
+// that cannot print their own, as recorded by funcFlag; each of them is
+// repeated once per value.
+func rerunArgs(fs *flag.FlagSet, values map[string][]string) []string {
+	var args []string
+	fs.Visit(func(f *flag.Flag) {
+		if notRerun[f.Name] {
+			return
+		}
+		vs, ok := values[f.Name]
+		if !ok {
+			vs = []string{f.Value.String()}
+		}
+		for _, v := range vs {
+			if p, ok := secretFlags[f.Name]; ok {
+				v = p
+			} else {
+				v = redact.URL(v)
+			}
+			args = append(args, "--"+f.Name+"="+shellQuote(v))
+		}
+	})
+	return args
+}
+
+// shellQuote quotes s for a POSIX shell when it needs it.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.,:/=@%+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// command formats a suggested invocation of this tool with the flags of
+// this run.
+func (cfg *config) command(name string, extra ...string) string {
+	args := append([]string{filepath.Base(os.Args[0]), name}, cfg.rerunArgs...)
+	return strings.Join(append(args, extra...), " ")
+}
+
+// strayGroup counts strays.
+type strayGroup struct {
+	files int
+	bytes int64
+}
+
+func (g *strayGroup) add(u matcher.UntrackedFile) {
+	g.files++
+	g.bytes += u.Size
+}
+
+func (g strayGroup) String() string {
+	return fmt.Sprintf("%d file(s), %s", g.files, report.FormatBytes(g.bytes))
+}
+
+// writeNextSteps suggests what to do about the strays a dry run found,
+// based on how they were classified.
+func writeNextSteps(w io.Writer, res *runResult, cfg *config) {
+	var safe, dumps, redundant, missing, sidecars, takeout, orphans, junk strayGroup
+	orphaned := make(map[string]bool, len(res.orphanDirs))
+	for _, d := range res.orphanDirs {
+		orphaned[d.Path] = true
+	}
+	var safeCats []string
+	owners := make(map[string]map[string]*strayGroup)
+	unmanaged := make(map[string]*strayGroup)
+	for _, u := range res.untracked {
+		switch {
+		case orphaned[orphanDirKey(u.RelPath)]:
+			orphans.add(u)
+		case u.Category == matcher.CategoryDerivative || u.Category == matcher.CategoryProfile:
+			safe.add(u)
+			if !slices.Contains(safeCats, string(u.Category)) {
+				safeCats = append(safeCats, string(u.Category))
+			}
+		case u.Category == matcher.CategoryBackup:
+			dumps.add(u)
+		case u.Category == matcher.CategorySidecar:
+			sidecars.add(u)
+		case u.Category == matcher.CategoryTakeout:
+			takeout.add(u)
+		case u.Category == matcher.CategoryJunk:
+			junk.add(u)
+		case u.MissingOriginalOf != "":
+			missing.add(u)
+		case u.Category == matcher.CategoryOriginal && (u.ProbablyTrackedAs != "" || u.DuplicateOf != ""):
+			redundant.add(u)
+		case u.Category == matcher.CategoryOriginal:
+			owner := paths.Owner(u.RelPath)
+			if owner != "" && paths.TopDir(u.RelPath) == "upload" {
+				owner = "the user with ID " + owner
+			}
+			if owners[owner] == nil {
+				owners[owner] = make(map[string]*strayGroup)
+			}
+			dir := path.Dir(u.RelPath)
+			if owners[owner][dir] == nil {
+				owners[owner][dir] = &strayGroup{}
+			}
+			owners[owner][dir].add(u)
+		case u.Category == matcher.CategoryUnmanaged && strings.Contains(u.RelPath, "/"):
+			top := paths.TopDir(u.RelPath)
+			if unmanaged[top] == nil {
+				unmanaged[top] = &strayGroup{}
+			}
+			unmanaged[top].add(u)
+		}
+	}
+
+	var steps []string
+	if safe.files > 0 {
+		slices.Sort(safeCats)
+		steps = append(steps, fmt.Sprintf("%s are thumbnails, encoded videos, or profile images of deleted assets and users, "+
+			"which Immich no longer uses. Quarantine just those:\n      %s",
+			safe, cfg.command("move", "--categories="+strings.Join(safeCats, ","))))
+	}
+	if dumps.files > 0 {
+		steps = append(steps, fmt.Sprintf("%s are database dumps outside backups/. Return them to backups/:\n      %s",
+			dumps, cfg.command("move", "--categories=backup")))
+	}
+	if junk.files > 0 {
+		steps = append(steps, fmt.Sprintf("%s are junk left by operating systems and NAS software, like .DS_Store files "+
+			"or Synology @eaDir thumbnails. Delete just those:\n      %s",
+			junk, cfg.command("move", "--categories=junk", "--junk=delete")))
+	}
+	if missing.files > 0 {
+		steps = append(steps, fmt.Sprintf("%s have the content of assets whose originals are missing from disk. "+
+			"Put them back where Immich expects them while quarantining the other strays:\n      %s",
+			missing, cfg.command("move", "--relink")))
+	}
+	if orphans.files > 0 {
+		var dirs []string
+		for _, d := range res.orphanDirs {
+			dirs = append(dirs, d.Path+"/")
+		}
+		steps = append(steps, fmt.Sprintf("%s are in directories of users no longer in Immich: %s. "+
+			"Nothing in Immich refers to them; quarantine them with the other strays:\n      %s",
+			orphans, strings.Join(dirs, ", "), cfg.command("move")))
+	}
+	if sidecars.files > 0 {
+		steps = append(steps, fmt.Sprintf("%s are sidecars next to tracked originals that Immich has no record of. "+
+			"If Immich does record them, scanning with --db-url tracks them; otherwise they may still hold edits made "+
+			"outside Immich. Quarantine just those once checked:\n      %s",
+			sidecars, cfg.command("move", "--categories=sidecar")))
+	}
+	if takeout.files > 0 {
+		steps = append(steps, fmt.Sprintf("%s are JSON metadata or edited copies a Google Takeout import left next to "+
+			"tracked originals. Immich does not use them; check a few, then quarantine them together:\n      %s",
+			takeout, cfg.command("move", "--categories=takeout")))
+	}
+	if redundant.files > 0 {
+		steps = append(steps, fmt.Sprintf("%s are originals that are probably copies of tracked assets or identical to one. "+
+			"Check the list above, then quarantine just those:\n      %s",
+			redundant, cfg.command("move", "--categories=original", "--redundant-only")))
+	}
+	for _, owner := range slices.Sorted(maps.Keys(owners)) {
+		dirs := owners[owner]
+		var total strayGroup
+		for _, g := range dirs {
+			total.files += g.files
+			total.bytes += g.bytes
+		}
+		byCount := slices.SortedFunc(maps.Keys(dirs), func(a, b string) int {
+			return cmp.Or(cmp.Compare(dirs[b].files, dirs[a].files), cmp.Compare(a, b))
+		})
+		var quoted []string
+		for _, dir := range byCount[:min(3, len(byCount))] {
+			quoted = append(quoted, shellQuote(cfg.libraryFile(dir)))
+		}
+		more := ""
+		if len(byCount) > 3 {
+			more = fmt.Sprintf(" (and %d more directories)", len(byCount)-3)
+		}
+		owner = cmp.Or(owner, "their owner")
+		steps = append(steps, fmt.Sprintf("%s are originals of %s that Immich does not know. To keep them, re-import them "+
+			"with the Immich CLI logged in as %s; Immich skips files it already has:\n      immich upload --recursive %s%s",
+			total, owner, owner, strings.Join(quoted, " "), more))
+	}
+	if len(unmanaged) > 0 {
+		var dirs []string
+		for _, top := range slices.Sorted(maps.Keys(unmanaged)) {
+			dirs = append(dirs, fmt.Sprintf("%s/ (%s)", top, unmanaged[top]))
+		}
+		known := append(splitList(cfg.knownDirs), slices.Sorted(maps.Keys(unmanaged))...)
+		steps = append(steps, fmt.Sprintf("Directories Immich does not manage hold strays: %s. If they belong to another service, "+
+			"leave them out of the scan:\n      %s",
+			strings.Join(dirs, ", "), cfg.command("scan", "--known-dirs="+shellQuote(strings.Join(known, ",")))))
+	}
+	if cfg.manifestDir != "" {
+		if runs, err := mover.ListRuns(cfg.manifestDir); err == nil && len(runs) > 0 {
+			purge := filepath.Base(os.Args[0]) + " purge --target-dir=" + shellQuote(cfg.targetDir)
+			if cfg.remote != nil {
+				purge += " --state-dir=" + shellQuote(cfg.stateDir)
+			}
+			steps = append(steps, fmt.Sprintf("The quarantine holds %d earlier run(s). Once you have checked them, free the space, "+
+				"oldest run first:\n      %s\n      %s",
+				len(runs), purge+" --dry-run", purge+" --run="+runs[0]))
+		}
+	}
+	if len(steps) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "\nNext steps:")
+	for _, s := range steps {
+		fmt.Fprintf(w, "  - %s\n", s)
+	}
+}
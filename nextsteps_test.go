@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestRerunArgs(t *testing.T) {
+	var cfg config
+	fs := newFlagSet("scan", &cfg)
+	addRunFlags(fs, &cfg)
+	fs.SetOutput(io.Discard)
+	err := fs.Parse([]string{
+		"--library-path=/mnt/immich", "--library-path=/mnt/ssd/thumbs=/data/thumbs/",
+		"--include=library/a/**", "--include=library/b/**",
+		"--path-map=/old/=/", "--api-key=secret", "--fold-case", "--categories=original",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := rerunArgs(fs, cfg.flagValues)
+	want := []string{
+		"--api-key='<api-key>'",
+		"--fold-case=true",
+		"--include='library/a/**'",
+		"--include='library/b/**'",
+		"--library-path=/mnt/immich",
+		"--library-path=/mnt/ssd/thumbs=/data/thumbs/",
+		"--path-map=/old/=/",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("rerunArgs = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/notify"
+)
+
+// notifyTopDirs is the number of stray directories a notification lists.
+const notifyTopDirs = 10
+
+// topStrayDirs returns the n directories with the most strays in res, by
+// count and then size.
+func topStrayDirs(res *runResult, n int) []notify.Dir {
+	byDir := make(map[string]*notify.Dir)
+	for _, u := range res.untracked {
+		dir := path.Dir(u.RelPath)
+		d := byDir[dir]
+		if d == nil {
+			d = &notify.Dir{Path: dir}
+			byDir[dir] = d
+		}
+		d.Files++
+		d.Bytes += u.Size
+	}
+	dirs := make([]notify.Dir, 0, len(byDir))
+	for _, d := range byDir {
+		dirs = append(dirs, *d)
+	}
+	slices.SortFunc(dirs, func(a, b notify.Dir) int {
+		return cmp.Or(cmp.Compare(b.Files, a.Files), cmp.Compare(b.Bytes, a.Bytes), cmp.Compare(a.Path, b.Path))
+	})
+	return dirs[:min(n, len(dirs))]
+}
+
+// notifyRun sends a summary of a finished run to the --notify and
+// --webhook-url sinks whose conditions it meets. res may be nil for a
+// failed run. Failures are only logged, as for the metrics push.
+func notifyRun(ctx context.Context, cfg *config, rs *runStatus, res *runResult, logger *slog.Logger) {
+	if len(cfg.notifiers) == 0 {
+		return
+	}
+	s := &notify.Summary{
+		Event:            "run_finished",
+		RunID:            rs.RunID,
+		Version:          buildVersion(),
+		Mode:             runMode(cfg),
+		DryRun:           !cfg.move,
+		Success:          rs.Error == "",
+		StartedAt:        rs.StartedAt,
+		FinishedAt:       rs.FinishedAt,
+		Duration:         rs.Duration,
+		AssetsFetched:    rs.AssetsFetched,
+		FilesScanned:     rs.FilesScanned,
+		Untracked:        rs.Untracked,
+		UntrackedBytes:   rs.UntrackedBytes,
+		QuarantinedBytes: rs.QuarantinedBytes,
+		Error:            rs.Error,
+		TopDirectories:   []notify.Dir{},
+	}
+	if res != nil {
+		s.TopDirectories = topStrayDirs(res, notifyTopDirs)
+	}
+
+	// Notify even when the run was interrupted, so the failure shows up.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	notify.SendAll(ctx, cfg.notifiers, s, logger)
+}
@@ -0,0 +1,183 @@
+// Package notify announces finished runs to chat services and webhooks.
+// Each sink is configured by one --notify value of the form
+// KIND[/WHEN]=TARGET, e.g. "slack/untracked=https://hooks.slack.com/...",
+// and is only sent the runs its condition selects.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/report"
+)
+
+// Summary describes a finished run. It is the JSON document webhook sinks
+// receive; chat sinks get the text of Message.
+type Summary struct {
+	Event            string    `json:"event"`
+	RunID            string    `json:"run_id,omitempty"`
+	Version          string    `json:"version"`
+	Mode             string    `json:"mode"`
+	DryRun           bool      `json:"dry_run"`
+	Success          bool      `json:"success"`
+	StartedAt        time.Time `json:"started_at"`
+	FinishedAt       time.Time `json:"finished_at"`
+	Duration         float64   `json:"duration_seconds"`
+	AssetsFetched    int       `json:"assets_fetched"`
+	FilesScanned     int       `json:"files_scanned"`
+	Untracked        int       `json:"untracked"`
+	UntrackedBytes   int64     `json:"untracked_bytes"`
+	QuarantinedBytes int64     `json:"quarantined_bytes"`
+	Error            string    `json:"error,omitempty"`
+	// TopDirectories are the directories holding the most strays.
+	TopDirectories []Dir `json:"top_directories"`
+}
+
+// Dir counts the strays in one directory.
+type Dir struct {
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Message renders s as a short plain-text message for chat services.
+func (s *Summary) Message() string {
+	if !s.Success {
+		return fmt.Sprintf("immich-stray-finder %s run failed after %s: %s", s.Mode, formatDuration(s.Duration), s.Error)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "immich-stray-finder %s run: %d untracked file(s), %s, among %d scanned",
+		s.Mode, s.Untracked, report.FormatBytes(s.UntrackedBytes), s.FilesScanned)
+	if s.QuarantinedBytes > 0 {
+		fmt.Fprintf(&b, "; %s quarantined", report.FormatBytes(s.QuarantinedBytes))
+	}
+	fmt.Fprintf(&b, " (%s).", formatDuration(s.Duration))
+	for _, d := range s.TopDirectories[:min(5, len(s.TopDirectories))] {
+		fmt.Fprintf(&b, "\n• %s: %d file(s), %s", d.Path, d.Files, report.FormatBytes(d.Bytes))
+	}
+	return b.String()
+}
+
+func formatDuration(seconds float64) string {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second).String()
+}
+
+// Conditions a sink can be limited to.
+const (
+	WhenAlways    = "always"
+	WhenSuccess   = "success"
+	WhenFailure   = "failure"
+	WhenUntracked = "untracked"
+)
+
+var conditions = []string{WhenAlways, WhenSuccess, WhenFailure, WhenUntracked}
+
+// Sink delivers summaries to one destination.
+type Sink interface {
+	// Send delivers s.
+	Send(ctx context.Context, s *Summary) error
+}
+
+// Target is a configured sink and the runs it is sent.
+type Target struct {
+	// Kind is the sink kind, e.g. "slack".
+	Kind string
+	// When lists the conditions of which at least one must hold.
+	When []string
+	Sink Sink
+	// Secrets are the parts of the configuration to keep out of the logs.
+	Secrets []string
+}
+
+// Wants reports whether t is sent s.
+func (t *Target) Wants(s *Summary) bool {
+	for _, w := range t.When {
+		switch {
+		case w == WhenAlways,
+			w == WhenSuccess && s.Success,
+			w == WhenFailure && !s.Success,
+			w == WhenUntracked && s.Untracked > 0:
+			return true
+		}
+	}
+	return false
+}
+
+// kinds maps sink kinds to their constructors, which parse the target.
+var kinds = map[string]func(target string, client *http.Client) (Sink, []string, error){
+	"webhook":  newWebhook,
+	"discord":  newDiscord,
+	"slack":    newSlack,
+	"telegram": newTelegram,
+	"matrix":   newMatrix,
+}
+
+// Kinds returns the supported sink kinds.
+func Kinds() []string {
+	return slices.Sorted(func(yield func(string) bool) {
+		for k := range kinds {
+			if !yield(k) {
+				return
+			}
+		}
+	})
+}
+
+// Parse parses a --notify value, KIND[/WHEN[+WHEN...]]=TARGET. WHEN
+// defaults to always.
+func Parse(spec string) (*Target, error) {
+	head, target, ok := strings.Cut(spec, "=")
+	if !ok || target == "" {
+		return nil, errors.New("want KIND[/WHEN]=TARGET")
+	}
+	kind, when, _ := strings.Cut(head, "/")
+	newSink := kinds[kind]
+	if newSink == nil {
+		return nil, fmt.Errorf("unknown kind %q; want one of %s", kind, strings.Join(Kinds(), ", "))
+	}
+	t := &Target{Kind: kind, When: []string{WhenAlways}}
+	if when != "" {
+		t.When = strings.Split(when, "+")
+		for _, w := range t.When {
+			if !slices.Contains(conditions, w) {
+				return nil, fmt.Errorf("unknown condition %q; want one of %s", w, strings.Join(conditions, ", "))
+			}
+		}
+	}
+	sink, secrets, err := newSink(target, &http.Client{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", kind, err)
+	}
+	t.Sink, t.Secrets = sink, append(secrets, target)
+	return t, nil
+}
+
+// SendAll sends s to every target that wants it, concurrently, and logs
+// the outcome. A failing sink does not keep the others from being sent.
+func SendAll(ctx context.Context, targets []*Target, s *Summary, logger *slog.Logger) {
+	done := make(chan struct{})
+	n := 0
+	for _, t := range targets {
+		if !t.Wants(s) {
+			continue
+		}
+		n++
+		go func() {
+			defer func() { done <- struct{}{} }()
+			if err := t.Sink.Send(ctx, s); err != nil {
+				logger.Warn("failed to send run notification", "sink", t.Kind, "error", err)
+				return
+			}
+			logger.Info("sent run notification", "sink", t.Kind)
+		}()
+	}
+	for range n {
+		<-done
+	}
+}
@@ -0,0 +1,186 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recorder is a test server that remembers the requests it receives.
+type recorder struct {
+	mu       sync.Mutex
+	requests []recorded
+}
+
+type recorded struct {
+	method, path, auth string
+	body               map[string]any
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	b, _ := io.ReadAll(req.Body)
+	var body map[string]any
+	json.Unmarshal(b, &body)
+	r.mu.Lock()
+	r.requests = append(r.requests, recorded{req.Method, req.URL.EscapedPath(), req.Header.Get("Authorization"), body})
+	r.mu.Unlock()
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec    string
+		kind    string
+		when    []string
+		secrets []string
+		wantErr string
+	}{
+		{spec: "webhook=https://example.org/hook", kind: "webhook", when: []string{"always"}, secrets: []string{"https://example.org/hook"}},
+		{spec: "slack/untracked+failure=https://hooks.slack.com/x", kind: "slack", when: []string{"untracked", "failure"}},
+		{spec: "telegram/failure=123:abc@-100200", kind: "telegram", when: []string{"failure"}, secrets: []string{"123:abc", "123:abc@-100200"}},
+		{spec: "matrix=https://tok@matrix.example.org/!room:example.org", kind: "matrix", when: []string{"always"}},
+		{spec: "webhook", wantErr: "want KIND[/WHEN]=TARGET"},
+		{spec: "email=me@example.org", wantErr: `unknown kind "email"`},
+		{spec: "slack/sometimes=https://x", wantErr: `unknown condition "sometimes"`},
+		{spec: "discord=ftp://x", wantErr: "http or https"},
+		{spec: "telegram=123:abc", wantErr: "BOT_TOKEN@CHAT_ID"},
+		{spec: "matrix=https://matrix.example.org/!room:example.org", wantErr: "ACCESS_TOKEN"},
+		{spec: "matrix=https://tok@matrix.example.org/#alias:example.org", wantErr: "room ID"},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.spec)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Parse(%q) error = %v, want %q", tt.spec, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.spec, err)
+			continue
+		}
+		if got.Kind != tt.kind || strings.Join(got.When, ",") != strings.Join(tt.when, ",") {
+			t.Errorf("Parse(%q) = %s %v, want %s %v", tt.spec, got.Kind, got.When, tt.kind, tt.when)
+		}
+		if tt.secrets != nil && strings.Join(got.Secrets, " ") != strings.Join(tt.secrets, " ") {
+			t.Errorf("Parse(%q) secrets = %q, want %q", tt.spec, got.Secrets, tt.secrets)
+		}
+	}
+}
+
+func TestWants(t *testing.T) {
+	clean := &Summary{Success: true}
+	strays := &Summary{Success: true, Untracked: 3}
+	failed := &Summary{Error: "boom"}
+	tests := []struct {
+		when []string
+		want [3]bool // clean, strays, failed
+	}{
+		{[]string{"always"}, [3]bool{true, true, true}},
+		{[]string{"success"}, [3]bool{true, true, false}},
+		{[]string{"failure"}, [3]bool{false, false, true}},
+		{[]string{"untracked"}, [3]bool{false, true, false}},
+		{[]string{"untracked", "failure"}, [3]bool{false, true, true}},
+	}
+	for _, tt := range tests {
+		target := &Target{When: tt.when}
+		got := [3]bool{target.Wants(clean), target.Wants(strays), target.Wants(failed)}
+		if got != tt.want {
+			t.Errorf("Wants with %v = %v, want %v", tt.when, got, tt.want)
+		}
+	}
+}
+
+func TestSendAll(t *testing.T) {
+	rec := &recorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	old := TelegramAPI
+	TelegramAPI = srv.URL
+	defer func() { TelegramAPI = old }()
+
+	matrixURL := strings.Replace(srv.URL, "://", "://tok@", 1) + "/!room:example.org"
+	var targets []*Target
+	for _, spec := range []string{
+		"webhook=" + srv.URL + "/hook",
+		"discord=" + srv.URL + "/discord",
+		"slack=" + srv.URL + "/slack",
+		"telegram=123:abc@42",
+		"matrix=" + matrixURL,
+		"webhook/failure=" + srv.URL + "/failures",
+	} {
+		target, err := Parse(spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", spec, err)
+		}
+		targets = append(targets, target)
+	}
+
+	s := &Summary{
+		Event: "run_finished", Mode: "scan", Success: true, Untracked: 2, UntrackedBytes: 2048,
+		TopDirectories: []Dir{{Path: "library/alice/2024", Files: 2, Bytes: 2048}},
+	}
+	SendAll(context.Background(), targets, s, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	byPath := make(map[string]recorded)
+	for _, r := range rec.requests {
+		p := r.path
+		if strings.HasPrefix(p, "/_matrix/") {
+			p = p[:strings.LastIndex(p, "/")]
+		}
+		byPath[p] = r
+	}
+	if len(rec.requests) != 5 {
+		t.Errorf("got %d requests, want 5: %v", len(rec.requests), byPath)
+	}
+	if r := byPath["/hook"]; r.body["untracked"] != 2.0 || r.body["event"] != "run_finished" {
+		t.Errorf("webhook body = %v", r.body)
+	}
+	if _, ok := byPath["/failures"]; ok {
+		t.Error("failure-only webhook was sent a successful run")
+	}
+	for _, p := range []string{"/discord", "/slack", "/bot123:abc/sendMessage"} {
+		var text string
+		for _, v := range byPath[p].body {
+			if s, ok := v.(string); ok && strings.Contains(s, "untracked") {
+				text = s
+			}
+		}
+		if !strings.Contains(text, "2 untracked file(s)") || !strings.Contains(text, "library/alice/2024") {
+			t.Errorf("%s body = %v", p, byPath[p].body)
+		}
+	}
+	if byPath["/bot123:abc/sendMessage"].body["chat_id"] != "42" {
+		t.Errorf("telegram body = %v", byPath["/bot123:abc/sendMessage"].body)
+	}
+	m := byPath["/_matrix/client/v3/rooms/%21room:example.org/send/m.room.message"]
+	if m.method != http.MethodPut || m.auth != "Bearer tok" || m.body["msgtype"] != "m.notice" {
+		t.Errorf("matrix request = %+v", m)
+	}
+}
+
+func TestSendError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such hook", http.StatusNotFound)
+	}))
+	defer srv.Close()
+	target, err := Parse("slack=" + srv.URL + "/secret-path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = target.Sink.Send(context.Background(), &Summary{Success: true})
+	if err == nil || !strings.Contains(err.Error(), "status 404: no such hook") {
+		t.Errorf("Send error = %v", err)
+	}
+
+	target, _ = Parse("slack=http://127.0.0.1:1/secret-path")
+	err = target.Sink.Send(context.Background(), &Summary{Success: true})
+	if err == nil || strings.Contains(err.Error(), "secret-path") {
+		t.Errorf("Send error = %v, want an error without the URL", err)
+	}
+}
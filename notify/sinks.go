@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TelegramAPI is the Bot API base URL telegram sinks post to.
+var TelegramAPI = "https://api.telegram.org"
+
+// webhook POSTs the Summary as JSON.
+type webhook struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhook(target string, client *http.Client) (Sink, []string, error) {
+	if err := checkURL(target); err != nil {
+		return nil, nil, err
+	}
+	return &webhook{url: target, client: client}, nil, nil
+}
+
+func (w *webhook) Send(ctx context.Context, s *Summary) error {
+	return send(ctx, w.client, http.MethodPost, w.url, "", s)
+}
+
+// chat POSTs the message to an incoming-webhook URL as {key: message}, the
+// format Discord ("content") and Slack ("text") expect.
+type chat struct {
+	url, key string
+	client   *http.Client
+}
+
+func newDiscord(target string, client *http.Client) (Sink, []string, error) {
+	if err := checkURL(target); err != nil {
+		return nil, nil, err
+	}
+	return &chat{url: target, key: "content", client: client}, nil, nil
+}
+
+func newSlack(target string, client *http.Client) (Sink, []string, error) {
+	if err := checkURL(target); err != nil {
+		return nil, nil, err
+	}
+	return &chat{url: target, key: "text", client: client}, nil, nil
+}
+
+func (c *chat) Send(ctx context.Context, s *Summary) error {
+	return send(ctx, c.client, http.MethodPost, c.url, "", map[string]string{c.key: s.Message()})
+}
+
+// telegram sends the message with a bot, configured as BOT_TOKEN@CHAT_ID.
+type telegram struct {
+	token, chat string
+	client      *http.Client
+}
+
+func newTelegram(target string, client *http.Client) (Sink, []string, error) {
+	i := strings.LastIndex(target, "@")
+	if i <= 0 || i == len(target)-1 {
+		return nil, nil, errors.New("want BOT_TOKEN@CHAT_ID")
+	}
+	t := &telegram{token: target[:i], chat: target[i+1:], client: client}
+	return t, []string{t.token}, nil
+}
+
+func (t *telegram) Send(ctx context.Context, s *Summary) error {
+	u := TelegramAPI + "/bot" + t.token + "/sendMessage"
+	return send(ctx, t.client, http.MethodPost, u, "", map[string]string{"chat_id": t.chat, "text": s.Message()})
+}
+
+// matrix posts the message as a notice to a room, configured as
+// https://ACCESS_TOKEN@homeserver/!room:server.
+type matrix struct {
+	homeserver, token, room string
+	client                  *http.Client
+}
+
+func newMatrix(target string, client *http.Client) (Sink, []string, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" || u.User == nil {
+		return nil, nil, errors.New("want https://ACCESS_TOKEN@HOMESERVER/ROOM_ID")
+	}
+	m := &matrix{
+		homeserver: u.Scheme + "://" + u.Host,
+		token:      u.User.Username(),
+		room:       strings.TrimPrefix(u.Path, "/"),
+		client:     client,
+	}
+	if m.token == "" || !strings.HasPrefix(m.room, "!") {
+		return nil, nil, errors.New("want https://ACCESS_TOKEN@HOMESERVER/ROOM_ID with a room ID like !abc:example.org")
+	}
+	return m, []string{m.token}, nil
+}
+
+func (m *matrix) Send(ctx context.Context, s *Summary) error {
+	// Matrix deduplicates by transaction ID, so each send needs a new one.
+	txn := make([]byte, 8)
+	rand.Read(txn)
+	u := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		m.homeserver, url.PathEscape(m.room), hex.EncodeToString(txn))
+	return send(ctx, m.client, http.MethodPut, u, m.token, map[string]string{"msgtype": "m.notice", "body": s.Message()})
+}
+
+// checkURL reports whether target is an http(s) URL. The URL itself is not
+// part of the error: webhook URLs embed their credentials.
+func checkURL(target string) error {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return errors.New("target must be an http or https URL")
+	}
+	return nil
+}
+
+// send sends v as JSON and expects a 2xx response.
+func send(ctx context.Context, client *http.Client, method, target, bearer string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode notification: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// url.Error repeats the URL, which may hold credentials.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("http request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("returned status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/goeland86/immich-stray-finder/matcher"
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/report"
+)
+
+// orphanUserDirs finds the user directories of the strays, like
+// library/<label>/ or thumbs/<userId>/, that belong to none of the users in
+// ownerDirs, which maps user IDs to their directory under library/. Such a
+// directory is left behind when a user is removed from Immich, so each is
+// reported as a whole instead of file by file.
+func orphanUserDirs(untracked []matcher.UntrackedFile, ownerDirs map[string]string) []report.OrphanDir {
+	if len(ownerDirs) == 0 {
+		return nil
+	}
+	labels := make(map[string]bool, len(ownerDirs))
+	for _, dir := range ownerDirs {
+		labels[dir] = true
+	}
+	dirs := make(map[string]*report.OrphanDir)
+	for _, u := range untracked {
+		owner := paths.Owner(u.RelPath)
+		if owner == "" {
+			continue
+		}
+		if paths.TopDir(u.RelPath) == "library" {
+			if labels[owner] {
+				continue
+			}
+		} else if _, ok := ownerDirs[owner]; ok || !matcher.IsUUID(owner) {
+			continue
+		}
+		dir := orphanDirKey(u.RelPath)
+		d := dirs[dir]
+		if d == nil {
+			d = &report.OrphanDir{Path: dir}
+			dirs[dir] = d
+		}
+		d.Files++
+		d.Bytes += u.Size
+	}
+	var out []report.OrphanDir
+	for _, d := range dirs {
+		out = append(out, *d)
+	}
+	slices.SortFunc(out, func(a, b report.OrphanDir) int {
+		return paths.CompareWalk(a.Path, b.Path)
+	})
+	return out
+}
+
+// orphanDirKey returns the user directory holding rel, in the form
+// orphanUserDirs reports it, or "".
+func orphanDirKey(rel string) string {
+	owner := paths.Owner(rel)
+	if owner == "" {
+		return ""
+	}
+	return paths.TopDir(rel) + "/" + owner
+}
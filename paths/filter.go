@@ -0,0 +1,105 @@
+package paths
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Filter limits a run to part of the library with --include and --exclude
+// globs, matched like MatchExclusion against forward-slash paths relative
+// to the library root. A nil *Filter keeps everything.
+type Filter struct {
+	// Include, when not empty, keeps only paths matching one of its
+	// patterns.
+	Include []string
+	// Exclude drops paths matching one of its patterns, even if included.
+	Exclude []string
+}
+
+// NewFilter checks the patterns and returns a filter with them, or nil if
+// there are none.
+func NewFilter(include, exclude []string) (*Filter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	f := &Filter{}
+	for _, list := range []struct {
+		patterns []string
+		dst      *[]string
+	}{{include, &f.Include}, {exclude, &f.Exclude}} {
+		for _, p := range list.patterns {
+			p = strings.Trim(p, "/")
+			for _, seg := range strings.Split(p, "/") {
+				if _, err := path.Match(seg, ""); err != nil || p == "" {
+					return nil, fmt.Errorf("invalid pattern %q", p)
+				}
+			}
+			*list.dst = append(*list.dst, p)
+		}
+	}
+	return f, nil
+}
+
+// Keep reports whether the file at rel is part of the run.
+func (f *Filter) Keep(rel string) bool {
+	if f == nil {
+		return true
+	}
+	for _, p := range f.Exclude {
+		if MatchExclusion(p, rel) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, p := range f.Include {
+		if MatchExclusion(p, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// SkipDir reports whether nothing below the directory at rel can be part
+// of the run, because an exclude pattern matches everything below it or no
+// include pattern can match anything below it.
+func (f *Filter) SkipDir(rel string) bool {
+	if f == nil {
+		return false
+	}
+	for _, p := range f.Exclude {
+		if (p == "**" || strings.HasSuffix(p, "/**")) && MatchExclusion(p, rel) {
+			return true
+		}
+	}
+	if len(f.Include) == 0 {
+		return false
+	}
+	dir := strings.Split(rel, "/")
+	for _, p := range f.Include {
+		if mayMatchBelow(strings.Split(p, "/"), dir) {
+			return false
+		}
+	}
+	return true
+}
+
+// mayMatchBelow reports whether pattern can match a path below the
+// directory with the segments dir.
+func mayMatchBelow(pattern, dir []string) bool {
+	for _, seg := range dir {
+		if len(pattern) == 0 {
+			return false
+		}
+		if pattern[0] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(pattern[0], seg); !ok {
+			return false
+		}
+		pattern = pattern[1:]
+	}
+	return true
+}
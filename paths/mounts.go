@@ -0,0 +1,44 @@
+package paths
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Mount is a directory of the library kept outside the library root on
+// disk, like thumbs/ on an SSD while the originals stay on a disk array.
+type Mount struct {
+	// Rel is the forward-slash path of the directory relative to the
+	// library root, e.g. "thumbs".
+	Rel string
+	// Dir is where the directory is on disk.
+	Dir string
+}
+
+// Within reports whether the forward-slash path rel is dir or below it.
+func Within(rel, dir string) bool {
+	return dir == "" || rel == dir || strings.HasPrefix(rel, dir+"/")
+}
+
+// Locate returns the directory on disk holding the forward-slash path rel
+// relative to the library root, and that directory's own path relative to
+// the library root: the deepest of mounts holding rel, or else root and "".
+func Locate(root string, mounts []Mount, rel string) (dir, prefix string) {
+	for _, m := range mounts {
+		if Within(rel, m.Rel) && len(m.Rel) >= len(prefix) {
+			dir, prefix = m.Dir, m.Rel
+		}
+	}
+	if dir == "" {
+		return root, ""
+	}
+	return dir, prefix
+}
+
+// Join returns the path on disk of the forward-slash path rel relative to
+// the library root, as located by Locate.
+func Join(root string, mounts []Mount, rel string) string {
+	dir, prefix := Locate(root, mounts, rel)
+	sub := strings.TrimPrefix(strings.TrimPrefix(rel, prefix), "/")
+	return filepath.Join(dir, filepath.FromSlash(sub))
+}
@@ -0,0 +1,47 @@
+package paths
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PathMap rewrites the asset paths below From to the same place below To,
+// for assets Immich recorded under an older media location, such as
+// "/usr/src/app/upload" before a move to "/data".
+type PathMap struct {
+	// From is the canonical directory the rule applies below.
+	From string
+	// To is the forward-slash directory relative to the library root that
+	// From stands for, "" for the root itself.
+	To string
+}
+
+// ParsePathMap parses a FROM=TO rule, like "/usr/src/app/upload/=/". TO is
+// relative to the library root; a leading slash is allowed.
+func ParsePathMap(s string) (PathMap, error) {
+	from, to, ok := strings.Cut(s, "=")
+	from, to = Canonical(WindowsToSlash(from)), strings.Trim(Canonical(to), "/")
+	if !ok || from == "" || from == "." || to == ".." || strings.HasPrefix(to, "../") {
+		return PathMap{}, fmt.Errorf("%q: want FROM=TO, e.g. /usr/src/app/upload/=/", s)
+	}
+	if to == "." {
+		to = ""
+	}
+	return PathMap{From: strings.TrimSuffix(from, "/"), To: to}, nil
+}
+
+// Rel returns the library-relative path of the asset path p by the first
+// of maps whose From p lies below, or false if there is none.
+func Rel(maps []PathMap, p string) (string, bool) {
+	for _, m := range maps {
+		rest, ok := strings.CutPrefix(p, m.From+"/")
+		if !ok {
+			continue
+		}
+		if m.To == "" {
+			return rest, true
+		}
+		return m.To + "/" + rest, true
+	}
+	return "", false
+}
@@ -0,0 +1,219 @@
+// Package paths turns asset paths from Immich and relative paths from the
+// filesystem scan into comparable keys. All normalization lives here so the
+// API, database, scanner, and matcher agree on a single configuration.
+package paths
+
+import (
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalizer converts paths into match keys. Paths are first made canonical
+// as by Canonical. A nil *Normalizer leaves keys untouched apart from slash
+// normalization.
+type Normalizer struct {
+	// Prefix is stripped from asset paths to make them relative to the
+	// library root (e.g. "/data/").
+	Prefix string
+	// Maps rewrite the asset paths they apply to instead of Prefix, for
+	// assets recorded under older media locations.
+	Maps []PathMap
+	// FoldUnicode converts keys to UnicodeForm, so NFD filenames returned by
+	// macOS and some SMB mounts match the NFC paths Immich stores.
+	FoldUnicode bool
+	// UnicodeForm is the normalization form FoldUnicode converts keys to.
+	// The zero value is norm.NFC, the form Immich stores paths in.
+	UnicodeForm norm.Form
+	// FoldCase lowercases keys, for libraries on case-insensitive storage.
+	FoldCase bool
+	// URLDecode percent-decodes paths, for imports that stored URL-encoded
+	// paths like "a%20b.jpg". Paths that do not decode are kept as they are.
+	URLDecode bool
+}
+
+// AssetKey returns the match key for an Immich originalPath. The
+// backslashes of Windows-style paths are separators, as by WindowsToSlash.
+func (n *Normalizer) AssetKey(originalPath string) string {
+	if n == nil {
+		return WindowsToSlash(originalPath)
+	}
+	p := n.canonical(WindowsToSlash(originalPath))
+	if rel, ok := Rel(n.Maps, p); ok {
+		return n.fold(rel)
+	}
+	// The prefix is made canonical alike, keeping its trailing slash.
+	raw := WindowsToSlash(n.Prefix)
+	prefix := Canonical(raw)
+	if strings.HasSuffix(raw, "/") && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return n.fold(strings.TrimPrefix(p, prefix))
+}
+
+// Key returns the match key for a forward-slash library-relative path, as
+// returned by FromOS. Backslashes are part of file names there.
+func (n *Normalizer) Key(rel string) string {
+	if n == nil {
+		return rel
+	}
+	return n.fold(n.canonical(rel))
+}
+
+// canonical decodes p if asked to, and makes it canonical.
+func (n *Normalizer) canonical(p string) string {
+	if n.URLDecode && strings.Contains(p, "%") {
+		if dec, err := url.PathUnescape(p); err == nil {
+			p = dec
+		}
+	}
+	return Canonical(p)
+}
+
+// fold applies the Unicode and case folding of n to a canonical path.
+func (n *Normalizer) fold(rel string) string {
+	if n.FoldUnicode && !n.UnicodeForm.IsNormalString(rel) {
+		rel = n.UnicodeForm.String(rel)
+	}
+	if n.FoldCase {
+		rel = strings.ToLower(rel)
+	}
+	return rel
+}
+
+// KeySet converts a set of asset paths into a set of match keys.
+func (n *Normalizer) KeySet(assetPaths map[string]struct{}) map[string]struct{} {
+	keys := make(map[string]struct{}, len(assetPaths))
+	for p := range assetPaths {
+		keys[n.AssetKey(p)] = struct{}{}
+	}
+	return keys
+}
+
+// Canonical cleans the forward-slash path p, which may come from an odd
+// import: it drops "." segments, duplicate and trailing slashes, resolves
+// ".." lexically, and lowercases a Windows drive letter, so "C:/x" and
+// "c:/x" agree. An empty path stays empty.
+func Canonical(p string) string {
+	if p == "" {
+		return ""
+	}
+	p = path.Clean(p)
+	if len(p) >= 2 && p[1] == ':' && 'A' <= p[0] && p[0] <= 'Z' {
+		p = string(p[0]+'a'-'A') + p[1:]
+	}
+	return p
+}
+
+// WindowsToSlash turns the backslashes of a Windows-style path, like
+// "C:\photos\a.jpg", "\\nas\photos\a.jpg", or a relative path without any
+// forward slash, into forward slashes. Other paths are returned unchanged,
+// since a backslash is a legal file name character outside Windows.
+func WindowsToSlash(p string) string {
+	drive := len(p) >= 2 && p[1] == ':' && ('a' <= p[0] && p[0] <= 'z' || 'A' <= p[0] && p[0] <= 'Z')
+	if drive || strings.HasPrefix(p, `\\`) || !strings.Contains(p, "/") {
+		return strings.ReplaceAll(p, `\`, "/")
+	}
+	return p
+}
+
+// FromOS converts an OS-specific relative path to the forward-slash form
+// Immich uses in originalPath.
+func FromOS(rel string) string {
+	return filepath.ToSlash(rel)
+}
+
+// TopDir returns the first segment of a forward-slash relative path.
+func TopDir(rel string) string {
+	top, _, _ := strings.Cut(rel, "/")
+	return top
+}
+
+// Owner returns the path segment identifying the user a library-relative
+// path belongs to: the storage label for library/<label>/..., or the user
+// UUID for upload/, thumbs/, encoded-video/, and profile/. It returns "" when
+// the path carries no owner.
+func Owner(rel string) string {
+	parts := strings.SplitN(rel, "/", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	switch parts[0] {
+	case "library", "upload", "thumbs", "encoded-video", "profile":
+		return parts[1]
+	}
+	return ""
+}
+
+// CompareWalk orders forward-slash relative paths the way a filesystem walk
+// visits them: segment by segment, so everything in "a/" comes before
+// "a.b", unlike in a plain string comparison. It returns -1, 0, or +1.
+func CompareWalk(a, b string) int {
+	for a != "" && b != "" {
+		as, arest, _ := strings.Cut(a, "/")
+		bs, brest, _ := strings.Cut(b, "/")
+		if c := strings.Compare(as, bs); c != 0 {
+			return c
+		}
+		a, b = arest, brest
+	}
+	return strings.Compare(a, b)
+}
+
+// Match reports whether the forward-slash relative path rel matches the
+// glob pattern, in the syntax of path.Match. A pattern without a slash is
+// matched against the file name; any other pattern against rel or one of
+// its parent directories, so "upload/<uuid>/2024" selects everything below
+// that directory.
+func Match(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	for p := rel; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidPattern reports whether pattern is a well-formed glob for Match.
+func ValidPattern(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
+
+// MatchExclusion reports whether the forward-slash path p matches one of
+// Immich's library exclusion patterns, like "**/@eaDir/**" or "**/._*".
+// Immich matches them against absolute paths: "**" is any number of whole
+// segments, including none, and every other segment is matched in the
+// syntax of path.Match.
+func MatchExclusion(pattern, p string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(p, "/"))
+}
+
+// matchSegments matches path segments against pattern segments.
+func matchSegments(pattern, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pattern[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
@@ -0,0 +1,289 @@
+package paths
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestNormalizer_AssetKey(t *testing.T) {
+	n := &Normalizer{Prefix: "/data/", FoldUnicode: true}
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"/data/library/admin/photo.jpg", "library/admin/photo.jpg"},
+		{"library/admin/photo.jpg", "library/admin/photo.jpg"},
+		{"/other/library/photo.jpg", "/other/library/photo.jpg"},
+		{"/data/library/admin/Cafe\u0301.jpg", "library/admin/Caf\u00e9.jpg"}, // NFD -> NFC
+	}
+	for _, tt := range tests {
+		if got := n.AssetKey(tt.input); got != tt.want {
+			t.Errorf("AssetKey(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizer_KeyFoldsBothSidesAlike(t *testing.T) {
+	n := &Normalizer{Prefix: "/data/", FoldUnicode: true, FoldCase: true}
+	if n.AssetKey("/data/library/a/Caf\u00e9.JPG") != n.Key("library/a/cafe\u0301.jpg") {
+		t.Error("expected asset and disk keys to match after folding")
+	}
+}
+
+func TestNormalizer_Backslashes(t *testing.T) {
+	n := &Normalizer{Prefix: "/data/"}
+	if got := n.Key(`library/admin/a\b.jpg`); got != `library/admin/a\b.jpg` {
+		t.Errorf("Key = %q, want the backslash kept as part of the file name", got)
+	}
+	if got := n.AssetKey(`/data/library/admin/a\b.jpg`); got != `library/admin/a\b.jpg` {
+		t.Errorf("AssetKey = %q, want the backslash of a Unix path kept", got)
+	}
+	n = &Normalizer{Prefix: `D:\immich\`}
+	if got := n.AssetKey(`D:\immich\library\admin\a.jpg`); got != "library/admin/a.jpg" {
+		t.Errorf("AssetKey = %q, want Windows separators folded", got)
+	}
+	if got := WindowsToSlash(`\\nas\photos\a.jpg`); got != "//nas/photos/a.jpg" {
+		t.Errorf("WindowsToSlash = %q, want UNC separators folded", got)
+	}
+}
+
+func TestNormalizer_UnicodeForm(t *testing.T) {
+	n := &Normalizer{FoldUnicode: true, UnicodeForm: norm.NFD}
+	if got := n.Key("library/a/Caf\u00e9.jpg"); got != "library/a/Cafe\u0301.jpg" {
+		t.Errorf("Key = %q, want the NFD form", got)
+	}
+	if n.AssetKey("library/a/Caf\u00e9.jpg") != n.Key("library/a/Cafe\u0301.jpg") {
+		t.Error("expected asset and disk keys to match in NFD")
+	}
+	n = &Normalizer{}
+	if got := n.Key("library/a/Cafe\u0301.jpg"); got != "library/a/Cafe\u0301.jpg" {
+		t.Errorf("Key = %q, want the path unchanged without FoldUnicode", got)
+	}
+}
+
+func TestNormalizer_Canonical(t *testing.T) {
+	n := &Normalizer{Prefix: "C:/immich/", URLDecode: true}
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"C:/immich/library/admin/./2024//a.jpg", "library/admin/2024/a.jpg"},
+		{`c:\immich\library\admin\a.jpg`, "library/admin/a.jpg"},
+		{"C:/immich/library/admin/a%20b.jpg", "library/admin/a b.jpg"},
+		{"C:/immich/library/admin/100%.jpg", "library/admin/100%.jpg"},
+	}
+	for _, tt := range tests {
+		if got := n.AssetKey(tt.input); got != tt.want {
+			t.Errorf("AssetKey(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+	if got := n.Key("./library/admin/a%20b.jpg"); got != "library/admin/a b.jpg" {
+		t.Errorf("Key = %q, want the disk path made canonical alike", got)
+	}
+	if got := (&Normalizer{}).Key("library/a%20b.jpg"); got != "library/a%20b.jpg" {
+		t.Errorf("Key = %q, want no decoding without URLDecode", got)
+	}
+}
+
+func TestNormalizer_Maps(t *testing.T) {
+	n := &Normalizer{Prefix: "/data/", Maps: []PathMap{{From: "/usr/src/app/upload", To: ""}, {From: "/photos", To: "library/admin"}}}
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"/data/library/admin/a.jpg", "library/admin/a.jpg"},
+		{"/usr/src/app/upload/upload/u1/ab/b.jpg", "upload/u1/ab/b.jpg"},
+		{"/usr/src/app//upload/library/admin/c.jpg", "library/admin/c.jpg"},
+		{"/photos/2019/d.jpg", "library/admin/2019/d.jpg"},
+		{"/photos2/e.jpg", "/photos2/e.jpg"},
+	}
+	for _, tt := range tests {
+		if got := n.AssetKey(tt.input); got != tt.want {
+			t.Errorf("AssetKey(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParsePathMap(t *testing.T) {
+	tests := []struct {
+		input string
+		want  PathMap
+	}{
+		{"/usr/src/app/upload/=/", PathMap{From: "/usr/src/app/upload", To: ""}},
+		{"/photos=library/admin/", PathMap{From: "/photos", To: "library/admin"}},
+		{"C:/immich/=/", PathMap{From: "c:/immich", To: ""}},
+	}
+	for _, tt := range tests {
+		got, err := ParsePathMap(tt.input)
+		if err != nil || got != tt.want {
+			t.Errorf("ParsePathMap(%q) = %+v, %v, want %+v", tt.input, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"/data/", "=/", "/data/=../x"} {
+		if _, err := ParsePathMap(bad); err == nil {
+			t.Errorf("ParsePathMap(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestNormalizer_Nil(t *testing.T) {
+	var n *Normalizer
+	if got := n.AssetKey(`C:\data\library\a.jpg`); got != "C:/data/library/a.jpg" {
+		t.Errorf("nil normalizer should only fix Windows slashes, got %q", got)
+	}
+	if got := n.AssetKey(`/data/library/a\b.jpg`); got != `/data/library/a\b.jpg` {
+		t.Errorf("nil normalizer should keep the backslashes of Unix paths, got %q", got)
+	}
+}
+
+func TestTopDir(t *testing.T) {
+	if got := TopDir("library/admin/a.jpg"); got != "library" {
+		t.Errorf("TopDir = %q, want library", got)
+	}
+	if got := TopDir("a.jpg"); got != "a.jpg" {
+		t.Errorf("TopDir = %q, want a.jpg", got)
+	}
+}
+
+func TestOwner(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"library/alice/2024/a.jpg", "alice"},
+		{"upload/aaaaaaaa-1111-2222-3333-444444444444/aa/bb/x.jpg", "aaaaaaaa-1111-2222-3333-444444444444"},
+		{"thumbs/u1/aa/x.webp", "u1"},
+		{"library/a.jpg", ""},
+		{"random/alice/a.jpg", ""},
+	}
+	for _, tt := range tests {
+		if got := Owner(tt.input); got != tt.want {
+			t.Errorf("Owner(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestCompareWalk(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"a/b.jpg", "a.b/c.jpg", -1},
+		{"a.b/c.jpg", "a/b.jpg", 1},
+		{"library/alice/x.jpg", "library/bob/a.jpg", -1},
+		{"library/x.jpg", "library/x.jpg", 0},
+		{"library", "library/x.jpg", -1},
+	}
+	for _, tt := range tests {
+		if got := CompareWalk(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareWalk(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, rel string
+		want         bool
+	}{
+		{"*.jpg", "upload/u1/ab/IMG_0042.jpg", true},
+		{"IMG_00??.jpg", "upload/u1/ab/IMG_0042.jpg", true},
+		{"*.mp4", "upload/u1/ab/IMG_0042.jpg", false},
+		{"upload/*/ab", "upload/u1/ab/IMG_0042.jpg", true},
+		{"upload/*/ab/*.jpg", "upload/u1/ab/IMG_0042.jpg", true},
+		{"upload/*", "library/admin/IMG_0042.jpg", false},
+		{"upload/u1/a", "upload/u1/ab/IMG_0042.jpg", false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.rel); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.rel, got, tt.want)
+		}
+	}
+	if ValidPattern("upload/[") {
+		t.Error("expected an unterminated class to be rejected")
+	}
+}
+
+func TestMatchExclusion(t *testing.T) {
+	tests := []struct {
+		pattern, p string
+		want       bool
+	}{
+		{"**/@eaDir/**", "/data/library/admin/@eaDir/IMG_0042.jpg/SYNOPHOTO_THUMB_M.jpg", true},
+		{"**/@eaDir/**", "/data/library/admin/IMG_0042.jpg", false},
+		{"**/._*", "/data/library/admin/._IMG_0042.jpg", true},
+		{"**/._*", "/data/library/admin/x._IMG_0042.jpg", false},
+		{"**/#recycle/**", "/data/library/#recycle/a.jpg", true},
+		{"/data/library/**/*.tmp", "/data/library/admin/2024/a.tmp", true},
+		{"/data/upload/**/*.tmp", "/data/library/admin/2024/a.tmp", false},
+		{"*.tmp", "/data/library/admin/a.tmp", false},
+	}
+	for _, tt := range tests {
+		if got := MatchExclusion(tt.pattern, tt.p); got != tt.want {
+			t.Errorf("MatchExclusion(%q, %q) = %v, want %v", tt.pattern, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	if f, err := NewFilter(nil, nil); f != nil || err != nil {
+		t.Errorf("expected no filter without patterns, got %v, %v", f, err)
+	}
+	if _, err := NewFilter([]string{"library/[a"}, nil); err == nil {
+		t.Error("expected an error for a malformed pattern")
+	}
+	f, err := NewFilter([]string{"library/admin/**"}, []string{"library/*/screenshots/**", "**/*.tmp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rel, want := range map[string]bool{
+		"library/admin/2024/a.jpg":        true,
+		"library/admin/screenshots/a.png": false,
+		"library/admin/2024/a.tmp":        false,
+		"library/bob/a.jpg":               false,
+		"upload/u/a.jpg":                  false,
+	} {
+		if got := f.Keep(rel); got != want {
+			t.Errorf("Keep(%q) = %v, want %v", rel, got, want)
+		}
+	}
+	for rel, want := range map[string]bool{
+		"library":                   false,
+		"library/admin":             false,
+		"library/admin/2024":        false,
+		"library/admin/screenshots": true,
+		"library/bob":               true,
+		"upload":                    true,
+	} {
+		if got := f.SkipDir(rel); got != want {
+			t.Errorf("SkipDir(%q) = %v, want %v", rel, got, want)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	mounts := []Mount{
+		{Rel: "thumbs", Dir: filepath.FromSlash("/ssd/thumbs")},
+		{Rel: "library/admin/raw", Dir: filepath.FromSlash("/nas/raw")},
+		{Rel: "library/admin", Dir: filepath.FromSlash("/hdd/admin")},
+	}
+	for rel, want := range map[string]string{
+		"upload/u/a.jpg":              "/lib/upload/u/a.jpg",
+		"thumbs/u/a.webp":             "/ssd/thumbs/u/a.webp",
+		"thumbs":                      "/ssd/thumbs",
+		"thumbsup/a.jpg":              "/lib/thumbsup/a.jpg",
+		"library/admin/2024/a.jpg":    "/hdd/admin/2024/a.jpg",
+		"library/admin/raw/a.dng":     "/nas/raw/a.dng",
+		"library/administrator/a.jpg": "/lib/library/administrator/a.jpg",
+	} {
+		if got := Join(filepath.FromSlash("/lib"), mounts, rel); got != filepath.FromSlash(want) {
+			t.Errorf("Join(%q) = %q, want %q", rel, got, want)
+		}
+	}
+	if dir, prefix := Locate("/lib", mounts, "library/admin/raw/a.dng"); dir != filepath.FromSlash("/nas/raw") || prefix != "library/admin/raw" {
+		t.Errorf("Locate returned %q, %q", dir, prefix)
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/goeland86/immich-stray-finder/ignore"
+	"github.com/goeland86/immich-stray-finder/immich"
+	"github.com/goeland86/immich-stray-finder/matcher"
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/progress"
+	"github.com/goeland86/immich-stray-finder/sampling"
+	"github.com/goeland86/immich-stray-finder/scanner"
+	"github.com/goeland86/immich-stray-finder/throttle"
+	"github.com/goeland86/immich-stray-finder/tracing"
+)
+
+const (
+	// scanBatch is the number of files the scan stage hands to the match
+	// stage at once. Large enough for the matcher to split across cores.
+	scanBatch = 16384
+	// scanQueue is the number of batches the scan stage may run ahead of
+	// matching. Matching cannot start until every asset is fetched, so this
+	// is what lets the filesystem walk overlap with a slow fetch; once it is
+	// full, the walk blocks instead of holding the whole library in memory.
+	scanQueue = 64
+)
+
+// pipeline runs the stages of a scan concurrently: fetching assets from
+// Immich (then normalizing them into a match index) overlaps with walking
+// the filesystem, and matching consumes scanned batches as soon as the
+// index is ready. The stages are joined by bounded channels and share an
+// errgroup, so a failure in one cancels the others.
+type pipeline struct {
+	// fetch retrieves the assets the scan is compared against.
+	fetch func(ctx context.Context) (*immich.AllAssetsResult, error)
+	// index normalizes the fetched assets into a match context.
+	index func(*immich.AllAssetsResult) *matcher.MatchContext
+	// scanRoot and scanPrefix select the part of the library to walk.
+	scanRoot, scanPrefix string
+	// known lists top-level directories that are not walked when scanRoot
+	// is the library root.
+	known []string
+	// units, when set, split scanRoot into parts that are each walked on
+	// their own, up to workers at a time; a unit that fails or exceeds
+	// unitTimeout is reported without failing the others.
+	units       []scanUnit
+	workers     int
+	unitTimeout time.Duration
+	// sample, when set, restricts the walk to a sample of directories.
+	sample *sampling.Selector
+	// cache, when set, spares the walk stat'ing the files of directories
+	// that have not changed since the last run.
+	cache *scanner.Cache
+	// ownerDirs is handed on to the result, see runResult.ownerDirs.
+	ownerDirs map[string]string
+	// checksums indexes the fetched assets by checksum for
+	// --match-checksums, once index has run.
+	checksums *immich.DuplicateIndex
+	// scanRate, when set, limits the files visited per second by all
+	// walks together.
+	scanRate *throttle.Limiter
+	// ignore holds the .strayignore rules of the library.
+	ignore *ignore.Rules
+	// filter limits the run to the paths of --include and --exclude.
+	filter *paths.Filter
+	// mounts are the directories of the library kept outside its root,
+	// which the units are split along.
+	mounts []paths.Mount
+	// expectAssets and expectFiles are the totals the progress bars of the
+	// fetch and the walk estimate their ETAs with, or 0 if unknown.
+	expectAssets, expectFiles int64
+}
+
+// scanUnit is a part of the library walked on its own: in admin mode, one
+// user's library/ directory, or everything outside them.
+type scanUnit struct {
+	// name identifies the unit in logs and reports, e.g. "library/alice".
+	name string
+	// root and prefix are the directory to walk and the relative path of
+	// it, as for scanner.Walk.
+	root, prefix string
+	// skip lists directories below root that other units cover.
+	skip []string
+}
+
+// unitResult is what the scan of one unit found.
+type unitResult struct {
+	name         string
+	filesScanned int
+	untracked    []matcher.UntrackedFile
+	readErrors   int
+	duration     time.Duration
+	// err is set when the unit could not be scanned completely; its
+	// findings are then left out of the run's result.
+	err error
+}
+
+// unitBatch is a batch of scanned files and the unit they belong to.
+type unitBatch struct {
+	unit  int
+	files []scanner.File
+}
+
+// run executes the pipeline and returns the untracked files in scan order.
+func (p *pipeline) run(ctx context.Context, logger *slog.Logger) (*runResult, error) {
+	if len(p.units) == 0 {
+		p.units = []scanUnit{{name: p.scanPrefix, root: p.scanRoot, prefix: p.scanPrefix, skip: p.known}}
+	}
+	p.units = mountUnits(p.units, p.mounts)
+	g, ctx := errgroup.WithContext(ctx)
+	ready := make(chan *matcher.MatchContext, 1)
+	// batches is never closed: a unit abandoned after --user-timeout may
+	// still be blocked in the filesystem when the scan finishes. scanned
+	// is closed instead once every unit is done or abandoned.
+	batches := make(chan unitBatch, scanQueue)
+	scanned := make(chan struct{})
+	units := make([]unitResult, len(p.units))
+	for i, u := range p.units {
+		units[i].name = u.name
+	}
+	res := &runResult{}
+	// expected is whether the walk should find any file at all: whether
+	// an asset lies in one of the units.
+	var expected bool
+
+	// Stage 1: fetch and normalize.
+	g.Go(func() (err error) {
+		ctx, span := tracing.Start(ctx, "fetch")
+		defer span.EndErr(&err)
+		started := time.Now()
+		bar := progress.Track("fetching assets", "assets", p.expectAssets)
+		result, err := p.fetch(progress.NewContext(ctx, bar))
+		bar.Finish()
+		res.fetchTime = time.Since(started)
+		if err != nil {
+			return err
+		}
+		res.assetsFetched = len(result.AssetIDs)
+		span.SetAttrs("assets", res.assetsFetched)
+		_, ispan := tracing.Start(ctx, "index")
+		mctx := p.index(result)
+		ispan.End()
+		expected = p.expectsFiles(mctx)
+		ready <- mctx
+		return nil
+	})
+
+	// Stage 2: walk the units in batches.
+	g.Go(func() error {
+		defer close(scanned)
+		started := time.Now()
+		defer func() { res.walkTime = time.Since(started) }()
+		bar := progress.Track("scanning files", "files", p.expectFiles)
+		defer bar.Finish()
+		return p.walkUnits(progress.NewContext(ctx, bar), batches, units, logger)
+	})
+
+	// Stage 3: match batches, in order per unit, once the index is ready.
+	if p.sample != nil {
+		res.sample = &sampleCounts{files: make(map[string]int), untracked: make(map[string]int)}
+	}
+	g.Go(func() (err error) {
+		var mctx *matcher.MatchContext
+		select {
+		case mctx = <-ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		// The match span starts once the index is ready, so it does not
+		// include the time spent waiting for the fetch.
+		ctx, span := tracing.Start(ctx, "match", "queued_batches", len(batches))
+		matched, found := 0, 0
+		defer func() {
+			span.SetAttrs("files", matched, "untracked", found, "excluded", mctx.Excluded(), "recent", mctx.Recent(), "junk_ignored", mctx.IgnoredJunk(), "ignored", mctx.Ignored(), "filtered_by_ext", mctx.FilteredByExt())
+			span.EndErr(&err)
+			if n := mctx.Excluded(); n > 0 {
+				logger.Info("skipped files matching library exclusion patterns", "files", n)
+			}
+			if n := mctx.Recent(); n > 0 {
+				logger.Info("skipped recently modified files, which may still be being uploaded", "files", n, "min_age", mctx.MinAge)
+			}
+			if n := mctx.Ignored(); n > 0 {
+				logger.Info("skipped files matching .strayignore patterns", "files", n)
+			}
+			if n := mctx.FilteredByExt(); n > 0 {
+				logger.Info("skipped strays filtered by extension", "files", n)
+			}
+			if n := mctx.IgnoredJunk(); n > 0 {
+				logger.Info("skipped junk files left by operating systems and NAS software", "files", n)
+			}
+		}()
+		logger.Info("matching files against Immich database", "queued_batches", len(batches))
+		for {
+			var b unitBatch
+			select {
+			case b = <-batches:
+			case <-scanned:
+				// Every unit is done; match what is still queued.
+				select {
+				case b = <-batches:
+				default:
+					return ctx.Err()
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			_, bspan := tracing.Start(ctx, "match.batch", "files", len(b.files))
+			untracked := matcher.FindUntracked(b.files, mctx, logger)
+			bspan.SetAttrs("untracked", len(untracked))
+			bspan.End()
+			u := &units[b.unit]
+			u.filesScanned += len(b.files)
+			u.untracked = append(u.untracked, untracked...)
+			matched += len(b.files)
+			found += len(untracked)
+			if res.sample != nil {
+				for _, f := range b.files {
+					res.sample.files[path.Dir(f.RelPath)]++
+				}
+				for _, uf := range untracked {
+					res.sample.untracked[path.Dir(uf.RelPath)]++
+				}
+			}
+		}
+	})
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	if res.sample != nil {
+		res.sample.directories, res.sample.sampled = p.sample.Clusters()
+	}
+
+	// Only completely scanned units count: a unit that failed halfway
+	// would understate the library and could hide its strays' context.
+	for i := range units {
+		u := &units[i]
+		if u.err != nil {
+			res.failedUnits++
+			continue
+		}
+		res.filesScanned += u.filesScanned
+		res.untracked = append(res.untracked, u.untracked...)
+	}
+	if len(units) > 1 {
+		// Units finish in any order; restore the order of a single walk.
+		slices.SortStableFunc(res.untracked, func(a, b matcher.UntrackedFile) int {
+			return paths.CompareWalk(a.RelPath, b.RelPath)
+		})
+		res.units = units
+	}
+	logger.Info("matching complete", "files_scanned", res.filesScanned, "untracked", len(res.untracked))
+	// Finding nothing where Immich has assets means the walk looked at an
+	// empty mountpoint rather than the library; its report would be
+	// garbage. A sample or --include may rightly find nothing.
+	if expected && res.filesScanned == 0 && res.failedUnits == 0 && p.sample == nil && p.filter == nil {
+		return nil, fmt.Errorf("no files found below %s although Immich has assets there; "+
+			"is the volume holding Immich's media mounted?", p.scanRoot)
+	}
+	return res, nil
+}
+
+// expectsFiles reports whether any asset of mctx lies in one of the units.
+func (p *pipeline) expectsFiles(mctx *matcher.MatchContext) bool {
+	for key := range mctx.AssetPaths {
+		for _, u := range p.units {
+			if u.coversAsset(key, mctx.Normalizer) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// walkUnits walks the units, up to p.workers at a time, sending their
+// files to batches. With a single unit, its failure fails the scan; with
+// several, a failure is recorded in units and the others carry on.
+func (p *pipeline) walkUnits(ctx context.Context, batches chan<- unitBatch, units []unitResult, logger *slog.Logger) error {
+	if len(p.units) == 1 {
+		return p.walkUnit(ctx, 0, batches, logger, nil)
+	}
+
+	sem := make(chan struct{}, max(p.workers, 1))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i := range p.units {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			started := time.Now()
+			var readErrors atomic.Int64
+			err := p.walkUnitTimeout(ctx, i, batches, logger, &readErrors)
+			u := &units[i]
+			u.duration, u.readErrors = time.Since(started), int(readErrors.Load())
+			switch {
+			case ctx.Err() != nil:
+				// The run is ending; the errgroup reports why.
+			case err != nil:
+				u.err = err
+				logger.Error("scan of library part failed; its strays are left out of this run",
+					"unit", u.name, "error", err)
+			default:
+				logger.Info("scanned library part", "unit", u.name, "duration", u.duration.Round(time.Millisecond),
+					"read_errors", u.readErrors)
+			}
+		}()
+	}
+	return ctx.Err()
+}
+
+// walkUnitTimeout walks unit i like walkUnit, but gives up waiting after
+// p.unitTimeout. A walk stuck on a hung mount cannot be interrupted; it is
+// cancelled and left to stop on its own once the filesystem answers.
+func (p *pipeline) walkUnitTimeout(ctx context.Context, i int, batches chan<- unitBatch, logger *slog.Logger, readErrors *atomic.Int64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- p.walkUnit(ctx, i, batches, logger, readErrors) }()
+
+	var timeout <-chan time.Time
+	if p.unitTimeout > 0 {
+		t := time.NewTimer(p.unitTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-timeout:
+		return fmt.Errorf("not finished after %s (--user-timeout)", p.unitTimeout)
+	}
+}
+
+// walkUnit walks unit i and sends its files to batches. With readErrors
+// set, paths that cannot be read are counted, and an unreadable root fails
+// the unit.
+func (p *pipeline) walkUnit(ctx context.Context, i int, batches chan<- unitBatch, logger *slog.Logger, readErrors *atomic.Int64) error {
+	u := p.units[i]
+	bar := progress.FromContext(ctx)
+	opts := scanner.Options{Sample: p.sample, Skip: u.skip, Cache: p.cache, Rate: p.scanRate, Ignore: p.ignore, Filter: p.filter}
+	var rootErr error
+	if readErrors != nil {
+		root := filepath.Clean(u.root)
+		opts.OnError = func(path string, err error) {
+			readErrors.Add(1)
+			if path == root && rootErr == nil {
+				rootErr = err
+			}
+		}
+	}
+
+	batch := make([]scanner.File, 0, scanBatch)
+	send := func() error {
+		select {
+		case batches <- unitBatch{unit: i, files: batch}:
+			batch = make([]scanner.File, 0, scanBatch)
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	err := scanner.Walk(ctx, u.root, u.prefix, opts, logger, func(f scanner.File) error {
+		batch = append(batch, f)
+		bar.Add(1)
+		if len(batch) == scanBatch {
+			return send()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if rootErr != nil {
+		return rootErr
+	}
+	if len(batch) > 0 {
+		return send()
+	}
+	return nil
+}
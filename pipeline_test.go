@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/immich"
+	"github.com/goeland86/immich-stray-finder/matcher"
+	"github.com/goeland86/immich-stray-finder/sampling"
+	"github.com/goeland86/immich-stray-finder/throttle"
+)
+
+// testPipeline returns a pipeline walking the library at lib whose fetch
+// yields the given asset paths.
+func testPipeline(lib string, assets ...string) *pipeline {
+	set := make(map[string]struct{}, len(assets))
+	for _, a := range assets {
+		set[a] = struct{}{}
+	}
+	return &pipeline{
+		fetch: func(context.Context) (*immich.AllAssetsResult, error) {
+			return &immich.AllAssetsResult{AssetPaths: set}, nil
+		},
+		index: func(result *immich.AllAssetsResult) *matcher.MatchContext {
+			return &matcher.MatchContext{AssetPaths: result.AssetPaths}
+		},
+		scanRoot: lib,
+	}
+}
+
+// writeFiles creates the files at the given paths below lib.
+func writeFiles(t *testing.T, lib string, rels ...string) {
+	t.Helper()
+	for _, rel := range rels {
+		p := filepath.Join(lib, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(rel), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// userUnit returns the unit of the library directory of user.
+func userUnit(lib, user string) scanUnit {
+	return scanUnit{name: "library/" + user, root: filepath.Join(lib, "library", user), prefix: "library/" + user}
+}
+
+// strayPaths returns the paths of the strays res found, in order.
+func strayPaths(res *runResult) []string {
+	var rels []string
+	for _, u := range res.untracked {
+		rels = append(rels, u.RelPath)
+	}
+	return rels
+}
+
+// discardLogger returns a logger that drops everything.
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestPipeline_OrderStableAcrossWorkers(t *testing.T) {
+	lib := t.TempDir()
+	users := []string{"alice", "bob", "carol", "dave"}
+	var assets []string
+	for _, u := range users {
+		writeFiles(t, lib, "library/"+u+"/2024/b.jpg", "library/"+u+"/2024/a.jpg", "library/"+u+"/z.jpg", "library/"+u+"/2023/c.jpg")
+		assets = append(assets, "library/"+u+"/2024/a.jpg")
+	}
+
+	single := testPipeline(lib, assets...)
+	single.scanRoot, single.scanPrefix = filepath.Join(lib, "library"), "library"
+	res, err := single.run(context.Background(), discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strayPaths(res)
+	if len(want) != 3*len(users) {
+		t.Fatalf("expected %d strays, got %v", 3*len(users), want)
+	}
+
+	for range 20 {
+		// Listed against walk order, so only the merge can restore it.
+		p := testPipeline(lib, assets...)
+		for _, u := range slices.Backward(users) {
+			p.units = append(p.units, userUnit(lib, u))
+		}
+		p.workers = len(users)
+		res, err := p.run(context.Background(), discardLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strayPaths(res); !slices.Equal(got, want) {
+			t.Fatalf("strays of parallel units = %v, want the order of a single walk %v", got, want)
+		}
+	}
+}
+
+func TestPipeline_FailedUnitLeavesOthers(t *testing.T) {
+	lib := t.TempDir()
+	writeFiles(t, lib, "library/alice/a.jpg", "library/alice/stray.jpg")
+
+	p := testPipeline(lib, "library/alice/a.jpg")
+	p.units = []scanUnit{userUnit(lib, "alice"), userUnit(lib, "gone")}
+	p.workers = 2
+	res, err := p.run(context.Background(), discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.failedUnits != 1 || res.units[1].err == nil {
+		t.Fatalf("expected the missing unit to fail, got %+v", res.units)
+	}
+	if got := strayPaths(res); !slices.Equal(got, []string{"library/alice/stray.jpg"}) {
+		t.Errorf("expected the other unit's strays, got %v", got)
+	}
+	if err := res.unitsErr(); err == nil || !strings.Contains(err.Error(), "library/gone") {
+		t.Errorf("unitsErr() = %v, want one naming library/gone", err)
+	}
+}
+
+func TestPipeline_UnitTimeout(t *testing.T) {
+	lib := t.TempDir()
+	writeFiles(t, lib, "library/alice/stray.jpg")
+	for i := range 20 {
+		writeFiles(t, lib, "library/slow/"+strings.Repeat("x", i+1)+".jpg")
+	}
+
+	p := testPipeline(lib)
+	// One at a time, so the slow unit does not hold up alice's walk at
+	// the shared --scan-rate.
+	p.units = []scanUnit{userUnit(lib, "alice"), userUnit(lib, "slow")}
+	p.workers, p.unitTimeout = 1, 100*time.Millisecond
+	p.scanRate = throttle.New(10)
+	res, err := p.run(context.Background(), discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.units[1].err; err == nil || !strings.Contains(err.Error(), "--user-timeout") {
+		t.Fatalf("expected the slow unit to time out, got %v", err)
+	}
+	if res.failedUnits != 1 {
+		t.Errorf("expected 1 failed unit, got %d", res.failedUnits)
+	}
+	if got := strayPaths(res); !slices.Equal(got, []string{"library/alice/stray.jpg"}) {
+		t.Errorf("expected only the strays of the unit that finished, got %v", got)
+	}
+}
+
+func TestPipeline_FetchErrorCancelsWalk(t *testing.T) {
+	lib := t.TempDir()
+	for i := range 50 {
+		writeFiles(t, lib, "library/alice/"+strings.Repeat("x", i+1)+".jpg")
+	}
+	errFetch := errors.New("database unreachable")
+
+	p := testPipeline(lib)
+	p.fetch = func(context.Context) (*immich.AllAssetsResult, error) { return nil, errFetch }
+	p.scanRoot, p.scanPrefix = filepath.Join(lib, "library"), "library"
+	p.scanRate = throttle.New(10)
+	started := time.Now()
+	if _, err := p.run(context.Background(), discardLogger()); !errors.Is(err, errFetch) {
+		t.Fatalf("run() error = %v, want the fetch error", err)
+	}
+	if d := time.Since(started); d > 2*time.Second {
+		t.Errorf("the walk went on for %s after the fetch failed", d)
+	}
+}
+
+func TestPipeline_EmptyMount(t *testing.T) {
+	lib := t.TempDir()
+	p := testPipeline(lib, "library/alice/a.jpg")
+	_, err := p.run(context.Background(), discardLogger())
+	if err == nil || !strings.Contains(err.Error(), "mounted") {
+		t.Fatalf("run() error = %v, want one about an unmounted volume", err)
+	}
+
+	// Without assets in the scanned part, an empty library is fine.
+	p = testPipeline(lib)
+	if _, err := p.run(context.Background(), discardLogger()); err != nil {
+		t.Errorf("unexpected error without assets: %v", err)
+	}
+}
+
+func TestPipeline_Sample(t *testing.T) {
+	lib := t.TempDir()
+	writeFiles(t, lib, "library/alice/2024/a.jpg", "library/alice/2024/b.jpg", "library/alice/2023/c.jpg")
+
+	p := testPipeline(lib, "library/alice/2024/a.jpg")
+	p.sample = sampling.NewSelector(1, 1)
+	res, err := p.run(context.Background(), discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := res.sample
+	if s == nil {
+		t.Fatal("expected sample counts")
+	}
+	if s.directories != 2 || s.sampled != 2 {
+		t.Errorf("expected 2 of 2 directories sampled, got %d of %d", s.sampled, s.directories)
+	}
+	if s.files["library/alice/2024"] != 2 || s.untracked["library/alice/2024"] != 1 || s.untracked["library/alice/2023"] != 1 {
+		t.Errorf("unexpected per-directory counts: files %v, untracked %v", s.files, s.untracked)
+	}
+
+	// A sample that picks no directory finds nothing, which is no sign of
+	// an empty mount.
+	p = testPipeline(lib, "library/alice/2024/a.jpg")
+	p.sample = sampling.NewSelector(1e-9, 1)
+	if res, err := p.run(context.Background(), discardLogger()); err != nil || res.filesScanned != 0 {
+		t.Errorf("run() = %+v, %v, want an empty sample", res, err)
+	}
+}
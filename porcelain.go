@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/goeland86/immich-stray-finder/matcher"
+	"github.com/goeland86/immich-stray-finder/mover"
+)
+
+// porcelainVersion is bumped whenever the porcelain format changes in a
+// way a parser could notice.
+const porcelainVersion = 1
+
+// Actions recorded per stray for the porcelain output's second status
+// column.
+const (
+	actionNone     = '.'
+	actionMoved    = 'M'
+	actionCopied   = 'C'
+	actionLinked   = 'L'
+	actionArchived = 'A'
+	actionSuspect  = 'X'
+	actionDeleted  = 'D'
+	actionBackups  = 'B'
+	actionVanished = '!'
+	actionSkipped  = 'S'
+	actionFailed   = 'F'
+	actionRelinked = 'R'
+)
+
+// recordMoves fills res.actions from what the mover did.
+func recordMoves(res *runResult, sum *mover.Summary) {
+	if sum == nil {
+		return
+	}
+	for _, e := range sum.Entries {
+		switch e.Action {
+		case mover.ActionMoved:
+			if e.Suspicious != "" {
+				res.setAction(e.Source, actionSuspect)
+			} else {
+				res.setAction(e.Source, actionMoved)
+			}
+		case mover.ActionCopied:
+			res.setAction(e.Source, actionCopied)
+		case mover.ActionLinked:
+			res.setAction(e.Source, actionLinked)
+		case mover.ActionArchived:
+			res.setAction(e.Source, actionArchived)
+		case mover.ActionDeduplicated, mover.ActionImmichDuplicate, mover.ActionDeleted:
+			res.setAction(e.Source, actionDeleted)
+		case mover.ActionRelinked:
+			res.setAction(e.Source, actionRelinked)
+		}
+	}
+	for _, p := range sum.Skipped {
+		res.setAction(p, actionSkipped)
+	}
+	for _, p := range sum.Changed {
+		res.setAction(p, actionSkipped)
+	}
+	for _, p := range sum.AlreadyLinked {
+		res.setAction(p, actionLinked)
+	}
+	for _, p := range sum.Vanished {
+		res.setAction(p, actionVanished)
+	}
+	for _, f := range sum.Failed {
+		res.setAction(f.RelPath, actionFailed)
+	}
+}
+
+// setAction records what was done to the stray at relPath.
+func (r *runResult) setAction(relPath string, action byte) {
+	if r.actions == nil {
+		r.actions = make(map[string]byte)
+	}
+	r.actions[relPath] = action
+}
+
+// writePorcelain writes the stable, line-oriented result format selected
+// by --porcelain. See "Porcelain Output" in the README for the contract.
+func writePorcelain(w io.Writer, res *runResult, cfg *config) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# porcelain v%d\n", porcelainVersion)
+	fmt.Fprintf(bw, "# mode %s\n", runMode(cfg))
+	fmt.Fprintf(bw, "# scanned %d\n", res.filesScanned)
+	fmt.Fprintf(bw, "# untracked %d\n", len(res.untracked))
+	if res.sample != nil {
+		fmt.Fprintf(bw, "# sample %s %d\n", strconv.FormatFloat(cfg.sample, 'g', -1, 64), cfg.sampleSeed)
+	}
+	writePorcelainFiles(bw, res.untracked, res.actions)
+	return bw.Flush()
+}
+
+// writePorcelainFiles writes one porcelain record per untracked file.
+// Files without an entry in actions get actionNone.
+func writePorcelainFiles(w io.Writer, untracked []matcher.UntrackedFile, actions map[string]byte) {
+	for _, u := range untracked {
+		finding := byte('?')
+		other := ""
+		switch {
+		case u.DuplicateOf != "":
+			finding, other = '=', u.DuplicateOf
+		case u.MissingOriginalOf != "":
+			finding, other = '>', u.MissingOriginalOf
+		case u.CopyOf != "":
+			finding, other = '*', u.CopyOf
+		case u.ProbablyTrackedAs != "":
+			finding, other = '~', u.ProbablyTrackedAs
+		case u.SidecarOf != "":
+			finding, other = '+', u.SidecarOf
+		}
+		action, ok := actions[u.RelPath]
+		if !ok {
+			action = actionNone
+		}
+		fmt.Fprintf(w, "%c%c\t%d\t%s\t%s", finding, action, u.Size, u.Category, porcelainPath(u.RelPath))
+		if other != "" {
+			fmt.Fprintf(w, "\t%s", porcelainPath(other))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// porcelainPath quotes p as a Go string literal when it contains a tab,
+// newline, double quote, backslash, or other control character, so every
+// record stays on one line. Other paths are written verbatim.
+func porcelainPath(p string) string {
+	if strings.ContainsFunc(p, func(r rune) bool { return r < 0x20 || r == 0x7f || r == '"' || r == '\\' }) {
+		return strconv.Quote(p)
+	}
+	return p
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os/exec"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/probe"
+	"github.com/goeland86/immich-stray-finder/tracing"
+)
+
+// ffprobeTimeout bounds probing a single stray video.
+const ffprobeTimeout = time.Minute
+
+// probeStrays sets Probe on the original and unmanaged strays in res that
+// are images, or videos when ffprobe is installed, to whether they still
+// decode. Corrupt strays are rarely worth rescuing.
+func probeStrays(ctx context.Context, cfg *config, res *runResult, logger *slog.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, "probe")
+	defer span.EndErr(&err)
+	prober := &probe.Prober{Timeout: ffprobeTimeout}
+	if prober.FFprobe, err = exec.LookPath(cfg.ffprobe); err != nil {
+		logger.Info("ffprobe not found, stray videos are not probed", "ffprobe", cfg.ffprobe)
+		prober.FFprobe, err = "", nil
+	}
+	probed, corrupt := 0, 0
+	for i := range res.untracked {
+		u := &res.untracked[i]
+		if !mayBeCopy(u) || !prober.Supported(u.RelPath) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		status, reason, err := prober.Check(ctx, cfg.libraryFile(u.RelPath))
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Vanished or unreadable; the move reports it if it matters.
+			logger.Warn("cannot probe stray", "path", u.RelPath, "error", err)
+			continue
+		}
+		probed++
+		u.Probe, u.ProbeError = string(status), reason
+		if status == probe.Corrupt {
+			corrupt++
+			logger.Debug("stray does not decode", "path", u.RelPath, "reason", reason)
+		}
+	}
+	span.SetAttrs("probed", probed, "corrupt", corrupt)
+	logger.Info("probed strays for corruption", "probed", probed, "corrupt", corrupt)
+	return nil
+}
@@ -0,0 +1,205 @@
+// Package probe checks whether stray images and videos still decode, to
+// tell files worth rescuing from the broken leftovers of failed uploads
+// and interrupted copies.
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Status is the outcome of probing a file.
+type Status string
+
+const (
+	// OK means the file decoded.
+	OK Status = "ok"
+	// Corrupt means the file did not decode.
+	Corrupt Status = "corrupt"
+)
+
+// maxPixels bounds the images fully decoded. Larger ones are only checked
+// up to their header, so probing does not take gigabytes of memory.
+const maxPixels = 200 << 20
+
+var (
+	imageExts = []string{".jpg", ".jpeg", ".jpe", ".png", ".gif"}
+	videoExts = []string{".mp4", ".mov", ".m4v", ".3gp", ".mkv", ".webm", ".avi", ".mts", ".m2ts", ".mpg", ".mpeg", ".wmv"}
+)
+
+// Prober checks files by their extension: JPEG, PNG, and GIF images are
+// decoded, WebP images have their container checked, and videos are read
+// by ffprobe.
+type Prober struct {
+	// FFprobe is the ffprobe binary videos are checked with. Empty skips
+	// videos.
+	FFprobe string
+	// Timeout bounds a single ffprobe invocation. Zero means no limit.
+	Timeout time.Duration
+}
+
+// Supported reports whether Check can probe files with the name of path.
+func (p *Prober) Supported(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return slices.Contains(imageExts, ext) || ext == ".webp" || p.FFprobe != "" && slices.Contains(videoExts, ext)
+}
+
+// Check probes the file at path. It returns the empty status for files it
+// cannot probe, and for corrupt files why they did not decode. The error is
+// only set when the file could not be read or ffprobe could not be run.
+func (p *Prober) Check(ctx context.Context, path string) (status Status, reason string, err error) {
+	if !p.Supported(path) {
+		return "", "", nil
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	if slices.Contains(videoExts, ext) {
+		return p.checkVideo(ctx, path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	if ext == ".webp" {
+		err = checkWebP(f)
+	} else {
+		err = checkImage(f)
+	}
+	var readErr *os.PathError
+	if errors.As(err, &readErr) {
+		return "", "", err
+	}
+	if err != nil {
+		return Corrupt, err.Error(), nil
+	}
+	return OK, "", nil
+}
+
+// checkImage decodes the image read from f, or only its header if it is
+// larger than maxPixels.
+func checkImage(f *os.File) error {
+	cfg, _, err := image.DecodeConfig(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, _, err = image.Decode(bufio.NewReader(f))
+	return err
+}
+
+// checkWebP checks the RIFF container of the WebP image read from f: the
+// chunks must fit in the file and the first must hold a VP8, VP8L, or VP8X
+// bitstream header. The image data itself is not decoded.
+func checkWebP(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var hdr [12]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil {
+		return errors.New("webp: missing RIFF header")
+	}
+	if string(hdr[0:4]) != "RIFF" || string(hdr[8:12]) != "WEBP" {
+		return errors.New("webp: not a RIFF WEBP file")
+	}
+	end := int64(binary.LittleEndian.Uint32(hdr[4:8])) + 8
+	if end > info.Size() {
+		return fmt.Errorf("webp: truncated, %d of %d bytes", info.Size(), end)
+	}
+	for off, first := int64(12), true; off < end; first = false {
+		var chunk [8]byte
+		if _, err := f.ReadAt(chunk[:], off); err != nil {
+			return fmt.Errorf("webp: truncated chunk header at %d", off)
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		if off+8+size > end {
+			return fmt.Errorf("webp: chunk %q at %d overruns the file", chunk[0:4], off)
+		}
+		if first {
+			if err := checkWebPBitstream(f, string(chunk[0:4]), off+8, size); err != nil {
+				return err
+			}
+		}
+		off += 8 + size + size&1
+	}
+	return nil
+}
+
+// checkWebPBitstream checks the signature at the start of the first chunk
+// of a WebP image.
+func checkWebPBitstream(f *os.File, fourCC string, off, size int64) error {
+	var sig [6]byte
+	n, _ := f.ReadAt(sig[:min(size, int64(len(sig)))], off)
+	switch fourCC {
+	case "VP8 ":
+		if n < 6 || !bytes.Equal(sig[3:6], []byte{0x9d, 0x01, 0x2a}) {
+			return errors.New("webp: invalid VP8 frame header")
+		}
+	case "VP8L":
+		if n < 1 || sig[0] != 0x2f {
+			return errors.New("webp: invalid VP8L signature")
+		}
+	case "VP8X":
+		if n < 1 {
+			return errors.New("webp: empty VP8X header")
+		}
+	default:
+		return fmt.Errorf("webp: unexpected first chunk %q", fourCC)
+	}
+	return nil
+}
+
+// checkVideo runs ffprobe on path. Any error it prints, like a missing moov
+// atom, marks the video corrupt.
+func (p *Prober) checkVideo(parent context.Context, path string) (Status, string, error) {
+	ctx := parent
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(parent, p.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, p.FFprobe, "-v", "error", "-show_entries", "format=duration", "-of", "csv=p=0", path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	if err := parent.Err(); err != nil {
+		return "", "", err
+	}
+	if ctx.Err() != nil {
+		return Corrupt, fmt.Sprintf("ffprobe timed out after %s", p.Timeout), nil
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return "", "", fmt.Errorf("run %s: %w", p.FFprobe, err)
+	}
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return Corrupt, line, nil
+		}
+	}
+	if exitErr != nil {
+		return Corrupt, exitErr.ProcessState.String(), nil
+	}
+	return OK, "", nil
+}
@@ -0,0 +1,89 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func webp(chunk string, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(4+8+len(data)))
+	b.WriteString("WEBP" + chunk)
+	binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	return b.Bytes()
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	var jpg, pngData bytes.Buffer
+	jpeg.Encode(&jpg, img, nil)
+	png.Encode(&pngData, img)
+	lossless := webp("VP8L", []byte{0x2f, 0, 0, 0, 0})
+
+	files := map[string][]byte{
+		"ok.jpg":         jpg.Bytes(),
+		"truncated.jpg":  jpg.Bytes()[:jpg.Len()/2],
+		"ok.png":         pngData.Bytes(),
+		"garbage.png":    []byte("not an image"),
+		"ok.webp":        lossless,
+		"truncated.webp": lossless[:len(lossless)-2],
+		"bad.webp":       webp("VP8L", []byte{0, 0, 0, 0, 0}),
+		"notes.txt":      []byte("hello"),
+	}
+	want := map[string]Status{
+		"ok.jpg": OK, "truncated.jpg": Corrupt, "ok.png": OK, "garbage.png": Corrupt,
+		"ok.webp": OK, "truncated.webp": Corrupt, "bad.webp": Corrupt, "notes.txt": "",
+	}
+	p := &Prober{}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, data, 0o644)
+		status, reason, err := p.Check(context.Background(), path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		if status != want[name] {
+			t.Errorf("%s: expected %q, got %q (%s)", name, want[name], status, reason)
+		}
+		if status == Corrupt && reason == "" {
+			t.Errorf("%s: expected a reason", name)
+		}
+	}
+}
+
+func TestCheck_VideoWithFFprobe(t *testing.T) {
+	dir := t.TempDir()
+	ffprobe := filepath.Join(dir, "ffprobe")
+	// Stands in for ffprobe, rejecting files named bad.*.
+	script := "#!/bin/sh\nfor a; do f=$a; done\ncase $(basename \"$f\") in bad.*) echo \"$f: moov atom not found\" >&2; exit 1;; esac\n"
+	if err := os.WriteFile(ffprobe, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"good.mp4", "bad.mov"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("video"), 0o644)
+	}
+
+	if (&Prober{}).Supported("good.mp4") {
+		t.Error("videos should not be supported without ffprobe")
+	}
+	p := &Prober{FFprobe: ffprobe}
+	if status, _, err := p.Check(context.Background(), filepath.Join(dir, "good.mp4")); err != nil || status != OK {
+		t.Errorf("good.mp4: expected ok, got %q, %v", status, err)
+	}
+	status, reason, err := p.Check(context.Background(), filepath.Join(dir, "bad.mov"))
+	if err != nil || status != Corrupt || !strings.Contains(reason, "moov atom not found") {
+		t.Errorf("bad.mov: expected corrupt with ffprobe's error, got %q, %q, %v", status, reason, err)
+	}
+}
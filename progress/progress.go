@@ -0,0 +1,350 @@
+// Package progress draws progress bars for the phases of a run on a
+// terminal, or logs their progress periodically. Like tracing, it is
+// process-wide and off until Start or StartLog is called: Track then
+// returns nil, and the methods of a nil *Bar do nothing, so instrumented
+// code needs no checks.
+package progress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// interval is how often the bars are redrawn.
+const interval = 200 * time.Millisecond
+
+// barWidth is the number of cells of a bar.
+const barWidth = 24
+
+var (
+	mu      sync.Mutex
+	current sink
+)
+
+// sink shows the bars: display on a terminal, or logDisplay in the logs.
+type sink interface {
+	add(b *Bar)
+	// close shows the bars one last time and stops.
+	close()
+}
+
+// Bar tracks the progress of one phase, e.g. walking the library.
+type Bar struct {
+	label, unit string
+	started     time.Time
+	total       atomic.Int64
+	n           atomic.Int64
+	// ended is the time the phase finished, in Unix nanoseconds, or 0.
+	ended atomic.Int64
+}
+
+// Track adds a bar for a phase counting unit, e.g. "files", towards total,
+// or 0 when the total is unknown. It returns nil when progress is off.
+func Track(label, unit string, total int64) *Bar {
+	mu.Lock()
+	d := current
+	mu.Unlock()
+	if d == nil {
+		return nil
+	}
+	b := &Bar{label: label, unit: unit, started: time.Now()}
+	b.total.Store(total)
+	d.add(b)
+	return b
+}
+
+// Add counts n more units done.
+func (b *Bar) Add(n int) {
+	if b != nil {
+		b.n.Add(int64(n))
+	}
+}
+
+// SetTotal sets the number of units the phase will take, once it is known.
+func (b *Bar) SetTotal(total int64) {
+	if b != nil {
+		b.total.Store(total)
+	}
+}
+
+// Finish marks the phase done. A finished bar is drawn once more and then
+// left in place above the bars still running.
+func (b *Bar) Finish() {
+	if b != nil {
+		b.ended.CompareAndSwap(0, time.Now().UnixNano())
+	}
+}
+
+func (b *Bar) finished() bool { return b.ended.Load() != 0 }
+
+// line renders b as of now.
+func (b *Bar) line(now time.Time) string {
+	n, total := b.n.Load(), b.total.Load()
+	if end := b.ended.Load(); end != 0 {
+		now = time.Unix(0, end)
+		elapsed := now.Sub(b.started)
+		return fmt.Sprintf("%-18s done: %s %s in %s", b.label, count(n), b.unit, formatDuration(elapsed))
+	}
+	elapsed := now.Sub(b.started)
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(n) / elapsed.Seconds()
+	}
+	if total <= 0 {
+		return fmt.Sprintf("%-18s %s %s, %s/s, %s elapsed", b.label, count(n), b.unit, count(int64(rate)), formatDuration(elapsed))
+	}
+	// The total may be an estimate from an earlier run; stay below 100%
+	// until the phase says it is done.
+	frac := min(float64(n)/float64(total), 0.99)
+	filled := int(frac * barWidth)
+	eta := "ETA --"
+	if rate > 0 && n < total {
+		eta = "ETA " + formatDuration(time.Duration(float64(total-n)/rate*float64(time.Second)))
+	}
+	return fmt.Sprintf("%-18s [%s%s] %3.0f%% %s/%s %s, %s/s, %s", b.label,
+		strings.Repeat("#", filled), strings.Repeat("-", barWidth-filled), frac*100,
+		count(n), count(total), b.unit, count(int64(rate)), eta)
+}
+
+// attrs describes b as of now as log attributes, like line does for a
+// terminal.
+func (b *Bar) attrs(now time.Time) []any {
+	n, total := b.n.Load(), b.total.Load()
+	elapsed := now.Sub(b.started)
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(n) / elapsed.Seconds()
+	}
+	attrs := []any{b.unit, n}
+	if total > 0 {
+		attrs = append(attrs, "total", total, "percent", int(min(float64(n)/float64(total), 0.99)*100))
+	}
+	attrs = append(attrs, "per_second", int64(rate), "elapsed", elapsed.Round(time.Second))
+	if total > 0 && rate > 0 && n < total {
+		attrs = append(attrs, "eta", time.Duration(float64(total-n)/rate*float64(time.Second)).Round(time.Second))
+	}
+	return attrs
+}
+
+// count formats n with thousands separators.
+func count(n int64) string {
+	s := fmt.Sprint(n)
+	for i := len(s) - 3; i > 0 && s[i-1] != '-'; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+// display draws the bars on a terminal. Bars still running are redrawn in
+// place at the bottom; everything else written to the terminal goes
+// through display.Write, which prints it above them.
+type display struct {
+	w    io.Writer
+	mu   sync.Mutex
+	bars []*Bar
+	// drawn is the number of bar lines currently at the bottom.
+	drawn int
+	// partial is set while the last line written has no newline yet; the
+	// bars wait for it so they do not end up in the middle of it.
+	partial bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Start draws bars on w, a terminal, until Stop is called. It returns the
+// writer that all other output to w must go through, so it does not get
+// mixed up with the bars.
+func Start(w io.Writer) io.Writer {
+	d := &display{w: w, stop: make(chan struct{}), done: make(chan struct{})}
+	mu.Lock()
+	current = d
+	mu.Unlock()
+	go func() {
+		defer close(d.done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				d.mu.Lock()
+				d.clear()
+				d.draw(time.Now())
+				d.mu.Unlock()
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+	return d
+}
+
+// Stop draws the bars one last time, leaving them on the terminal, and
+// turns progress off.
+func Stop() {
+	mu.Lock()
+	s := current
+	current = nil
+	mu.Unlock()
+	if s != nil {
+		s.close()
+	}
+}
+
+func (d *display) close() {
+	close(d.stop)
+	<-d.done
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clear()
+	for _, b := range d.bars {
+		b.Finish()
+	}
+	if d.partial {
+		io.WriteString(d.w, "\n")
+		d.partial = false
+	}
+	d.draw(time.Now())
+}
+
+func (d *display) add(b *Bar) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.bars = append(d.bars, b)
+}
+
+// clear erases the bar lines at the bottom of the terminal.
+func (d *display) clear() {
+	if d.drawn > 0 {
+		fmt.Fprintf(d.w, "\x1b[%dA\x1b[J", d.drawn)
+		d.drawn = 0
+	}
+}
+
+// draw prints the bars after clear. Finished bars are printed for the last
+// time and dropped; those still running stay at the bottom.
+func (d *display) draw(now time.Time) {
+	if d.partial {
+		return
+	}
+	var b strings.Builder
+	var running []*Bar
+	for _, bar := range d.bars {
+		if bar.finished() {
+			b.WriteString(bar.line(now) + "\n")
+		} else {
+			running = append(running, bar)
+		}
+	}
+	for _, bar := range running {
+		b.WriteString("\x1b[K" + bar.line(now) + "\n")
+	}
+	io.WriteString(d.w, b.String())
+	d.bars, d.drawn = running, len(running)
+}
+
+// Write prints p above the bars.
+func (d *display) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clear()
+	n, err := d.w.Write(p)
+	if len(p) > 0 {
+		d.partial = p[len(p)-1] != '\n'
+	}
+	d.draw(time.Now())
+	return n, err
+}
+
+// logDisplay logs the bars still running every interval, once they have
+// run that long, so short phases stay out of the logs.
+type logDisplay struct {
+	logger *slog.Logger
+	every  time.Duration
+	mu     sync.Mutex
+	bars   []*Bar
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// StartLog logs the progress of the phases that run longer than every to
+// logger, every so often, until Stop is called. It is for when there is no
+// terminal to draw bars on.
+func StartLog(logger *slog.Logger, every time.Duration) {
+	l := &logDisplay{logger: logger, every: every, stop: make(chan struct{}), done: make(chan struct{})}
+	mu.Lock()
+	current = l
+	mu.Unlock()
+	go func() {
+		defer close(l.done)
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				l.log(now)
+			case <-l.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (l *logDisplay) add(b *Bar) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bars = append(l.bars, b)
+}
+
+// log logs the bars running for at least l.every as of now, and drops the
+// finished ones, whose phases log their own results.
+func (l *logDisplay) log(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	running := l.bars[:0]
+	for _, b := range l.bars {
+		if b.finished() {
+			continue
+		}
+		running = append(running, b)
+		if now.Sub(b.started) >= l.every {
+			l.logger.Info(b.label, b.attrs(now)...)
+		}
+	}
+	clear(l.bars[len(running):])
+	l.bars = running
+}
+
+func (l *logDisplay) close() {
+	close(l.stop)
+	<-l.done
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx that carries b, for code that reports
+// progress several calls down.
+func NewContext(ctx context.Context, b *Bar) context.Context {
+	if b == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxKey{}, b)
+}
+
+// FromContext returns the bar ctx carries, or nil.
+func FromContext(ctx context.Context) *Bar {
+	b, _ := ctx.Value(ctxKey{}).(*Bar)
+	return b
+}
@@ -0,0 +1,118 @@
+package progress
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNilBar(t *testing.T) {
+	b := Track("fetching assets", "assets", 10)
+	if b != nil {
+		t.Fatal("Track returned a bar while progress is off")
+	}
+	b.Add(1)
+	b.SetTotal(5)
+	b.Finish()
+	if FromContext(NewContext(context.Background(), b)) != nil {
+		t.Error("context carries a nil bar")
+	}
+}
+
+func TestLine(t *testing.T) {
+	start := time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC)
+	b := &Bar{label: "scanning files", unit: "files", started: start}
+	b.n.Store(2500)
+
+	if got, want := b.line(start.Add(10*time.Second)), "scanning files     2,500 files, 250/s, 10s elapsed"; got != want {
+		t.Errorf("without total:\n got %q\nwant %q", got, want)
+	}
+	b.total.Store(10000)
+	if got, want := b.line(start.Add(10*time.Second)),
+		"scanning files     [######------------------]  25% 2,500/10,000 files, 250/s, ETA 30s"; got != want {
+		t.Errorf("with total:\n got %q\nwant %q", got, want)
+	}
+	// An estimated total that turns out too low does not reach 100%.
+	b.n.Store(12000)
+	if got := b.line(start.Add(10 * time.Second)); !strings.Contains(got, " 99% 12,000/10,000 files") || !strings.Contains(got, "ETA --") {
+		t.Errorf("past total: %q", got)
+	}
+	b.ended.Store(start.Add(90 * time.Second).UnixNano())
+	if got, want := b.line(start.Add(time.Hour)), "scanning files     done: 12,000 files in 1m30s"; got != want {
+		t.Errorf("finished:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestDisplay(t *testing.T) {
+	var out strings.Builder
+	d := &display{w: &out}
+	fetch := &Bar{label: "fetching assets", unit: "assets", started: time.Now()}
+	scan := &Bar{label: "scanning files", unit: "files", started: time.Now()}
+	d.add(fetch)
+	d.add(scan)
+	d.draw(time.Now())
+	if d.drawn != 2 {
+		t.Fatalf("drawn = %d, want 2", d.drawn)
+	}
+
+	// Output goes above the bars, which are redrawn below it.
+	fetch.Finish()
+	out.Reset()
+	d.Write([]byte("level=INFO msg=\"fetched assets\"\n"))
+	got := out.String()
+	if !strings.HasPrefix(got, "\x1b[2A\x1b[J") {
+		t.Errorf("bars not cleared first: %q", got)
+	}
+	iLog, iFetch, iScan := strings.Index(got, "fetched assets"), strings.Index(got, "fetching assets"), strings.Index(got, "scanning files")
+	if iLog < 0 || iFetch < iLog || iScan < iFetch {
+		t.Errorf("want the log line, the finished bar, then the running one: %q", got)
+	}
+	if len(d.bars) != 1 || d.drawn != 1 {
+		t.Errorf("finished bar still redrawn: %d bars, %d drawn", len(d.bars), d.drawn)
+	}
+
+	// A line written in pieces is not split by the bars.
+	out.Reset()
+	d.Write([]byte("Moved 3 file(s)"))
+	d.Write([]byte(".\n"))
+	if got := out.String(); !strings.Contains(got, "Moved 3 file(s).\n") {
+		t.Errorf("partial line split: %q", got)
+	}
+}
+
+func TestLogDisplay(t *testing.T) {
+	var out strings.Builder
+	start := time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC)
+	l := &logDisplay{logger: slog.New(slog.NewTextHandler(&out, nil)), every: time.Minute}
+	move := &Bar{label: "moving strays", unit: "files", started: start}
+	move.total.Store(80000)
+	move.n.Store(10000)
+	scan := &Bar{label: "scanning files", unit: "files", started: start.Add(30 * time.Second)}
+	done := &Bar{label: "fetching assets", unit: "assets", started: start}
+	done.Finish()
+	l.add(move)
+	l.add(scan)
+	l.add(done)
+
+	l.log(start.Add(2 * time.Minute))
+	got := out.String()
+	if want := `msg="moving strays" files=10000 total=80000 percent=12 per_second=83 elapsed=2m0s eta=14m0s`; !strings.Contains(got, want) {
+		t.Errorf("want %q in %q", want, got)
+	}
+	if !strings.Contains(got, `msg="scanning files" files=0 per_second=0 elapsed=1m30s`) {
+		t.Errorf("phase without total not logged: %q", got)
+	}
+	if strings.Contains(got, "fetching assets") || len(l.bars) != 2 {
+		t.Errorf("finished phase still logged: %q, %d bars", got, len(l.bars))
+	}
+
+	// Phases shorter than the interval are not logged.
+	out.Reset()
+	l.add(&Bar{label: "copying strays", unit: "files", started: start.Add(2 * time.Minute)})
+	l.log(start.Add(150 * time.Second))
+	if strings.Contains(out.String(), "copying strays") {
+		t.Errorf("short phase logged: %q", out.String())
+	}
+}
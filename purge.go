@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/mover"
+	"github.com/goeland86/immich-stray-finder/report"
+)
+
+// cmdPurge deletes quarantined files recorded in the move manifests.
+func cmdPurge(ctx context.Context, args []string) int {
+	var cfg config
+	fs := newFlagSet("purge", &cfg)
+	fs.StringVar(&cfg.runID, "run", "", "Only purge files quarantined by this run ID; defaults to all runs")
+	fs.Func("retention", "Only purge files quarantined longer ago than this, e.g. 30d, 2w, or 36h; defaults to all files", func(s string) error {
+		d, err := parseRetention(s)
+		cfg.retention = d
+		return err
+	})
+	fs.BoolVar(&cfg.dryRun, "dry-run", false, "Only show what would be deleted")
+	addStateDirFlag(fs, &cfg)
+	if ok, code := parseFlags(fs, &cfg, args); !ok {
+		return code
+	}
+	if !resolveTarget(&cfg) {
+		return exitError
+	}
+	if cfg.readOnly {
+		cfg.dryRun = true
+	}
+	if !cfg.dryRun && !openAuditLog(&cfg) {
+		return exitError
+	}
+	defer cfg.audit.Close()
+	applyReadOnly(&cfg)
+	logger := newLogger(&cfg)
+	// Only the mounts of --library-path need the prefix here.
+	if len(cfg.mountSpecs) > 0 {
+		if err := discoverLayout(ctx, &cfg, logger); err != nil {
+			logger.Error("fatal error", "error", err)
+			return exitError
+		}
+	}
+
+	opts := mover.PurgeOptions{
+		LibraryPath: cfg.libraryPath,
+		Mounts:      cfg.mounts,
+		RunID:       cfg.runID,
+		DryRun:      cfg.dryRun,
+		Audit:       cfg.audit,
+	}
+	if cfg.retention > 0 {
+		opts.Before = time.Now().Add(-cfg.retention)
+	}
+	sum, err := mover.Purge(cfg.manifestDir, opts, logger)
+	if sum != nil {
+		verb := "Deleted"
+		if cfg.dryRun {
+			verb = "Would delete"
+		}
+		fmt.Fprintf(stderr, "\n%s %d quarantined file(s), %s.\n", verb, sum.Deleted, report.FormatBytes(sum.Bytes))
+		if sum.Kept > 0 {
+			fmt.Fprintf(stderr, "Kept %d file(s) quarantined within the last %s.\n", sum.Kept, formatRetention(cfg.retention))
+		}
+		if sum.Changed > 0 {
+			fmt.Fprintf(stderr, "Left %d file(s) that no longer match their manifest entry; see the warnings above.\n", sum.Changed)
+		}
+	}
+	if err != nil {
+		logger.Error("fatal error", "error", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// parseRetention parses a retention period, see parseAge, which must be
+// positive.
+func parseRetention(s string) (time.Duration, error) {
+	d, err := parseAge(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid retention %q: want e.g. 30d, 2w, or 36h", s)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid retention %q: must be positive", s)
+	}
+	return d, nil
+}
+
+// parseAge parses a number of days (30d) or weeks (2w), or any duration
+// time.ParseDuration accepts.
+func parseAge(s string) (time.Duration, error) {
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		return parseDays(n, 1)
+	}
+	if n, ok := strings.CutSuffix(s, "w"); ok {
+		return parseDays(n, 7)
+	}
+	return time.ParseDuration(s)
+}
+
+func parseDays(s string, unit int) (time.Duration, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(n*unit) * 24 * time.Hour, nil
+}
+
+// formatRetention renders whole days as such, and anything else as a
+// duration.
+func formatRetention(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return strconv.Itoa(int(d/(24*time.Hour))) + "d"
+	}
+	return d.String()
+}
@@ -0,0 +1,36 @@
+// Package readonly provides a process-wide lock that hard-disables every code
+// path able to modify the library, the quarantine, or Immich itself. Once
+// enabled it cannot be turned off again for the lifetime of the process, so
+// a run started with auditor credentials can never write anything.
+package readonly
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrReadOnly is returned by Check when the read-only lock is engaged.
+var ErrReadOnly = errors.New("refusing to write: read-only mode is enabled")
+
+var enabled atomic.Bool
+
+// Enable engages the read-only lock. There is deliberately no Disable.
+func Enable() {
+	enabled.Store(true)
+}
+
+// Enabled reports whether the read-only lock is engaged.
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Check must be called by every writing code path before it touches the
+// filesystem or issues a mutating API call. action describes the write for
+// the error message (e.g. "move file").
+func Check(action string) error {
+	if enabled.Load() {
+		return fmt.Errorf("%s: %w", action, ErrReadOnly)
+	}
+	return nil
+}
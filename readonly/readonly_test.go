@@ -0,0 +1,24 @@
+package readonly
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	enabled.Store(false)
+	t.Cleanup(func() { enabled.Store(false) })
+
+	if err := Check("move file"); err != nil {
+		t.Fatalf("unexpected error before Enable: %v", err)
+	}
+
+	Enable()
+	if !Enabled() {
+		t.Fatal("expected lock to be enabled")
+	}
+	err := Check("move file")
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+// Package redact keeps secrets out of the logs. A Redactor wraps the
+// process's slog handler, so every package that logs through the logger
+// it is given is covered without having to remember which values are
+// sensitive.
+package redact
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces redacted values.
+const Mask = "***"
+
+// DefaultKeys are the attribute keys whose values are always masked,
+// compared case-insensitively.
+var DefaultKeys = []string{
+	"api_key", "apikey", "x-api-key", "authorization", "password", "secret", "token", "email",
+}
+
+// minSecret is the shortest secret masked wherever it appears; shorter
+// ones would mask unrelated text.
+const minSecret = 4
+
+var (
+	// urlPassword matches the password of a URL's user info.
+	urlPassword = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^:/@\s]*:)[^@\s]*@`)
+	// dsnPassword matches the password of a key/value connection string.
+	dsnPassword = regexp.MustCompile(`(?i)(\bpassword\s*=\s*)('[^']*'|\S+)`)
+)
+
+// Redactor masks secrets in log records: the values of sensitive
+// attribute keys (such as email), registered secret values, and passwords
+// in URLs and connection strings. Email addresses in free text are left
+// alone, since file names like icon@2x.png look just like them.
+type Redactor struct {
+	keys    map[string]bool
+	secrets []string
+}
+
+// New returns a Redactor masking the values of DefaultKeys and of keys.
+func New(keys ...string) *Redactor {
+	r := &Redactor{keys: make(map[string]bool)}
+	for _, k := range append(DefaultKeys, keys...) {
+		if k = strings.TrimSpace(k); k != "" {
+			r.keys[strings.ToLower(k)] = true
+		}
+	}
+	return r
+}
+
+// AddSecret masks s wherever it appears, e.g. the API key in an error
+// message that echoes a request.
+func (r *Redactor) AddSecret(s string) {
+	if len(s) >= minSecret {
+		r.secrets = append(r.secrets, s)
+	}
+}
+
+// AddURLSecrets registers the password of a URL or connection string, so
+// it is masked even where it appears on its own.
+func (r *Redactor) AddURLSecrets(raw string) {
+	if u, err := url.Parse(raw); err == nil && u.User != nil {
+		if p, ok := u.User.Password(); ok {
+			r.AddSecret(p)
+		}
+	}
+	for _, m := range dsnPassword.FindAllStringSubmatch(raw, -1) {
+		r.AddSecret(strings.Trim(m[2], "'"))
+	}
+}
+
+// String masks the secrets in s.
+func (r *Redactor) String(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, Mask)
+	}
+	return URL(s)
+}
+
+// URL masks the password of a URL or connection string in s. It needs no
+// registered secrets, so it also suits values that are hashed or printed
+// on purpose, like the database URL in the config hash.
+func URL(s string) string {
+	s = urlPassword.ReplaceAllString(s, "${1}"+Mask+"@")
+	return dsnPassword.ReplaceAllString(s, "${1}"+Mask)
+}
+
+// Attr returns a with its value redacted.
+func (r *Redactor) Attr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	if r.keys[strings.ToLower(a.Key)] && v.Kind() != slog.KindGroup {
+		if v.Kind() == slog.KindString && v.String() == "" {
+			return a
+		}
+		return slog.String(a.Key, Mask)
+	}
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, r.String(v.String()))
+	case slog.KindGroup:
+		attrs := v.Group()
+		out := make([]slog.Attr, len(attrs))
+		for i, ga := range attrs {
+			out[i] = r.Attr(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(out...)}
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case error:
+			return slog.String(a.Key, r.String(x.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, r.String(x.String()))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// Handler wraps h so that every record it handles is redacted first.
+func (r *Redactor) Handler(h slog.Handler) slog.Handler {
+	return &handler{r: r, next: h}
+}
+
+type handler struct {
+	r    *Redactor
+	next slog.Handler
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *handler) Handle(ctx context.Context, rec slog.Record) error {
+	out := slog.NewRecord(rec.Time, rec.Level, h.r.String(rec.Message), rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.r.Attr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = h.r.Attr(a)
+	}
+	return &handler{r: h.r, next: h.next.WithAttrs(out)}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{r: h.r, next: h.next.WithGroup(name)}
+}
@@ -0,0 +1,65 @@
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestURL(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"postgres://user:s3cret@db:5432/immich", "postgres://user:***@db:5432/immich"},
+		{"postgres://user@db/immich", "postgres://user@db/immich"},
+		{"host=db user=immich password=s3cret dbname=immich", "host=db user=immich password=*** dbname=immich"},
+		{"host=db password='two words'", "host=db password=***"},
+		{"http://pushgateway:9091", "http://pushgateway:9091"},
+	} {
+		if got := URL(tt.in); got != tt.want {
+			t.Errorf("URL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestString_KeepsFileNames(t *testing.T) {
+	got := New().String("stray detected: library/admin/icon@2x.png")
+	if got != "stray detected: library/admin/icon@2x.png" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	r := New("session")
+	r.AddSecret("abcd1234key")
+	r.AddURLSecrets("postgres://immich:hunter22@db/immich")
+	logger := slog.New(r.Handler(slog.NewTextHandler(&buf, nil)))
+
+	logger.With("api_key", "abcd1234key").Info("request to http://immich failed: key abcd1234key rejected",
+		"error", errors.New("connect postgres://immich:hunter22@db/immich: refused"),
+		"session", "xyz",
+		"email", "bob@example.org",
+		slog.Group("db", "password", "hunter22", "host", "db"),
+		"count", 3,
+	)
+	out := buf.String()
+	for _, leak := range []string{"abcd1234key", "hunter22", "xyz", "bob@"} {
+		if strings.Contains(out, leak) {
+			t.Errorf("log leaks %q: %s", leak, out)
+		}
+	}
+	for _, keep := range []string{"count=3", "db.host=db", "email=***", "immich:***@db"} {
+		if !strings.Contains(out, keep) {
+			t.Errorf("log lost %q: %s", keep, out)
+		}
+	}
+}
+
+func TestAddSecret_IgnoresShortValues(t *testing.T) {
+	r := New()
+	r.AddSecret("ab")
+	if got := r.String("abc"); got != "abc" {
+		t.Errorf("short secret masked: %q", got)
+	}
+}
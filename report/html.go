@@ -0,0 +1,232 @@
+package report
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	_ "image/gif" // register decoders for previews
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/goeland86/immich-stray-finder/paths"
+)
+
+// HTMLOptions controls the HTML report.
+type HTMLOptions struct {
+	// LibraryPath is the library root the report's paths are relative to.
+	// Previews are decoded from the files found there.
+	LibraryPath string
+	// Mounts are the directories of the library kept outside LibraryPath.
+	Mounts []paths.Mount
+	// ThumbSize bounds the longer edge of a preview in pixels.
+	ThumbSize int
+	// MaxPreviews caps how many previews are embedded, to keep the report a
+	// manageable size. Zero means no previews.
+	MaxPreviews int
+}
+
+// maxPreviewPixels skips decoding images larger than this, so a crafted or
+// enormous file cannot exhaust memory.
+const maxPreviewPixels = 100_000_000
+
+// previewExts lists the extensions the standard library can decode.
+var previewExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+type htmlFile struct {
+	File
+	SizeText string
+	Preview  template.URL
+}
+
+// WriteHTML renders r as a self-contained HTML page to w. Image files are
+// shown with inline thumbnails, decoded locally from opts.LibraryPath, so
+// the strays can be checked visually before they are quarantined.
+func WriteHTML(w io.Writer, r *Report, opts HTMLOptions) error {
+	files := make([]htmlFile, len(r.Files))
+	previews := 0
+	for i, f := range r.Files {
+		files[i] = htmlFile{File: f, SizeText: FormatBytes(f.Size)}
+		if previews >= opts.MaxPreviews || !previewExts[strings.ToLower(path.Ext(f.Path))] {
+			continue
+		}
+		uri, err := previewURI(paths.Join(opts.LibraryPath, opts.Mounts, f.Path), opts.ThumbSize)
+		if err != nil {
+			// The file may be corrupt or gone; the listing is still useful.
+			continue
+		}
+		files[i].Preview = uri
+		previews++
+	}
+
+	data := struct {
+		*Report
+		BytesText string
+		Files     []htmlFile
+	}{r, FormatBytes(r.UntrackedBytes), files}
+	if err := htmlTemplate.Execute(w, data); err != nil {
+		return fmt.Errorf("render HTML report: %w", err)
+	}
+	return nil
+}
+
+// WriteHTMLFile writes the HTML report to path.
+func WriteHTMLFile(path string, r *Report, opts HTMLOptions) error {
+	var buf bytes.Buffer
+	if err := WriteHTML(&buf, r, opts); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("write HTML report: %w", err)
+	}
+	return nil
+}
+
+// previewURI returns Preview(file, size) as a data URI.
+func previewURI(file string, size int) (template.URL, error) {
+	data, err := Preview(file, size)
+	if err != nil {
+		return "", err
+	}
+	return template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(data)), nil
+}
+
+// Preview decodes the image at file and returns a JPEG thumbnail whose
+// longer edge is at most size pixels. Only formats registered with the
+// image package (JPEG, PNG, GIF) can be previewed.
+func Preview(file string, size int) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	// In int64: the product overflows int on 32-bit platforms.
+	if int64(cfg.Width)*int64(cfg.Height) > maxPreviewPixels {
+		return nil, fmt.Errorf("image too large to preview: %dx%d", cfg.Width, cfg.Height)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumbnail(img, size), &jpeg.Options{Quality: 75}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// thumbnail downscales img so its longer edge is at most size pixels, by
+// averaging the source pixels that fall into each destination pixel.
+func thumbnail(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	tw, th := size, muldiv(h, size, w)
+	if h > w {
+		tw, th = muldiv(w, size, h), size
+	}
+	tw, th = max(tw, 1), max(th, 1)
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+muldiv(y, h, th), b.Min.Y+max(muldiv(y+1, h, th), muldiv(y, h, th)+1)
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+muldiv(x, w, tw), b.Min.X+max(muldiv(x+1, w, tw), muldiv(x, w, tw)+1)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// muldiv returns a*b/c without overflowing int on 32-bit platforms, where
+// a pixel coordinate times an image dimension can exceed 2^31.
+func muldiv(a, b, c int) int {
+	return int(int64(a) * int64(b) / int64(c))
+}
+
+// FormatBytes renders n with a binary unit suffix, e.g. "1.5 GiB".
+func FormatBytes(n int64) string {
+	const unit = 1024
+	sign := ""
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	if n < unit {
+		return fmt.Sprintf("%s%d B", sign, n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%s%.1f %ciB", sign, float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Immich stray report</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 4px 8px; text-align: left; vertical-align: middle; }
+td.num { text-align: right; white-space: nowrap; }
+td.path { font-family: monospace; word-break: break-all; }
+img { max-width: 160px; max-height: 160px; display: block; }
+.note { color: #a60; }
+</style>
+</head>
+<body>
+<h1>Immich stray report</h1>
+<p>Generated {{.GeneratedAt.Format "2006-01-02 15:04:05 MST"}} by immich-stray-finder {{.Version}} ({{.Mode}}).
+{{.Untracked}} untracked file(s), {{.BytesText}}, out of {{.FilesScanned}} scanned.</p>
+{{- if .OrphanUserDirs}}
+<p>Directories of users no longer in Immich, whose files are all listed below:</p>
+<ul>
+{{- range .OrphanUserDirs}}
+<li><code>{{.Path}}/</code>: {{.Files}} file(s)</li>
+{{- end}}
+</ul>
+{{- end}}
+<table>
+<thead><tr><th>Preview</th><th>Path</th><th>Category</th><th>Size</th><th>Modified</th></tr></thead>
+<tbody>
+{{- range .Files}}
+<tr>
+<td>{{if .Preview}}<img src="{{.Preview}}" alt="" loading="lazy">{{end}}</td>
+<td class="path">{{.Path}}{{if .ProbablyTrackedAs}}<br><span class="note">probably tracked as {{.ProbablyTrackedAs}}</span>{{end}}{{if .DuplicateOf}}<br><span class="note">identical to {{.DuplicateOf}}, an Immich asset</span>{{end}}{{if .MissingOriginalOf}}<br><span class="note">content of {{.MissingOriginalOf}}, an Immich asset missing from disk</span>{{end}}{{if .CopyOf}}<br><span class="note">copy of {{.CopyOf}}, also a stray</span>{{end}}{{if .SidecarOf}}<br><span class="note">sidecar of {{.SidecarOf}}, an Immich asset</span>{{end}}{{if .TakeoutOf}}<br><span class="note">Google Takeout leftover of {{.TakeoutOf}}, an Immich asset</span>{{end}}{{if .CompanionOf}}<br><span class="note">companion of {{.CompanionOf}}, an Immich asset</span>{{end}}{{if eq .Probe "corrupt"}}<br><span class="note">corrupt: {{.ProbeError}}</span>{{else if .Probe}}<br><span class="note">decodes OK</span>{{end}}</td>
+<td>{{.Category}}</td>
+<td class="num">{{.SizeText}}</td>
+<td class="num">{{.ModTime.Format "2006-01-02 15:04"}}</td>
+</tr>
+{{- end}}
+</tbody>
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,89 @@
+package report
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goeland86/immich-stray-finder/matcher"
+	"github.com/goeland86/immich-stray-finder/paths"
+)
+
+func TestWriteHTML_EmbedsPreviews(t *testing.T) {
+	lib := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			img.Set(x, y, color.RGBA{200, 10, 10, 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	os.WriteFile(filepath.Join(lib, "photo.png"), buf.Bytes(), 0o644)
+	os.WriteFile(filepath.Join(lib, "broken.jpg"), []byte("not a jpeg"), 0o644)
+	os.WriteFile(filepath.Join(lib, "<notes>.txt"), []byte("x"), 0o644)
+
+	r := New("v1", "dry-run", 3, []matcher.UntrackedFile{
+		{RelPath: "photo.png", Size: int64(buf.Len())},
+		{RelPath: "broken.jpg"},
+		{RelPath: "<notes>.txt"},
+	})
+
+	var out bytes.Buffer
+	if err := WriteHTML(&out, r, HTMLOptions{LibraryPath: lib, ThumbSize: 64, MaxPreviews: 10}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	html := out.String()
+	if got := strings.Count(html, `src="data:image/jpeg;base64,`); got != 1 {
+		t.Errorf("expected 1 embedded preview, got %d", got)
+	}
+	if strings.Contains(html, "<notes>") || !strings.Contains(html, "&lt;notes&gt;.txt") {
+		t.Error("expected paths to be HTML-escaped")
+	}
+}
+
+func TestWriteHTML_MaxPreviews(t *testing.T) {
+	lib := t.TempDir()
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+	os.WriteFile(filepath.Join(lib, "a.png"), buf.Bytes(), 0o644)
+	os.WriteFile(filepath.Join(lib, "b.png"), buf.Bytes(), 0o644)
+
+	r := New("v1", "dry-run", 2, []matcher.UntrackedFile{{RelPath: "a.png"}, {RelPath: "b.png"}})
+	var out bytes.Buffer
+	WriteHTML(&out, r, HTMLOptions{LibraryPath: lib, ThumbSize: 64, MaxPreviews: 1})
+	if got := strings.Count(out.String(), "data:image/jpeg"); got != 1 {
+		t.Errorf("expected previews capped at 1, got %d", got)
+	}
+}
+
+func TestWriteHTML_Mounts(t *testing.T) {
+	lib, thumbs := t.TempDir(), t.TempDir()
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+	os.MkdirAll(filepath.Join(thumbs, "u1"), 0o755)
+	os.WriteFile(filepath.Join(thumbs, "u1", "a.png"), buf.Bytes(), 0o644)
+
+	r := New("v1", "dry-run", 1, []matcher.UntrackedFile{{RelPath: "thumbs/u1/a.png"}})
+	var out bytes.Buffer
+	WriteHTML(&out, r, HTMLOptions{LibraryPath: lib, Mounts: []paths.Mount{{Rel: "thumbs", Dir: thumbs}}, ThumbSize: 64, MaxPreviews: 10})
+	if got := strings.Count(out.String(), "data:image/jpeg"); got != 1 {
+		t.Errorf("expected the preview decoded from the mount, got %d", got)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	got := thumbnail(image.NewRGBA(image.Rect(0, 0, 1000, 250)), 100).Bounds()
+	if got.Dx() != 100 || got.Dy() != 25 {
+		t.Errorf("expected 100x25, got %dx%d", got.Dx(), got.Dy())
+	}
+	got = thumbnail(image.NewRGBA(image.Rect(0, 0, 50, 40)), 100).Bounds()
+	if got.Dx() != 50 || got.Dy() != 40 {
+		t.Errorf("small images must not be upscaled, got %dx%d", got.Dx(), got.Dy())
+	}
+}
@@ -0,0 +1,209 @@
+// Package report renders the result of a run as a machine-readable JSON
+// document for cron jobs and other automation.
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/matcher"
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/sampling"
+)
+
+// Report is the top-level JSON document.
+type Report struct {
+	RunID          string    `json:"run_id,omitempty"`
+	Version        string    `json:"version"`
+	GeneratedAt    time.Time `json:"generated_at"`
+	Mode           string    `json:"mode"`
+	FilesScanned   int       `json:"files_scanned"`
+	Untracked      int       `json:"untracked"`
+	UntrackedBytes int64     `json:"untracked_bytes"`
+	Files          []File    `json:"files"`
+	// Sample is set when only a sample of directories was scanned; the
+	// counts above then cover the sample only.
+	Sample *Sample `json:"sample,omitempty"`
+	// Parts is set when the library was scanned in parts, one per user
+	// directory; the counts above then cover only the parts that were
+	// scanned completely.
+	Parts []Part `json:"parts,omitempty"`
+	// Diff is set when the run was compared against an earlier report.
+	Diff *Diff `json:"diff,omitempty"`
+	// OrphanUserDirs are the directories of users no longer in Immich,
+	// all of whose files are strays. Their files are in Files too.
+	OrphanUserDirs []OrphanDir `json:"orphan_user_dirs,omitempty"`
+}
+
+// OrphanDir is a directory of a user no longer in Immich.
+type OrphanDir struct {
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Diff describes how the strays changed since an earlier report.
+type Diff struct {
+	PreviousRunID       string    `json:"previous_run_id,omitempty"`
+	PreviousGeneratedAt time.Time `json:"previous_generated_at"`
+	// New are the strays missing from the earlier report; Gone are those
+	// only in the earlier report, as it described them.
+	New  []File `json:"new"`
+	Gone []File `json:"gone"`
+}
+
+// Compare returns how the strays in files differ from those in prev. Files
+// are matched by path.
+func Compare(prev *Report, files []File) *Diff {
+	d := &Diff{
+		PreviousRunID:       prev.RunID,
+		PreviousGeneratedAt: prev.GeneratedAt,
+		New:                 []File{},
+		Gone:                []File{},
+	}
+	seen := make(map[string]bool, len(prev.Files))
+	for _, f := range prev.Files {
+		seen[f.Path] = true
+	}
+	current := make(map[string]bool, len(files))
+	for _, f := range files {
+		current[f.Path] = true
+		if !seen[f.Path] {
+			d.New = append(d.New, f)
+		}
+	}
+	for _, f := range prev.Files {
+		if !current[f.Path] {
+			d.Gone = append(d.Gone, f)
+		}
+	}
+	return d
+}
+
+// Part describes the scan of one part of the library.
+type Part struct {
+	Name            string  `json:"name"`
+	FilesScanned    int     `json:"files_scanned"`
+	Untracked       int     `json:"untracked"`
+	ReadErrors      int     `json:"read_errors"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	// Error is set when the part could not be scanned completely; its
+	// strays are then missing from Files.
+	Error string `json:"error,omitempty"`
+}
+
+// Sample describes a sampled scan and what it extrapolates to.
+type Sample struct {
+	Fraction           float64           `json:"fraction"`
+	Seed               uint64            `json:"seed"`
+	Directories        int               `json:"directories"`
+	SampledDirectories int               `json:"sampled_directories"`
+	EstimatedFiles     sampling.Estimate `json:"estimated_files"`
+	EstimatedUntracked sampling.Estimate `json:"estimated_untracked"`
+}
+
+// File describes a single untracked file.
+type File struct {
+	Path              string           `json:"path"`
+	TopDir            string           `json:"top_dir"`
+	Category          matcher.Category `json:"category"`
+	Size              int64            `json:"size"`
+	ModTime           time.Time        `json:"mtime"`
+	Device            uint64           `json:"device"`
+	ProbablyTrackedAs string           `json:"probably_tracked_as,omitempty"`
+	DuplicateOf       string           `json:"duplicate_of,omitempty"`
+	MissingOriginalOf string           `json:"missing_original_of,omitempty"`
+	UnknownContent    bool             `json:"unknown_content,omitempty"`
+	CopyOf            string           `json:"copy_of,omitempty"`
+	SidecarOf         string           `json:"sidecar_of,omitempty"`
+	TakeoutOf         string           `json:"takeout_of,omitempty"`
+	CompanionOf       string           `json:"companion_of,omitempty"`
+	Probe             string           `json:"probe,omitempty"`
+	ProbeError        string           `json:"probe_error,omitempty"`
+}
+
+// New builds a report from the untracked files of a run.
+func New(version, mode string, filesScanned int, untracked []matcher.UntrackedFile) *Report {
+	r := &Report{
+		Version:      version,
+		GeneratedAt:  time.Now().UTC(),
+		Mode:         mode,
+		FilesScanned: filesScanned,
+		Untracked:    len(untracked),
+		Files:        make([]File, len(untracked)),
+	}
+	for i, u := range untracked {
+		r.UntrackedBytes += u.Size
+		r.Files[i] = File{
+			Path:              u.RelPath,
+			TopDir:            paths.TopDir(u.RelPath),
+			Category:          u.Category,
+			Size:              u.Size,
+			ModTime:           u.ModTime.UTC(),
+			Device:            u.Dev,
+			ProbablyTrackedAs: u.ProbablyTrackedAs,
+			DuplicateOf:       u.DuplicateOf,
+			MissingOriginalOf: u.MissingOriginalOf,
+			UnknownContent:    u.UnknownContent,
+			CopyOf:            u.CopyOf,
+			SidecarOf:         u.SidecarOf,
+			TakeoutOf:         u.TakeoutOf,
+			CompanionOf:       u.CompanionOf,
+			Probe:             u.Probe,
+			ProbeError:        u.ProbeError,
+		}
+	}
+	return r
+}
+
+// Write encodes r as indented JSON to w.
+func Write(w io.Writer, r *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		return fmt.Errorf("encode report: %w", err)
+	}
+	return nil
+}
+
+// Load reads a report written by Write or WriteFile.
+func Load(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read report: %w", err)
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("parse report %s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// WriteFile writes r to path. The file is replaced atomically, so a reader
+// never sees a partial report.
+func WriteFile(path string, r *Report) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".report-*.json")
+	if err != nil {
+		return fmt.Errorf("create report: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := Write(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package report
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/matcher"
+)
+
+func TestNew(t *testing.T) {
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	r := New("v1", "dry-run", 10, []matcher.UntrackedFile{
+		{RelPath: "library/alice/a.jpg", Category: matcher.CategoryOriginal, Size: 100, ModTime: mtime},
+		{RelPath: "thumbs/u/x.webp", Category: matcher.CategoryDerivative, Size: 20, ModTime: mtime},
+	})
+
+	if r.FilesScanned != 10 || r.Untracked != 2 || r.UntrackedBytes != 120 {
+		t.Errorf("unexpected totals: %+v", r)
+	}
+	want := File{Path: "library/alice/a.jpg", TopDir: "library", Category: "original", Size: 100, ModTime: mtime}
+	if r.Files[0] != want {
+		t.Errorf("expected %+v, got %+v", want, r.Files[0])
+	}
+	if r.Files[1].TopDir != "thumbs" {
+		t.Errorf("expected top dir thumbs, got %q", r.Files[1].TopDir)
+	}
+}
+
+func TestWriteFile_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	r := New("v1", "move", 1, []matcher.UntrackedFile{{RelPath: "a.jpg", Category: matcher.CategoryUnmanaged}})
+
+	if err := WriteFile(path, r); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Report
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("report is not valid JSON: %v", err)
+	}
+	if got.Mode != "move" || len(got.Files) != 1 || got.Files[0].Path != "a.jpg" {
+		t.Errorf("unexpected report: %+v", got)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("expected only the report in the directory, got %d entries", len(entries))
+	}
+}
+
+func TestCompare(t *testing.T) {
+	prev := New("v1", "dry-run", 3, []matcher.UntrackedFile{
+		{RelPath: "a.jpg", Size: 1},
+		{RelPath: "b.jpg", Size: 2},
+	})
+	prev.RunID = "20240601T030002Z"
+	cur := New("v1", "dry-run", 3, []matcher.UntrackedFile{
+		{RelPath: "b.jpg", Size: 2},
+		{RelPath: "c.jpg", Size: 3},
+	})
+
+	d := Compare(prev, cur.Files)
+	if d.PreviousRunID != prev.RunID || !d.PreviousGeneratedAt.Equal(prev.GeneratedAt) {
+		t.Errorf("unexpected previous run: %+v", d)
+	}
+	if len(d.New) != 1 || d.New[0].Path != "c.jpg" {
+		t.Errorf("expected c.jpg to be new, got %+v", d.New)
+	}
+	if len(d.Gone) != 1 || d.Gone[0].Path != "a.jpg" || d.Gone[0].Size != 1 {
+		t.Errorf("expected a.jpg to be gone, got %+v", d.Gone)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	r := New("v1", "move", 1, []matcher.UntrackedFile{{RelPath: "a.jpg"}})
+	r.RunID = "20240601T030002Z"
+	if err := WriteFile(path, r); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got.RunID != r.RunID || len(got.Files) != 1 || got.Files[0].Path != "a.jpg" {
+		t.Errorf("unexpected report: %+v", got)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("expected an error for a truncated report")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/goeland86/immich-stray-finder/mover"
+	"github.com/goeland86/immich-stray-finder/paths"
+)
+
+// cmdRestore moves files quarantined by a previous run back into the library.
+func cmdRestore(ctx context.Context, args []string) int {
+	var cfg config
+	fs := newFlagSet("restore", &cfg)
+	fs.StringVar(&cfg.runID, "run", "", "Run ID to undo (a manifest name in <target-dir>/.manifests); defaults to the most recent run")
+	fs.StringVar(&cfg.glob, "glob", "", "Only restore files whose library path matches this glob, e.g. '*.heic' or 'upload/*/2024'")
+	fs.BoolVar(&cfg.dryRun, "dry-run", false, "Only show what would be restored")
+	addStateDirFlag(fs, &cfg)
+	if ok, code := parseFlags(fs, &cfg, args); !ok {
+		return code
+	}
+	if !resolveTarget(&cfg) {
+		return exitError
+	}
+	if cfg.libraryPath == "" {
+		fmt.Fprintln(os.Stderr, "Error: --library-path is required")
+		fs.Usage()
+		return exitError
+	}
+	if cfg.glob != "" && !paths.ValidPattern(cfg.glob) {
+		fmt.Fprintf(os.Stderr, "Error: invalid --glob %q\n", cfg.glob)
+		return exitError
+	}
+	if cfg.readOnly {
+		cfg.dryRun = true
+	}
+	if !cfg.dryRun && !openAuditLog(&cfg) {
+		return exitError
+	}
+	defer cfg.audit.Close()
+	applyReadOnly(&cfg)
+	logger := newLogger(&cfg)
+	// Only the mounts of --library-path need the prefix here.
+	if len(cfg.mountSpecs) > 0 {
+		if err := discoverLayout(ctx, &cfg, logger); err != nil {
+			logger.Error("fatal error", "error", err)
+			return exitError
+		}
+	}
+
+	sum, err := mover.Restore(cfg.libraryPath, cfg.manifestDir, mover.RestoreOptions{
+		RunID:  cfg.runID,
+		Glob:   cfg.glob,
+		DryRun: cfg.dryRun,
+		Audit:  cfg.audit,
+		Mounts: cfg.mounts,
+	}, logger)
+	if sum != nil {
+		verb := "Restored"
+		if cfg.dryRun {
+			verb = "Would restore"
+		}
+		fmt.Fprintf(stderr, "\n%s %d file(s) from run %s.\n", verb, sum.Restored, sum.RunID)
+		if len(sum.Skipped) > 0 {
+			fmt.Fprintf(stderr, "%d file(s) could not be restored:\n", len(sum.Skipped))
+			for _, p := range sum.Skipped {
+				fmt.Fprintf(stderr, "  %s\n", p)
+			}
+		}
+	}
+	if err != nil {
+		logger.Error("fatal error", "error", err)
+		return exitError
+	}
+	return exitOK
+}
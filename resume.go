@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/goeland86/immich-stray-finder/matcher"
+	"github.com/goeland86/immich-stray-finder/mover"
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/progress"
+	"github.com/goeland86/immich-stray-finder/scanner"
+)
+
+// resumeMove finishes the oldest move in the quarantine that was interrupted,
+// instead of scanning the library. The strays it never got to are checked
+// against the current assets, without walking the library, and those still
+// untracked are moved under the interrupted run's ID. It reports whether
+// it resumed a move; without --move, it only warns about one.
+func resumeMove(ctx context.Context, p *pipeline, cfg *config, runID string, logger *slog.Logger) (*runResult, bool, error) {
+	pending, err := mover.PendingRuns(cfg.manifestDir)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(pending) == 0 {
+		return nil, false, nil
+	}
+	if !cfg.move {
+		logger.Warn("a move was interrupted; the next move finishes it before scanning", "previous_run_id", pending[0])
+		return nil, false, nil
+	}
+	prevID := pending[0]
+	logger.Info("resuming interrupted move", "previous_run_id", prevID)
+	items, err := mover.Resume(prevID, cfg.libraryPath, cfg.manifestDir, mover.Options{Audit: cfg.audit, Mounts: cfg.mounts}, logger)
+	if err != nil {
+		return nil, true, fmt.Errorf("resume move %s: %w", prevID, err)
+	}
+
+	result, err := p.fetch(ctx)
+	if err != nil {
+		return nil, true, err
+	}
+	res := &runResult{runID: runID, resumed: prevID, assetsFetched: len(result.AssetIDs), ownerDirs: p.ownerDirs}
+	planned := make(map[string]mover.Item, len(items))
+	var files []scanner.File
+	for _, item := range items {
+		planned[item.RelPath] = item
+		root, prefix := paths.Locate(cfg.libraryPath, cfg.mounts, item.RelPath)
+		f, err := scanner.Stat(root, prefix, cfg.libraryFile(item.RelPath))
+		if err != nil {
+			// Vanished strays are reported by the mover.
+			f = scanner.File{RelPath: item.RelPath}
+		}
+		files = append(files, f)
+	}
+	res.filesScanned = len(files)
+	// The interrupted move is finished whatever part of the library, or
+	// extensions, this run is limited to.
+	mctx := p.index(result)
+	mctx.Filter, mctx.OnlyExts, mctx.SkipExts = nil, nil, nil
+	res.untracked = matcher.FindUntracked(files, mctx, logger)
+	var still []mover.Item
+	for i := range res.untracked {
+		u := &res.untracked[i]
+		item := planned[u.RelPath]
+		u.DuplicateOf, u.MissingOriginalOf = item.DuplicateOf, item.RelinkTo
+		still = append(still, mover.Item{RelPath: u.RelPath, Category: string(u.Category), User: item.User, DuplicateOf: u.DuplicateOf, RelinkTo: u.MissingOriginalOf, ModTime: u.ModTime, Delete: item.Delete})
+	}
+	if n := len(items) - len(still); n > 0 {
+		logger.Info("strays of the interrupted move are now tracked by Immich and stay in place", "files", n)
+	}
+	if cfg.output == "text" && len(res.untracked) > 0 {
+		printUntracked(res.untracked, nil)
+	}
+
+	opts := moverOptions(cfg, prevID)
+	opts.Progress = progress.Track("moving strays", "files", int64(len(still)))
+	sum, err := mover.MoveOrphans(still, cfg.libraryPath, cfg.manifestDir, opts, logger)
+	opts.Progress.Finish()
+	printMoveSummary(sum, true)
+	recordMoves(res, sum)
+	res.quarantinedBytes = sum.MovedBytes + sum.CopiedBytes + sum.LinkedBytes + sum.ArchivedBytes
+	if err == nil && len(pending) > 1 {
+		logger.Info("more interrupted moves remain; the next runs finish them", "runs", len(pending)-1)
+	}
+	return res, true, err
+}
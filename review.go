@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/immich"
+	"github.com/goeland86/immich-stray-finder/matcher"
+	"github.com/goeland86/immich-stray-finder/report"
+)
+
+// reviewBatch bounds the number of asset IDs sent to Immich per album or
+// tag request.
+const reviewBatch = 500
+
+// cmdReview scans like scan, then uploads low-resolution previews of image
+// strays to an Immich album, so they can be reviewed in the Immich apps.
+// Only the previews are uploaded; the strays themselves stay where they are.
+func cmdReview(ctx context.Context, args []string) int {
+	cfg := config{review: true}
+	fs := newFlagSet("review", &cfg)
+	addRunFlags(fs, &cfg)
+	fs.StringVar(&cfg.reviewAlbum, "album", "Stray review", "Immich album the previews are added to; created if missing")
+	fs.StringVar(&cfg.reviewTag, "tag", "immich-stray-finder/stray-preview", "Tag added to every uploaded preview")
+	fs.IntVar(&cfg.reviewMax, "review-max", 200, "Maximum number of previews uploaded per run")
+	fs.IntVar(&cfg.reviewSize, "review-size", 1024, "Longer edge of the uploaded previews, in pixels")
+	if ok, code := parseFlags(fs, &cfg, args); !ok {
+		return code
+	}
+	if cfg.readOnly {
+		fmt.Fprintln(os.Stderr, "Error: review uploads to Immich and cannot be combined with --read-only")
+		return exitError
+	}
+	if cfg.reviewMax <= 0 || cfg.reviewSize <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: --review-max and --review-size must be positive")
+		return exitError
+	}
+	return startRun(ctx, fs, &cfg)
+}
+
+// reviewMap records the previews uploaded for review, so later runs skip
+// strays that already have one and the previews can be traced back.
+type reviewMap struct {
+	AlbumID string        `json:"album_id"`
+	TagID   string        `json:"tag_id"`
+	Entries []reviewEntry `json:"entries"`
+}
+
+// reviewEntry maps an uploaded preview to its stray.
+type reviewEntry struct {
+	AssetID    string    `json:"asset_id"`
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mtime"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	// Filed is set once the preview was added to the album and tagged.
+	Filed bool `json:"filed,omitempty"`
+}
+
+// key identifies a stray version: a stray rewritten since its preview was
+// uploaded gets a new one.
+func (e reviewEntry) key() string {
+	return e.Path + "\x00" + strconv.FormatInt(e.Size, 10) + "\x00" + e.ModTime.UTC().Format(time.RFC3339Nano)
+}
+
+// uploadReview uploads previews of the image strays in res that have none
+// yet, adds them to the review album, tags them, and updates the review map.
+func uploadReview(ctx context.Context, cfg *config, res *runResult, logger *slog.Logger) error {
+	m, err := loadReviewMap(cfg.reviewMap)
+	if err != nil {
+		return err
+	}
+	done := make(map[string]bool, len(m.Entries))
+	for _, e := range m.Entries {
+		done[e.key()] = true
+	}
+
+	client := immich.NewClient(cfg.immichURL, cfg.apiKey, logger)
+	if m.AlbumID, err = client.EnsureAlbum(ctx, cfg.reviewAlbum,
+		"Low-resolution previews of files in the Immich storage folder that Immich does not know about. "+
+			"Uploaded by immich-stray-finder; each description names the file on disk."); err != nil {
+		return err
+	}
+	if m.TagID, err = client.EnsureTag(ctx, cfg.reviewTag); err != nil {
+		return err
+	}
+
+	uploaded, skipped := 0, 0
+	for _, u := range res.untracked {
+		if uploaded == cfg.reviewMax {
+			logger.Info("review upload limit reached; the remaining strays are previewed on the next run", "limit", cfg.reviewMax)
+			break
+		}
+		if u.Category != matcher.CategoryOriginal && u.Category != matcher.CategoryUnmanaged {
+			continue
+		}
+		e := reviewEntry{Path: u.RelPath, Size: u.Size, ModTime: u.ModTime.UTC()}
+		if done[strayKey(u)] {
+			continue
+		}
+		data, err := report.Preview(cfg.libraryFile(u.RelPath), cfg.reviewSize)
+		if err != nil {
+			// Not an image, or a format the preview decoder does not know.
+			logger.Debug("no preview for stray", "path", u.RelPath, "error", err)
+			skipped++
+			continue
+		}
+
+		sum := sha256.Sum256([]byte(e.key()))
+		name := "stray-" + strings.TrimSuffix(path.Base(u.RelPath), path.Ext(u.RelPath)) + ".jpg"
+		up, err := client.UploadAsset(ctx, name, data, "stray-review-"+hex.EncodeToString(sum[:8]), u.ModTime)
+		if err != nil {
+			return err
+		}
+		desc := fmt.Sprintf("Stray file on disk, not in Immich: %s (%s). Preview uploaded by immich-stray-finder.",
+			u.RelPath, report.FormatBytes(u.Size))
+		if err := client.SetAssetDescription(ctx, up.ID, desc); err != nil {
+			return err
+		}
+		e.AssetID, e.UploadedAt = up.ID, time.Now().UTC()
+		m.Entries = append(m.Entries, e)
+		uploaded++
+		logger.Info("uploaded stray preview", "path", u.RelPath, "asset_id", up.ID, "status", up.Status)
+
+		// Save progress as we go, so an interrupted run does not upload
+		// the same previews again.
+		if err := writeReviewMap(cfg.reviewMap, m); err != nil {
+			return err
+		}
+	}
+
+	// File the previews in batches, including those of earlier runs that
+	// stopped before filing them, and record each batch only once it is in
+	// the album and tagged.
+	var pending []int
+	for i, e := range m.Entries {
+		if !e.Filed {
+			pending = append(pending, i)
+		}
+	}
+	for start := 0; start < len(pending); start += reviewBatch {
+		batch := pending[start:min(start+reviewBatch, len(pending))]
+		ids := make([]string, len(batch))
+		for j, i := range batch {
+			ids[j] = m.Entries[i].AssetID
+		}
+		if err := client.AddToAlbum(ctx, m.AlbumID, ids); err != nil {
+			return err
+		}
+		if err := client.TagAssets(ctx, m.TagID, ids); err != nil {
+			return err
+		}
+		for _, i := range batch {
+			m.Entries[i].Filed = true
+		}
+		if err := writeReviewMap(cfg.reviewMap, m); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(stderr, "\nUploaded %d preview(s) to the Immich album %q", uploaded, cfg.reviewAlbum)
+	if skipped > 0 {
+		fmt.Fprintf(stderr, "; %d stray(s) could not be previewed", skipped)
+	}
+	fmt.Fprintf(stderr, ". Mapping: %s\n", cfg.reviewMap)
+	return nil
+}
+
+// approvedStrays returns the keys of the stray versions whose preview in
+// the review map is a favorite in Immich: marking a preview as a favorite,
+// in the web UI or a mobile app, approves moving its stray. Trashed or
+// deleted previews approve nothing.
+func approvedStrays(ctx context.Context, cfg *config, logger *slog.Logger) (map[string]bool, error) {
+	m, err := loadReviewMap(cfg.reviewMap)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Entries) == 0 {
+		logger.Warn("the review map lists no previews; run the review command first", "review_map", cfg.reviewMap)
+	}
+	client := immich.NewClient(cfg.immichURL, cfg.apiKey, logger)
+	approved := make(map[string]bool)
+	for _, e := range m.Entries {
+		st, err := client.FetchAssetState(ctx, e.AssetID)
+		if err != nil {
+			return nil, err
+		}
+		if st != nil && st.IsFavorite && !st.IsTrashed {
+			approved[e.key()] = true
+		}
+	}
+	logger.Info("read review approvals", "previews", len(m.Entries), "approved", len(approved))
+	return approved, nil
+}
+
+// strayKey is the reviewEntry key of u.
+func strayKey(u matcher.UntrackedFile) string {
+	return reviewEntry{Path: u.RelPath, Size: u.Size, ModTime: u.ModTime.UTC()}.key()
+}
+
+// loadReviewMap reads the review map at path. A missing file is an empty
+// map.
+func loadReviewMap(path string) (*reviewMap, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &reviewMap{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read review map: %w", err)
+	}
+	var m reviewMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse review map %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// writeReviewMap replaces the review map at path atomically.
+func writeReviewMap(path string, m *reviewMap) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode review map: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".review-map-*.json")
+	if err != nil {
+		return fmt.Errorf("create review map: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write review map: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write review map: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write review map: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package runstats
+
+// PeakRSS is not available on this platform.
+func PeakRSS() (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package runstats
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// PeakRSS returns the peak resident set size of the process in bytes.
+func PeakRSS() (int64, bool) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, false
+	}
+	// Linux and the BSDs report kilobytes; Darwin reports bytes.
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		return int64(ru.Maxrss), true
+	}
+	return int64(ru.Maxrss) * 1024, true
+}
@@ -0,0 +1,106 @@
+// Package runstats keeps process-wide counters of the work a run does, so a
+// summary with meaningful numbers can be logged at the end of each run.
+package runstats
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	apiCalls     atomic.Int64
+	dbRows       atomic.Int64
+	filesStatted atomic.Int64
+	apiErrors    atomic.Int64
+	dbErrors     atomic.Int64
+)
+
+// AddAPICall counts one HTTP request to Immich.
+func AddAPICall() { apiCalls.Add(1) }
+
+// AddDBRow counts one row read from the Immich database.
+func AddDBRow() { dbRows.Add(1) }
+
+// AddFileStat counts one file stat'ed by the scanner.
+func AddFileStat() { filesStatted.Add(1) }
+
+// AddAPIError counts one failed HTTP request to Immich.
+func AddAPIError() { apiErrors.Add(1) }
+
+// AddDBError counts one failed query of the Immich database.
+func AddDBError() { dbErrors.Add(1) }
+
+// Counters is a snapshot of the process-wide counters.
+type Counters struct {
+	APICalls     int64
+	DBRows       int64
+	FilesStatted int64
+	APIErrors    int64
+	DBErrors     int64
+}
+
+// Snapshot returns the current counter values.
+func Snapshot() Counters {
+	return Counters{
+		APICalls:     apiCalls.Load(),
+		DBRows:       dbRows.Load(),
+		FilesStatted: filesStatted.Load(),
+		APIErrors:    apiErrors.Load(),
+		DBErrors:     dbErrors.Load(),
+	}
+}
+
+// Sub returns the work done between an earlier snapshot and c.
+func (c Counters) Sub(earlier Counters) Counters {
+	return Counters{
+		APICalls:     c.APICalls - earlier.APICalls,
+		DBRows:       c.DBRows - earlier.DBRows,
+		FilesStatted: c.FilesStatted - earlier.FilesStatted,
+		APIErrors:    c.APIErrors - earlier.APIErrors,
+		DBErrors:     c.DBErrors - earlier.DBErrors,
+	}
+}
+
+// GoroutineSampler records the highest goroutine count seen while it runs.
+type GoroutineSampler struct {
+	peak atomic.Int64
+	done chan struct{}
+}
+
+// SampleGoroutines starts sampling runtime.NumGoroutine every interval until
+// Stop is called.
+func SampleGoroutines(interval time.Duration) *GoroutineSampler {
+	s := &GoroutineSampler{done: make(chan struct{})}
+	s.sample()
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-t.C:
+				s.sample()
+			}
+		}
+	}()
+	return s
+}
+
+func (s *GoroutineSampler) sample() {
+	n := int64(runtime.NumGoroutine())
+	for {
+		cur := s.peak.Load()
+		if n <= cur || s.peak.CompareAndSwap(cur, n) {
+			return
+		}
+	}
+}
+
+// Stop ends sampling and returns the peak goroutine count.
+func (s *GoroutineSampler) Stop() int {
+	close(s.done)
+	s.sample()
+	return int(s.peak.Load())
+}
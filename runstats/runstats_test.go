@@ -0,0 +1,42 @@
+package runstats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnapshotSub(t *testing.T) {
+	before := Snapshot()
+	AddAPICall()
+	AddAPICall()
+	AddDBRow()
+	AddFileStat()
+	AddAPIError()
+	AddDBError()
+
+	got := Snapshot().Sub(before)
+	want := Counters{APICalls: 2, DBRows: 1, FilesStatted: 1, APIErrors: 1, DBErrors: 1}
+	if got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestSampleGoroutines(t *testing.T) {
+	s := SampleGoroutines(time.Millisecond)
+	block := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		go func() { <-block }()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(block)
+
+	if peak := s.Stop(); peak < 11 {
+		t.Errorf("expected peak of at least 11 goroutines, got %d", peak)
+	}
+}
+
+func TestPeakRSS(t *testing.T) {
+	if rss, ok := PeakRSS(); ok && rss <= 0 {
+		t.Errorf("expected positive peak RSS, got %d", rss)
+	}
+}
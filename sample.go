@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+
+	"github.com/goeland86/immich-stray-finder/report"
+	"github.com/goeland86/immich-stray-finder/sampling"
+)
+
+// sampleCounts holds the per-directory counts of a sampled run, keyed by
+// the directory's library-relative path.
+type sampleCounts struct {
+	directories, sampled int
+	files, untracked     map[string]int
+}
+
+// addSampleFlags adds --sample and --sample-seed to the scan command.
+func addSampleFlags(fs *flag.FlagSet, cfg *config) {
+	fs.StringVar(&cfg.sampleSpec, "sample", "", "Scan only this share of directories, e.g. 1%, and extrapolate the stray count for the whole library")
+	fs.Uint64Var(&cfg.sampleSeed, "sample-seed", 0, "Seed that picks the sampled directories (0 picks one at random); reuse it to repeat a sample")
+}
+
+// parseSampleFlags validates --sample and picks a seed when none was given.
+func parseSampleFlags(cfg *config) bool {
+	if cfg.sampleSpec == "" {
+		return true
+	}
+	f, err := sampling.ParseFraction(cfg.sampleSpec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --sample: %v\n", err)
+		return false
+	}
+	if cfg.move {
+		fmt.Fprintln(os.Stderr, "Error: --sample cannot be combined with moving; a sample does not find every stray")
+		return false
+	}
+	cfg.sample = f
+	for cfg.sampleSeed == 0 {
+		cfg.sampleSeed = rand.Uint64()
+	}
+	return true
+}
+
+// sampleReport extrapolates the counts of a sampled run.
+func sampleReport(cfg *config, s *sampleCounts) *report.Sample {
+	return &report.Sample{
+		Fraction:           cfg.sample,
+		Seed:               cfg.sampleSeed,
+		Directories:        s.directories,
+		SampledDirectories: s.sampled,
+		EstimatedFiles:     sampling.Extrapolate(s.directories, s.sampled, s.files),
+		EstimatedUntracked: sampling.Extrapolate(s.directories, s.sampled, s.untracked),
+	}
+}
+
+// printSampleEstimate prints what a sampled run extrapolates to.
+func printSampleEstimate(res *runResult, sr *report.Sample) {
+	fmt.Fprintf(stderr, "\nSampled %d of %d director(ies) (%.4g%%, seed %d): %d file(s) scanned, %d untracked.\n",
+		sr.SampledDirectories, sr.Directories, sr.Fraction*100, sr.Seed, res.filesScanned, len(res.untracked))
+	fmt.Fprintf(stderr, "Estimated for the whole library (95%% confidence):\n")
+	fmt.Fprintf(stderr, "  files:     ~%.0f (%.0f–%.0f)\n", sr.EstimatedFiles.Total, sr.EstimatedFiles.Low, sr.EstimatedFiles.High)
+	fmt.Fprintf(stderr, "  untracked: ~%.0f (%.0f–%.0f)\n", sr.EstimatedUntracked.Total, sr.EstimatedUntracked.Low, sr.EstimatedUntracked.High)
+	if sr.SampledDirectories < 30 {
+		fmt.Fprintln(stderr, "  Few directories were sampled; the interval is unreliable. Use a larger --sample.")
+	}
+}
@@ -0,0 +1,117 @@
+// Package sampling selects a pseudo-random subset of directories for a quick
+// scan and extrapolates what a full scan would find.
+//
+// Directories are the sampling unit (cluster sampling): a sampled directory
+// has all of its files scanned, an unsampled one none. Strays cluster by
+// directory (a failed import, an orphaned year folder), so the estimate's
+// confidence interval is computed from the spread between directories
+// rather than between files.
+package sampling
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseFraction parses a sample size such as "1%", "0.5%", or "0.01".
+func ParseFraction(s string) (float64, error) {
+	v, pct := strings.CutSuffix(strings.TrimSpace(s), "%")
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sample size %q", s)
+	}
+	if pct {
+		f /= 100
+	}
+	if f <= 0 || f > 1 {
+		return 0, fmt.Errorf("sample size %q must be above 0%% and at most 100%%", s)
+	}
+	return f, nil
+}
+
+// Selector decides which directories are part of the sample. The decision
+// is a hash of the directory path and the seed, so it is stable within a
+// run and reproducible across runs with the same seed. A Selector is not
+// safe for concurrent use.
+type Selector struct {
+	Fraction float64
+	Seed     uint64
+
+	seen    map[string]bool
+	sampled int
+}
+
+// NewSelector returns a selector for the given fraction and seed.
+func NewSelector(fraction float64, seed uint64) *Selector {
+	return &Selector{Fraction: fraction, Seed: seed, seen: make(map[string]bool)}
+}
+
+// Include reports whether the files directly inside dir are sampled. It
+// must be called for every directory containing files, sampled or not, so
+// the selector knows the size of the population.
+func (s *Selector) Include(dir string) bool {
+	if in, ok := s.seen[dir]; ok {
+		return in
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d\x00%s", s.Seed, dir)
+	in := float64(mix(h.Sum64())>>11)/(1<<53) < s.Fraction
+	s.seen[dir] = in
+	if in {
+		s.sampled++
+	}
+	return in
+}
+
+// mix is the SplitMix64 finalizer. FNV alone spreads similar paths poorly
+// across the high bits the threshold compares.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// Clusters returns the number of directories with files seen, and how many
+// of them were sampled.
+func (s *Selector) Clusters() (total, sampled int) {
+	return len(s.seen), s.sampled
+}
+
+// Estimate is an extrapolated total with a 95% confidence interval.
+type Estimate struct {
+	Total float64 `json:"estimate"`
+	Low   float64 `json:"low"`
+	High  float64 `json:"high"`
+}
+
+// Extrapolate estimates the population total of a per-directory count from
+// the counts observed in the sampled directories. counts maps sampled
+// directories to their count; sampled directories missing from it count as
+// zero.
+func Extrapolate(total, sampled int, counts map[string]int) Estimate {
+	if sampled == 0 {
+		return Estimate{}
+	}
+	var sum, sumSq float64
+	for _, c := range counts {
+		sum += float64(c)
+		sumSq += float64(c) * float64(c)
+	}
+	m, M := float64(sampled), float64(total)
+	mean := sum / m
+
+	est := Estimate{Total: M * mean, Low: M * mean, High: M * mean}
+	if sampled > 1 {
+		variance := (sumSq - m*mean*mean) / (m - 1)
+		// Expansion estimator with finite population correction.
+		se := M * math.Sqrt((1-m/M)*variance/m)
+		est.Low = math.Max(sum, est.Total-1.96*se)
+		est.High = est.Total + 1.96*se
+	}
+	return est
+}
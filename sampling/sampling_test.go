@@ -0,0 +1,76 @@
+package sampling
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestParseFraction(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"1%", 0.01, true},
+		{"0.5%", 0.005, true},
+		{"0.25", 0.25, true},
+		{"100%", 1, true},
+		{"0%", 0, false},
+		{"150%", 0, false},
+		{"abc", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseFraction(tt.in)
+		if (err == nil) != tt.ok || math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("ParseFraction(%q) = %v, %v; want %v, ok=%v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSelector_StableAndProportional(t *testing.T) {
+	s := NewSelector(0.1, 42)
+	for i := 0; i < 10000; i++ {
+		s.Include(fmt.Sprintf("library/u/%d", i))
+	}
+	total, sampled := s.Clusters()
+	if total != 10000 {
+		t.Fatalf("expected 10000 clusters, got %d", total)
+	}
+	if sampled < 850 || sampled > 1150 {
+		t.Errorf("expected about 1000 sampled directories, got %d", sampled)
+	}
+
+	again := NewSelector(0.1, 42)
+	for i := 0; i < 100; i++ {
+		dir := fmt.Sprintf("library/u/%d", i)
+		if s.Include(dir) != again.Include(dir) {
+			t.Fatalf("selection of %s differs between selectors with the same seed", dir)
+		}
+	}
+}
+
+func TestExtrapolate(t *testing.T) {
+	// 10 of 100 directories sampled, 2 strays in each.
+	counts := map[string]int{}
+	for i := 0; i < 10; i++ {
+		counts[fmt.Sprint(i)] = 2
+	}
+	est := Extrapolate(100, 10, counts)
+	if est.Total != 200 || est.Low != 200 || est.High != 200 {
+		t.Errorf("uniform counts should give an exact estimate, got %+v", est)
+	}
+
+	// Strays concentrated in one directory give a wide interval.
+	est = Extrapolate(100, 10, map[string]int{"a": 20})
+	if est.Total != 200 {
+		t.Errorf("expected total 200, got %v", est.Total)
+	}
+	if est.Low != 20 || est.High <= 400 {
+		t.Errorf("expected a wide interval floored at the observed 20, got %+v", est)
+	}
+
+	if est := Extrapolate(5, 0, nil); est != (Estimate{}) {
+		t.Errorf("expected zero estimate without samples, got %+v", est)
+	}
+}
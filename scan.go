@@ -0,0 +1,1374 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/attest"
+	"github.com/goeland86/immich-stray-finder/history"
+	"github.com/goeland86/immich-stray-finder/ignore"
+	"github.com/goeland86/immich-stray-finder/immich"
+	"github.com/goeland86/immich-stray-finder/matcher"
+	"github.com/goeland86/immich-stray-finder/mover"
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/probe"
+	"github.com/goeland86/immich-stray-finder/progress"
+	"github.com/goeland86/immich-stray-finder/redact"
+	"github.com/goeland86/immich-stray-finder/report"
+	"github.com/goeland86/immich-stray-finder/runstats"
+	"github.com/goeland86/immich-stray-finder/sampling"
+	"github.com/goeland86/immich-stray-finder/scanner"
+	"github.com/goeland86/immich-stray-finder/tracing"
+)
+
+// cmdScan implements the scan, move, and delete subcommands, which share all
+// flags and differ only in what is done to strays.
+func cmdScan(ctx context.Context, name string, args []string, move bool) int {
+	cfg := config{move: move, delete: name == "delete"}
+	fs := newFlagSet(name, &cfg)
+	addRunFlags(fs, &cfg)
+	addProgressFlag(fs, &cfg)
+	if move {
+		addYesFlag(fs, &cfg)
+		if name == "move" {
+			addCopyFlags(fs, &cfg)
+		}
+	} else {
+		addFailFlag(fs, &cfg)
+		addSampleFlags(fs, &cfg)
+	}
+	if ok, code := parseFlags(fs, &cfg, args); !ok {
+		return code
+	}
+	return startRun(ctx, fs, &cfg)
+}
+
+// cmdLegacy runs the original flat interface, where --move selected the
+// action. It is kept so existing cron jobs and scripts keep working.
+func cmdLegacy(ctx context.Context, args []string) int {
+	var cfg config
+	fs := newFlagSet(os.Args[0], &cfg)
+	addRunFlags(fs, &cfg)
+	addProgressFlag(fs, &cfg)
+	addFailFlag(fs, &cfg)
+	addYesFlag(fs, &cfg)
+	addCopyFlags(fs, &cfg)
+	fs.BoolVar(&cfg.move, "move", false, "Actually move files (deprecated: use the move command)")
+	fs.BoolVar(&cfg.delete, "delete", false, "Delete untracked files outright instead of moving them; cannot be combined with --move")
+	fs.Usage = func() {
+		usage()
+		fmt.Fprintln(os.Stderr, "\nWithout a command, the flags of 'scan' are accepted, plus --move:")
+		fs.PrintDefaults()
+	}
+	if ok, code := parseFlags(fs, &cfg, args); !ok {
+		return code
+	}
+	if cfg.move && cfg.delete {
+		fmt.Fprintln(os.Stderr, "Error: --delete and --move cannot be combined")
+		return exitError
+	}
+	cfg.move = cfg.move || cfg.delete
+	return startRun(ctx, fs, &cfg)
+}
+
+// startRun validates cfg and performs a single scan.
+func startRun(ctx context.Context, fs *flag.FlagSet, cfg *config) int {
+	if !requireConnection(fs, cfg) {
+		return exitError
+	}
+	if cfg.readOnly && cfg.move {
+		fmt.Fprintln(os.Stderr, "Error: moving cannot be combined with --read-only")
+		return exitError
+	}
+	if !parseRunFlags(cfg) {
+		return exitError
+	}
+	if (cfg.copy || cfg.link) && !validCopy(cfg) {
+		return exitError
+	}
+	if cfg.archive != "" && !validArchive(cfg) {
+		return exitError
+	}
+	if cfg.move && !openAuditLog(cfg) {
+		return exitError
+	}
+	defer cfg.audit.Close()
+	cfg.confirm = cfg.move && !cfg.yes && isTerminal(os.Stdin)
+	if cfg.delete && !cfg.yes && !cfg.confirm {
+		fmt.Fprintln(os.Stderr, "Error: deleting without a terminal to confirm on requires --yes")
+		return exitError
+	}
+	cfg.progress = cfg.progress && isTerminal(os.Stderr)
+	cfg.rerunArgs = rerunArgs(fs, cfg.flagValues)
+	applyReadOnly(cfg)
+
+	logger := newLogger(cfg)
+	defer progress.Stop()
+	defer startTracing(cfg, logger)()
+	if err := checkCapabilities(ctx, cfg, logger); err != nil {
+		logger.Error("fatal error", "error", err)
+		return exitError
+	}
+	res, _, err := runOnce(ctx, logger, cfg)
+	if err != nil {
+		logger.Error("fatal error", "error", err)
+		return exitError
+	}
+	if cfg.review {
+		if err := uploadReview(ctx, cfg, res, logger); err != nil {
+			logger.Error("failed to upload review previews", "error", err)
+			return exitError
+		}
+	}
+	if cfg.failOnUntracked && !cfg.move && len(res.untracked) > 0 {
+		return exitUntracked
+	}
+	return exitOK
+}
+
+// validCopy reports whether --copy or --link can be honored: either needs
+// a move, and nothing that removes strays from the library.
+func validCopy(cfg *config) bool {
+	name := "--copy"
+	if cfg.link {
+		name = "--link"
+	}
+	var conflict string
+	switch {
+	case !cfg.move:
+		fmt.Fprintf(os.Stderr, "Error: %s requires --move\n", name)
+		return false
+	case cfg.copy && cfg.link:
+		fmt.Fprintln(os.Stderr, "Error: --copy and --link cannot be combined")
+		return false
+	case cfg.link && cfg.remote != nil:
+		fmt.Fprintln(os.Stderr, "Error: --link needs a local --target-dir")
+		return false
+	case cfg.delete:
+		conflict = "--delete"
+	case cfg.dedupe:
+		conflict = "--dedupe"
+	case cfg.deleteDups:
+		conflict = "--delete-duplicates"
+	case cfg.relink:
+		conflict = "--relink"
+	case cfg.identical != mover.IdenticalKeep:
+		conflict = "--identical-strays " + string(cfg.identical)
+	default:
+		return true
+	}
+	fmt.Fprintf(os.Stderr, "Error: %s cannot be combined with %s, which removes strays from the library\n", name, conflict)
+	return false
+}
+
+// validArchive checks --archive and makes it absolute, since the manifest
+// records it for restore.
+func validArchive(cfg *config) bool {
+	switch {
+	case !cfg.move:
+		fmt.Fprintln(os.Stderr, "Error: --archive requires --move")
+		return false
+	case cfg.copy || cfg.link || cfg.delete:
+		fmt.Fprintln(os.Stderr, "Error: --archive cannot be combined with --copy, --link, or --delete")
+		return false
+	case cfg.identical != mover.IdenticalKeep:
+		fmt.Fprintf(os.Stderr, "Error: --archive cannot be combined with --identical-strays %s\n", cfg.identical)
+		return false
+	case cfg.remote != nil:
+		fmt.Fprintln(os.Stderr, "Error: --archive cannot be combined with a remote --target-dir")
+		return false
+	}
+	abs, err := filepath.Abs(cfg.archive)
+	if err == nil {
+		err = mover.ValidArchivePath(abs)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --archive: %v\n", err)
+		return false
+	}
+	cfg.archive = abs
+	return true
+}
+
+// resolveTarget parses --target-dir and sets where the manifests are kept.
+// A remote target keeps them in --state-dir, which it therefore requires.
+func resolveTarget(cfg *config) bool {
+	remote, err := mover.ParseRemote(cfg.targetDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --target-dir: %v\n", err)
+		return false
+	}
+	cfg.remote, cfg.manifestDir = remote, cfg.targetDir
+	if remote == nil {
+		return true
+	}
+	if cfg.stateDir == "" {
+		fmt.Fprintln(os.Stderr, "Error: a remote --target-dir requires --state-dir to keep the move manifests in")
+		return false
+	}
+	if cfg.dedupe {
+		fmt.Fprintln(os.Stderr, "Error: --dedupe needs a local --target-dir")
+		return false
+	}
+	if cfg.identical != mover.IdenticalKeep {
+		fmt.Fprintf(os.Stderr, "Error: --identical-strays %s needs a local --target-dir\n", cfg.identical)
+		return false
+	}
+	cfg.manifestDir = cfg.stateDir
+	return true
+}
+
+// runOnce performs a full run and its bookkeeping: reports, attestation,
+// summary, and history. It returns the status of the run even when it
+// failed.
+func runOnce(ctx context.Context, logger *slog.Logger, cfg *config) (_ *runResult, rs *runStatus, err error) {
+	runID := mover.NewRunID()
+	logger = logger.With("run_id", runID)
+	ctx, span := tracing.Start(ctx, "run", "run_id", runID, "move", cfg.move, "sample", cfg.sample > 0)
+	defer span.EndErr(&err)
+	startedAt := time.Now()
+	counters := runstats.Snapshot()
+	goroutines := runstats.SampleGoroutines(250 * time.Millisecond)
+	res, err := run(ctx, logger, cfg, runID)
+	usage := runstats.Snapshot().Sub(counters)
+	logUsage(logger, startedAt, usage, goroutines.Stop())
+	if res != nil {
+		if cfg.output == "porcelain" {
+			if perr := writePorcelain(os.Stdout, res, cfg); perr != nil && err == nil {
+				err = perr
+			}
+		}
+		if rerr := writeReports(cfg, res, logger); rerr != nil && err == nil {
+			err = rerr
+		}
+		// Only a complete result is worth comparing the next run against.
+		if cfg.stateDir != "" && err == nil && res.sample == nil && res.resumed == "" && len(scopeFlags(cfg)) == 0 {
+			if serr := writeLastReport(cfg, res); serr != nil {
+				logger.Warn("failed to store report for the next run", "dir", cfg.stateDir, "error", serr)
+			}
+		}
+	}
+	if cfg.attestKey != "" {
+		if aerr := writeAttestation(cfg, startedAt, res, err); aerr != nil {
+			logger.Error("failed to write attestation", "error", aerr)
+			if err == nil {
+				err = aerr
+			}
+		} else {
+			logger.Info("wrote signed attestation", "file", cfg.attestFile)
+		}
+	}
+	rs = newRunStatus(startedAt, res, err)
+	rs.RunID = runID
+	if cfg.stateDir != "" {
+		if serr := writeRunSummary(cfg, rs, res, err); serr != nil {
+			logger.Warn("failed to write run summary", "dir", cfg.stateDir, "error", serr)
+		}
+	}
+	exportRunMetrics(ctx, cfg, rs, usage, logger)
+	notifyRun(ctx, cfg, rs, res, logger)
+	if err != nil {
+		return nil, rs, err
+	}
+	// A scoped run's counts are not comparable with those of a full run.
+	if cfg.historyFile != "" && res.sample == nil && res.resumed == "" && len(scopeFlags(cfg)) == 0 {
+		if err := recordHistory(cfg, startedAt, res); err != nil {
+			logger.Warn("failed to update run history", "file", cfg.historyFile, "error", err)
+		}
+	}
+	return res, rs, nil
+}
+
+func run(ctx context.Context, logger *slog.Logger, cfg *config, runID string) (*runResult, error) {
+	p, err := newPipeline(ctx, logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.sample > 0 {
+		p.sample = sampling.NewSelector(cfg.sample, cfg.sampleSeed)
+		logger.Info("scanning a sample of directories", "fraction", cfg.sample, "seed", cfg.sampleSeed)
+	}
+	// A move killed halfway is finished before anything else is moved.
+	if res, ok, err := resumeMove(ctx, p, cfg, runID, logger); ok || err != nil {
+		return res, err
+	}
+	if cfg.stateDir != "" && p.sample == nil {
+		p.cache = loadScanCache(cfg, logger)
+	}
+	if cfg.progress {
+		p.expectAssets, p.expectFiles = expectedTotals(cfg)
+		if p.sample != nil {
+			p.expectFiles = 0
+		}
+	}
+
+	// Step 4: Fetch, scan, and match concurrently.
+	res, err := p.run(ctx, logger)
+	if err != nil {
+		return nil, err
+	}
+	res.runID, res.ownerDirs = runID, p.ownerDirs
+	if res.orphanDirs = orphanUserDirs(res.untracked, res.ownerDirs); len(res.orphanDirs) > 0 {
+		logger.Info("found directories of users no longer in Immich", "directories", len(res.orphanDirs))
+	}
+	if p.cache != nil {
+		saveScanCache(cfg, p.cache, logger)
+	}
+	if cfg.immichDups {
+		if err := labelDuplicates(ctx, cfg, res, logger); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.matchSums {
+		if err := matchChecksums(ctx, cfg, res, p.checksums, logger); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.groupIdentical {
+		if err := groupIdentical(ctx, cfg, res, logger); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.probe {
+		if err := probeStrays(ctx, cfg, res, logger); err != nil {
+			return nil, err
+		}
+	}
+
+	// A sample only reports: it cannot say which strays it missed.
+	if res.sample != nil {
+		if cfg.output == "text" && len(res.untracked) > 0 {
+			printUntracked(res.untracked, res.orphanDirs)
+		}
+		printSampleEstimate(res, sampleReport(cfg, res.sample))
+		return res, nil
+	}
+
+	if cfg.diffAgainst != "" {
+		if err := diffAgainst(cfg, res, logger); err != nil {
+			return nil, err
+		}
+	}
+
+	// Step 5: Report and act on the untracked files.
+	err = reportAndMove(ctx, res, cfg, logger)
+	if err == nil && !cfg.move && !cfg.readOnly && !cfg.review && cfg.output == "text" && isTerminal(os.Stderr) {
+		writeNextSteps(stderr, res, cfg)
+	}
+	if res.failedUnits > 0 {
+		if cfg.output == "text" {
+			printFailedUnits(res)
+		}
+		if err == nil {
+			err = res.unitsErr()
+		}
+	}
+	return res, err
+}
+
+// newPipeline works out which assets a run compares against and which part
+// of the library it scans (steps 1 to 3 of a run), without fetching or
+// scanning anything yet.
+func newPipeline(ctx context.Context, logger *slog.Logger, cfg *config) (*pipeline, error) {
+	if err := discoverLayout(ctx, cfg, logger); err != nil {
+		return nil, err
+	}
+	client := immich.NewClient(cfg.immichURL, cfg.apiKey, logger)
+
+	// Step 1: Detect admin mode by trying the admin users endpoint.
+	adminMode := false
+	var allUserIDs map[string]struct{}
+	ownerDirs := make(map[string]string)
+
+	users, err := client.FetchAllUsers(ctx)
+	if err == nil {
+		// Admin mode: we have the full user list.
+		adminMode = true
+		allUserIDs = make(map[string]struct{}, len(users))
+		for _, u := range users {
+			allUserIDs[u.ID] = struct{}{}
+			ownerDirs[u.ID] = libraryDir(u)
+			logger.Info("discovered user", "name", u.Name, "id", u.ID, "storage_label", u.StorageLabel,
+				"deleted", u.DeletedAt != nil)
+		}
+		logger.Info("admin mode activated", "user_count", len(users))
+	} else if errors.Is(err, immich.ErrNotAdmin) {
+		// Single-user fallback.
+		logger.Info("not an admin API key, falling back to single-user mode")
+	} else {
+		return nil, fmt.Errorf("check admin status: %w", err)
+	}
+
+	checkMounts(cfg, logger)
+	if err := checkLibrary(cfg); err != nil {
+		return nil, err
+	}
+	checkMarkers(cfg, logger)
+
+	// Step 2: Decide where assets come from and which part of the library
+	// they cover.
+	p := &pipeline{ownerDirs: ownerDirs, scanRate: cfg.scanLimiter, ignore: ignore.New(cfg.libraryPath, cfg.mounts, logger), mounts: cfg.mounts}
+	if p.filter, err = paths.NewFilter(cfg.include, cfg.exclude); err != nil {
+		return nil, err
+	}
+	if adminMode && cfg.dbURL != "" {
+		if p.known, err = knownDirs(cfg, logger); err != nil {
+			return nil, err
+		}
+		// Admin mode with direct DB access: query PostgreSQL for all users'
+		// assets and scan the entire library-path root.
+		p.scanRoot = cfg.libraryPath
+		if cfg.sample == 0 {
+			// A sample is drawn across the whole library in one walk.
+			var err error
+			if cfg.users != "" {
+				p.units, err = scopedUnits(cfg.libraryPath, cfg.mounts, users, splitList(cfg.users))
+			} else {
+				p.units, err = userUnits(cfg.libraryPath, cfg.mounts, splitList(cfg.onlyUsers), p.known)
+			}
+			if err != nil {
+				return nil, err
+			}
+			p.workers, p.unitTimeout = cfg.scanWorkers, cfg.userTimeout
+		}
+		p.fetch = func(ctx context.Context) (*immich.AllAssetsResult, error) {
+			logger.Info("fetching all assets from database", "db", cfg.dbURL)
+			result, err := immich.FetchAllAssetsFromDB(ctx, cfg.dbURL)
+			if err != nil {
+				return nil, fmt.Errorf("fetch assets from database: %w", err)
+			}
+			// Merge user IDs from the admin user list (in case some users have no assets).
+			for uid := range allUserIDs {
+				result.UserIDs[uid] = struct{}{}
+			}
+			return result, nil
+		}
+		logger.Info("scanning filesystem (admin mode)", "path", p.scanRoot, "parts", len(p.units), "workers", p.workers)
+	} else {
+		if cfg.onlyUsers != "" || cfg.users != "" {
+			return nil, errors.New("--only-users and --users need admin mode with --db-url")
+		}
+		if adminMode {
+			// Admin key detected but no --db-url: warn and fall back to single-user scan.
+			logger.Warn("admin API key detected but --db-url not provided; the Immich v2 search API " +
+				"cannot fetch other users' assets. Falling back to single-user scan (admin's assets only). " +
+				"Provide --db-url for full multi-user stray detection.")
+		}
+
+		// Single-user mode: identify the current user.
+		user, err := client.FetchCurrentUser(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("fetch current user: %w", err)
+		}
+		if user.StorageLabel == "" {
+			return nil, fmt.Errorf("user %q has no storage label set in Immich", user.Name)
+		}
+		ownerDirs[user.ID] = libraryDir(*user)
+
+		p.fetch = func(ctx context.Context) (*immich.AllAssetsResult, error) {
+			logger.Info("fetching asset paths from Immich", "url", cfg.immichURL)
+			result, err := client.FetchAllAssets(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("fetch assets: %w", err)
+			}
+			if adminMode && cfg.libraryExclusions {
+				libs, err := client.FetchLibraries(ctx)
+				if err != nil {
+					logger.Warn("cannot fetch the library exclusion patterns; files Immich ignores may be reported", "error", err)
+				}
+				for _, lib := range libs {
+					for _, pattern := range lib.ExclusionPatterns {
+						if !slices.Contains(result.ExclusionPatterns, pattern) {
+							result.ExclusionPatterns = append(result.ExclusionPatterns, pattern)
+						}
+					}
+				}
+			}
+			// Add the current user's ID.
+			result.UserIDs[user.ID] = struct{}{}
+			return result, nil
+		}
+
+		// In single-user mode, we only scan the user's library directory.
+		p.scanRoot = cfg.libraryFile("library/" + user.StorageLabel)
+		p.scanPrefix = "library/" + user.StorageLabel
+		logger.Info("scanning filesystem (single-user mode)", "path", p.scanRoot, "user", user.StorageLabel)
+	}
+
+	// Step 3: Normalize asset paths into match keys once they are fetched.
+	p.index = func(result *immich.AllAssetsResult) *matcher.MatchContext {
+		norm := cfg.normalizer()
+		result.AssetPaths = norm.KeySet(result.AssetPaths)
+		result.SidecarPaths = norm.KeySet(result.SidecarPaths)
+		logger.Info("normalized asset paths", "prefix_stripped", cfg.pathPrefix, "path_maps", len(cfg.pathMaps), "assets", len(result.AssetPaths), "sidecars", len(result.SidecarPaths))
+
+		mctx := &matcher.MatchContext{
+			AssetPaths:   result.AssetPaths,
+			SidecarPaths: result.SidecarPaths,
+			AssetIDs:     result.AssetIDs,
+			PersonIDs:    result.PersonIDs,
+			UserIDs:      result.UserIDs,
+			Normalizer:   norm,
+			SidecarExts:  extList(cfg.sidecarExts),
+			Takeout:      cfg.takeout,
+			MinAge:       cfg.minAge,
+			UploadGrace:  matcher.DefaultUploadGrace,
+			IgnoreJunk:   cfg.junk == junkIgnore,
+			Ignore:       p.ignore,
+			Filter:       p.filter,
+			OnlyExts:     extList(cfg.onlyExts),
+			SkipExts:     extList(cfg.skipExts),
+		}
+		mctx.IndexSidecars()
+		if cfg.libraryExclusions && len(result.ExclusionPatterns) > 0 {
+			mctx.Exclusions = result.ExclusionPatterns
+			logger.Info("honoring library exclusion patterns", "patterns", strings.Join(result.ExclusionPatterns, ", "))
+		}
+		if cfg.matchSums {
+			p.checksums = immich.NewChecksumIndex(result.Files)
+		}
+		if cfg.matchName {
+			for _, f := range result.Files {
+				mctx.AddFileName(ownerDirs[f.OwnerID], f.OriginalFileName, f.OriginalPath, f.Size)
+			}
+			logger.Info("file-name fallback enabled", "indexed_assets", len(mctx.FileNames))
+		}
+		return mctx
+	}
+	return p, nil
+}
+
+// extList parses a comma-separated list of extensions, like --sidecar-exts,
+// into extensions with a leading dot.
+func extList(list string) []string {
+	var exts []string
+	for _, ext := range splitList(list) {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts = append(exts, ext)
+	}
+	return exts
+}
+
+// libraryDir returns the directory Immich stores u's originals under in
+// library/: the storage label, or the user ID when no label is set.
+func libraryDir(u immich.User) string {
+	if u.StorageLabel != "" {
+		return u.StorageLabel
+	}
+	return u.ID
+}
+
+// strayUser returns the user the stray at rel belongs to, for the {user}
+// placeholder of --layout: the storage label of the user whose ID a path
+// below upload/, thumbs/, and the like carries, so that a user's strays end
+// up in one directory, or else the owner segment of rel itself.
+func strayUser(rel string, ownerDirs map[string]string) string {
+	owner := paths.Owner(rel)
+	if dir, ok := ownerDirs[owner]; ok && paths.TopDir(rel) != "library" {
+		return dir
+	}
+	return owner
+}
+
+// knownDirs returns the top-level directories skipped besides backups/:
+// those named by --known-dirs, plus any model cache or geodata directory
+// recognized by its contents.
+func knownDirs(cfg *config, logger *slog.Logger) ([]string, error) {
+	known := splitList(cfg.knownDirs)
+	for _, name := range known {
+		if !filepath.IsLocal(name) || strings.ContainsAny(name, `/\`) || scanner.Managed(name) {
+			return nil, fmt.Errorf("--known-dirs: %q is not a top-level directory outside the ones Immich manages", name)
+		}
+	}
+	found, err := scanner.RecognizeKnownDirs(cfg.libraryPath)
+	if err != nil {
+		logger.Warn("cannot look for model cache and geodata directories", "error", err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(found)) {
+		if !slices.Contains(known, name) {
+			logger.Info("skipping directory recognized as Immich data", "dir", name, "kind", found[name])
+			known = append(known, name)
+		}
+	}
+	return known, nil
+}
+
+// checkMounts logs which top-level directories are separate mounts and warns
+// about layouts that look like a volume failed to mount. Directories kept
+// elsewhere with --library-path DIR=PREFIX need not exist below the root.
+func checkMounts(cfg *config, logger *slog.Logger) {
+	mounts, err := scanner.DetectMounts(cfg.libraryPath)
+	if err != nil {
+		logger.Warn("cannot inspect library mounts", "error", err)
+		return
+	}
+	mounts = slices.DeleteFunc(mounts, func(m scanner.Mount) bool {
+		return m.Missing && slices.ContainsFunc(cfg.mounts, func(lm paths.Mount) bool {
+			return paths.Within(lm.Rel, m.Dir) || paths.Within(m.Dir, lm.Rel)
+		})
+	})
+	for _, m := range mounts {
+		if m.Boundary {
+			logger.Info("top-level directory is a separate mount", "dir", m.Dir, "device", fmt.Sprintf("%#x", m.Dev))
+		}
+	}
+	for _, w := range scanner.MountWarnings(mounts) {
+		logger.Warn(w)
+	}
+}
+
+// libraryDirs are the top-level directories of which a library holding
+// any media has at least one.
+var libraryDirs = []string{"library", "upload", "thumbs"}
+
+// checkLibrary refuses a library path that looks like an unmounted volume:
+// an empty directory, or one without any of libraryDirs. Every asset would
+// otherwise be reported missing and nothing be found to scan.
+func checkLibrary(cfg *config) error {
+	entries, err := os.ReadDir(cfg.libraryPath)
+	if err != nil {
+		return fmt.Errorf("read library path: %w", err)
+	}
+	if len(entries) == 0 && len(cfg.mounts) == 0 {
+		return fmt.Errorf("library path %s is empty; is the volume holding Immich's media mounted?", cfg.libraryPath)
+	}
+	for _, dir := range libraryDirs {
+		if info, err := os.Stat(cfg.libraryFile(dir)); err == nil && info.IsDir() {
+			return nil
+		}
+	}
+	return fmt.Errorf("library path %s has none of %s/; is the volume holding Immich's media mounted, "+
+		"and is --library-path the directory Immich stores its media in?", cfg.libraryPath, strings.Join(libraryDirs, "/, "))
+}
+
+// checkMarkers warns about the top-level directories of the library lacking
+// the marker file Immich writes into them.
+func checkMarkers(cfg *config, logger *slog.Logger) {
+	for _, w := range scanner.MarkerWarnings(cfg.libraryFile) {
+		logger.Warn(w)
+	}
+}
+
+// logUsage logs what the run cost, for performance reports.
+func logUsage(logger *slog.Logger, startedAt time.Time, c runstats.Counters, peakGoroutines int) {
+	attrs := []any{
+		"duration", time.Since(startedAt).Round(time.Millisecond),
+		"peak_goroutines", peakGoroutines,
+		"api_calls", c.APICalls,
+		"db_rows", c.DBRows,
+		"files_statted", c.FilesStatted,
+		"api_errors", c.APIErrors,
+		"db_errors", c.DBErrors,
+	}
+	if rss, ok := runstats.PeakRSS(); ok {
+		attrs = append(attrs, "peak_rss", report.FormatBytes(rss))
+	}
+	logger.Info("run resource usage", attrs...)
+}
+
+// writeReports emits the JSON report on stdout and to cfg.reportFile, and the
+// HTML report to cfg.htmlReport, as requested.
+func writeReports(cfg *config, res *runResult, logger *slog.Logger) error {
+	if cfg.output != "json" && cfg.reportFile == "" && cfg.htmlReport == "" {
+		return nil
+	}
+	r := newReport(cfg, res)
+	if cfg.output == "json" {
+		if err := report.Write(os.Stdout, r); err != nil {
+			return err
+		}
+	}
+	if cfg.reportFile != "" {
+		if err := report.WriteFile(cfg.reportFile, r); err != nil {
+			return err
+		}
+		logger.Info("wrote report", "file", cfg.reportFile, "untracked", r.Untracked)
+	}
+	if cfg.htmlReport != "" {
+		err := report.WriteHTMLFile(cfg.htmlReport, r, report.HTMLOptions{
+			LibraryPath: cfg.libraryPath,
+			Mounts:      cfg.mounts,
+			ThumbSize:   160,
+			MaxPreviews: cfg.previews,
+		})
+		if err != nil {
+			return err
+		}
+		logger.Info("wrote HTML report", "file", cfg.htmlReport)
+	}
+	return nil
+}
+
+// newReport describes the result of a run for the JSON and HTML reports.
+func newReport(cfg *config, res *runResult) *report.Report {
+	r := report.New(buildVersion(), runMode(cfg), res.filesScanned, res.untracked)
+	r.RunID = res.runID
+	if res.sample != nil {
+		r.Sample = sampleReport(cfg, res.sample)
+	}
+	r.Parts = partReports(res.units)
+	r.Diff = res.diff
+	r.OrphanUserDirs = res.orphanDirs
+	return r
+}
+
+// writeAttestation signs a statement describing the run and writes it to
+// cfg.attestFile. res may be nil when the run failed before matching.
+func writeAttestation(cfg *config, startedAt time.Time, res *runResult, runErr error) error {
+	key, err := attest.LoadPrivateKey(cfg.attestKey)
+	if err != nil {
+		return err
+	}
+
+	st := &attest.Statement{
+		Version:    buildVersion(),
+		ConfigHash: configHash(cfg),
+		StartedAt:  startedAt.UTC(),
+		FinishedAt: time.Now().UTC(),
+		Mode:       runMode(cfg),
+		Outcome:    "success",
+	}
+	if runErr != nil {
+		st.Outcome = "error: " + runErr.Error()
+	}
+	if res != nil {
+		paths := make([]string, len(res.untracked))
+		for i, u := range res.untracked {
+			paths[i] = u.RelPath
+		}
+		st.FilesScanned = res.filesScanned
+		st.Untracked = len(paths)
+		st.UntrackedHash = attest.HashPaths(paths)
+		// A move's manifest is named after the move, which a resumed run
+		// did not start.
+		var manifest string
+		if cfg.move && cfg.manifestDir != "" {
+			id := res.runID
+			if res.resumed != "" {
+				id = res.resumed
+			}
+			manifest = mover.ManifestPath(cfg.manifestDir, id)
+		}
+		for _, art := range []struct{ name, file string }{
+			{"report", cfg.reportFile},
+			{"html-report", cfg.htmlReport},
+			{"audit-log", cfg.auditFile},
+			{"manifest", manifest},
+		} {
+			if art.file == "" {
+				continue
+			}
+			a, err := attest.FileArtifact(art.name, art.file)
+			if errors.Is(err, fs.ErrNotExist) && (art.name == "audit-log" || art.name == "manifest") {
+				// Nothing was moved, or the run failed before it could.
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			st.Artifacts = append(st.Artifacts, a)
+		}
+	}
+	return attest.WriteFile(cfg.attestFile, st, key)
+}
+
+// expectedTotals returns the assets fetched and files scanned by the last
+// run in --history-file, which the progress bars estimate their ETAs with.
+// Without a history, the totals are 0 and the bars only show throughput.
+func expectedTotals(cfg *config) (assets, files int64) {
+	if cfg.historyFile == "" {
+		return 0, 0
+	}
+	records, err := history.Load(cfg.historyFile)
+	if err != nil || len(records) == 0 {
+		return 0, 0
+	}
+	last := records[len(records)-1]
+	return int64(last.AssetsFetched), int64(last.FilesScanned)
+}
+
+// recordHistory appends this run to the history file and prints a growth
+// forecast when enough runs have been recorded.
+func recordHistory(cfg *config, startedAt time.Time, res *runResult) error {
+	rec := history.Record{
+		Time:          startedAt.UTC(),
+		Mode:          runMode(cfg),
+		AssetsFetched: res.assetsFetched,
+		FilesScanned:  res.filesScanned,
+		Untracked:     len(res.untracked),
+	}
+	for _, u := range res.untracked {
+		rec.UntrackedBytes += u.Size
+	}
+	if err := history.Append(cfg.historyFile, rec); err != nil {
+		return err
+	}
+
+	records, err := history.Load(cfg.historyFile)
+	if err != nil {
+		return err
+	}
+	f, ok := history.NewForecast(records)
+	if !ok {
+		fmt.Fprintln(stderr, "\nStray growth forecast: not enough run history yet.")
+		return nil
+	}
+	const horizon = 30 * 24 * time.Hour
+	files, bytes := f.Project(horizon)
+	fmt.Fprintf(stderr, "\nStray growth over the last %d run(s) (%s): %+.1f file(s)/week, %s/week\n",
+		f.Runs, f.Span.Round(time.Hour), f.FilesPerWeek, report.FormatBytes(int64(f.BytesPerWeek)))
+	fmt.Fprintf(stderr, "Projected in 30 days: ~%.0f untracked file(s), ~%s\n", files, report.FormatBytes(int64(bytes)))
+	return nil
+}
+
+// runMode names the effective mode of the run for reports and attestations.
+func runMode(cfg *config) string {
+	switch {
+	case cfg.readOnly:
+		return "read-only"
+	case cfg.delete:
+		return "delete"
+	case cfg.copy:
+		return "copy"
+	case cfg.link:
+		return "link"
+	case cfg.archive != "" && cfg.move:
+		return "archive"
+	case cfg.move:
+		return "move"
+	case cfg.sample > 0:
+		return "sample"
+	default:
+		return "dry-run"
+	}
+}
+
+// scopeFlags returns the flags that limit the run to part of the library or
+// of its strays. Such a run says nothing about the strays outside its scope.
+func scopeFlags(cfg *config) []string {
+	var flags []string
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"--include", len(cfg.include) > 0},
+		{"--exclude", len(cfg.exclude) > 0},
+		{"--only-users", cfg.onlyUsers != ""},
+		{"--users", cfg.users != ""},
+		{"--only-ext", cfg.onlyExts != ""},
+		{"--skip-ext", cfg.skipExts != ""},
+		{"--min-age", cfg.minAge > 0},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	return flags
+}
+
+// buildVersion returns the release version, extended with the VCS revision
+// when the binary was built from a checkout.
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			return version + "+" + s.Value
+		}
+	}
+	return version
+}
+
+// configHash returns a stable SHA-256 over the settings that influence what a
+// run does. Secrets are excluded: the API key entirely, and the database
+// password via redaction. TestConfigHashCoversConfig fails for a config
+// field that is neither hashed nor listed as left out.
+func configHash(cfg *config) string {
+	fields := []string{
+		"immich-url=" + cfg.immichURL,
+		"library-path=" + strings.Join(append([]string{cfg.libraryPath}, cfg.mountSpecs...), ","),
+		"path-prefix=" + cfg.pathPrefix,
+		"path-map=" + fmt.Sprint(cfg.pathMaps),
+		"target-dir=" + cfg.targetDir,
+		"db-url=" + redact.URL(cfg.dbURL),
+		"mode=" + runMode(cfg),
+		"dedupe=" + strconv.FormatBool(cfg.dedupe),
+		"immich-duplicates=" + strconv.FormatBool(cfg.immichDups),
+		"match-checksums=" + strconv.FormatBool(cfg.matchSums),
+		"delete-duplicates=" + strconv.FormatBool(cfg.deleteDups),
+		"relink=" + strconv.FormatBool(cfg.relink),
+		"group-identical=" + strconv.FormatBool(cfg.groupIdentical),
+		"probe=" + strconv.FormatBool(cfg.probe),
+		"identical-strays=" + cfg.identicalMode,
+		"fold-case=" + strconv.FormatBool(cfg.foldCase),
+		"unicode-form=" + cfg.unicodeForm,
+		"url-decode=" + strconv.FormatBool(cfg.urlDecode),
+		"layout=" + cfg.layoutTmpl,
+		"per-user=" + strconv.FormatBool(cfg.perUser),
+		"on-conflict=" + cfg.onConflict,
+		"verify=" + strconv.FormatBool(cfg.verify),
+		"keep-going=" + strconv.FormatBool(cfg.keepGoing),
+		"prune-empty-dirs=" + strconv.FormatBool(cfg.pruneDirs),
+		"archive=" + cfg.archive,
+		"match-filename=" + strconv.FormatBool(cfg.matchName),
+		"sidecar-exts=" + cfg.sidecarExts,
+		"takeout=" + strconv.FormatBool(cfg.takeout),
+		"leave-companions=" + strconv.FormatBool(cfg.leaveCompanions),
+		"include=" + strings.Join(cfg.include, ","),
+		"exclude=" + strings.Join(cfg.exclude, ","),
+		"only-ext=" + cfg.onlyExts,
+		"skip-ext=" + cfg.skipExts,
+		"library-exclusions=" + strconv.FormatBool(cfg.libraryExclusions),
+		"min-age=" + cfg.minAge.String(),
+		"junk=" + cfg.junk,
+		"output=" + cfg.output,
+		"diff-against=" + cfg.diffAgainst,
+		"report-file=" + cfg.reportFile,
+		"metrics-file=" + cfg.metricsFile,
+		"pushgateway-url=" + redact.URL(cfg.pushURL),
+		"html-report=" + cfg.htmlReport,
+		"max-stray-percent=" + strconv.FormatFloat(cfg.maxStrayPercent, 'g', -1, 64),
+		"max-stray-count=" + strconv.Itoa(cfg.maxStrayCount),
+		"force=" + strconv.FormatBool(cfg.force),
+		"pre-move-hook=" + cfg.hookCmd,
+		"pre-move-hook-timeout=" + cfg.hookTimeout.String(),
+		"suspicious-dir=" + cfg.suspectDir,
+		"review=" + strconv.FormatBool(cfg.review),
+		"album=" + cfg.reviewAlbum,
+		"tag=" + cfg.reviewTag,
+		"sample=" + strconv.FormatFloat(cfg.sample, 'g', -1, 64),
+		"sample-seed=" + strconv.FormatUint(cfg.sampleSeed, 10),
+		"only-users=" + cfg.onlyUsers,
+		"users=" + cfg.users,
+		"known-dirs=" + cfg.knownDirs,
+		"categories=" + cfg.categories,
+		"review-approved=" + strconv.FormatBool(cfg.reviewApproved),
+		"redundant-only=" + strconv.FormatBool(cfg.redundantOnly),
+		"user-timeout=" + cfg.userTimeout.String(),
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+func reportAndMove(ctx context.Context, res *runResult, cfg *config, logger *slog.Logger) error {
+	untracked := res.untracked
+	if cfg.output == "text" && res.diff != nil {
+		printDiff(res.diff, len(untracked))
+	}
+	if len(untracked) == 0 {
+		logger.Info("no untracked files found")
+		return nil
+	}
+
+	if cfg.output == "text" && res.diff == nil {
+		printUntracked(untracked, res.orphanDirs)
+	}
+
+	var approved map[string]bool
+	if cfg.reviewApproved {
+		var err error
+		if approved, err = approvedStrays(ctx, cfg, logger); err != nil {
+			return err
+		}
+	}
+
+	// Misplaced database dumps go back to backups/, everything else to the
+	// quarantine. Strays outside --categories, with --review-approved those
+	// not approved, and with --redundant-only those not redundant, stay
+	// where they are.
+	var selected []matcher.UntrackedFile
+	var items []mover.Item
+	var dumps []string
+	for _, u := range untracked {
+		if cfg.moveOnly != nil && !cfg.moveOnly[u.Category] {
+			continue
+		}
+		if approved != nil && !approved[strayKey(u)] {
+			continue
+		}
+		if cfg.redundantOnly && u.ProbablyTrackedAs == "" && u.DuplicateOf == "" {
+			continue
+		}
+		if u.Category == matcher.CategoryCompanion && cfg.leaveCompanions && !cfg.moveOnly[u.Category] {
+			continue
+		}
+		selected = append(selected, u)
+		if u.Category == matcher.CategoryBackup {
+			dumps = append(dumps, u.RelPath)
+			continue
+		}
+		items = append(items, mover.Item{RelPath: u.RelPath, Category: string(u.Category), User: strayUser(u.RelPath, res.ownerDirs), DuplicateOf: u.DuplicateOf, RelinkTo: u.MissingOriginalOf, ModTime: u.ModTime,
+			Delete: u.Category == matcher.CategoryJunk && cfg.junk == junkDelete})
+	}
+
+	if err := checkStrayThreshold(res, cfg); err != nil {
+		if cfg.move {
+			return err
+		}
+		logger.Warn("a move would be refused", "reason", err)
+	}
+	if cfg.move {
+		if err := checkFreeSpace(selected, cfg); err != nil {
+			if !cfg.force {
+				return err
+			}
+			logger.Warn("moving anyway because of --force", "reason", err)
+		}
+	}
+	if cfg.moveOnly != nil || approved != nil || cfg.redundantOnly {
+		logger.Info("moving only the selected strays", "categories", cfg.categories, "review_approved", cfg.reviewApproved, "redundant_only", cfg.redundantOnly, "selected", len(selected))
+		if len(selected) == 0 {
+			return nil
+		}
+	}
+
+	switch {
+	case cfg.readOnly:
+		fmt.Fprintln(stderr, "\nRead-only mode: no files were moved.")
+	case !cfg.move:
+		fmt.Fprintln(stderr, "\nDry-run mode: no files were moved. Use the move command to relocate untracked files.")
+	case cfg.confirm:
+		if err := confirmMove(ctx, selected, cfg, os.Stdin); err != nil {
+			return err
+		}
+	}
+
+	opts := moverOptions(cfg, res.runID)
+	if len(dumps) > 0 && (cfg.copy || cfg.link) {
+		logger.Info("leaving misplaced database dumps in place", "mode", runMode(cfg), "files", len(dumps))
+	} else if len(dumps) > 0 {
+		relocated, skipped, err := mover.RelocateBackups(dumps, cfg.libraryPath, opts, logger)
+		verb := "Relocated"
+		if !cfg.move {
+			verb = "Would relocate"
+		}
+		fmt.Fprintf(stderr, "\n%s %d misplaced database dump(s) into %s/.\n", verb, relocated, mover.BackupsDir)
+		for _, p := range skipped {
+			fmt.Fprintf(stderr, "  left in place (name taken in %s/ or vanished): %s\n", mover.BackupsDir, p)
+		}
+		if cfg.move {
+			for _, p := range dumps[:relocated+len(skipped)] {
+				res.setAction(p, actionBackups)
+			}
+			for _, p := range skipped {
+				res.setAction(p, actionSkipped)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, span := tracing.Start(ctx, "move", "items", len(items), "dry_run", !cfg.move)
+	if cfg.delete {
+		opts.Progress = progress.Track("deleting strays", "files", int64(len(items)))
+	} else if cfg.copy {
+		opts.Progress = progress.Track("copying strays", "files", int64(len(items)))
+	} else if cfg.link {
+		opts.Progress = progress.Track("linking strays", "files", int64(len(items)))
+	} else if cfg.archive != "" && cfg.move {
+		opts.Progress = progress.Track("archiving strays", "files", int64(len(items)))
+	} else if cfg.move {
+		opts.Progress = progress.Track("moving strays", "files", int64(len(items)))
+	}
+	started := time.Now()
+	sum, err := mover.MoveOrphans(items, cfg.libraryPath, cfg.manifestDir, opts, logger)
+	res.moveTime = time.Since(started)
+	opts.Progress.Finish()
+	span.SetAttrs("moved", sum.Moved, "moved_bytes", sum.MovedBytes, "copied", sum.Copied, "linked", sum.Linked, "archived", sum.Archived, "deleted", sum.Deleted)
+	span.EndErr(&err)
+	printMoveSummary(sum, cfg.move)
+	if cfg.move {
+		recordMoves(res, sum)
+		res.quarantinedBytes = sum.MovedBytes + sum.CopiedBytes + sum.LinkedBytes + sum.ArchivedBytes
+	}
+	return err
+}
+
+// moverOptions returns the options a move with run ID runID is made with.
+func moverOptions(cfg *config, runID string) mover.Options {
+	return mover.Options{
+		DryRun: !cfg.move,
+		RunID:  runID,
+		Dedupe: cfg.dedupe,
+		Layout: cfg.layout,
+		Mounts: cfg.mounts,
+
+		Hook:          cfg.hook,
+		SuspiciousDir: cfg.suspectDir,
+
+		DeleteDuplicates: cfg.deleteDups,
+		Relink:           cfg.relink,
+		Delete:           cfg.delete,
+		Copy:             cfg.copy,
+		Link:             cfg.link,
+		ArchivePath:      cfg.archive,
+		Remote:           cfg.remote,
+		OnConflict:       cfg.conflict,
+		Identical:        cfg.identical,
+		Verify:           cfg.verify,
+		KeepGoing:        cfg.keepGoing,
+		PruneEmptyDirs:   cfg.pruneDirs,
+		IOLimit:          cfg.ioLimiter,
+		MinAge:           cfg.minAge,
+
+		Audit: cfg.audit,
+	}
+}
+
+// checkStrayThreshold guards against moving most of the library when the
+// asset list is empty or partial, e.g. because --db-url points at the wrong
+// database or a migration is in progress. It returns an error when the
+// untracked files exceed --max-stray-percent or --max-stray-count, unless
+// --force is set.
+func checkStrayThreshold(res *runResult, cfg *config) error {
+	if cfg.force {
+		return nil
+	}
+	n := len(res.untracked)
+	if cfg.maxStrayCount > 0 && n > cfg.maxStrayCount {
+		return fmt.Errorf("refusing to move %d untracked files: more than --max-stray-count %d; "+
+			"check that Immich returned the full asset list, then rerun with --force", n, cfg.maxStrayCount)
+	}
+	if cfg.maxStrayPercent > 0 && res.filesScanned > 0 {
+		pct := 100 * float64(n) / float64(res.filesScanned)
+		if pct > cfg.maxStrayPercent {
+			return fmt.Errorf("refusing to move %d untracked files: %.1f%% of the %d scanned exceeds --max-stray-percent %g; "+
+				"check that Immich returned the full asset list, then rerun with --force", n, pct, res.filesScanned, cfg.maxStrayPercent)
+		}
+	}
+	return nil
+}
+
+// checkFreeSpace refuses a move that would fill the filesystem holding the
+// quarantine, or the archive, before it is done. Strays on the same
+// filesystem are renamed and take no extra space unless they are copied.
+// Deletions, links, and remote targets are not checked, and neither are
+// platforms that cannot report free space.
+func checkFreeSpace(selected []matcher.UntrackedFile, cfg *config) error {
+	if cfg.delete || cfg.link || cfg.remote != nil {
+		return nil
+	}
+	dir := cfg.targetDir
+	if cfg.archive != "" {
+		dir = filepath.Dir(cfg.archive)
+	}
+	free, dev, ok := mover.FreeSpace(dir)
+	if !ok {
+		return nil
+	}
+	var need int64
+	for _, u := range selected {
+		// Database dumps go back to backups/ in the library.
+		if u.Category != matcher.CategoryBackup && (cfg.copy || cfg.archive != "" || u.Dev != dev) {
+			need += u.Size
+		}
+	}
+	if need > free {
+		return fmt.Errorf("refusing to move: the strays need %s in %s, but only %s is free; "+
+			"free up space or pick another target, or rerun with --force", report.FormatBytes(need), dir, report.FormatBytes(free))
+	}
+	return nil
+}
+
+// printUntracked lists untracked files on stderr for a human reader. The
+// files in orphans, directories of users no longer in Immich, are listed
+// as one line per directory.
+func printUntracked(untracked []matcher.UntrackedFile, orphans []report.OrphanDir) {
+	// Only annotate devices when the strays actually span several mounts.
+	devices := make(map[uint64]int)
+	for _, u := range untracked {
+		devices[u.Dev]++
+	}
+
+	probable, identical, unknown, copies, sidecars, takeout, companions, junk, probed, corrupt := 0, 0, 0, 0, 0, 0, 0, 0, 0, 0
+	var copyBytes int64
+	fmt.Fprintf(stderr, "\nFound %d untracked file(s):\n", len(untracked))
+	orphaned := make(map[string]bool, len(orphans))
+	orphanFiles := 0
+	for _, d := range orphans {
+		orphaned[d.Path] = true
+		orphanFiles += d.Files
+		fmt.Fprintf(stderr, "  %s/  (entire directory of a user no longer in Immich: %d file(s), %s)\n", d.Path, d.Files, report.FormatBytes(d.Bytes))
+	}
+	for _, u := range untracked {
+		if len(orphaned) > 0 && orphaned[orphanDirKey(u.RelPath)] {
+			continue
+		}
+		line := "  " + u.RelPath
+		if len(devices) > 1 {
+			line += fmt.Sprintf("  [device %#x]", u.Dev)
+		}
+		if u.ProbablyTrackedAs != "" {
+			line += "  (probably tracked as " + u.ProbablyTrackedAs + ")"
+			probable++
+		}
+		if u.SidecarOf != "" {
+			line += "  (sidecar of " + u.SidecarOf + ", an Immich asset)"
+			sidecars++
+		}
+		if u.TakeoutOf != "" {
+			line += "  (Google Takeout leftover of " + u.TakeoutOf + ", an Immich asset)"
+			takeout++
+		}
+		if u.CompanionOf != "" {
+			line += "  (companion of " + u.CompanionOf + ", an Immich asset)"
+			companions++
+		}
+		if u.Category == matcher.CategoryJunk {
+			line += "  (junk)"
+			junk++
+		}
+		if u.DuplicateOf != "" {
+			line += "  (identical to " + u.DuplicateOf + ", an Immich asset)"
+			identical++
+		}
+		if u.MissingOriginalOf != "" {
+			line += "  (content of " + u.MissingOriginalOf + ", an Immich asset missing from disk)"
+		}
+		if u.CopyOf != "" {
+			line += "  (copy of " + u.CopyOf + ", also a stray)"
+			copies++
+			copyBytes += u.Size
+		}
+		if u.UnknownContent {
+			unknown++
+		}
+		if u.Probe != "" {
+			probed++
+		}
+		if u.Probe == string(probe.Corrupt) {
+			line += "  (corrupt: " + u.ProbeError + ")"
+			corrupt++
+		}
+		fmt.Fprintln(stderr, line)
+	}
+	if len(orphans) > 0 {
+		fmt.Fprintf(stderr, "\n%d untracked file(s) are in %d directories of users no longer in Immich, listed as whole directories above; "+
+			"Immich left them behind when the users were removed.\n", orphanFiles, len(orphans))
+	}
+	if probable > 0 {
+		fmt.Fprintf(stderr, "\n%d untracked file(s) match an asset by owner, file name, and size and are probably tracked under a different path, e.g. after a storage template change.\n", probable)
+	}
+	if sidecars > 0 {
+		fmt.Fprintf(stderr, "\n%d untracked file(s) are sidecars of tracked originals that Immich has no record of; scan with --db-url to check the sidecars it does record.\n", sidecars)
+	}
+	if takeout > 0 {
+		fmt.Fprintf(stderr, "\n%d untracked file(s) are JSON metadata or edited copies a Google Takeout import left next to tracked originals.\n", takeout)
+	}
+	if companions > 0 {
+		fmt.Fprintf(stderr, "\n%d untracked file(s) are RAW or JPEG companions of tracked assets; moves leave them in place unless --categories lists companion or --leave-companions=false.\n", companions)
+	}
+	if junk > 0 {
+		fmt.Fprintf(stderr, "\n%d untracked file(s) are junk left by operating systems and NAS software; --junk=delete deletes them when moving, --junk=ignore leaves them out.\n", junk)
+	}
+	if unknown > 0 {
+		fmt.Fprintf(stderr, "\n%d untracked file(s) are identical to an Immich asset; %d others match no asset's checksum, so their content is unknown to Immich and needs a review.\n", identical, unknown)
+	}
+	if probed > 0 {
+		fmt.Fprintf(stderr, "\n%d of %d probed untracked file(s) no longer decode and are probably not worth rescuing; the others decode fine.\n", corrupt, probed)
+	}
+	if copies > 0 {
+		fmt.Fprintf(stderr, "\n%d untracked file(s), %s, are copies of other untracked files; --identical-strays link or delete quarantines their content once.\n", copies, report.FormatBytes(copyBytes))
+	}
+	if len(devices) > 1 {
+		fmt.Fprintf(stderr, "\nUntracked files span %d devices:\n", len(devices))
+		for _, dev := range slices.Sorted(maps.Keys(devices)) {
+			fmt.Fprintf(stderr, "  device %#x: %d file(s)\n", dev, devices[dev])
+		}
+	}
+}
+
+// printMoveSummary reports the outcome of the move phase on stderr.
+func printMoveSummary(sum *mover.Summary, moved bool) {
+	if moved {
+		if sum.Deleted > 0 {
+			fmt.Fprintf(stderr, "\nDeleted %d file(s), %s; moved %d file(s)", sum.Deleted, report.FormatBytes(sum.DeletedBytes), sum.Moved)
+		} else if sum.Copied > 0 {
+			fmt.Fprintf(stderr, "\nCopied %d file(s), %s, leaving the originals in place", sum.Copied, report.FormatBytes(sum.CopiedBytes))
+		} else if sum.Archived > 0 {
+			fmt.Fprintf(stderr, "\nArchived %d file(s), %s", sum.Archived, report.FormatBytes(sum.ArchivedBytes))
+		} else if sum.Linked > 0 || len(sum.AlreadyLinked) > 0 {
+			fmt.Fprintf(stderr, "\nLinked %d file(s), %s; the originals are deleted by purge", sum.Linked, report.FormatBytes(sum.LinkedBytes))
+			if len(sum.AlreadyLinked) > 0 {
+				fmt.Fprintf(stderr, " (%d more were linked by earlier runs)", len(sum.AlreadyLinked))
+			}
+		} else {
+			fmt.Fprintf(stderr, "\nMoved %d file(s)", sum.Moved)
+		}
+		if sum.Deduplicated > 0 {
+			fmt.Fprintf(stderr, ", deleted %d duplicate(s) of already-quarantined files", sum.Deduplicated)
+		}
+		if sum.ImmichDuplicates > 0 {
+			fmt.Fprintf(stderr, ", deleted %d duplicate(s) of Immich assets", sum.ImmichDuplicates)
+		}
+		if sum.Relinked > 0 {
+			fmt.Fprintf(stderr, ", relinked %d as the missing original(s) of Immich assets", sum.Relinked)
+		}
+		fmt.Fprintln(stderr, ".")
+		if sum.IdenticalLinked > 0 {
+			fmt.Fprintf(stderr, "%d of the moved file(s) are hardlinks of an identical file moved before them, taking no space of their own.\n", sum.IdenticalLinked)
+		}
+		if sum.Suspicious > 0 {
+			verb := "moved"
+			if sum.Copied > 0 {
+				verb = "copied"
+			} else if sum.Linked > 0 {
+				verb = "linked"
+			} else if sum.Archived > 0 {
+				verb = "archived"
+			}
+			fmt.Fprintf(stderr, "%d file(s) were rejected by the pre-move hook and %s to the suspicious directory:\n", sum.Suspicious, verb)
+			for _, e := range sum.Entries {
+				if e.Suspicious != "" {
+					fmt.Fprintf(stderr, "  %s: %s\n", e.Source, e.Suspicious)
+				}
+			}
+		}
+		if sum.Pruned > 0 {
+			fmt.Fprintf(stderr, "Removed %d directory(ies) left empty.\n", sum.Pruned)
+		}
+	}
+	if len(sum.Skipped) > 0 {
+		fmt.Fprintf(stderr, "%d file(s) were left in place because their destination is taken:\n", len(sum.Skipped))
+		for _, p := range sum.Skipped {
+			fmt.Fprintf(stderr, "  %s\n", p)
+		}
+	}
+	if len(sum.Changed) > 0 {
+		fmt.Fprintf(stderr, "%d file(s) were left in place because they were modified within --min-age or since the scan:\n", len(sum.Changed))
+		for _, p := range sum.Changed {
+			fmt.Fprintf(stderr, "  %s\n", p)
+		}
+	}
+	if len(sum.Vanished) > 0 {
+		fmt.Fprintf(stderr, "%d file(s) vanished between scan and move and were skipped:\n", len(sum.Vanished))
+		for _, p := range sum.Vanished {
+			fmt.Fprintf(stderr, "  %s\n", p)
+		}
+	}
+	if len(sum.Failed) > 0 {
+		fmt.Fprintf(stderr, "%d file(s) could not be handled and were left in place:\n", len(sum.Failed))
+		for _, f := range sum.Failed {
+			fmt.Fprintf(stderr, "  %s: %v\n", f.RelPath, f.Err)
+		}
+	}
+}
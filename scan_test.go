@@ -0,0 +1,153 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/goeland86/immich-stray-finder/matcher"
+)
+
+func TestCheckStrayThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		untracked int
+		scanned   int
+		percent   float64
+		count     int
+		force     bool
+		wantErr   string
+	}{
+		{"below both", 5, 100, 10, 10, false, ""},
+		{"percent exactly at the limit", 10, 100, 10, 0, false, ""},
+		{"percent just above the limit", 11, 100, 10, 0, false, "--max-stray-percent"},
+		{"count exactly at the limit", 10, 1000, 0, 10, false, ""},
+		{"count just above the limit", 11, 1000, 0, 10, false, "--max-stray-count"},
+		{"count checked before percent", 50, 100, 10, 10, false, "--max-stray-count"},
+		{"both disabled", 100, 100, 0, 0, false, ""},
+		{"nothing scanned", 3, 0, 10, 0, false, ""},
+		{"no strays", 0, 0, 10, 1, false, ""},
+		{"every file a stray", 100, 100, 99.9, 0, false, "100.0% of the 100 scanned"},
+		{"force lifts both", 100, 100, 10, 10, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &runResult{filesScanned: tt.scanned, untracked: make([]matcher.UntrackedFile, tt.untracked)}
+			cfg := &config{maxStrayPercent: tt.percent, maxStrayCount: tt.count, force: tt.force}
+			err := checkStrayThreshold(res, cfg)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScopeFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config
+		want []string
+	}{
+		{"whole library", config{}, nil},
+		{"include", config{include: []string{"library/**"}}, []string{"--include"}},
+		{"exclude", config{exclude: []string{"**/*.xmp"}}, []string{"--exclude"}},
+		{"users", config{users: "alice"}, []string{"--users"}},
+		{"only users", config{onlyUsers: "alice"}, []string{"--only-users"}},
+		{"extensions", config{onlyExts: ".mp4", skipExts: ".xmp"}, []string{"--only-ext", "--skip-ext"}},
+		{"min age", config{minAge: time.Hour}, []string{"--min-age"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scopeFlags(&tt.cfg); !slices.Equal(got, tt.want) {
+				t.Errorf("scopeFlags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// notHashed lists the config fields configHash leaves out on purpose. A new
+// field must either change the hash or be added here.
+var notHashed = map[string]bool{
+	// Secrets.
+	"apiKey": true, "attestKey": true, "webhookToken": true,
+	// Derived while parsing from fields that are hashed.
+	"mounts": true, "remote": true, "notifiers": true, "scanLimiter": true, "ioLimiter": true,
+	"moveOnly": true, "layout": true, "conflict": true, "identical": true, "hook": true,
+	"sampleSpec": true, "diffLast": true, "rerunArgs": true, "flagValues": true,
+	// Logging, tracing, and notifications, which do not change the result.
+	"verbose": true, "redactKeys": true, "logFormat": true, "logFile": true, "logMaxSize": true,
+	"logBackups": true, "otlpURL": true, "notifyURL": true, "notifySpecs": true,
+	"progressEvery": true, "progress": true,
+	// Where the records of a run are kept.
+	"manifestDir": true, "stateDir": true, "historyFile": true, "auditFile": true, "audit": true,
+	"attestFile": true, "outputDir": true, "reviewMap": true,
+	// Pace and parallelism, and the caches that only save work.
+	"scanWorkers": true, "scanRate": true, "ioLimit": true, "hashWorkers": true, "full": true,
+	// Limits on what is shown or uploaded, and how the run is confirmed.
+	"previews": true, "reviewMax": true, "reviewSize": true, "ffprobe": true,
+	"failOnUntracked": true, "yes": true, "confirm": true,
+	// Settings of the commands that do not scan.
+	"derivatives": true, "dryRun": true, "runID": true, "glob": true, "retention": true,
+	"interval": true, "schedule": true, "settle": true, "refresh": true, "listenAddr": true,
+	"webhookPath": true,
+}
+
+func TestConfigHashCoversConfig(t *testing.T) {
+	base := configHash(&config{})
+	typ := reflect.TypeFor[config]()
+	fields := make(map[string]bool)
+	for i := range typ.NumField() {
+		name := typ.Field(i).Name
+		fields[name] = true
+		var cfg config
+		f := reflect.ValueOf(&cfg).Elem().Field(i)
+		setNonZero(reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem())
+		switch hashed := configHash(&cfg) != base; {
+		case !hashed && !notHashed[name]:
+			t.Errorf("config.%s does not change configHash; hash it or add it to notHashed", name)
+		case hashed && notHashed[name]:
+			t.Errorf("config.%s changes configHash but is listed in notHashed", name)
+		}
+	}
+	for name := range notHashed {
+		if !fields[name] {
+			t.Errorf("notHashed lists %s, which is not a config field", name)
+		}
+	}
+}
+
+// setNonZero sets v, and the first element of a slice or map, to a value
+// other than its zero value.
+func setNonZero(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1)
+	case reflect.Slice:
+		s := reflect.MakeSlice(v.Type(), 1, 1)
+		setNonZero(s.Index(0))
+		v.Set(s)
+	case reflect.Map:
+		key, elem := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+		setNonZero(key)
+		setNonZero(elem)
+		m := reflect.MakeMap(v.Type())
+		m.SetMapIndex(key, elem)
+		v.Set(m)
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+	}
+}
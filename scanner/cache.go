@@ -0,0 +1,229 @@
+package scanner
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/runstats"
+)
+
+// cacheVersion is bumped whenever the cache file format changes; a cache
+// of another version is discarded.
+const cacheVersion = 1
+
+// racyWindow is how old a directory must be for its files to be cached.
+// A directory changed again within the resolution of its modification
+// time would look unchanged to the next walk.
+const racyWindow = 2 * time.Second
+
+// Cache remembers the size, modification time, and device of the files
+// in every directory a walk listed, so that a later walk can skip
+// stat'ing the files of directories that have not changed since. Which
+// files exist is always taken from the directory listing; only their
+// attributes come from the cache. A file rewritten in place keeps its
+// directory's modification time, so its cached size and time go stale
+// until a walk without the cache.
+//
+// A Cache may be shared by concurrent walks of disjoint directories.
+type Cache struct {
+	// dirs is what the cache was loaded with; it is never modified.
+	dirs map[string]*cacheDir
+
+	mu sync.Mutex
+	// next collects the directories listed by walks since the cache was
+	// loaded, which is what Save writes.
+	next           map[string]*cacheDir
+	reused, stated int
+}
+
+// cacheDir describes the files of one directory, by name.
+type cacheDir struct {
+	ModTime int64
+	Files   map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	Size    int64
+	ModTime int64
+	Dev     uint64
+}
+
+// cacheFile is the on-disk form of a Cache.
+type cacheFile struct {
+	Version int
+	Dirs    map[string]*cacheDir
+}
+
+// NewCache returns an empty cache.
+func NewCache() *Cache {
+	return &Cache{dirs: map[string]*cacheDir{}, next: map[string]*cacheDir{}}
+}
+
+// LoadCache reads a cache written by Save. A missing file yields an empty
+// cache.
+func LoadCache(path string) (*Cache, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return NewCache(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open scan cache: %w", err)
+	}
+	defer f.Close()
+	var cf cacheFile
+	if err := gob.NewDecoder(f).Decode(&cf); err != nil {
+		return nil, fmt.Errorf("read scan cache %s: %w", path, err)
+	}
+	if cf.Version != cacheVersion {
+		return nil, fmt.Errorf("scan cache %s has version %d, want %d", path, cf.Version, cacheVersion)
+	}
+	c := NewCache()
+	if cf.Dirs != nil {
+		c.dirs = cf.Dirs
+	}
+	return c, nil
+}
+
+// Save writes the directories listed since the cache was loaded to path,
+// replacing it atomically. Directories no walk listed are dropped.
+func (c *Cache) Save(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cf := cacheFile{Version: cacheVersion, Dirs: c.next}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".scan-cache-*")
+	if err != nil {
+		return fmt.Errorf("create scan cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(&cf); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write scan cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write scan cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write scan cache: %w", err)
+	}
+	return nil
+}
+
+// Stats returns how many files walks took from the cache and how many
+// they had to stat.
+func (c *Cache) Stats() (reused, stated int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reused, c.stated
+}
+
+// cacheWalk is the part of a Cache used by a single walk. It needs no
+// locking until commit.
+type cacheWalk struct {
+	c *Cache
+	// root is the absolute path of the walked directory; paths passed to
+	// the methods are relative to it.
+	root           string
+	now            time.Time
+	dirs           map[string]*walkDir
+	reused, stated int
+}
+
+// walkDir is what a walk knows about one directory it entered.
+type walkDir struct {
+	// old is the cached files of the directory, or nil if it changed.
+	old map[string]cacheEntry
+	// rec collects the files for the next cache, or is nil if the
+	// directory changed too recently to be cached.
+	rec *cacheDir
+}
+
+// walk starts a walk of root. A nil Cache yields a nil cacheWalk, whose
+// methods do nothing.
+func (c *Cache) walk(root string) *cacheWalk {
+	if c == nil {
+		return nil
+	}
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	return &cacheWalk{c: c, root: root, now: time.Now(), dirs: make(map[string]*walkDir)}
+}
+
+// enterDir looks up the directory rel, described by d.
+func (w *cacheWalk) enterDir(rel string, d fs.DirEntry) {
+	if w == nil {
+		return
+	}
+	info, err := d.Info()
+	runstats.AddFileStat()
+	if err != nil {
+		// Its files are stat'ed and not cached.
+		return
+	}
+	key := filepath.Join(w.root, rel)
+	mtime := info.ModTime().UnixNano()
+	wd := &walkDir{}
+	if old := w.c.dirs[key]; old != nil && old.ModTime == mtime {
+		wd.old = old.Files
+	}
+	if w.now.Sub(info.ModTime()) > racyWindow {
+		wd.rec = &cacheDir{ModTime: mtime, Files: make(map[string]cacheEntry)}
+	}
+	w.dirs[key] = wd
+}
+
+// lookup returns the cached attributes of the file rel.
+func (w *cacheWalk) lookup(rel string) (File, bool) {
+	if w == nil {
+		return File{}, false
+	}
+	dir, name := filepath.Split(filepath.Join(w.root, rel))
+	wd := w.dirs[filepath.Clean(dir)]
+	if wd == nil {
+		return File{}, false
+	}
+	e, ok := wd.old[name]
+	if !ok {
+		return File{}, false
+	}
+	if wd.rec != nil {
+		wd.rec.Files[name] = e
+	}
+	w.reused++
+	return File{Size: e.Size, ModTime: time.Unix(0, e.ModTime), Dev: e.Dev}, true
+}
+
+// record stores the attributes of the file rel, which had to be stat'ed.
+func (w *cacheWalk) record(rel string, f File) {
+	if w == nil {
+		return
+	}
+	w.stated++
+	dir, name := filepath.Split(filepath.Join(w.root, rel))
+	if wd := w.dirs[filepath.Clean(dir)]; wd != nil && wd.rec != nil {
+		wd.rec.Files[name] = cacheEntry{Size: f.Size, ModTime: f.ModTime.UnixNano(), Dev: f.Dev}
+	}
+}
+
+// commit adds what the walk learned to the cache.
+func (w *cacheWalk) commit() {
+	if w == nil {
+		return
+	}
+	w.c.mu.Lock()
+	defer w.c.mu.Unlock()
+	for key, wd := range w.dirs {
+		if wd.rec != nil {
+			w.c.next[key] = wd.rec
+		}
+	}
+	w.c.reused += w.reused
+	w.c.stated += w.stated
+}
@@ -0,0 +1,121 @@
+package scanner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// walkWithCache walks root with cache and returns the files by path.
+func walkWithCache(t *testing.T, root string, cache *Cache) map[string]File {
+	t.Helper()
+	files := make(map[string]File)
+	err := Walk(context.Background(), root, "", Options{Cache: cache}, testLogger(), func(f File) error {
+		files[f.RelPath] = f
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	return files
+}
+
+// age sets the modification time of the paths well past the racy window.
+func age(t *testing.T, paths ...string) {
+	t.Helper()
+	old := time.Now().Add(-time.Hour)
+	for _, p := range paths {
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCache_ReusesUnchangedDirectories(t *testing.T) {
+	root := t.TempDir()
+	a, b := filepath.Join(root, "a"), filepath.Join(root, "b")
+	os.MkdirAll(a, 0o755)
+	os.MkdirAll(b, 0o755)
+	os.WriteFile(filepath.Join(a, "1.jpg"), []byte("one"), 0o644)
+	os.WriteFile(filepath.Join(b, "2.jpg"), []byte("two"), 0o644)
+	age(t, a, b, root)
+
+	cachePath := filepath.Join(t.TempDir(), "cache")
+	first := NewCache()
+	want := walkWithCache(t, root, first)
+	if reused, stated := first.Stats(); reused != 0 || stated != 2 {
+		t.Fatalf("first walk: expected 0 reused and 2 stated, got %d and %d", reused, stated)
+	}
+	if err := first.Save(cachePath); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	// A new file in b changes b's modification time; a is untouched.
+	os.WriteFile(filepath.Join(b, "3.jpg"), []byte("three"), 0o644)
+	age(t, b)
+
+	second, err := LoadCache(cachePath)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	got := walkWithCache(t, root, second)
+	if reused, stated := second.Stats(); reused != 1 || stated != 2 {
+		t.Errorf("second walk: expected 1 reused and 2 stated, got %d and %d", reused, stated)
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 files, got %v", got)
+	}
+	for _, p := range []string{"a/1.jpg", "b/2.jpg"} {
+		if got[p].Size != want[p].Size || !got[p].ModTime.Equal(want[p].ModTime) || got[p].Dev != want[p].Dev {
+			t.Errorf("%s: expected %+v, got %+v", p, want[p], got[p])
+		}
+	}
+	if got["b/3.jpg"].Size != 5 {
+		t.Errorf("expected the new file to be stat'ed, got %+v", got["b/3.jpg"])
+	}
+}
+
+func TestCache_DeletedFilesAreNotReported(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "1.jpg"), []byte("one"), 0o644)
+	os.WriteFile(filepath.Join(root, "2.jpg"), []byte("two"), 0o644)
+	age(t, root)
+	cache := NewCache()
+	walkWithCache(t, root, cache)
+
+	os.Remove(filepath.Join(root, "2.jpg"))
+	// Even if the directory looked unchanged, the listing decides which
+	// files exist.
+	age(t, root)
+	next := &Cache{dirs: cache.next, next: map[string]*cacheDir{}}
+	got := walkWithCache(t, root, next)
+	if len(got) != 1 {
+		t.Errorf("expected only 1.jpg, got %v", got)
+	}
+}
+
+func TestCache_SkipsRecentDirectories(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "1.jpg"), []byte("one"), 0o644)
+
+	cache := NewCache()
+	walkWithCache(t, root, cache)
+	if len(cache.next) != 0 {
+		t.Errorf("expected a directory changed just now not to be cached, got %v", cache.next)
+	}
+}
+
+func TestLoadCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	c, err := LoadCache(path)
+	if err != nil || len(c.dirs) != 0 {
+		t.Fatalf("expected an empty cache for a missing file, got %v, %v", c, err)
+	}
+
+	os.WriteFile(path, []byte("not a cache"), 0o644)
+	if _, err := LoadCache(path); err == nil {
+		t.Error("expected an error for a corrupt cache")
+	}
+}
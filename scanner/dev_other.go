@@ -0,0 +1,11 @@
+//go:build !unix
+
+package scanner
+
+import "io/fs"
+
+// deviceOf is not supported on this platform; every file reports device 0,
+// which disables mount boundary detection.
+func deviceOf(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,54 @@
+package scanner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DefaultKnownDirs are the names under which Immich's machine-learning
+// model cache and reverse-geocoding data are usually mounted. Some compose
+// setups place them under the storage root; they hold gigabytes of files
+// that are not assets and must not be reported as strays.
+var DefaultKnownDirs = []string{"model-cache", "geodata"}
+
+// Kinds of known directories found by RecognizeKnownDirs.
+const (
+	KnownModelCache = "model-cache"
+	KnownGeodata    = "geodata"
+)
+
+// knownMarkers are entries whose presence identifies a directory's kind:
+// the model cache keeps one subdirectory per model task, and the geodata
+// directory holds the GeoNames dumps Immich imports.
+var knownMarkers = []struct {
+	kind, entry string
+}{
+	{KnownModelCache, "clip"},
+	{KnownModelCache, "facial-recognition"},
+	{KnownGeodata, "cities500.txt"},
+	{KnownGeodata, "geodata-date.txt"},
+}
+
+// RecognizeKnownDirs looks for a model cache or geodata directory among
+// the top-level directories of root that Immich does not manage, whatever
+// their name. It returns the kind of each one it recognizes, by name.
+func RecognizeKnownDirs(root string) (map[string]string, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, fmt.Errorf("list library root: %w", err)
+	}
+	found := make(map[string]string)
+	for _, e := range entries {
+		if !e.IsDir() || Managed(e.Name()) || Excluded(e.Name()) {
+			continue
+		}
+		for _, m := range knownMarkers {
+			if _, err := os.Stat(filepath.Join(root, e.Name(), m.entry)); err == nil {
+				found[e.Name()] = m.kind
+				break
+			}
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,31 @@
+package scanner
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecognizeKnownDirs(t *testing.T) {
+	root := t.TempDir()
+	for _, p := range []string{
+		"ml/clip/ViT-B-32__openai/textual/model.onnx",
+		"ml/facial-recognition/buffalo_l/detection/model.onnx",
+		"reverse-geocoding/cities500.txt",
+		"misc/clip.txt",
+		"library/admin/clip/a.jpg",
+	} {
+		os.MkdirAll(filepath.Join(root, filepath.Dir(p)), 0o755)
+		os.WriteFile(filepath.Join(root, p), []byte("x"), 0o644)
+	}
+
+	got, err := RecognizeKnownDirs(root)
+	if err != nil {
+		t.Fatalf("RecognizeKnownDirs: %v", err)
+	}
+	want := map[string]string{"ml": KnownModelCache, "reverse-geocoding": KnownGeodata}
+	if !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
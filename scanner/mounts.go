@@ -0,0 +1,156 @@
+package scanner
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// expectedDirs are the top-level directories Immich manages below its
+// storage root. They may live on different mounts.
+var expectedDirs = []string{"library", "upload", "thumbs", "encoded-video", "profile"}
+
+// Managed reports whether name is one of the top-level directories Immich
+// manages.
+func Managed(name string) bool {
+	return slices.Contains(expectedDirs, name)
+}
+
+// Mount describes the device a top-level directory lives on.
+type Mount struct {
+	// Dir is the top-level directory name ("" for the root itself).
+	Dir string
+	// Dev is the device ID.
+	Dev uint64
+	// Boundary is true when Dir is on a different device than the root,
+	// i.e. it is (or is below) a separate mount.
+	Boundary bool
+	// Empty is true when Dir contains no entries at all.
+	Empty bool
+	// Missing is true when an expected directory does not exist.
+	Missing bool
+}
+
+// DetectMounts inspects root and its expected top-level directories and
+// reports which device each one is on. On platforms without device IDs every
+// directory reports device 0 and no boundaries.
+func DetectMounts(root string) ([]Mount, error) {
+	rootInfo, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("stat library root: %w", err)
+	}
+	rootDev, _ := deviceOf(rootInfo)
+
+	mounts := []Mount{{Dev: rootDev}}
+	for _, dir := range expectedDirs {
+		path := filepath.Join(root, dir)
+		info, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				mounts = append(mounts, Mount{Dir: dir, Missing: true})
+				continue
+			}
+			return nil, fmt.Errorf("stat %s: %w", dir, err)
+		}
+		if !info.IsDir() {
+			continue
+		}
+		dev, _ := deviceOf(info)
+		empty, err := isEmptyDir(path)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, Mount{Dir: dir, Dev: dev, Boundary: dev != rootDev, Empty: empty})
+	}
+	return mounts, nil
+}
+
+// MountWarnings flags expected directories that do not exist, and layouts
+// that look like a partially migrated library with a missing mount: some
+// top-level directories are separate mounts while another one sits empty on
+// the root device, which is exactly what an unmounted volume looks like.
+func MountWarnings(mounts []Mount) []string {
+	var warnings []string
+	var separate []string
+	for _, m := range mounts {
+		if m.Missing {
+			warnings = append(warnings, fmt.Sprintf(
+				"%s/ does not exist, though Immich creates it; is a volume not mounted, or is this not the directory Immich stores its media in?", m.Dir))
+		}
+		if m.Boundary {
+			separate = append(separate, m.Dir+"/")
+		}
+	}
+	if len(separate) == 0 {
+		return warnings
+	}
+	sort.Strings(separate)
+
+	for _, m := range mounts {
+		if m.Dir != "" && m.Empty && !m.Boundary {
+			warnings = append(warnings, fmt.Sprintf(
+				"%s/ is empty and on the root filesystem while %s are separate mounts; is a volume not mounted?",
+				m.Dir, strings.Join(separate, ", ")))
+		}
+	}
+	return warnings
+}
+
+// MarkerFile is the file Immich writes into each top-level directory it
+// manages, and checks for when it starts, to tell a mounted volume from an
+// empty mount point.
+const MarkerFile = ".immich"
+
+// MarkerWarnings flags the expected top-level directories lacking their
+// MarkerFile, which suggests a wrong library path or a volume that is not
+// mounted. join maps a forward-slash path relative to the library root to
+// its path on disk, so a directory mounted elsewhere is checked where it
+// is. When no directory has a marker at all, it returns a single warning
+// instead, since older versions of Immich write none.
+func MarkerWarnings(join func(rel string) string) []string {
+	var marked, unmarked []string
+	var warnings []string
+	for _, dir := range expectedDirs {
+		_, err := os.Stat(join(dir + "/" + MarkerFile))
+		switch {
+		case err == nil:
+			marked = append(marked, dir+"/")
+		case errors.Is(err, fs.ErrNotExist):
+			unmarked = append(unmarked, dir)
+		default:
+			warnings = append(warnings, fmt.Sprintf("cannot check the %s marker of %s/: %v", MarkerFile, dir, err))
+		}
+	}
+	if len(marked) == 0 {
+		return append(warnings, fmt.Sprintf(
+			"no top-level directory has an %s marker file; is this the directory Immich stores its media in? (Older versions of Immich write none.)",
+			MarkerFile))
+	}
+	for _, dir := range unmarked {
+		warnings = append(warnings, fmt.Sprintf(
+			"%s has no %s marker file while %s do; is a volume not mounted there?",
+			join(dir), MarkerFile, strings.Join(marked, ", ")))
+	}
+	return warnings
+}
+
+// isEmptyDir reports whether the directory at path has no entries.
+func isEmptyDir(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	_, err = f.Readdirnames(1)
+	if err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
@@ -0,0 +1,97 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectMounts_SingleDevice(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "library", "admin"), 0o755)
+	os.MkdirAll(filepath.Join(root, "thumbs"), 0o755)
+
+	mounts, err := DetectMounts(root)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	byDir := make(map[string]Mount)
+	for _, m := range mounts {
+		byDir[m.Dir] = m
+	}
+	if byDir["library"].Boundary || byDir["library"].Empty {
+		t.Errorf("library should be a non-empty directory on the root device: %+v", byDir["library"])
+	}
+	if !byDir["thumbs"].Empty {
+		t.Error("thumbs should be reported empty")
+	}
+	if !byDir["upload"].Missing {
+		t.Error("upload should be reported missing")
+	}
+	// Only the missing directories are flagged on a single device.
+	if w := MountWarnings(mounts); len(w) != 3 || !strings.HasPrefix(w[0], "upload/ does not exist") {
+		t.Errorf("expected warnings about upload/, encoded-video/, and profile/, got %v", w)
+	}
+}
+
+func TestMountWarnings_Missing(t *testing.T) {
+	mounts := []Mount{
+		{Dev: 1},
+		{Dir: "library", Dev: 1},
+		{Dir: "upload", Missing: true},
+		{Dir: "thumbs", Dev: 2, Boundary: true},
+	}
+	w := MountWarnings(mounts)
+	if len(w) != 1 || !strings.HasPrefix(w[0], "upload/ does not exist") {
+		t.Errorf("expected one warning about upload/, got %v", w)
+	}
+	if w := MountWarnings(mounts[:2]); len(w) != 0 {
+		t.Errorf("expected no warnings, got %v", w)
+	}
+}
+
+func TestMountWarnings_EmptyDirBesideSeparateMount(t *testing.T) {
+	mounts := []Mount{
+		{Dev: 1},
+		{Dir: "library", Dev: 1, Empty: true},
+		{Dir: "upload", Dev: 2, Boundary: true},
+		{Dir: "thumbs", Dev: 1},
+	}
+	w := MountWarnings(mounts)
+	if len(w) != 1 || !strings.HasPrefix(w[0], "library/") {
+		t.Errorf("expected one warning about library/, got %v", w)
+	}
+}
+
+func TestMarkerWarnings(t *testing.T) {
+	root := t.TempDir()
+	join := func(rel string) string { return filepath.Join(root, filepath.FromSlash(rel)) }
+	if w := MarkerWarnings(join); len(w) != 1 || !strings.HasPrefix(w[0], "no top-level directory") {
+		t.Errorf("expected one warning about the missing markers, got %v", w)
+	}
+
+	for _, dir := range expectedDirs {
+		os.MkdirAll(filepath.Join(root, dir), 0o755)
+		if dir != "thumbs" {
+			os.WriteFile(filepath.Join(root, dir, MarkerFile), nil, 0o644)
+		}
+	}
+	if w := MarkerWarnings(join); len(w) != 1 || !strings.HasPrefix(w[0], filepath.Join(root, "thumbs")+" ") {
+		t.Errorf("expected one warning about thumbs/, got %v", w)
+	}
+
+	// A marker of a directory mounted elsewhere is looked for there.
+	elsewhere := t.TempDir()
+	os.WriteFile(filepath.Join(elsewhere, MarkerFile), nil, 0o644)
+	join = func(rel string) string {
+		if sub, ok := strings.CutPrefix(rel, "thumbs/"); ok {
+			return filepath.Join(elsewhere, sub)
+		}
+		return filepath.Join(root, filepath.FromSlash(rel))
+	}
+	if w := MarkerWarnings(join); len(w) != 0 {
+		t.Errorf("expected no warnings, got %v", w)
+	}
+}
@@ -2,10 +2,21 @@ package scanner
 
 import (
 	"context"
+	"errors"
 	"io/fs"
 	"log/slog"
+	"os"
+	pathpkg "path"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/ignore"
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/runstats"
+	"github.com/goeland86/immich-stray-finder/sampling"
+	"github.com/goeland86/immich-stray-finder/throttle"
+	"github.com/goeland86/immich-stray-finder/tracing"
 )
 
 // excludeDirs are directories that should be skipped during scanning.
@@ -16,17 +27,102 @@ var excludeDirs = map[string]struct{}{
 	"backups": {},
 }
 
-// ScanFiles walks libraryPath and returns all file paths relative to it,
-// using forward slashes to match Immich's originalPath format.
-// The backups/ directory is automatically excluded.
-func ScanFiles(ctx context.Context, libraryPath string, logger *slog.Logger) ([]string, error) {
-	var files []string
+// File is a regular file found on disk.
+type File struct {
+	// RelPath is the path relative to the scanned root, using forward slashes
+	// to match Immich's originalPath format.
+	RelPath string
+	// Size is the file size in bytes.
+	Size int64
+	// ModTime is the file's last modification time.
+	ModTime time.Time
+	// Dev is the ID of the device holding the file (0 where unsupported).
+	Dev uint64
+}
+
+// ScanFiles walks libraryPath and returns all files below it, with paths
+// relative to libraryPath. The backups/ directory is automatically excluded.
+func ScanFiles(ctx context.Context, libraryPath string, logger *slog.Logger) ([]File, error) {
+	return ScanFilesWithPrefix(ctx, libraryPath, "", logger)
+}
+
+// ScanFilesWithPrefix walks libraryPath and returns paths with the given
+// prefix prepended, using forward slashes. This is useful when Immich stores
+// paths like "upload/library/admin/..." and libraryPath points to the parent
+// of "upload/".
+func ScanFilesWithPrefix(ctx context.Context, libraryPath, prefix string, logger *slog.Logger) ([]File, error) {
+	var files []File
+	err := Walk(ctx, libraryPath, prefix, Options{}, logger, func(f File) error {
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Options refine a Walk.
+type Options struct {
+	// Sample, when set, restricts the walk to a sample of directories:
+	// only files in directories the selector includes are stat'ed and
+	// passed to fn, but every directory is still listed so the selector
+	// learns the size of the population.
+	Sample *sampling.Selector
+	// Skip lists directories, relative to the walked root in forward-slash
+	// form, that are not descended into, e.g. because another walk covers
+	// them.
+	Skip []string
+	// OnError, when set, is called for every path that cannot be read.
+	// The walk logs such paths and carries on either way.
+	OnError func(path string, err error)
+	// Cache, when set, supplies the attributes of files in directories
+	// that have not changed since an earlier walk, and learns those of
+	// the others. It is not used for sampled walks.
+	Cache *Cache
+	// Rate, when set, limits how many files per second the walk visits.
+	Rate *throttle.Limiter
+	// Ignore, when set, holds the .strayignore rules of the library that
+	// paths with the prefix are matched against. Ignored directories are
+	// not descended into and ignored files not passed to fn.
+	Ignore *ignore.Rules
+	// Filter, when set, limits the walk to the paths of --include and
+	// --exclude, with the prefix.
+	Filter *paths.Filter
+}
 
+// Walk walks libraryPath and calls fn for every file below it, in lexical
+// order, with prefix prepended to its relative path. The backups/ directory
+// is automatically excluded. An error from fn stops the walk and is
+// returned.
+func Walk(ctx context.Context, libraryPath, prefix string, opts Options, logger *slog.Logger, fn func(File) error) (err error) {
 	libraryPath = filepath.Clean(libraryPath)
+	if prefix != "" {
+		prefix = strings.TrimRight(prefix, "/") + "/"
+	}
+	skip := make(map[string]bool, len(opts.Skip))
+	for _, dir := range opts.Skip {
+		skip[strings.Trim(dir, "/")] = true
+	}
+	sample := opts.Sample
+	var cache *cacheWalk
+	if sample == nil {
+		cache = opts.Cache.walk(libraryPath)
+		defer cache.commit()
+	}
+	count, ignored := 0, 0
+	_, span := tracing.Start(ctx, "scan.walk", "root", libraryPath)
+	defer func() {
+		span.SetAttrs("files", count, "ignored", ignored)
+		span.EndErr(&err)
+	}()
 
-	err := filepath.WalkDir(libraryPath, func(path string, d fs.DirEntry, err error) error {
+	err = filepath.WalkDir(libraryPath, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			logger.Warn("error accessing path", "path", path, "error", err)
+			if opts.OnError != nil {
+				opts.OnError(path, err)
+			}
 			return nil // skip but continue
 		}
 
@@ -35,17 +131,29 @@ func ScanFiles(ctx context.Context, libraryPath string, logger *slog.Logger) ([]
 		}
 
 		if d.IsDir() {
+			rel, relErr := filepath.Rel(libraryPath, path)
+			if relErr != nil {
+				return nil
+			}
 			// Skip excluded top-level directories.
 			if path != libraryPath {
-				rel, relErr := filepath.Rel(libraryPath, path)
-				if relErr == nil {
-					topDir := strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
-					if _, excluded := excludeDirs[topDir]; excluded {
-						logger.Debug("skipping excluded directory", "dir", topDir)
-						return filepath.SkipDir
-					}
+				if Excluded(paths.FromOS(rel)) {
+					logger.Debug("skipping excluded directory", "dir", paths.TopDir(paths.FromOS(rel)))
+					return filepath.SkipDir
+				}
+				if skip[paths.FromOS(rel)] {
+					return filepath.SkipDir
+				}
+				if opts.Filter.SkipDir(prefix + paths.FromOS(rel)) {
+					return filepath.SkipDir
+				}
+				if opts.Ignore.Match(prefix+paths.FromOS(rel), true) {
+					logger.Debug("skipping ignored directory", "dir", prefix+paths.FromOS(rel))
+					ignored++
+					return filepath.SkipDir
 				}
 			}
+			cache.enterDir(rel, d)
 			return nil
 		}
 
@@ -54,42 +162,83 @@ func ScanFiles(ctx context.Context, libraryPath string, logger *slog.Logger) ([]
 			logger.Warn("cannot compute relative path", "path", path, "error", err)
 			return nil
 		}
+		relPath := prefix + paths.FromOS(rel)
+		if !opts.Filter.Keep(relPath) {
+			return nil
+		}
+		if opts.Ignore.Match(relPath, false) {
+			ignored++
+			return nil
+		}
+		if sample != nil && !sample.Include(pathpkg.Dir(relPath)) {
+			return nil
+		}
 
-		// Normalize to forward slashes to match Immich's originalPath.
-		rel = filepath.ToSlash(rel)
+		if err := opts.Rate.Wait(ctx, 1); err != nil {
+			return err
+		}
+		f, ok := cache.lookup(rel)
+		if !ok {
+			info, err := d.Info()
+			runstats.AddFileStat()
+			if err != nil {
+				// The file may have been removed since the directory was read.
+				logger.Warn("cannot stat file", "path", path, "error", err)
+				if opts.OnError != nil && !errors.Is(err, fs.ErrNotExist) {
+					opts.OnError(path, err)
+				}
+				return nil
+			}
+			dev, _ := deviceOf(info)
+			f = File{Size: info.Size(), ModTime: info.ModTime(), Dev: dev}
+			cache.record(rel, f)
+		}
+		count++
 
-		files = append(files, rel)
-		return nil
+		// Normalize to forward slashes to match Immich's originalPath.
+		f.RelPath = relPath
+		return fn(f)
 	})
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	logger.Info("filesystem scan complete",
 		"library_path", libraryPath,
-		"files_found", len(files),
+		"files_found", count,
+		"ignored", ignored,
 	)
-	return files, nil
+	return nil
 }
 
-// ScanFilesWithPrefix walks libraryPath and returns paths with the given
-// prefix prepended, using forward slashes. This is useful when Immich stores
-// paths like "upload/library/admin/..." and libraryPath points to the parent
-// of "upload/".
-func ScanFilesWithPrefix(ctx context.Context, libraryPath, prefix string, logger *slog.Logger) ([]string, error) {
-	files, err := ScanFiles(ctx, libraryPath, logger)
+// Excluded reports whether rel, a forward-slash path relative to the
+// scanned root, lies in a directory the scan skips.
+func Excluded(rel string) bool {
+	_, excluded := excludeDirs[paths.TopDir(rel)]
+	return excluded
+}
+
+// Stat describes the single file at path, which must lie below
+// libraryPath, the way Walk would with the same prefix.
+func Stat(libraryPath, prefix, path string) (File, error) {
+	rel, err := filepath.Rel(filepath.Clean(libraryPath), path)
 	if err != nil {
-		return nil, err
+		return File{}, err
 	}
-
-	if prefix == "" {
-		return files, nil
+	if prefix != "" {
+		prefix = strings.TrimRight(prefix, "/") + "/"
 	}
-
-	prefix = strings.TrimRight(prefix, "/") + "/"
-	for i, f := range files {
-		files[i] = prefix + f
+	info, err := os.Lstat(path)
+	runstats.AddFileStat()
+	if err != nil {
+		return File{}, err
 	}
-	return files, nil
+	dev, _ := deviceOf(info)
+	return File{
+		RelPath: prefix + paths.FromOS(rel),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Dev:     dev,
+	}, nil
 }
@@ -4,15 +4,32 @@ import (
 	"context"
 	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"testing"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/ignore"
+	"github.com/goeland86/immich-stray-finder/sampling"
 )
 
 func testLogger() *slog.Logger {
 	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
 }
 
+// relPaths extracts the sorted relative paths from scan results.
+func relPaths(files []File) []string {
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.RelPath
+	}
+	sort.Strings(paths)
+	return paths
+}
+
 func TestScanFiles(t *testing.T) {
 	// Create a temp directory structure.
 	tmpDir := t.TempDir()
@@ -37,12 +54,12 @@ func TestScanFiles(t *testing.T) {
 		os.WriteFile(f, []byte("test"), 0o644)
 	}
 
-	result, err := ScanFiles(context.Background(), tmpDir, testLogger())
+	scanned, err := ScanFiles(context.Background(), tmpDir, testLogger())
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	sort.Strings(result)
+	result := relPaths(scanned)
 
 	expected := []string{
 		"upload/library/admin/2023/video.mp4",
@@ -103,12 +120,12 @@ func TestScanFiles_ExcludesBackupsOnly(t *testing.T) {
 		os.WriteFile(filepath.Join(tmpDir, dir, file), []byte("test"), 0o644)
 	}
 
-	result, err := ScanFiles(context.Background(), tmpDir, testLogger())
+	scanned, err := ScanFiles(context.Background(), tmpDir, testLogger())
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	sort.Strings(result)
+	result := relPaths(scanned)
 
 	// Should include thumbs, encoded-video, profile, and upload files.
 	// Only backups should be excluded.
@@ -143,7 +160,143 @@ func TestScanFilesWithPrefix(t *testing.T) {
 	if len(result) != 1 {
 		t.Fatalf("expected 1 file, got %d", len(result))
 	}
-	if result[0] != "prefix/subdir/file.txt" {
-		t.Errorf("expected %q, got %q", "prefix/subdir/file.txt", result[0])
+	if result[0].RelPath != "prefix/subdir/file.txt" {
+		t.Errorf("expected %q, got %q", "prefix/subdir/file.txt", result[0].RelPath)
+	}
+}
+
+func TestScanFiles_RecordsSizeAndModTime(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "photo.jpg")
+	os.WriteFile(path, []byte("12345"), 0o644)
+	mtime := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	os.Chtimes(path, mtime, mtime)
+
+	files, err := ScanFiles(context.Background(), tmpDir, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(files))
+	}
+	if files[0].Size != 5 {
+		t.Errorf("expected size 5, got %d", files[0].Size)
+	}
+	if !files[0].ModTime.Equal(mtime) {
+		t.Errorf("expected mtime %v, got %v", mtime, files[0].ModTime)
+	}
+}
+
+func TestWalk_Sample(t *testing.T) {
+	tmpDir := t.TempDir()
+	for i := range 200 {
+		dir := filepath.Join(tmpDir, "upload", "d"+strconv.Itoa(i))
+		os.MkdirAll(dir, 0o755)
+		os.WriteFile(filepath.Join(dir, "a.jpg"), []byte("x"), 0o644)
+		os.WriteFile(filepath.Join(dir, "b.jpg"), []byte("x"), 0o644)
+	}
+
+	sel := sampling.NewSelector(0.25, 42)
+	perDir := make(map[string]int)
+	err := Walk(context.Background(), tmpDir, "", Options{Sample: sel}, testLogger(), func(f File) error {
+		perDir[path.Dir(f.RelPath)]++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+
+	total, sampled := sel.Clusters()
+	if total != 200 {
+		t.Errorf("directories seen = %d, want 200", total)
+	}
+	if sampled != len(perDir) {
+		t.Errorf("sampled directories = %d, but files came from %d", sampled, len(perDir))
+	}
+	if sampled == 0 || sampled == 200 {
+		t.Errorf("sampled %d of 200 directories at 25%%", sampled)
+	}
+	for dir, n := range perDir {
+		if n != 2 {
+			t.Errorf("%s: got %d files, want all 2", dir, n)
+		}
+	}
+}
+
+func TestWalk_SkipAndOnError(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, p := range []string{"library/alice/a.jpg", "library/bob/b.jpg", "library/c.jpg", "upload/u/d.jpg"} {
+		os.MkdirAll(filepath.Join(tmpDir, filepath.Dir(p)), 0o755)
+		os.WriteFile(filepath.Join(tmpDir, p), []byte("x"), 0o644)
+	}
+
+	var got []string
+	var errs []string
+	opts := Options{
+		Skip:    []string{"library/alice", "library/bob/"},
+		OnError: func(path string, err error) { errs = append(errs, path) },
+	}
+	err := Walk(context.Background(), tmpDir, "", opts, testLogger(), func(f File) error {
+		got = append(got, f.RelPath)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	want := []string{"library/c.jpg", "upload/u/d.jpg"}
+	if !slices.Equal(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+	if len(errs) != 0 {
+		t.Errorf("unexpected errors for %v", errs)
+	}
+
+	missing := filepath.Join(tmpDir, "missing")
+	Walk(context.Background(), missing, "", opts, testLogger(), func(File) error { return nil })
+	if len(errs) != 1 || errs[0] != missing {
+		t.Errorf("errors = %v, want the missing root", errs)
+	}
+}
+
+func TestWalk_Ignore(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, p := range []string{"library/admin/a.jpg", "library/admin/import.sh", "library/admin/staging/b.jpg", "upload/u/c.jpg"} {
+		os.MkdirAll(filepath.Join(tmpDir, filepath.Dir(p)), 0o755)
+		os.WriteFile(filepath.Join(tmpDir, p), []byte("x"), 0o644)
+	}
+	os.WriteFile(filepath.Join(tmpDir, "library", ignore.FileName), []byte("*.sh\nstaging/\n"), 0o644)
+
+	// The walk starts below the ignore file, as for a per-user unit.
+	var got []string
+	opts := Options{Ignore: ignore.New(tmpDir, nil, testLogger())}
+	err := Walk(context.Background(), filepath.Join(tmpDir, "library"), "library", opts, testLogger(), func(f File) error {
+		got = append(got, f.RelPath)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	if want := []string{"library/admin/a.jpg"}; !slices.Equal(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+}
+
+func TestScanFiles_LargeFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// A sparse file past 4 GiB, the size a 32-bit int overflows at.
+	const size = 5 << 30
+	path := filepath.Join(tmpDir, "video.mp4")
+	os.WriteFile(path, nil, 0o644)
+	if err := os.Truncate(path, size); err != nil {
+		t.Skipf("cannot create sparse file: %v", err)
+	}
+
+	files, err := ScanFiles(context.Background(), tmpDir, testLogger())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(files) != 1 || files[0].Size != size {
+		t.Fatalf("expected one file of %d bytes, got %+v", int64(size), files)
 	}
 }
@@ -0,0 +1,82 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	// 2024-03-15 is a Friday.
+	from := time.Date(2024, 3, 15, 10, 30, 45, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"0 3 * * *", time.Date(2024, 3, 16, 3, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 3, 15, 10, 45, 0, 0, time.UTC)},
+		{"31 10 * * *", time.Date(2024, 3, 15, 10, 31, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2024, 3, 16, 10, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-wed", time.Date(2024, 3, 18, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2024, 3, 17, 9, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1,20 jan,jun *", time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 3, 15, 11, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2024, 3, 15, 10, 45, 0, 0, time.UTC)},
+		// Both day fields restricted: either one matches.
+		{"0 0 20 * fri", time.Date(2024, 3, 20, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("%q: Next = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestNext_DayOfMonthOrWeek(t *testing.T) {
+	s, err := Parse("0 0 1 * fri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// From Thursday 2024-03-14 the Friday comes before the 1st.
+	got := s.Next(time.Date(2024, 3, 14, 12, 0, 0, 0, time.UTC))
+	if want := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Next = %v, want %v", got, want)
+	}
+}
+
+func TestNext_Never(t *testing.T) {
+	s, err := Parse("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Next(time.Now()); !got.IsZero() {
+		t.Errorf("Next = %v, want zero time", got)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@often",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", expr)
+		}
+	}
+}
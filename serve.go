@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/schedule"
+
+	// Embed the time zone database so --schedule honours TZ in minimal
+	// container images that ship without one.
+	_ "time/tzdata"
+)
+
+// cmdServe stays resident and repeats the scan every --interval, or at the
+// times given by --schedule, until the process is interrupted. With
+// --listen it also scans whenever a webhook arrives, e.g. after Immich
+// finishes a library scan.
+func cmdServe(ctx context.Context, args []string) int {
+	var cfg config
+	fs := newFlagSet("serve", &cfg)
+	addRunFlags(fs, &cfg)
+	fs.BoolVar(&cfg.move, "move", false, "Relocate strays on every run instead of only reporting them")
+	fs.DurationVar(&cfg.interval, "interval", 24*time.Hour, "Time between runs (0 disables periodic runs; requires --listen)")
+	fs.StringVar(&cfg.schedule, "schedule", "", `Cron expression in local time for when to run, e.g. "0 3 * * *"; replaces --interval`)
+	fs.StringVar(&cfg.listenAddr, "listen", "", "Address for the webhook receiver and the /healthz, /status, and /metrics endpoints (e.g. :8080); a POST to the webhook triggers a scan")
+	fs.StringVar(&cfg.webhookPath, "webhook-path", "/webhook", "URL path of the webhook receiver")
+	fs.StringVar(&cfg.webhookToken, "webhook-token", "", "Shared secret webhook callers must send as a bearer token or ?token= parameter")
+	if ok, code := parseFlags(fs, &cfg, args); !ok {
+		return code
+	}
+	if !requireConnection(fs, &cfg) {
+		return exitError
+	}
+	if cfg.readOnly && cfg.move {
+		fmt.Fprintln(os.Stderr, "Error: --move cannot be combined with --read-only")
+		return exitError
+	}
+	var sched *schedule.Schedule
+	if cfg.schedule != "" {
+		if flagSet(fs, "interval") {
+			fmt.Fprintln(os.Stderr, "Error: --schedule and --interval cannot be combined")
+			return exitError
+		}
+		var err error
+		if sched, err = schedule.Parse(cfg.schedule); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --schedule: %v\n", err)
+			return exitError
+		}
+		if sched.Next(time.Now()).IsZero() {
+			fmt.Fprintf(os.Stderr, "Error: --schedule %q never fires\n", cfg.schedule)
+			return exitError
+		}
+	} else if cfg.interval < 0 || cfg.interval == 0 && cfg.listenAddr == "" {
+		fmt.Fprintln(os.Stderr, "Error: --interval must be positive unless --listen is set")
+		return exitError
+	}
+	if cfg.webhookPath == "/healthz" || cfg.webhookPath == "/status" || cfg.webhookPath == "/metrics" {
+		fmt.Fprintf(os.Stderr, "Error: --webhook-path %s is taken by the status endpoints\n", cfg.webhookPath)
+		return exitError
+	}
+	if !parseRunFlags(&cfg) {
+		return exitError
+	}
+	if cfg.move && !cfg.readOnly && !openAuditLog(&cfg) {
+		return exitError
+	}
+	defer cfg.audit.Close()
+	applyReadOnly(&cfg)
+	logger := newLogger(&cfg)
+	defer startTracing(&cfg, logger)()
+	if err := checkCapabilities(ctx, &cfg, logger); err != nil {
+		// Immich may still be starting up; every run reports its own errors.
+		logger.Warn("capability check failed", "error", err)
+	}
+
+	trigger := make(chan struct{}, 1)
+	status := newDaemonStatus("serve", activityIdle)
+	if cfg.listenAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle(cfg.webhookPath, webhookHandler(cfg.webhookToken, trigger, logger))
+		status.register(mux)
+		if err := startHTTP(ctx, cfg.listenAddr, mux, logger); err != nil {
+			logger.Error("cannot start webhook receiver", "error", err)
+			return exitError
+		}
+		logger.Info("webhook receiver ready", "webhook_path", cfg.webhookPath, "token_required", cfg.webhookToken != "")
+	}
+
+	if sched != nil {
+		logger.Info("serving", "schedule", cfg.schedule)
+	} else {
+		logger.Info("serving", "interval", cfg.interval)
+	}
+	// With an interval the first run starts right away; a schedule waits
+	// for its first slot.
+	runNow := sched == nil
+	for {
+		if runNow {
+			status.setActivity(activityScanning)
+			_, rs, err := runOnce(ctx, logger, &cfg)
+			switch {
+			case err == nil:
+				status.finishRun(rs)
+			case ctx.Err() == nil:
+				// A failed run must not stop the service; try again next time.
+				logger.Error("run failed", "run_id", rs.RunID, "error", err)
+				status.finishRun(rs)
+			}
+			status.setActivity(activityIdle)
+		}
+		runNow = true
+
+		// A nil channel blocks forever, disabling periodic runs.
+		var tick <-chan time.Time
+		var next time.Time
+		switch {
+		case sched != nil:
+			next = sched.Next(time.Now())
+		case cfg.interval > 0:
+			next = time.Now().Add(cfg.interval)
+		}
+		status.setNextRun(next)
+		if !next.IsZero() {
+			logger.Info("next run scheduled", "at", next.Format(time.RFC3339))
+			tick = time.After(time.Until(next))
+		}
+		select {
+		case <-ctx.Done():
+			logger.Info("shutting down")
+			return exitOK
+		case <-tick:
+		case <-trigger:
+			logger.Info("running scan requested by webhook")
+		}
+	}
+}
+
+// flagSet reports whether the flag name was given on the command line.
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
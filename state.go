@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/goeland86/immich-stray-finder/report"
+	"github.com/goeland86/immich-stray-finder/scanner"
+)
+
+const (
+	// runsDir is the directory under --state-dir holding one summary per
+	// run.
+	runsDir = "runs"
+	// lastReportFile is the JSON report of the last complete run in
+	// --state-dir, which --diff-against last compares against.
+	lastReportFile = "last-report.json"
+	// scanCacheFile is the scan cache in --state-dir.
+	scanCacheFile = "scan-cache"
+	// verifyProgressFile records the originals the verify command hashed,
+	// in --state-dir.
+	verifyProgressFile = "verify-progress.jsonl"
+)
+
+// runSummary is the document written to --state-dir for every run, so
+// consecutive runs can be correlated with each other and with the logs,
+// manifests, and audit entries carrying the same run ID.
+type runSummary struct {
+	*runStatus
+	Version    string `json:"version"`
+	ConfigHash string `json:"config_hash"`
+	Mode       string `json:"mode"`
+	DryRun     bool   `json:"dry_run"`
+	// Outcome is success, failed, or interrupted.
+	Outcome string `json:"outcome"`
+	// PhaseSeconds holds how long each phase that ran took.
+	PhaseSeconds map[string]float64 `json:"phase_seconds,omitempty"`
+	Parts        []report.Part      `json:"parts,omitempty"`
+}
+
+// writeRunSummary writes the summary of a finished run to
+// <state-dir>/runs/<run-id>.json. The file is replaced atomically, so a
+// reader never sees a partial summary.
+func writeRunSummary(cfg *config, rs *runStatus, res *runResult, runErr error) error {
+	s := runSummary{
+		runStatus:  rs,
+		Version:    buildVersion(),
+		ConfigHash: configHash(cfg),
+		Mode:       runMode(cfg),
+		DryRun:     !cfg.move,
+		Outcome:    "success",
+	}
+	switch {
+	case errors.Is(runErr, context.Canceled):
+		s.Outcome = "interrupted"
+	case runErr != nil:
+		s.Outcome = "failed"
+	}
+	if res != nil {
+		s.PhaseSeconds = make(map[string]float64)
+		for phase, d := range map[string]float64{
+			"fetch": res.fetchTime.Seconds(),
+			"scan":  res.walkTime.Seconds(),
+			"move":  res.moveTime.Seconds(),
+		} {
+			if d > 0 {
+				s.PhaseSeconds[phase] = d
+			}
+		}
+		s.Parts = partReports(res.units)
+	}
+
+	dir := filepath.Join(cfg.stateDir, runsDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode run summary: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".run-*.json")
+	if err != nil {
+		return fmt.Errorf("create run summary: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write run summary: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write run summary: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("write run summary: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, rs.RunID+".json")); err != nil {
+		return fmt.Errorf("write run summary: %w", err)
+	}
+	return nil
+}
+
+// writeLastReport stores the JSON report of a complete run in --state-dir
+// for the next run to compare against.
+func writeLastReport(cfg *config, res *runResult) error {
+	if err := os.MkdirAll(cfg.stateDir, 0o755); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+	return report.WriteFile(filepath.Join(cfg.stateDir, lastReportFile), newReport(cfg, res))
+}
+
+// loadScanCache returns the scan cache in --state-dir, or an empty one with
+// --full or when it cannot be read.
+func loadScanCache(cfg *config, logger *slog.Logger) *scanner.Cache {
+	if cfg.full {
+		logger.Info("ignoring the scan cache (--full)")
+		return scanner.NewCache()
+	}
+	path := filepath.Join(cfg.stateDir, scanCacheFile)
+	cache, err := scanner.LoadCache(path)
+	if err != nil {
+		logger.Warn("cannot use the scan cache; scanning every file", "file", path, "error", err)
+		return scanner.NewCache()
+	}
+	return cache
+}
+
+// saveScanCache replaces the scan cache in --state-dir with what the walk
+// learned. A run that cannot save it only loses the speedup.
+func saveScanCache(cfg *config, cache *scanner.Cache, logger *slog.Logger) {
+	reused, stated := cache.Stats()
+	logger.Info("scan cache", "files_reused", reused, "files_stated", stated)
+	path := filepath.Join(cfg.stateDir, scanCacheFile)
+	err := os.MkdirAll(cfg.stateDir, 0o755)
+	if err == nil {
+		err = cache.Save(path)
+	}
+	if err != nil {
+		logger.Warn("failed to save the scan cache", "file", path, "error", err)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/metrics"
+	"github.com/goeland86/immich-stray-finder/runstats"
+)
+
+// Activities reported by the status endpoint.
+const (
+	activityIdle     = "idle"
+	activityScanning = "scanning"
+	activityWatching = "watching"
+	activityChecking = "checking"
+)
+
+// daemonStatus tracks what a long-running command (serve or watch) is doing
+// for the /healthz and /status endpoints. It is safe for concurrent use.
+type daemonStatus struct {
+	mu sync.Mutex
+	st statusReport
+}
+
+// statusReport is the JSON document served at /status.
+type statusReport struct {
+	Version   string     `json:"version"`
+	Command   string     `json:"command"`
+	StartedAt time.Time  `json:"started_at"`
+	Activity  string     `json:"activity"`
+	Runs      int        `json:"runs"`
+	Failures  int        `json:"failures"`
+	LastRun   *runStatus `json:"last_run,omitempty"`
+	// LastSuccess is the most recent run that did not fail.
+	LastSuccess *runStatus `json:"last_success,omitempty"`
+	NextRun     *time.Time `json:"next_run,omitempty"`
+
+	// quarantined is the number of bytes moved by all runs, for /metrics.
+	quarantined int64
+}
+
+// runStatus describes the most recent finished run. For watch, a run is
+// one check of new files after refetching the asset list.
+type runStatus struct {
+	// RunID is set for scans; watch checks have none.
+	RunID            string    `json:"run_id,omitempty"`
+	StartedAt        time.Time `json:"started_at"`
+	FinishedAt       time.Time `json:"finished_at"`
+	Duration         float64   `json:"duration_seconds"`
+	AssetsFetched    int       `json:"assets_fetched"`
+	FilesScanned     int       `json:"files_scanned"`
+	Untracked        int       `json:"untracked"`
+	UntrackedBytes   int64     `json:"untracked_bytes"`
+	QuarantinedBytes int64     `json:"quarantined_bytes"`
+	Error            string    `json:"error,omitempty"`
+}
+
+// newRunStatus describes a run that started at startedAt and just ended
+// with res, or failed with err. res may be nil for a failed run.
+func newRunStatus(startedAt time.Time, res *runResult, err error) *runStatus {
+	now := time.Now()
+	rs := &runStatus{
+		StartedAt:  startedAt.UTC(),
+		FinishedAt: now.UTC(),
+		Duration:   now.Sub(startedAt).Seconds(),
+	}
+	if err != nil {
+		rs.Error = err.Error()
+	}
+	if res != nil {
+		rs.AssetsFetched = res.assetsFetched
+		rs.FilesScanned = res.filesScanned
+		rs.Untracked = len(res.untracked)
+		rs.QuarantinedBytes = res.quarantinedBytes
+		for _, u := range res.untracked {
+			rs.UntrackedBytes += u.Size
+		}
+	}
+	return rs
+}
+
+func newDaemonStatus(command, activity string) *daemonStatus {
+	return &daemonStatus{st: statusReport{
+		Version:   buildVersion(),
+		Command:   command,
+		StartedAt: time.Now().UTC(),
+		Activity:  activity,
+	}}
+}
+
+// setActivity records what the daemon is doing now.
+func (s *daemonStatus) setActivity(activity string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.st.Activity = activity
+}
+
+// setNextRun records when the next periodic run is due; the zero time
+// means none is scheduled.
+func (s *daemonStatus) setNextRun(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.st.NextRun = nil
+	if !t.IsZero() {
+		t = t.UTC()
+		s.st.NextRun = &t
+	}
+}
+
+// finishRun records a finished run.
+func (s *daemonStatus) finishRun(rs *runStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.st.Runs++
+	s.st.quarantined += rs.QuarantinedBytes
+	if rs.Error != "" {
+		s.st.Failures++
+	} else {
+		s.st.LastSuccess = rs
+	}
+	s.st.LastRun = rs
+}
+
+// snapshot returns a copy of the current status.
+func (s *daemonStatus) snapshot() statusReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.st
+	if st.LastRun != nil {
+		lr := *st.LastRun
+		st.LastRun = &lr
+	}
+	if st.LastSuccess != nil {
+		ls := *st.LastSuccess
+		st.LastSuccess = &ls
+	}
+	return st
+}
+
+// register adds the status endpoints to mux. /healthz answers 200 while
+// the last run succeeded (or none has finished yet) and 503 after a failed
+// one, so container healthchecks notice a daemon that can no longer reach
+// Immich or the library. /status reports the full state as JSON, and
+// /metrics the same numbers for Prometheus.
+func (s *daemonStatus) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		st := s.snapshot()
+		if st.LastRun != nil && st.LastRun.Error != "" {
+			http.Error(w, "last run failed: "+st.LastRun.Error, http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(s.snapshot())
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		st := s.snapshot()
+		fams := []metrics.Family{buildInfo()}
+		if st.LastRun != nil {
+			fams = append(fams, runFamilies(st.LastRun, st.LastSuccess)...)
+		}
+		fams = append(fams, counterFamilies(runstats.Snapshot(), st.Runs, st.Failures, st.quarantined)...)
+		w.Header().Set("Content-Type", metrics.ContentType)
+		metrics.Write(w, fams)
+	})
+}
+
+// cmdHealthcheck queries the /healthz endpoint of a running serve or watch
+// and exits non-zero unless it reports healthy. Minimal container images
+// have no curl, so this is what a HEALTHCHECK can run.
+func cmdHealthcheck(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	url := fs.String("url", "http://127.0.0.1:8080/healthz", "URL of the /healthz endpoint to query")
+	timeout := fs.Duration("timeout", 5*time.Second, "Time limit for the request")
+	if ok, code := parseFlags(fs, nil, args); !ok {
+		return code
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *url, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unhealthy: %v\n", err)
+		return exitError
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "unhealthy: %s: %s", resp.Status, body)
+		return exitError
+	}
+	return exitOK
+}
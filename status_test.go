@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDaemonStatusHandlers(t *testing.T) {
+	ok := &runStatus{RunID: "r1", FilesScanned: 10, Untracked: 2, QuarantinedBytes: 100}
+	failed := &runStatus{RunID: "r2", Error: "immich unreachable"}
+	tests := []struct {
+		name       string
+		runs       []*runStatus
+		path       string
+		method     string
+		wantStatus int
+		wantBody   string
+	}{
+		{"healthy before any run", nil, "/healthz", http.MethodGet, http.StatusOK, "ok"},
+		{"healthy after a good run", []*runStatus{ok}, "/healthz", http.MethodGet, http.StatusOK, "ok"},
+		{"unhealthy after a failed run", []*runStatus{ok, failed}, "/healthz", http.MethodGet, http.StatusServiceUnavailable, "immich unreachable"},
+		{"healthy again after recovering", []*runStatus{failed, ok}, "/healthz", http.MethodGet, http.StatusOK, "ok"},
+		{"status", []*runStatus{ok, failed}, "/status", http.MethodGet, http.StatusOK, `"failures": 1`},
+		{"metrics", []*runStatus{ok}, "/metrics", http.MethodGet, http.StatusOK, "immich_stray_finder_"},
+		{"wrong method", nil, "/status", http.MethodPost, http.StatusMethodNotAllowed, ""},
+		{"unknown path", nil, "/nope", http.MethodGet, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newDaemonStatus("serve", activityIdle)
+			for _, rs := range tt.runs {
+				s.finishRun(rs)
+			}
+			mux := http.NewServeMux()
+			s.register(mux)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body %q does not contain %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestDaemonStatusReport(t *testing.T) {
+	s := newDaemonStatus("watch", activityWatching)
+	next := time.Date(2024, 1, 2, 3, 0, 0, 0, time.FixedZone("CET", 3600))
+	s.setNextRun(next)
+	s.finishRun(&runStatus{RunID: "r1", QuarantinedBytes: 5})
+	s.finishRun(&runStatus{RunID: "r2", Error: "boom"})
+
+	mux := http.NewServeMux()
+	s.register(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var st statusReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatalf("decode /status: %v", err)
+	}
+	if st.Command != "watch" || st.Activity != activityWatching || st.Runs != 2 || st.Failures != 1 {
+		t.Errorf("unexpected status %+v", st)
+	}
+	if st.LastRun == nil || st.LastRun.RunID != "r2" || st.LastSuccess == nil || st.LastSuccess.RunID != "r1" {
+		t.Errorf("last run %+v, last success %+v", st.LastRun, st.LastSuccess)
+	}
+	if st.NextRun == nil || !st.NextRun.Equal(next) || st.NextRun.Location() != time.UTC {
+		t.Errorf("next run = %v, want %v in UTC", st.NextRun, next)
+	}
+}
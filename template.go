@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/immich"
+)
+
+// cmdTemplate lists the originals not laid out the way the storage template
+// says, e.g. because they were uploaded before the template last changed.
+// The copies a template migration leaves behind are a common source of
+// strays.
+func cmdTemplate(ctx context.Context, args []string) int {
+	var cfg config
+	fs := newFlagSet("template", &cfg)
+	fs.StringVar(&cfg.output, "output", "text", "text lists the originals on stderr; json prints them as a JSON report on stdout")
+	if ok, code := parseFlags(fs, &cfg, args); !ok {
+		return code
+	}
+	if cfg.immichURL == "" || cfg.apiKey == "" {
+		fmt.Fprintln(os.Stderr, "Error: --immich-url and --api-key are required")
+		fs.Usage()
+		return exitError
+	}
+	if cfg.output != "text" && cfg.output != "json" {
+		fmt.Fprintf(os.Stderr, "Error: --output must be text or json, not %q\n", cfg.output)
+		return exitError
+	}
+	logger := newLogger(&cfg)
+
+	if err := discoverLayout(ctx, &cfg, logger); err != nil {
+		logger.Error("fatal error", "error", err)
+		return exitError
+	}
+	rep, err := checkTemplate(ctx, &cfg, logger)
+	if err != nil {
+		logger.Error("fatal error", "error", err)
+		return exitError
+	}
+	if cfg.output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			logger.Error("fatal error", "error", err)
+			return exitError
+		}
+		return exitOK
+	}
+	printTemplate(rep)
+	return exitOK
+}
+
+// templateReport is the result of the template command.
+type templateReport struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Template    string    `json:"template"`
+	Enabled     bool      `json:"enabled"`
+	Assets      int       `json:"assets_checked"`
+	// Unchecked counts the originals whose path does not start with
+	// --path-prefix, like those of external libraries, which the template
+	// does not apply to.
+	Unchecked int                 `json:"unchecked,omitempty"`
+	Misplaced []misplacedOriginal `json:"misplaced"`
+}
+
+// misplacedOriginal is an original not where the storage template puts it.
+type misplacedOriginal struct {
+	AssetID string `json:"asset_id"`
+	OwnerID string `json:"owner_id"`
+	// Reason is upload when the original was never moved out of upload/,
+	// owner when it is in another directory of library/ than its owner's,
+	// e.g. one named after a storage label since changed, and layout when
+	// its path below the owner's directory does not follow the template.
+	Reason string `json:"reason"`
+	// Path is relative to --library-path.
+	Path string `json:"path"`
+}
+
+// checkTemplate fetches the storage template, the users, and the assets,
+// and compares the path of each original with the template. Reading the
+// template and the users needs an admin API key.
+func checkTemplate(ctx context.Context, cfg *config, logger *slog.Logger) (*templateReport, error) {
+	client := immich.NewClient(cfg.immichURL, cfg.apiKey, logger)
+	tmpl, err := client.FetchStorageTemplate(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read the storage template (needs an admin API key): %w", err)
+	}
+	rep := &templateReport{GeneratedAt: time.Now().UTC(), Template: tmpl.Template, Enabled: tmpl.Enabled, Misplaced: []misplacedOriginal{}}
+	if !tmpl.Enabled {
+		logger.Warn("the storage template is disabled, so originals stay where they were uploaded; nothing to check")
+		return rep, nil
+	}
+	re, err := tmpl.Pattern()
+	if err != nil {
+		return nil, err
+	}
+	users, err := client.FetchAllUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetch users: %w", err)
+	}
+	ownerDirs := make(map[string]string, len(users))
+	for _, u := range users {
+		ownerDirs[u.ID] = libraryDir(u)
+	}
+	if cfg.dbURL == "" {
+		logger.Warn("without --db-url only the API key owner's assets are checked")
+	}
+	result, err := fetchAssetFiles(ctx, cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	rep.Assets = len(result.Files)
+	for _, f := range result.Files {
+		rel := cfg.libraryRel(f.OriginalPath)
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			rep.Unchecked++
+			continue
+		}
+		if reason := templateMismatch(rel, ownerDirs[f.OwnerID], re.MatchString); reason != "" {
+			rep.Misplaced = append(rep.Misplaced, misplacedOriginal{AssetID: f.ID, OwnerID: f.OwnerID, Reason: reason, Path: rel})
+		}
+	}
+	if rep.Unchecked > 0 {
+		logger.Info("originals outside --path-prefix were not checked", "files", rep.Unchecked, "path_prefix", cfg.pathPrefix)
+	}
+	logger.Info("checked originals against the storage template", "template", tmpl.Template, "assets", rep.Assets, "misplaced", len(rep.Misplaced))
+	return rep, nil
+}
+
+// templateMismatch returns why the original at rel, owned by the user with
+// the directory ownerDir in library/, is not where the storage template
+// puts it, as in misplacedOriginal.Reason, or "" if it is. match reports
+// whether a path below the owner's directory follows the template.
+func templateMismatch(rel, ownerDir string, match func(string) bool) string {
+	below, ok := strings.CutPrefix(rel, "library/")
+	if !ok {
+		if strings.HasPrefix(rel, "upload/") {
+			return "upload"
+		}
+		return "layout"
+	}
+	sub, ok := strings.CutPrefix(below, ownerDir+"/")
+	if ownerDir == "" || !ok {
+		return "owner"
+	}
+	if !match(sub) {
+		return "layout"
+	}
+	return ""
+}
+
+// printTemplate lists the misplaced originals on stderr.
+func printTemplate(rep *templateReport) {
+	if !rep.Enabled {
+		fmt.Fprintln(stderr, "\nThe storage template is disabled; originals stay where they were uploaded.")
+		return
+	}
+	checked := rep.Assets - rep.Unchecked
+	if len(rep.Misplaced) == 0 {
+		fmt.Fprintf(stderr, "\nAll %d original(s) follow the storage template %s.\n", checked, rep.Template)
+		return
+	}
+	fmt.Fprintf(stderr, "\n%d of %d original(s) do not follow the storage template %s:\n", len(rep.Misplaced), checked, rep.Template)
+	for _, m := range rep.Misplaced {
+		fmt.Fprintf(stderr, "  %-7s %s  (asset %s)\n", m.Reason, m.Path, m.AssetID)
+	}
+	fmt.Fprintln(stderr, "Immich's Storage Template Migration job moves them into place; strays next to them may be copies an earlier migration left behind.")
+}
@@ -0,0 +1,86 @@
+// Package throttle paces work to a steady rate, so scans and copies can run
+// next to a busy Immich server without starving it of I/O.
+package throttle
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter lets a fixed number of units, such as files or bytes, through per
+// second, shared by everyone using it. A nil Limiter does not limit.
+type Limiter struct {
+	rate float64
+
+	mu sync.Mutex
+	// next is when the work let through so far is paid for.
+	next time.Time
+}
+
+// New returns a Limiter for perSecond units per second, or nil, which does
+// not limit, if perSecond is not positive.
+func New(perSecond float64) *Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &Limiter{rate: perSecond}
+}
+
+// Rate returns the units per second l lets through, or 0 for a nil l.
+func (l *Limiter) Rate() float64 {
+	if l == nil {
+		return 0
+	}
+	return l.rate
+}
+
+// Wait blocks until n more units may be used, or ctx is done. Bursts are
+// not saved up: time not used by anyone is lost.
+func (l *Limiter) Wait(ctx context.Context, n int64) error {
+	if l == nil || n <= 0 {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Writer returns w paced by l, counting bytes. It returns w itself for a
+// nil l.
+func Writer(w io.Writer, l *Limiter) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &writer{w: w, l: l}
+}
+
+type writer struct {
+	w io.Writer
+	l *Limiter
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if err := w.l.Wait(context.Background(), int64(len(p))); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
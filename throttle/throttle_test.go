@@ -0,0 +1,64 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Wait(t *testing.T) {
+	l := New(100)
+	start := time.Now()
+	for range 11 {
+		if err := l.Wait(context.Background(), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first unit goes through at once, the next ten take 10ms each.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("11 units at 100/s took %v, want about 100ms", elapsed)
+	}
+}
+
+func TestLimiter_WaitCancelled(t *testing.T) {
+	l := New(1)
+	l.Wait(context.Background(), 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx, 1); err == nil {
+		t.Error("expected a cancelled wait to fail")
+	}
+}
+
+func TestLimiter_Nil(t *testing.T) {
+	var l *Limiter
+	if New(0) != nil {
+		t.Error("expected New(0) to return nil")
+	}
+	if err := l.Wait(context.Background(), 1<<30); err != nil {
+		t.Errorf("nil Limiter Wait() = %v", err)
+	}
+	var buf bytes.Buffer
+	if w := Writer(&buf, l); w != io.Writer(&buf) {
+		t.Error("expected Writer with a nil Limiter to return the writer itself")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := Writer(&buf, New(1000))
+	start := time.Now()
+	if _, err := io.Copy(w, strings.NewReader(strings.Repeat("x", 100))); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("y"))
+	if buf.Len() != 101 {
+		t.Errorf("wrote %d bytes, want 101", buf.Len())
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("101 bytes at 1000/s took %v, want about 100ms", elapsed)
+	}
+}
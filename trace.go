@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/redact"
+	"github.com/goeland86/immich-stray-finder/tracing"
+)
+
+// startTracing installs the span exporter when --otlp-endpoint is set. The
+// returned function sends the spans still queued; call it before exiting.
+func startTracing(cfg *config, logger *slog.Logger) func() {
+	if cfg.otlpURL == "" {
+		return func() {}
+	}
+	e := tracing.Setup(cfg.otlpURL, "immich-stray-finder", buildVersion(), logger)
+	logger.Info("tracing enabled", "endpoint", redact.URL(cfg.otlpURL))
+	return func() {
+		// Like the metrics push, tracing must not fail or hang the run.
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := e.Shutdown(ctx); err != nil {
+			logger.Warn("failed to export traces", "error", err)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// flushInterval is how often queued spans are sent.
+	flushInterval = 5 * time.Second
+	// maxQueue bounds the spans held while the collector is unreachable;
+	// further spans are dropped.
+	maxQueue = 8192
+)
+
+// Exporter sends finished spans to an OTLP/HTTP endpoint in batches.
+type Exporter struct {
+	url      string
+	resource []keyValue
+	scope    scope
+	client   *http.Client
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	queue   []*Span
+	dropped int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Setup installs an exporter sending spans to the OTLP/HTTP collector at
+// endpoint (e.g. http://otel-collector:4318; /v1/traces is appended unless
+// the path already ends in it). service and version describe this
+// process. Call Shutdown before exiting to send the remaining spans.
+func Setup(endpoint, service, version string, logger *slog.Logger) *Exporter {
+	url := strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	e := &Exporter{
+		url: url,
+		resource: []keyValue{
+			{Key: "service.name", Value: anyValue(service)},
+			{Key: "service.version", Value: anyValue(version)},
+		},
+		scope:  scope{Name: service, Version: version},
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go e.loop()
+	exporter.Store(e)
+	return e
+}
+
+// Shutdown stops recording spans and sends the queued ones.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	exporter.CompareAndSwap(e, nil)
+	close(e.stop)
+	<-e.done
+	return e.flush(ctx)
+}
+
+func (e *Exporter) loop() {
+	defer close(e.done)
+	t := time.NewTicker(flushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-t.C:
+			ctx, cancel := context.WithTimeout(context.Background(), e.client.Timeout)
+			if err := e.flush(ctx); err != nil {
+				e.logger.Warn("cannot export trace spans", "error", err)
+			}
+			cancel()
+		}
+	}
+}
+
+func (e *Exporter) enqueue(s *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.queue) >= maxQueue {
+		e.dropped++
+		return
+	}
+	e.queue = append(e.queue, s)
+}
+
+// flush sends the queued spans. Spans that cannot be sent are dropped, so
+// an unreachable collector does not grow the queue without bound.
+func (e *Exporter) flush(ctx context.Context) error {
+	e.mu.Lock()
+	spans, dropped := e.queue, e.dropped
+	e.queue, e.dropped = nil, 0
+	e.mu.Unlock()
+	if dropped > 0 {
+		e.logger.Warn("trace span queue full, spans dropped", "dropped", dropped)
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return fmt.Errorf("encode spans: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("export spans: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("export %d spans: %w", len(spans), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("export %d spans: collector returned status %d: %s", len(spans), resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// The OTLP/JSON request, with only the fields this package sets.
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	resource struct {
+		Attributes []keyValue `json:"attributes"`
+	}
+	scopeSpans struct {
+		Scope scope      `json:"scope"`
+		Spans []spanJSON `json:"spans"`
+	}
+	scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+	spanJSON struct {
+		TraceID      string     `json:"traceId"`
+		SpanID       string     `json:"spanId"`
+		ParentSpanID string     `json:"parentSpanId,omitempty"`
+		Name         string     `json:"name"`
+		Kind         int        `json:"kind"`
+		Start        string     `json:"startTimeUnixNano"`
+		End          string     `json:"endTimeUnixNano"`
+		Attributes   []keyValue `json:"attributes,omitempty"`
+		Status       status     `json:"status"`
+	}
+	status struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	keyValue struct {
+		Key   string `json:"key"`
+		Value value  `json:"value"`
+	}
+	value struct {
+		String *string  `json:"stringValue,omitempty"`
+		Bool   *bool    `json:"boolValue,omitempty"`
+		Int    *string  `json:"intValue,omitempty"`
+		Double *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// statusError is OTLP's STATUS_CODE_ERROR.
+const statusError = 2
+
+func (e *Exporter) request(spans []*Span) exportRequest {
+	out := make([]spanJSON, len(spans))
+	for i, s := range spans {
+		s.mu.Lock()
+		sj := spanJSON{
+			TraceID: hex.EncodeToString(s.traceID[:]),
+			SpanID:  hex.EncodeToString(s.spanID[:]),
+			Name:    s.name,
+			Kind:    s.kind,
+			Start:   strconv.FormatInt(s.start.UnixNano(), 10),
+			End:     strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentID != ([8]byte{}) {
+			sj.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for _, a := range s.attrs {
+			sj.Attributes = append(sj.Attributes, keyValue{Key: a.key, Value: anyValue(a.value)})
+		}
+		if s.errMsg != "" {
+			sj.Status = status{Code: statusError, Message: s.errMsg}
+		}
+		s.mu.Unlock()
+		out[i] = sj
+	}
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: e.resource},
+		ScopeSpans: []scopeSpans{{Scope: e.scope, Spans: out}},
+	}}}
+}
+
+// anyValue converts an attribute value to its OTLP form. OTLP encodes
+// 64-bit integers as JSON strings.
+func anyValue(v any) value {
+	switch x := v.(type) {
+	case string:
+		return value{String: &x}
+	case bool:
+		return value{Bool: &x}
+	case int:
+		s := strconv.Itoa(x)
+		return value{Int: &s}
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		return value{Int: &s}
+	case float64:
+		return value{Double: &x}
+	case time.Duration:
+		s := x.String()
+		return value{String: &s}
+	default:
+		s := fmt.Sprint(x)
+		return value{String: &s}
+	}
+}
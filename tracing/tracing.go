@@ -0,0 +1,131 @@
+// Package tracing records spans of a run's phases and exports them to an
+// OpenTelemetry collector over OTLP/HTTP with JSON encoding. It implements
+// the small subset of OpenTelemetry this tool needs, without the SDK and
+// its dependencies.
+//
+// Tracing is process-wide, like the counters in runstats: once Setup has
+// installed an exporter, Start records spans from any package; before
+// that, or after Shutdown, Start returns a nil *Span, and every method of
+// a nil *Span does nothing.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Span kinds, as numbered by OTLP.
+const (
+	KindInternal = 1
+	KindClient   = 3
+)
+
+// Span is a timed operation within a trace.
+type Span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	mu     sync.Mutex
+	end    time.Time
+	attrs  []attr
+	errMsg string
+	ended  bool
+}
+
+type attr struct {
+	key   string
+	value any
+}
+
+type spanKey struct{}
+
+// exporter is the installed exporter, or nil when tracing is off.
+var exporter atomic.Pointer[Exporter]
+
+// Enabled reports whether spans are being recorded.
+func Enabled() bool {
+	return exporter.Load() != nil
+}
+
+// Start begins an internal span named name as a child of the span in ctx,
+// if any, and returns a context carrying the new span. kv are alternating
+// attribute keys and values, as for slog.
+func Start(ctx context.Context, name string, kv ...any) (context.Context, *Span) {
+	return StartKind(ctx, name, KindInternal, kv...)
+}
+
+// StartKind is Start with an explicit span kind.
+func StartKind(ctx context.Context, name string, kind int, kv ...any) (context.Context, *Span) {
+	if !Enabled() {
+		return ctx, nil
+	}
+	s := &Span{name: name, kind: kind, start: time.Now()}
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok && parent != nil {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	s.SetAttrs(kv...)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SetAttrs adds attributes to the span. kv are alternating keys and
+// values; values may be strings, bools, integers, or floats, and anything
+// else is recorded as its string form.
+func (s *Span) SetAttrs(kv ...any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(kv); i += 2 {
+		if key, ok := kv[i].(string); ok {
+			s.attrs = append(s.attrs, attr{key, kv[i+1]})
+		}
+	}
+}
+
+// SetError marks the span as failed with err. A nil err does nothing.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errMsg = err.Error()
+}
+
+// End finishes the span and queues it for export. Only the first call has
+// an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.end = true, time.Now()
+	s.mu.Unlock()
+	if e := exporter.Load(); e != nil {
+		e.enqueue(s)
+	}
+}
+
+// EndErr sets *errp as the span's error, if any, and ends it. It suits
+// a deferred call in a function with a named error result.
+func (s *Span) EndErr(errp *error) {
+	if errp != nil {
+		s.SetError(*errp)
+	}
+	s.End()
+}
@@ -0,0 +1,82 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+}
+
+func TestDisabled(t *testing.T) {
+	ctx, span := Start(context.Background(), "noop", "k", 1)
+	if span != nil {
+		t.Fatal("expected a nil span without an exporter")
+	}
+	// Methods of a nil span must not panic.
+	span.SetAttrs("k", 2)
+	span.SetError(errors.New("x"))
+	span.End()
+	if ctx != context.Background() {
+		t.Error("context should be unchanged")
+	}
+}
+
+func TestExport(t *testing.T) {
+	var got exportRequest
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	e := Setup(server.URL, "svc", "v1", testLogger())
+	ctx, root := Start(context.Background(), "run", "mode", "dry-run")
+	_, child := StartKind(ctx, "GET /api/users/me", KindClient, "status", 200, "bytes", int64(5<<30))
+	child.End()
+	err := errors.New("boom")
+	root.EndErr(&err)
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if Enabled() {
+		t.Error("tracing still enabled after Shutdown")
+	}
+
+	if path != "/v1/traces" {
+		t.Errorf("path = %s", path)
+	}
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected request %+v", got)
+	}
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	c, r := spans[0], spans[1]
+	if c.TraceID != r.TraceID || c.ParentSpanID != r.SpanID || r.ParentSpanID != "" {
+		t.Errorf("child not linked to root: %+v / %+v", c, r)
+	}
+	if len(r.TraceID) != 32 || len(r.SpanID) != 16 {
+		t.Errorf("bad IDs %q %q", r.TraceID, r.SpanID)
+	}
+	if c.Kind != KindClient || r.Kind != KindInternal {
+		t.Errorf("kinds = %d, %d", c.Kind, r.Kind)
+	}
+	if r.Status.Code != statusError || r.Status.Message != "boom" || c.Status.Code != 0 {
+		t.Errorf("statuses = %+v, %+v", r.Status, c.Status)
+	}
+	if len(c.Attributes) != 2 || *c.Attributes[0].Value.Int != "200" || *c.Attributes[1].Value.Int != "5368709120" {
+		t.Errorf("attributes = %+v", c.Attributes)
+	}
+}
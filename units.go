@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/immich"
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/report"
+)
+
+// userUnits splits the library into one scan unit per directory in
+// library/, plus one for everything outside them but the known
+// directories. With only set, just the library/ directories named in it
+// are scanned.
+func userUnits(libraryPath string, mounts []paths.Mount, only, known []string) ([]scanUnit, error) {
+	entries, err := os.ReadDir(paths.Join(libraryPath, mounts, "library"))
+	if err != nil && (len(only) > 0 || !errors.Is(err, fs.ErrNotExist)) {
+		return nil, fmt.Errorf("list user directories: %w", err)
+	}
+	want := make(map[string]bool, len(only))
+	for _, name := range only {
+		want[name] = true
+	}
+	rest := scanUnit{name: "(outside library/)", root: libraryPath, skip: slices.Clone(known)}
+	var units []scanUnit
+	for _, e := range entries {
+		if !e.IsDir() || len(only) > 0 && !want[e.Name()] {
+			continue
+		}
+		delete(want, e.Name())
+		rel := "library/" + e.Name()
+		units = append(units, scanUnit{name: rel, root: paths.Join(libraryPath, mounts, rel), prefix: rel})
+		rest.skip = append(rest.skip, rel)
+	}
+	for name := range want {
+		return nil, fmt.Errorf("--only-users: no directory library/%s", name)
+	}
+	if len(only) == 0 {
+		units = append(units, rest)
+	}
+	return units, nil
+}
+
+// userDirs are the top-level directories of the library holding a
+// directory per user ID.
+var userDirs = []string{"upload", "thumbs", "encoded-video", "profile"}
+
+// scopedUnits returns scan units covering only the users named in names,
+// by storage label or ID: one for each of their library/ directory and
+// their directories in userDirs that exist.
+func scopedUnits(libraryPath string, mounts []paths.Mount, all []immich.User, names []string) ([]scanUnit, error) {
+	var units []scanUnit
+	for _, name := range names {
+		i := slices.IndexFunc(all, func(u immich.User) bool { return u.ID == name || u.StorageLabel == name })
+		if i < 0 {
+			return nil, fmt.Errorf("--users: no user with storage label or ID %q", name)
+		}
+		u := all[i]
+		rels := []string{"library/" + libraryDir(u)}
+		for _, dir := range userDirs {
+			rels = append(rels, dir+"/"+u.ID)
+		}
+		for _, rel := range rels {
+			root := paths.Join(libraryPath, mounts, rel)
+			if _, err := os.Stat(root); errors.Is(err, fs.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("--users: %w", err)
+			}
+			if !slices.ContainsFunc(units, func(s scanUnit) bool { return s.prefix == rel }) {
+				units = append(units, scanUnit{name: rel, root: root, prefix: rel})
+			}
+		}
+	}
+	if len(units) == 0 {
+		return nil, fmt.Errorf("--users: none of %s has a directory in the library", strings.Join(names, ", "))
+	}
+	return units, nil
+}
+
+// mountUnits splits units along the directories of the library kept
+// outside its root: a mount within the part a unit walks is skipped by it
+// and walked as a unit of its own, skipping in turn the units within it.
+func mountUnits(units []scanUnit, mounts []paths.Mount) []scanUnit {
+	below := func(rel, dir string) bool { return rel != dir && paths.Within(rel, dir) }
+	for i := 0; i < len(units); i++ {
+		for _, m := range mounts {
+			u := &units[i]
+			if !below(m.Rel, u.prefix) || slices.ContainsFunc(mounts, func(o paths.Mount) bool {
+				// Walked as part of the unit of o instead.
+				return below(m.Rel, o.Rel) && below(o.Rel, u.prefix)
+			}) {
+				continue
+			}
+			sub := strings.TrimPrefix(strings.TrimPrefix(m.Rel, u.prefix), "/")
+			if slices.ContainsFunc(u.skip, func(dir string) bool { return paths.Within(sub, dir) }) {
+				// Another unit covers it.
+				continue
+			}
+			u.skip = append(u.skip, sub)
+			if slices.ContainsFunc(units, func(o scanUnit) bool { return o.prefix == m.Rel }) {
+				continue
+			}
+			mu := scanUnit{name: m.Rel, root: m.Dir, prefix: m.Rel}
+			for _, o := range units {
+				if below(o.prefix, m.Rel) {
+					mu.skip = append(mu.skip, strings.TrimPrefix(o.prefix, m.Rel+"/"))
+				}
+			}
+			units = append(units, mu)
+		}
+	}
+	return units
+}
+
+// coversAsset reports whether the unit walks the asset with the match key
+// key, given the normalizer n the key was made with.
+func (u scanUnit) coversAsset(key string, n *paths.Normalizer) bool {
+	prefix := n.Key(u.prefix)
+	if !paths.Within(key, prefix) {
+		return false
+	}
+	sub := strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
+	return !slices.ContainsFunc(u.skip, func(dir string) bool { return paths.Within(sub, n.Key(dir)) })
+}
+
+// unitsErr describes the parts of the library a run failed to scan, or
+// returns nil when there are none.
+func (r *runResult) unitsErr() error {
+	if r.failedUnits == 0 {
+		return nil
+	}
+	var names []string
+	for _, u := range r.units {
+		if u.err != nil {
+			names = append(names, u.name)
+		}
+	}
+	return fmt.Errorf("scan incomplete: %d of %d parts of the library failed: %s",
+		r.failedUnits, len(r.units), strings.Join(names, ", "))
+}
+
+// printFailedUnits lists the parts of the library a run failed to scan and
+// how to rescan just those.
+func printFailedUnits(res *runResult) {
+	fmt.Fprintf(stderr, "\nScan incomplete: %d part(s) of the library failed; their strays are not listed above:\n", res.failedUnits)
+	var users []string
+	for _, u := range res.units {
+		if u.err == nil {
+			continue
+		}
+		fmt.Fprintf(stderr, "  %s: %v\n", u.name, u.err)
+		if user, ok := strings.CutPrefix(u.name, "library/"); ok {
+			users = append(users, user)
+		}
+	}
+	if len(users) > 0 {
+		fmt.Fprintf(stderr, "Rescan them with --only-users %s\n", strings.Join(users, ","))
+	}
+}
+
+// partReports describes the parts of the library for the JSON report, or
+// returns nil when it was scanned in one walk.
+func partReports(units []unitResult) []report.Part {
+	var parts []report.Part
+	for _, u := range units {
+		p := report.Part{
+			Name:            u.name,
+			FilesScanned:    u.filesScanned,
+			Untracked:       len(u.untracked),
+			ReadErrors:      u.readErrors,
+			DurationSeconds: u.duration.Round(time.Millisecond).Seconds(),
+		}
+		if u.err != nil {
+			p.Error = u.err.Error()
+		}
+		parts = append(parts, p)
+	}
+	return parts
+}
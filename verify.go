@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/bitrot"
+	"github.com/goeland86/immich-stray-finder/immich"
+	"github.com/goeland86/immich-stray-finder/progress"
+	"github.com/goeland86/immich-stray-finder/report"
+	"github.com/goeland86/immich-stray-finder/throttle"
+)
+
+// cmdVerify rehashes the originals of the assets and compares them with
+// the checksums Immich recorded at upload, to find files silently
+// corrupted on disk.
+func cmdVerify(ctx context.Context, args []string) int {
+	var cfg config
+	fs := newFlagSet("verify", &cfg)
+	fs.StringVar(&cfg.stateDir, "state-dir", "", "Keep the progress of the verification in this directory, so an interrupted run resumes where it stopped")
+	fs.BoolVar(&cfg.full, "full", false, "Start over instead of resuming an interrupted verification in --state-dir")
+	fs.IntVar(&cfg.hashWorkers, "hash-workers", 2, "How many files are hashed at once")
+	fs.Float64Var(&cfg.ioLimit, "io-limit", 0, "Read files at most at this many MiB per second, across all workers (0 for no limit)")
+	fs.StringVar(&cfg.output, "output", "text", "text lists the corrupted files on stderr; json prints them as a JSON report on stdout")
+	if ok, code := parseFlags(fs, &cfg, args); !ok {
+		return code
+	}
+	if !requireConnection(fs, &cfg) {
+		return exitError
+	}
+	if cfg.output != "text" && cfg.output != "json" {
+		fmt.Fprintf(os.Stderr, "Error: --output must be text or json, not %q\n", cfg.output)
+		return exitError
+	}
+	if cfg.hashWorkers < 1 {
+		fmt.Fprintln(os.Stderr, "Error: --hash-workers must be at least 1")
+		return exitError
+	}
+	if cfg.ioLimit < 0 {
+		fmt.Fprintln(os.Stderr, "Error: --io-limit must not be negative")
+		return exitError
+	}
+	cfg.ioLimiter = throttle.New(cfg.ioLimit * (1 << 20))
+	logger := newLogger(&cfg)
+
+	if err := discoverLayout(ctx, &cfg, logger); err != nil {
+		logger.Error("fatal error", "error", err)
+		return exitError
+	}
+	rep, err := verifyAssets(ctx, &cfg, logger)
+	if err != nil {
+		if errors.Is(err, context.Canceled) && cfg.stateDir != "" {
+			logger.Warn("verification interrupted; the next run with the same --state-dir resumes it")
+		}
+		logger.Error("fatal error", "error", err)
+		return exitError
+	}
+	if cfg.output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			logger.Error("fatal error", "error", err)
+			return exitError
+		}
+	} else {
+		printVerify(rep)
+	}
+	if len(rep.Corrupted) > 0 {
+		return exitCorrupt
+	}
+	return exitOK
+}
+
+// verifyReport is the result of the verify command.
+type verifyReport struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Assets      int       `json:"assets_checked"`
+	// Hashed counts the files hashed by this run, Resumed those hashed by
+	// the interrupted run it resumed.
+	Hashed  int `json:"hashed"`
+	Resumed int `json:"resumed,omitempty"`
+	// Missing counts the originals not found on disk; the missing command
+	// lists them.
+	Missing int `json:"missing"`
+	// Unchecked counts the originals outside --path-prefix or without a
+	// recorded checksum.
+	Unchecked int             `json:"unchecked,omitempty"`
+	Failed    int             `json:"failed,omitempty"`
+	Corrupted []corruptedFile `json:"corrupted"`
+}
+
+// corruptedFile is an original whose content no longer has the checksum
+// Immich recorded for it.
+type corruptedFile struct {
+	bitrot.Entry
+	OwnerID string `json:"owner_id,omitempty"`
+}
+
+// verifyAssets fetches the assets and checks the checksums of their
+// originals, hashing cfg.hashWorkers files at once.
+func verifyAssets(ctx context.Context, cfg *config, logger *slog.Logger) (*verifyReport, error) {
+	if err := checkLibrary(cfg); err != nil {
+		return nil, err
+	}
+	checkMarkers(cfg, logger)
+	var prog *bitrot.Progress
+	if cfg.stateDir != "" {
+		if err := os.MkdirAll(cfg.stateDir, 0o755); err != nil {
+			return nil, fmt.Errorf("create state directory: %w", err)
+		}
+		path := filepath.Join(cfg.stateDir, verifyProgressFile)
+		if cfg.full {
+			if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("remove verify progress: %w", err)
+			}
+		}
+		var err error
+		if prog, err = bitrot.Open(path); err != nil {
+			return nil, err
+		}
+		defer prog.Close()
+		if n := prog.Len(); n > 0 {
+			logger.Info("resuming interrupted verification", "files_done", n)
+		}
+	}
+
+	result, err := fetchAssetFiles(ctx, cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	rep := &verifyReport{GeneratedAt: time.Now().UTC(), Assets: len(result.Files), Corrupted: []corruptedFile{}}
+	logger.Info("verifying checksums", "assets", len(result.Files), "workers", cfg.hashWorkers)
+
+	bar := progress.Track("verifying checksums", "files", int64(len(result.Files)))
+	defer bar.Finish()
+	var mu sync.Mutex
+	jobs := make(chan immich.AssetFile)
+	var wg sync.WaitGroup
+	for range cfg.hashWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range jobs {
+				e, outcome := verifyFile(cfg, prog, f, logger)
+				bar.Add(1)
+				mu.Lock()
+				switch outcome {
+				case verifyHashed:
+					rep.Hashed++
+				case verifyResumed:
+					rep.Resumed++
+				case verifyMissing:
+					rep.Missing++
+				case verifyUnchecked:
+					rep.Unchecked++
+				case verifyFailed:
+					rep.Failed++
+				}
+				if (outcome == verifyHashed || outcome == verifyResumed) && !e.OK() {
+					rep.Corrupted = append(rep.Corrupted, corruptedFile{Entry: e, OwnerID: f.OwnerID})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, f := range result.Files {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- f
+	}
+	close(jobs)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := prog.Finish(); err != nil {
+		logger.Warn("cannot remove verification progress", "error", err)
+	}
+	if rep.Missing > 0 {
+		logger.Warn("originals are missing from disk; run the missing command to list them", "files", rep.Missing)
+	}
+	logger.Info("verified checksums", "hashed", rep.Hashed, "resumed", rep.Resumed, "corrupted", len(rep.Corrupted))
+	return rep, nil
+}
+
+// Outcomes of verifyFile.
+const (
+	verifyHashed = iota
+	verifyResumed
+	verifyMissing
+	verifyUnchecked
+	verifyFailed
+)
+
+// verifyFile checks the original of f, taking the result from prog when
+// the interrupted run being resumed hashed it and it is unchanged since.
+func verifyFile(cfg *config, prog *bitrot.Progress, f immich.AssetFile, logger *slog.Logger) (bitrot.Entry, int) {
+	rel := cfg.libraryRel(f.OriginalPath)
+	if f.Checksum == "" || !filepath.IsLocal(filepath.FromSlash(rel)) {
+		return bitrot.Entry{}, verifyUnchecked
+	}
+	file := cfg.libraryFile(rel)
+	info, err := os.Stat(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return bitrot.Entry{}, verifyMissing
+	}
+	if err != nil {
+		logger.Warn("cannot verify asset", "path", rel, "asset_id", f.ID, "error", err)
+		return bitrot.Entry{}, verifyFailed
+	}
+	if e, ok := prog.Lookup(f.ID); ok && e.Current(rel, f.Checksum, info.Size(), info.ModTime()) {
+		return e, verifyResumed
+	}
+	sum, err := bitrot.Checksum(file, cfg.ioLimiter)
+	if err != nil {
+		logger.Warn("cannot verify asset", "path", rel, "asset_id", f.ID, "error", err)
+		return bitrot.Entry{}, verifyFailed
+	}
+	e := bitrot.Entry{
+		AssetID:    f.ID,
+		Path:       rel,
+		Size:       info.Size(),
+		ModTime:    info.ModTime().UTC(),
+		Expected:   f.Checksum,
+		Actual:     sum,
+		VerifiedAt: time.Now().UTC(),
+	}
+	if !e.OK() {
+		logger.Warn("checksum mismatch", "path", rel, "asset_id", f.ID, "expected", e.Expected, "actual", e.Actual)
+	}
+	if err := prog.Record(e); err != nil {
+		logger.Warn("cannot record verification progress", "error", err)
+	}
+	return e, verifyHashed
+}
+
+// printVerify lists the corrupted files on stderr.
+func printVerify(rep *verifyReport) {
+	checked := rep.Hashed + rep.Resumed
+	if len(rep.Corrupted) == 0 {
+		fmt.Fprintf(stderr, "\nAll %d verified original(s) match their checksums.\n", checked)
+	} else {
+		fmt.Fprintf(stderr, "\n%d of %d verified original(s) do not match their checksums:\n", len(rep.Corrupted), checked)
+		for _, c := range rep.Corrupted {
+			fmt.Fprintf(stderr, "  %s  (asset %s, %s, modified %s)\n", c.Path, c.AssetID, report.FormatBytes(c.Size), c.ModTime.Format("2006-01-02 15:04"))
+			fmt.Fprintf(stderr, "    expected %s, got %s\n", c.Expected, c.Actual)
+		}
+	}
+	if rep.Missing > 0 {
+		fmt.Fprintf(stderr, "%d original(s) are missing from disk.\n", rep.Missing)
+	}
+	if rep.Unchecked+rep.Failed > 0 {
+		fmt.Fprintf(stderr, "%d original(s) could not be verified.\n", rep.Unchecked+rep.Failed)
+	}
+}
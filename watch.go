@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goeland86/immich-stray-finder/matcher"
+	"github.com/goeland86/immich-stray-finder/paths"
+	"github.com/goeland86/immich-stray-finder/scanner"
+	"github.com/goeland86/immich-stray-finder/watch"
+)
+
+// cmdWatch stays resident and checks files as they appear in the library,
+// instead of rescanning the whole tree. It only reports; strays are moved
+// by a later move run.
+func cmdWatch(ctx context.Context, args []string) int {
+	var cfg config
+	fs := newFlagSet("watch", &cfg)
+	addMatchFlags(fs, &cfg)
+	fs.DurationVar(&cfg.settle, "settle", 30*time.Second, "How long a new file must stay unchanged before it is checked")
+	fs.DurationVar(&cfg.refresh, "refresh", time.Minute, "Minimum time between refetches of the asset list")
+	fs.StringVar(&cfg.listenAddr, "listen", "", "Address for the /healthz, /status, and /metrics endpoints (e.g. :8080)")
+	fs.BoolFunc("porcelain", "Print strays in the porcelain format on stdout as they are found", func(string) error {
+		cfg.output = "porcelain"
+		return nil
+	})
+	if ok, code := parseFlags(fs, &cfg, args); !ok {
+		return code
+	}
+	if !requireConnection(fs, &cfg) {
+		return exitError
+	}
+	if cfg.settle <= 0 || cfg.refresh <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: --settle and --refresh must be positive")
+		return exitError
+	}
+	applyReadOnly(&cfg)
+	logger := newLogger(&cfg)
+
+	if err := watchLibrary(ctx, &cfg, logger); err != nil && ctx.Err() == nil {
+		logger.Error("watch failed", "error", err)
+		return exitError
+	}
+	logger.Info("shutting down")
+	return exitOK
+}
+
+// watchLibrary checks settled new files against the asset index. A file
+// that is not found is only flagged once the asset list has been refetched
+// after the file settled: Immich writes the file before it records the
+// asset, so the index from before the upload cannot know it.
+func watchLibrary(ctx context.Context, cfg *config, logger *slog.Logger) error {
+	status := newDaemonStatus("watch", activityChecking)
+	if cfg.listenAddr != "" {
+		mux := http.NewServeMux()
+		status.register(mux)
+		if err := startHTTP(ctx, cfg.listenAddr, mux, logger); err != nil {
+			return err
+		}
+	}
+
+	p, err := newPipeline(ctx, logger, cfg)
+	if err != nil {
+		return err
+	}
+	var mctx *matcher.MatchContext
+	var fetchedAt time.Time
+	var assets int
+	refetch := func() error {
+		started := time.Now()
+		result, err := p.fetch(ctx)
+		if err != nil {
+			return err
+		}
+		assets = len(result.AssetIDs)
+		mctx, fetchedAt = p.index(result), started
+		// Settling and refetching already keep uploads in progress from
+		// being flagged.
+		mctx.UploadGrace = 0
+		return nil
+	}
+	if err := refetch(); err != nil {
+		return err
+	}
+	status.setActivity(activityWatching)
+
+	if cfg.output == "porcelain" {
+		fmt.Printf("# porcelain v%d\n# mode watch\n", porcelainVersion)
+	}
+
+	batches := make(chan []string)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- watch.Run(ctx, p.scanRoot, watch.Options{
+			Settle: cfg.settle,
+			Skip: func(rel string) bool {
+				rel = paths.FromOS(rel)
+				return scanner.Excluded(rel) || slices.Contains(p.known, paths.TopDir(rel)) ||
+					p.ignore.Match(strings.TrimPrefix(p.scanPrefix+"/"+rel, "/"), true) ||
+					p.filter.SkipDir(strings.TrimPrefix(p.scanPrefix+"/"+rel, "/"))
+			},
+		}, logger, func(settled []string) {
+			select {
+			case batches <- settled:
+			case <-ctx.Done():
+			}
+		})
+	}()
+
+	// suspects are settled files not in the index, waiting for a refetch.
+	suspects := make(map[string]struct{})
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watchErr:
+			return err
+
+		case settled := <-batches:
+			files, abs := statFiles(p, settled)
+			unknown := matcher.FindUntracked(files, mctx, logger)
+			logger.Debug("checked new files", "files", len(files), "not_in_index", len(unknown))
+			for _, u := range unknown {
+				suspects[abs[u.RelPath]] = struct{}{}
+			}
+			if len(suspects) > 0 && retry == nil {
+				retry = time.After(time.Until(fetchedAt.Add(cfg.refresh)))
+			}
+
+		case <-retry:
+			retry = nil
+			startedAt := time.Now()
+			status.setActivity(activityChecking)
+			if err := refetch(); err != nil {
+				// Immich may be restarting; keep the suspects for next time.
+				logger.Warn("cannot refetch assets", "error", err)
+				status.finishRun(newRunStatus(startedAt, nil, err))
+				status.setActivity(activityWatching)
+				retry = time.After(cfg.refresh)
+				continue
+			}
+			// Restat: Immich may have moved an upload into place meanwhile.
+			files, _ := statFiles(p, slices.Sorted(maps.Keys(suspects)))
+			strays := matcher.FindUntracked(files, mctx, logger)
+			clear(suspects)
+			reportStrays(strays, cfg, logger)
+			status.finishRun(newRunStatus(startedAt, &runResult{
+				assetsFetched: assets,
+				filesScanned:  len(files),
+				untracked:     strays,
+			}, nil))
+			status.setActivity(activityWatching)
+		}
+	}
+}
+
+// statFiles describes the files at the given absolute paths as the scan
+// would, skipping those that no longer exist. abs maps each file's
+// relative path back to its absolute one.
+func statFiles(p *pipeline, names []string) (files []scanner.File, abs map[string]string) {
+	abs = make(map[string]string, len(names))
+	for _, path := range names {
+		f, err := scanner.Stat(p.scanRoot, p.scanPrefix, path)
+		if err != nil {
+			// Gone again, e.g. a temporary file.
+			continue
+		}
+		files = append(files, f)
+		abs[f.RelPath] = path
+	}
+	return files, abs
+}
+
+// reportStrays logs strays found by watch and prints them with --porcelain.
+func reportStrays(strays []matcher.UntrackedFile, cfg *config, logger *slog.Logger) {
+	for _, u := range strays {
+		logger.Warn("stray detected", "path", u.RelPath, "category", u.Category, "size", u.Size)
+	}
+	if cfg.output == "porcelain" && len(strays) > 0 {
+		writePorcelainFiles(os.Stdout, strays, nil)
+	}
+}
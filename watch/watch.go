@@ -0,0 +1,153 @@
+// Package watch reports files that appear or change below a directory tree,
+// once they have stopped changing.
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Options configures Run.
+type Options struct {
+	// Settle is how long a file must go without events before it is
+	// reported, so files still being written or renamed into place are
+	// only reported once.
+	Settle time.Duration
+	// Skip, if set, is called with each directory's path relative to the
+	// root (using the OS separator) and excludes it and everything below
+	// it from watching.
+	Skip func(rel string) bool
+}
+
+// Run watches root and every directory below it until ctx is cancelled,
+// calling fn with the paths of files that were created or written and have
+// since been quiet for opts.Settle. Directories created while watching are
+// watched too, and files already inside them are reported. fn runs on the
+// watching goroutine; events that arrive meanwhile are queued.
+func Run(ctx context.Context, root string, opts Options, logger *slog.Logger, fn func(paths []string)) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("create watcher: %w", err)
+	}
+	defer w.Close()
+
+	root = filepath.Clean(root)
+	pending := make(map[string]time.Time)
+	dirs, err := addTree(w, root, root, opts.Skip, nil)
+	if err != nil {
+		return err
+	}
+	logger.Info("watching library", "path", root, "directories", dirs)
+
+	interval := max(opts.Settle/4, 100*time.Millisecond)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			now := time.Now()
+			switch {
+			case ev.Has(fsnotify.Remove), ev.Has(fsnotify.Rename):
+				// A rename's new name arrives as a separate Create.
+				delete(pending, ev.Name)
+			case ev.Has(fsnotify.Create), ev.Has(fsnotify.Write):
+				info, err := os.Lstat(ev.Name)
+				if err != nil {
+					continue
+				}
+				if !info.IsDir() {
+					pending[ev.Name] = now
+					continue
+				}
+				if !ev.Has(fsnotify.Create) {
+					continue
+				}
+				// Files may have been created before the new directory was
+				// watched; report everything inside it.
+				n, err := addTree(w, root, ev.Name, opts.Skip, func(path string) { pending[path] = now })
+				if err != nil {
+					return err
+				}
+				logger.Debug("watching new directory", "path", ev.Name, "directories", n)
+			}
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				logger.Warn("filesystem events were dropped; run a full scan to catch anything missed", "error", err)
+				continue
+			}
+			logger.Warn("watch error", "error", err)
+
+		case now := <-ticker.C:
+			var settled []string
+			for path, last := range pending {
+				if now.Sub(last) >= opts.Settle {
+					settled = append(settled, path)
+					delete(pending, path)
+				}
+			}
+			if len(settled) > 0 {
+				slices.Sort(settled)
+				fn(settled)
+			}
+		}
+	}
+}
+
+// addTree watches dir and the directories below it, skipping those
+// opts.Skip excludes, and returns how many it added. If onFile is set it is
+// called for every file found.
+func addTree(w *fsnotify.Watcher, root, dir string, skip func(string) bool, onFile func(string)) (int, error) {
+	n := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Vanished or unreadable; nothing to watch.
+			return nil
+		}
+		if !d.IsDir() {
+			if onFile != nil {
+				onFile(path)
+			}
+			return nil
+		}
+		if skip != nil && path != root {
+			if rel, err := filepath.Rel(root, path); err == nil && skip(rel) {
+				return filepath.SkipDir
+			}
+		}
+		if err := w.Add(path); err != nil {
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOENT) {
+				// Removed since the walk listed it, e.g. a temporary
+				// directory; there is nothing left below it to watch.
+				return filepath.SkipDir
+			}
+			if errors.Is(err, syscall.ENOSPC) {
+				return fmt.Errorf("watch %s: out of inotify watches; raise fs.inotify.max_user_watches: %w", path, err)
+			}
+			return fmt.Errorf("watch %s: %w", path, err)
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
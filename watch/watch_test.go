@@ -0,0 +1,144 @@
+package watch
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+}
+
+// start runs Run on root in the background and returns a channel of
+// reported batches.
+func start(t *testing.T, root string, opts Options) <-chan []string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	got := make(chan []string, 16)
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx, root, opts, testLogger(), func(paths []string) { got <- paths })
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	})
+	// Give the watcher time to register the tree.
+	time.Sleep(100 * time.Millisecond)
+	return got
+}
+
+// collect gathers reported paths until none arrive for a while.
+func collect(got <-chan []string, quiet time.Duration) []string {
+	var all []string
+	for {
+		select {
+		case paths := <-got:
+			all = append(all, paths...)
+		case <-time.After(quiet):
+			slices.Sort(all)
+			return all
+		}
+	}
+}
+
+func TestRun_ReportsSettledFiles(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "upload"), 0o755)
+	got := start(t, root, Options{Settle: 200 * time.Millisecond})
+
+	path := filepath.Join(root, "upload", "a.jpg")
+	os.WriteFile(path, []byte("1"), 0o644)
+	// Keep writing; the file must only be reported once it is quiet.
+	for range 3 {
+		time.Sleep(100 * time.Millisecond)
+		os.WriteFile(path, []byte("12"), 0o644)
+	}
+
+	if all := collect(got, time.Second); !slices.Equal(all, []string{path}) {
+		t.Errorf("reported %v, want [%s] once", all, path)
+	}
+}
+
+func TestRun_NewDirectories(t *testing.T) {
+	root := t.TempDir()
+	got := start(t, root, Options{Settle: 100 * time.Millisecond})
+
+	// Files created together with their directory may predate its watch.
+	dir := filepath.Join(root, "library", "admin", "2024")
+	os.MkdirAll(dir, 0o755)
+	os.WriteFile(filepath.Join(dir, "a.jpg"), []byte("1"), 0o644)
+	time.Sleep(200 * time.Millisecond)
+	os.WriteFile(filepath.Join(dir, "b.jpg"), []byte("1"), 0o644)
+
+	want := []string{filepath.Join(dir, "a.jpg"), filepath.Join(dir, "b.jpg")}
+	if all := collect(got, time.Second); !slices.Equal(all, want) {
+		t.Errorf("reported %v, want %v", all, want)
+	}
+}
+
+func TestRun_SkipsExcludedDirectories(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "backups"), 0o755)
+	got := start(t, root, Options{
+		Settle: 100 * time.Millisecond,
+		Skip:   func(rel string) bool { return rel == "backups" },
+	})
+
+	os.WriteFile(filepath.Join(root, "backups", "dump.sql.gz"), []byte("1"), 0o644)
+	kept := filepath.Join(root, "kept.jpg")
+	os.WriteFile(kept, []byte("1"), 0o644)
+
+	if all := collect(got, time.Second); !slices.Equal(all, []string{kept}) {
+		t.Errorf("reported %v, want only %s", all, kept)
+	}
+}
+
+func TestRun_RemovedBeforeSettling(t *testing.T) {
+	root := t.TempDir()
+	got := start(t, root, Options{Settle: 300 * time.Millisecond})
+
+	path := filepath.Join(root, "upload.tmp")
+	os.WriteFile(path, []byte("1"), 0o644)
+	os.Rename(path, filepath.Join(root, "final.jpg"))
+
+	want := []string{filepath.Join(root, "final.jpg")}
+	if all := collect(got, time.Second); !slices.Equal(all, want) {
+		t.Errorf("reported %v, want %v", all, want)
+	}
+}
+
+func TestAddTree_VanishedDirectory(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "gone", "sub"), 0o755)
+	os.MkdirAll(filepath.Join(root, "kept"), 0o755)
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// Remove gone/ after the walk listed it, right before it is watched.
+	skip := func(rel string) bool {
+		if rel == "gone" {
+			os.RemoveAll(filepath.Join(root, rel))
+		}
+		return false
+	}
+	n, err := addTree(w, root, root, skip, nil)
+	if err != nil {
+		t.Fatalf("addTree: %v", err)
+	}
+	if n != 2 || !slices.Contains(w.WatchList(), filepath.Join(root, "kept")) {
+		t.Errorf("expected the root and kept/ watched, got %d: %v", n, w.WatchList())
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxWebhookBody bounds how much of a webhook body is logged. The body is
+// never interpreted.
+const maxWebhookBody = 512
+
+// webhookHandler accepts POSTs from Immich (or any job runner) and requests a
+// scan on trigger. Requests must carry token, when set, as a bearer token or
+// a "token" query parameter. trigger never blocks: a scan requested while one
+// is pending is coalesced into it.
+func webhookHandler(token string, trigger chan<- struct{}, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if token != "" && !validWebhookToken(r, token) {
+			logger.Warn("rejected webhook with invalid token", "remote", r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, _ := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+		logger.Info("webhook received, scheduling scan", "remote", r.RemoteAddr, "body", string(body))
+
+		select {
+		case trigger <- struct{}{}:
+		default:
+			logger.Debug("scan already pending, coalescing webhook")
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// validWebhookToken reports whether r carries token.
+func validWebhookToken(r *http.Request, token string) bool {
+	got := r.URL.Query().Get("token")
+	if auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		got = auth
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// startHTTP serves mux on addr until ctx is done.
+func startHTTP(ctx context.Context, addr string, mux *http.ServeMux, logger *slog.Logger) error {
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("HTTP server stopped", "error", err)
+		}
+	}()
+	logger.Info("HTTP server listening", "addr", ln.Addr().String())
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	tests := []struct {
+		name    string
+		token   string
+		method  string
+		target  string
+		auth    string
+		want    int
+		trigger bool
+	}{
+		{"no token required", "", http.MethodPost, "/webhook", "", http.StatusAccepted, true},
+		{"bearer token", "s3cret", http.MethodPost, "/webhook", "Bearer s3cret", http.StatusAccepted, true},
+		{"query token", "s3cret", http.MethodPost, "/webhook?token=s3cret", "", http.StatusAccepted, true},
+		{"missing token", "s3cret", http.MethodPost, "/webhook", "", http.StatusUnauthorized, false},
+		{"wrong bearer token", "s3cret", http.MethodPost, "/webhook", "Bearer guess", http.StatusUnauthorized, false},
+		{"wrong query token", "s3cret", http.MethodPost, "/webhook?token=guess", "", http.StatusUnauthorized, false},
+		{"bearer token overrides query", "s3cret", http.MethodPost, "/webhook?token=s3cret", "Bearer guess", http.StatusUnauthorized, false},
+		{"not a bearer token", "s3cret", http.MethodPost, "/webhook", "Basic s3cret", http.StatusUnauthorized, false},
+		{"wrong method", "", http.MethodGet, "/webhook", "", http.StatusMethodNotAllowed, false},
+		{"wrong method before token", "s3cret", http.MethodPut, "/webhook", "", http.StatusMethodNotAllowed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trigger := make(chan struct{}, 1)
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(`{"event":"done"}`))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			webhookHandler(tt.token, trigger, logger).ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := len(trigger) == 1; got != tt.trigger {
+				t.Errorf("triggered = %v, want %v", got, tt.trigger)
+			}
+			if tt.want == http.StatusMethodNotAllowed && rec.Header().Get("Allow") != http.MethodPost {
+				t.Errorf("Allow = %q, want POST", rec.Header().Get("Allow"))
+			}
+		})
+	}
+}
+
+func TestWebhookHandler_CoalescesPendingScans(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	trigger := make(chan struct{}, 1)
+	h := webhookHandler("", trigger, logger)
+	for range 3 {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/webhook", nil))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusAccepted)
+		}
+	}
+	if len(trigger) != 1 {
+		t.Errorf("expected one pending scan, got %d", len(trigger))
+	}
+}